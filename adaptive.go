@@ -0,0 +1,140 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	adaptivePercentile = 0.95
+	adaptiveFactor     = 2
+	adaptiveWindow     = 50
+	adaptiveMinSamples = 5
+)
+
+// AdaptiveTimeout tracks the response times of each slave and bounds
+// waiting for its responses to a multiple of their percentile, within Min
+// and Max, so that a timeout on a fast device does not stall the bus for
+// the timeout of the slowest one:
+//  handler := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  handler.Adaptive = &modbus.AdaptiveTimeout{Min: 20 * time.Millisecond, Max: time.Second}
+// Responses timing out are counted with the timeout, so that the timeout
+// of slaves slowing down grows back up to Max.
+type AdaptiveTimeout struct {
+	// Min and Max bound the timeout, Max is used until MinSamples
+	// response times are known. Max defaults to the response timeout of
+	// the transporter.
+	Min time.Duration
+	Max time.Duration
+	// Percentile of the response times, 0.95 if zero, multiplied by
+	// Factor, 2 if zero.
+	Percentile float64
+	Factor     float64
+	// Window is the number of last response times kept per slave, 50 if
+	// zero, and MinSamples the number needed to adapt the timeout, 5 if
+	// zero.
+	Window     int
+	MinSamples int
+
+	mu     sync.Mutex
+	slaves map[byte]*responseTimes
+}
+
+// responseTimes are the last response times of a slave.
+type responseTimes struct {
+	samples []time.Duration
+	next    int
+	// timeout is the adapted timeout, zero until MinSamples are known.
+	timeout time.Duration
+	// sorted is the buffer of the percentile.
+	sorted []time.Duration
+}
+
+// Timeout returns the response timeout of slaveId, or zero if it is not
+// known yet.
+func (a *AdaptiveTimeout) Timeout(slaveId byte) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if times := a.slaves[slaveId]; times != nil {
+		return times.timeout
+	}
+	return 0
+}
+
+// Observe records a response time of slaveId.
+func (a *AdaptiveTimeout) Observe(slaveId byte, responseTime time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.slaves == nil {
+		a.slaves = make(map[byte]*responseTimes)
+	}
+	times := a.slaves[slaveId]
+	if times == nil {
+		window := a.Window
+		if window <= 0 {
+			window = adaptiveWindow
+		}
+		times = &responseTimes{
+			samples: make([]time.Duration, 0, window),
+			sorted:  make([]time.Duration, 0, window),
+		}
+		a.slaves[slaveId] = times
+	}
+	if len(times.samples) < cap(times.samples) {
+		times.samples = append(times.samples, responseTime)
+	} else {
+		times.samples[times.next] = responseTime
+		times.next = (times.next + 1) % len(times.samples)
+	}
+	minSamples := a.MinSamples
+	if minSamples <= 0 {
+		minSamples = adaptiveMinSamples
+	}
+	if len(times.samples) < minSamples {
+		return
+	}
+	percentile, factor := a.Percentile, a.Factor
+	if percentile <= 0 || percentile > 1 {
+		percentile = adaptivePercentile
+	}
+	if factor <= 0 {
+		factor = adaptiveFactor
+	}
+	times.timeout = time.Duration(factor * float64(times.percentile(percentile)))
+}
+
+// percentile returns the percentile p of the samples.
+func (t *responseTimes) percentile(p float64) time.Duration {
+	// Insertion sort does not allocate, windows are small
+	t.sorted = append(t.sorted[:0], t.samples...)
+	for i := 1; i < len(t.sorted); i++ {
+		for j := i; j > 0 && t.sorted[j] < t.sorted[j-1]; j-- {
+			t.sorted[j], t.sorted[j-1] = t.sorted[j-1], t.sorted[j]
+		}
+	}
+	i := int(p*float64(len(t.sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	return t.sorted[i]
+}
+
+// bound returns the timeout of slaveId within Min and max, the timeout of
+// the transporter or Max if set.
+func (a *AdaptiveTimeout) bound(slaveId byte, max time.Duration) time.Duration {
+	if a.Max > 0 {
+		max = a.Max
+	}
+	timeout := a.Timeout(slaveId)
+	switch {
+	case timeout <= 0 || (max > 0 && timeout > max):
+		return max
+	case timeout < a.Min:
+		return a.Min
+	}
+	return timeout
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	a := &AdaptiveTimeout{Min: 10 * time.Millisecond, Max: time.Second, Window: 10}
+	if timeout := a.bound(1, 5*time.Second); timeout != time.Second {
+		t.Fatalf("unexpected timeout %v before samples", timeout)
+	}
+	for i := 1; i <= 10; i++ {
+		a.Observe(1, time.Duration(i)*10*time.Millisecond)
+	}
+	// 95th percentile of 10ms..100ms is 100ms
+	if timeout := a.bound(1, 0); timeout != 200*time.Millisecond {
+		t.Fatalf("unexpected timeout %v", timeout)
+	}
+	for i := 0; i < 10; i++ {
+		a.Observe(1, time.Millisecond)
+	}
+	if timeout := a.bound(1, 0); timeout != a.Min {
+		t.Fatalf("unexpected timeout %v below min", timeout)
+	}
+	for i := 0; i < 10; i++ {
+		a.Observe(1, time.Second)
+	}
+	if timeout := a.bound(1, 0); timeout != a.Max {
+		t.Fatalf("unexpected timeout %v above max", timeout)
+	}
+	if timeout := a.bound(2, 0); timeout != a.Max {
+		t.Fatalf("unexpected timeout %v of other slave", timeout)
+	}
+}
@@ -4,30 +4,52 @@
 
 package modbus
 
+// Client is the union of the operations of a Modbus client. Code needing
+// only some of them can depend on the narrower interfaces, e.g. a display
+// on RegisterReader, so that it can be passed any Client or a mock of
+// those operations only.
 type Client interface {
-	// Bit access
+	CoilReader
+	CoilWriter
+	RegisterReader
+	RegisterWriter
+}
 
+// CoilReader reads coils and discrete inputs.
+type CoilReader interface {
 	// ReadCoils reads from 1 to 2000 contiguous status of coils in a
 	// remote device and returns coil status.
 	ReadCoils(address, quantity uint16) (results []byte, err error)
 	// ReadDiscreteInputs reads from 1 to 2000 contiguous status of
 	// discrete inputs in a remote device and returns input status.
 	ReadDiscreteInputs(address, quantity uint16) (results []byte, err error)
+}
+
+// CoilWriter writes coils.
+type CoilWriter interface {
 	// WriteSingleCoil write a single output to either ON or OFF in a
 	// remote device and returns output value.
 	WriteSingleCoil(address, value uint16) (results []byte, err error)
 	// WriteMultipleCoils forces each coil in a sequence of coils to either
 	// ON or OFF in a remote device and returns quantity of outputs.
 	WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error)
+}
 
-	// 16-bit access
-
+// RegisterReader reads input and holding registers.
+type RegisterReader interface {
 	// ReadInputRegisters reads from 1 to 125 contiguous input registers in
 	// a remote device and returns input registers.
 	ReadInputRegisters(address, quantity uint16) (results []byte, err error)
 	// ReadHoldingRegisters reads the contents of a contiguous block of
 	// holding registers in a remote device and returns register value.
 	ReadHoldingRegisters(address, quantity uint16) (results []byte, err error)
+	//ReadFIFOQueue reads the contents of a First-In-First-Out (FIFO) queue
+	// of register in a remote device and returns FIFO value register.
+	ReadFIFOQueue(address uint16) (results []byte, err error)
+}
+
+// RegisterWriter writes holding registers.
+type RegisterWriter interface {
 	// WriteSingleRegister writes a single holding register in a remote
 	// device and returns register value.
 	WriteSingleRegister(address, value uint16) (results []byte, err error)
@@ -43,7 +65,4 @@ type Client interface {
 	// register's current contents. The function returns
 	// AND-mask and OR-mask.
 	MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error)
-	//ReadFIFOQueue reads the contents of a First-In-First-Out (FIFO) queue
-	// of register in a remote device and returns FIFO value register.
-	ReadFIFOQueue(address uint16) (results []byte, err error)
 }
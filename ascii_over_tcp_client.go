@@ -5,7 +5,7 @@
 package modbus
 
 import (
-	"time"
+	"context"
 )
 
 // ASCIIOverTCPClientHandler implements Packager and Transporter interface.
@@ -14,6 +14,11 @@ type ASCIIOverTCPClientHandler struct {
 	asciiTCPTransporter
 }
 
+// buffered implements bufferTransporter.
+func (mb *ASCIIOverTCPClientHandler) buffered() Transporter {
+	return mb
+}
+
 // NewASCIIOverTCPClientHandler allocates and initializes a ASCIIOverTCPClientHandler.
 func NewASCIIOverTCPClientHandler(address string) *ASCIIOverTCPClientHandler {
 	handler := &ASCIIOverTCPClientHandler{}
@@ -35,50 +40,41 @@ type asciiTCPTransporter struct {
 }
 
 func (mb *asciiTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.tcpTransporter.mu.Lock()
-	defer mb.tcpTransporter.mu.Unlock()
+	return mb.sendBuffer(context.Background(), new(aduBuffer), aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (mb *asciiTCPTransporter) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	if !heldByBatch(ctx, &mb.tcpTransporter.mu) {
+		mb.tcpTransporter.mu.Lock()
+		defer mb.tcpTransporter.mu.Unlock()
+	}
+	defer mb.tcpTransporter.notifyError(&err)
+	defer mb.tcpTransporter.closeFailed(&err)
 
 	// Make sure port is connected
 	if err = mb.tcpTransporter.connect(); err != nil {
 		return
 	}
+	mb.tcpTransporter.pace(clockOrSystem(mb.tcpTransporter.Clock))
+	defer mb.tcpTransporter.paced(clockOrSystem(mb.tcpTransporter.Clock))
 	// Start the timer to close when idle
-	mb.tcpTransporter.lastActivity = time.Now()
+	mb.tcpTransporter.lastActivity = mb.tcpTransporter.now()
 	mb.tcpTransporter.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
-	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
-		return
-	}
-
 	// Send the request
-	mb.tcpTransporter.logf("modbus: sending %q\n", aduRequest)
-	if _, err = mb.conn.Write(aduRequest); err != nil {
+	mb.tcpTransporter.logFrame(frameASCII, true, aduRequest)
+	slaveId, _ := readHex(aduRequest[1:])
+	if err = mb.tcpTransporter.send(aduRequest, slaveId); err != nil {
 		return
 	}
+	defer mb.tcpTransporter.observeResponse(slaveId, mb.tcpTransporter.now(), &err)
 	// Get the response
-	var n int
-	var data [asciiMaxSize]byte
-	length := 0
-	for {
-		if n, err = mb.conn.Read(data[length:]); err != nil {
-			return
-		}
-		length += n
-		if length >= asciiMaxSize || n == 0 {
-			break
-		}
-		// Expect end of frame in the data received
-		if length > asciiMinSize {
-			if string(data[length-len(asciiEnd):length]) == asciiEnd {
-				break
-			}
-		}
+	length, err := readASCIIFrame(mb.conn, buf[:])
+	if err != nil {
+		err = mb.tcpTransporter.responseError(err, length, slaveId)
+		return
 	}
-	aduResponse = data[:length]
-	mb.tcpTransporter.logf("modbus: received %q\n", aduResponse)
+	aduResponse = buf[:length]
+	mb.tcpTransporter.logFrame(frameASCII, false, aduResponse)
 	return
 }
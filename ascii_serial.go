@@ -0,0 +1,129 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"context"
+)
+
+// ASCIIClientHandler implements Packager and Transporter interface.
+type ASCIIClientHandler struct {
+	asciiPackager
+	asciiSerialTransporter
+}
+
+// buffered implements bufferTransporter.
+func (mb *ASCIIClientHandler) buffered() Transporter {
+	return mb
+}
+
+// NewASCIIClientHandler allocates and initializes a ASCIIClientHandler.
+func NewASCIIClientHandler(address string) *ASCIIClientHandler {
+	handler := &ASCIIClientHandler{}
+	handler.Address = address
+	handler.Timeout = serialTimeout
+	handler.IdleTimeout = serialIdleTimeout
+	handler.BroadcastDelay = serialBroadcastDelay
+	return handler
+}
+
+// ASCIIClient creates ASCII client with default handler and given connect string.
+func ASCIIClient(address string) Client {
+	handler := NewASCIIClientHandler(address)
+	return NewClient(handler)
+}
+
+// asciiSerialTransporter implements Transporter interface.
+type asciiSerialTransporter struct {
+	serialPort
+}
+
+func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendASCII(context.Background(), new(aduBuffer), aduRequest)
+}
+
+// SendContext is Send returning early with the error of ctx when it is
+// done, see ContextTransporter.
+func (mb *asciiSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendASCII(ctx, new(aduBuffer), aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (mb *asciiSerialTransporter) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendASCII(ctx, buf, aduRequest)
+}
+
+// sendASCII sends an ASCII frame and reads the response into buf.
+func (mb *serialPort) sendASCII(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	broadcast := len(aduRequest) >= 5 && string(aduRequest[1:3]) == "00"
+	if broadcast {
+		var function byte
+		if function, err = readHex(aduRequest[3:]); err != nil {
+			return
+		}
+		if err = checkBroadcast(function); err != nil {
+			return
+		}
+	}
+	if !heldByBatch(ctx, &mb.mu) {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+	}
+	defer mb.notifyError(&err)
+
+	// Make sure port is connected
+	if err = mb.connect(); err != nil {
+		return
+	}
+	mb.waitTurnaround()
+	mb.pace(clockOrSystem(mb.Clock))
+	defer mb.paced(clockOrSystem(mb.Clock))
+	// Start the timer to close when idle
+	mb.lastActivity = mb.now()
+	mb.startCloseTimer()
+	if function, hexErr := readHex(aduRequest[3:]); hexErr == nil && !broadcast {
+		defer mb.startTurnaround(function)
+	}
+
+	for attempt := 0; ; attempt++ {
+		aduResponse, err = mb.exchangeASCII(ctx, buf, aduRequest, broadcast)
+		if err != nil || len(aduResponse) == 0 || asciiChecksumValid(aduResponse) || !mb.retryChecksum(attempt) {
+			return
+		}
+	}
+}
+
+// exchangeASCII sends the request and reads the response. Caller must
+// hold the mutex.
+func (mb *serialPort) exchangeASCII(ctx context.Context, buf *aduBuffer, aduRequest []byte, broadcast bool) (aduResponse []byte, err error) {
+	// Discard data pending from previous exchanges
+	if err = mb.flush(); err != nil {
+		return
+	}
+	defer mb.exchanged(&err)
+	// Send the request
+	mb.logFrame(frameASCII, true, aduRequest)
+	if err = mb.write(aduRequest); err != nil {
+		return
+	}
+	if broadcast {
+		// Slaves do not respond, wait for the request to be processed.
+		mb.sleep(mb.BroadcastDelay)
+		return
+	}
+	// Get the response
+	slaveId, _ := readHex(aduRequest[1:])
+	defer mb.observeResponse(slaveId, mb.now(), &err)
+	length, err := readASCIIFrame(&portReader{mb: mb, ctx: ctx, timeout: mb.slaveTimeout(slaveId, mb.responseTimeout())}, buf[:])
+	if err != nil {
+		return
+	}
+	aduResponse = buf[:length]
+	mb.logFrame(frameASCII, false, aduResponse)
+	return
+}
+
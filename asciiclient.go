@@ -8,7 +8,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
-	"time"
+	"io"
 )
 
 const (
@@ -20,30 +20,29 @@ const (
 	hexTable = "0123456789ABCDEF"
 )
 
-// ASCIIClientHandler implements Packager and Transporter interface.
-type ASCIIClientHandler struct {
-	asciiPackager
-	asciiSerialTransporter
+// NewASCIIPackager returns a packager of ASCII frames of slaveId, see
+// NewRTUPackager.
+func NewASCIIPackager(slaveId byte) Packager {
+	return &asciiPackager{SlaveId: slaveId}
 }
 
-// NewASCIIClientHandler allocates and initializes a ASCIIClientHandler.
-func NewASCIIClientHandler(address string) *ASCIIClientHandler {
-	handler := &ASCIIClientHandler{}
-	handler.Address = address
-	handler.Timeout = serialTimeout
-	handler.IdleTimeout = serialIdleTimeout
-	return handler
+// asciiPackager implements Packager interface.
+type asciiPackager struct {
+	SlaveId byte
+	// Delimiter ends frames after CR, LF if zero. Slaves may be configured
+	// to another delimiter with the Change ASCII Input Delimiter
+	// diagnostic.
+	Delimiter byte
 }
 
-// ASCIIClient creates ASCII client with default handler and given connect string.
-func ASCIIClient(address string) Client {
-	handler := NewASCIIClientHandler(address)
-	return NewClient(handler)
+// withSlaveId implements slavePackager.
+func (mb *asciiPackager) withSlaveId(slaveId byte) Packager {
+	return &asciiPackager{SlaveId: slaveId, Delimiter: mb.Delimiter}
 }
 
-// asciiPackager implements Packager interface.
-type asciiPackager struct {
-	SlaveId byte
+// isBroadcast implements broadcastPackager.
+func (mb *asciiPackager) isBroadcast(aduRequest []byte) bool {
+	return len(aduRequest) >= 3 && string(aduRequest[1:3]) == "00"
 }
 
 // Encode encodes PDU in a ASCII frame:
@@ -72,7 +71,7 @@ func (mb *asciiPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 	if err = writeHex(&buf, []byte{lrc.value()}); err != nil {
 		return
 	}
-	if _, err = buf.WriteString(asciiEnd); err != nil {
+	if _, err = buf.Write([]byte{'\r', mb.delimiter()}); err != nil {
 		return
 	}
 	adu = buf.Bytes()
@@ -100,8 +99,8 @@ func (mb *asciiPackager) Verify(aduRequest []byte, aduResponse []byte) (err erro
 	}
 	// 2 last chars must be \r\n
 	str = string(aduResponse[len(aduResponse)-len(asciiEnd):])
-	if str != asciiEnd {
-		err = fmt.Errorf("modbus: response frame ...'%v' is not ended with '%v'", str, asciiEnd)
+	if end := string([]byte{'\r', mb.delimiter()}); str != end {
+		err = fmt.Errorf("modbus: response frame ...'%v' is not ended with '%v'", str, end)
 		return
 	}
 	// Slave id
@@ -122,6 +121,11 @@ func (mb *asciiPackager) Verify(aduRequest []byte, aduResponse []byte) (err erro
 
 // Decode extracts PDU from ASCII frame and verify LRC.
 func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
+	// Colon, address, function, LRC and CRLF
+	if len(adu) < 9 || len(adu) > asciiMaxSize {
+		err = fmt.Errorf("modbus: frame length '%v' must be between '%v' and '%v'", len(adu), 9, asciiMaxSize)
+		return
+	}
 	pdu = &ProtocolDataUnit{}
 	// Slave address
 	address, err := readHex(adu[1:])
@@ -149,56 +153,63 @@ func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	lrc.reset()
 	lrc.pushByte(address).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
 	if lrcVal != lrc.value() {
-		err = fmt.Errorf("modbus: response lrc '%v' does not match expected '%v'", lrcVal, lrc.value())
+		err = &ChecksumError{Checksum: "lrc", Received: uint16(lrcVal), Expected: uint16(lrc.value())}
 		return
 	}
 	return
 }
 
-// asciiSerialTransporter implements Transporter interface.
-type asciiSerialTransporter struct {
-	serialPort
+// asciiChecksumValid returns false if the LRC of the frame does not match
+// or its characters are not hexadecimal.
+func asciiChecksumValid(adu []byte) bool {
+	if len(adu) < asciiMinSize+6 {
+		return true
+	}
+	_, err := new(asciiPackager).Decode(adu)
+	return err == nil
 }
 
-func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.serialPort.mu.Lock()
-	defer mb.serialPort.mu.Unlock()
-
-	// Make sure port is connected
-	if err = mb.serialPort.connect(); err != nil {
-		return
+func (mb *asciiPackager) delimiter() byte {
+	if mb.Delimiter == 0 {
+		return '\n'
 	}
-	// Start the timer to close when idle
-	mb.serialPort.lastActivity = time.Now()
-	mb.serialPort.startCloseTimer()
+	return mb.Delimiter
+}
 
-	// Send the request
-	mb.serialPort.logf("modbus: sending %q\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
-		return
-	}
-	// Get the response
-	var n int
-	var data [asciiMaxSize]byte
-	length := 0
+// readASCIIFrame reads a frame into data, from the start colon to the
+// character following CR, which is the delimiter. Characters received
+// before the colon, such as noise of the line, are discarded.
+func readASCIIFrame(r io.Reader, data []byte) (length int, err error) {
+	data = data[:asciiMaxSize]
+	started := false
 	for {
-		if n, err = mb.port.Read(data[length:]); err != nil {
+		var n int
+		if n, err = r.Read(data[length:]); err != nil {
 			return
 		}
-		length += n
-		if length >= asciiMaxSize || n == 0 {
-			break
+		if n == 0 {
+			return
 		}
-		// Expect end of frame in the data received
-		if length > asciiMinSize {
-			if string(data[length-len(asciiEnd):length]) == asciiEnd {
-				break
+		length += n
+		if !started {
+			i := bytes.IndexByte(data[:length], asciiStart[0])
+			if i < 0 {
+				length = 0
+				continue
 			}
+			length = copy(data, data[i:length])
+			started = true
+		}
+		// CR is followed by the delimiter
+		if i := bytes.IndexByte(data[:length], '\r'); i >= 0 && i+1 < length {
+			length = i + 2
+			return
+		}
+		if length >= asciiMaxSize {
+			err = fmt.Errorf("modbus: response frame exceeds '%v' characters", asciiMaxSize)
+			return
 		}
 	}
-	aduResponse = data[:length]
-	mb.serialPort.logf("modbus: received %q\n", aduResponse)
-	return
 }
 
 // writeHex encodes byte to string in hexadecimal, e.g. 0xA5 => "A5"
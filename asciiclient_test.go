@@ -6,7 +6,9 @@ package modbus
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestASCIIEncoding(t *testing.T) {
@@ -46,6 +48,15 @@ func TestASCIIDecoding(t *testing.T) {
 	}
 }
 
+func TestASCIIChecksumValid(t *testing.T) {
+	if !asciiChecksumValid([]byte(":F7031389000A60\r\n")) {
+		t.Fatal("valid frame rejected")
+	}
+	if asciiChecksumValid([]byte(":F7031389000A61\r\n")) {
+		t.Fatal("lrc mismatch not detected")
+	}
+}
+
 func BenchmarkASCIIEncoder(b *testing.B) {
 	encoder := asciiPackager{
 		SlaveId: 10,
@@ -74,3 +85,46 @@ func BenchmarkASCIIDecoder(b *testing.B) {
 		}
 	}
 }
+
+func TestASCIIDelimiter(t *testing.T) {
+	encoder := asciiPackager{SlaveId: 17, Delimiter: '>'}
+	adu, err := encoder.Encode(&ProtocolDataUnit{FunctionCode: 3, Data: []byte{0, 107, 0, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(adu) != ":1103006B00037E\r>" {
+		t.Fatalf("unexpected frame %q", adu)
+	}
+	if err = encoder.Verify(adu, []byte(":1103006B00037E\r\n")); err == nil {
+		t.Fatal("frame ended with CRLF verified")
+	}
+	if err = encoder.Verify(adu, adu); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadASCIIFrame(t *testing.T) {
+	tests := []struct {
+		input string
+		frame string
+	}{
+		{":110300\r\n", ":110300\r\n"},
+		{"\x00\xFF:110300\r\n:12", ":110300\r\n"},
+		{"noise\r\n:110300\r>", ":110300\r>"},
+	}
+	for _, test := range tests {
+		var buf aduBuffer
+		length, err := readASCIIFrame(iotest.OneByteReader(strings.NewReader(test.input)), buf[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame := string(buf[:length]); frame != test.frame {
+			t.Errorf("%q: expected %q, actual %q", test.input, test.frame, frame)
+		}
+	}
+	var buf aduBuffer
+	_, err := readASCIIFrame(strings.NewReader(":"+strings.Repeat("0", asciiMaxSize)), buf[:])
+	if err == nil || err.Error() != "modbus: response frame exceeds '513' characters" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by requests to a closed client.
+var ErrClosed = errors.New("modbus: client is closed")
+
+// ErrDeadlineExceeded is returned by work not started before its deadline.
+var ErrDeadlineExceeded = errors.New("modbus: deadline exceeded")
+
+// Result is the outcome of an asynchronous request.
+type Result struct {
+	Results []byte
+	Err     error
+}
+
+// AsyncClient sends requests from a single worker goroutine which owns the
+// underlying client. Methods queue the request and return immediately a
+// channel receiving its result:
+//  client := modbus.NewAsyncClient(modbus.NewClient(handler))
+//  defer client.Close()
+//  result := <-client.ReadHoldingRegistersAsync(0, 10)
+// Requests are sent in the order they are queued. The channel is buffered
+// so the result may be ignored. Requests with a priority or a deadline are
+// queued with Enqueue.
+type AsyncClient struct {
+	client Client
+	// Schedule returns the index of the work sent next among the queued
+	// work, to implement custom scheduling policies. By default the work
+	// of highest priority is sent first, then the one of earliest
+	// deadline, then the one queued first. It is called with the queue
+	// locked and must not be changed once work is queued.
+	Schedule func(queue []*Work) int
+	// Clock timestamps the work and checks the deadlines, it defaults to
+	// the system time if nil. It must not be changed once work is queued.
+	Clock Clock
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*Work
+	closed  bool
+	stopped chan struct{}
+}
+
+// Work is a request queued in an AsyncClient.
+type Work struct {
+	// Priority of the work, higher is sent first.
+	Priority int
+	// Deadline is the time the work must be started before, zero if none.
+	Deadline time.Time
+	// Queued is the time the work was queued.
+	Queued time.Time
+
+	send func(client Client) ([]byte, error)
+	done func(result Result)
+}
+
+// NewAsyncClient allocates a new AsyncClient and starts its worker.
+func NewAsyncClient(client Client) *AsyncClient {
+	mb := &AsyncClient{
+		client:  client,
+		stopped: make(chan struct{}),
+	}
+	mb.cond = sync.NewCond(&mb.mu)
+	go mb.run()
+	return mb
+}
+
+// Close stops the worker after the queued requests have been sent.
+// Requests queued after Close fail with ErrClosed.
+func (mb *AsyncClient) Close() error {
+	mb.mu.Lock()
+	mb.closed = true
+	mb.cond.Broadcast()
+	mb.mu.Unlock()
+
+	<-mb.stopped
+	return nil
+}
+
+// Pending returns the number of queued requests.
+func (mb *AsyncClient) Pending() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.queue)
+}
+
+// Enqueue queues the request, sent with the client of the AsyncClient,
+// and calls done, if not nil, with its result from the worker goroutine.
+// Work not started before deadline fails with ErrDeadlineExceeded:
+//  client.Enqueue(func(c modbus.Client) ([]byte, error) {
+//  	return c.ReadHoldingRegisters(0, 10)
+//  }, 1, time.Now().Add(time.Second), func(result modbus.Result) {
+//  	...
+//  })
+// The order of the work sent is given by Schedule.
+func (mb *AsyncClient) Enqueue(request func(client Client) ([]byte, error), priority int, deadline time.Time, done func(result Result)) {
+	if done == nil {
+		done = func(Result) {}
+	}
+	work := &Work{
+		Priority: priority,
+		Deadline: deadline,
+		Queued:   clockOrSystem(mb.Clock).Now(),
+		send:     request,
+		done:     done,
+	}
+	mb.mu.Lock()
+	if mb.closed {
+		mb.mu.Unlock()
+		done(Result{Err: ErrClosed})
+		return
+	}
+	mb.queue = append(mb.queue, work)
+	mb.cond.Signal()
+	mb.mu.Unlock()
+}
+
+func (mb *AsyncClient) enqueue(send func() ([]byte, error)) <-chan Result {
+	result := make(chan Result, 1)
+	mb.Enqueue(func(Client) ([]byte, error) {
+		return send()
+	}, 0, time.Time{}, func(r Result) {
+		result <- r
+	})
+	return result
+}
+
+// scheduleDefault returns the work of highest priority, earliest deadline
+// and queued first.
+func scheduleDefault(queue []*Work) (next int) {
+	for i := 1; i < len(queue); i++ {
+		w, n := queue[i], queue[next]
+		if w.Priority != n.Priority {
+			if w.Priority > n.Priority {
+				next = i
+			}
+			continue
+		}
+		if !w.Deadline.IsZero() && (n.Deadline.IsZero() || w.Deadline.Before(n.Deadline)) {
+			next = i
+		}
+	}
+	return
+}
+
+func (mb *AsyncClient) run() {
+	defer close(mb.stopped)
+	for {
+		mb.mu.Lock()
+		for len(mb.queue) == 0 && !mb.closed {
+			mb.cond.Wait()
+		}
+		if len(mb.queue) == 0 {
+			mb.mu.Unlock()
+			return
+		}
+		schedule := mb.Schedule
+		if schedule == nil {
+			schedule = scheduleDefault
+		}
+		i := schedule(mb.queue)
+		work := mb.queue[i]
+		copy(mb.queue[i:], mb.queue[i+1:])
+		mb.queue[len(mb.queue)-1] = nil
+		mb.queue = mb.queue[:len(mb.queue)-1]
+		mb.mu.Unlock()
+
+		if !work.Deadline.IsZero() && clockOrSystem(mb.Clock).Now().After(work.Deadline) {
+			work.done(Result{Err: ErrDeadlineExceeded})
+			continue
+		}
+		results, err := work.send(mb.client)
+		work.done(Result{results, err})
+	}
+}
+
+// ReadCoilsAsync queues ReadCoils.
+func (mb *AsyncClient) ReadCoilsAsync(address, quantity uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadCoils(address, quantity)
+	})
+}
+
+// ReadDiscreteInputsAsync queues ReadDiscreteInputs.
+func (mb *AsyncClient) ReadDiscreteInputsAsync(address, quantity uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadDiscreteInputs(address, quantity)
+	})
+}
+
+// WriteSingleCoilAsync queues WriteSingleCoil.
+func (mb *AsyncClient) WriteSingleCoilAsync(address, value uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.WriteSingleCoil(address, value)
+	})
+}
+
+// WriteMultipleCoilsAsync queues WriteMultipleCoils.
+func (mb *AsyncClient) WriteMultipleCoilsAsync(address, quantity uint16, value []byte) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.WriteMultipleCoils(address, quantity, value)
+	})
+}
+
+// ReadInputRegistersAsync queues ReadInputRegisters.
+func (mb *AsyncClient) ReadInputRegistersAsync(address, quantity uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadInputRegisters(address, quantity)
+	})
+}
+
+// ReadHoldingRegistersAsync queues ReadHoldingRegisters.
+func (mb *AsyncClient) ReadHoldingRegistersAsync(address, quantity uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadHoldingRegisters(address, quantity)
+	})
+}
+
+// WriteSingleRegisterAsync queues WriteSingleRegister.
+func (mb *AsyncClient) WriteSingleRegisterAsync(address, value uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.WriteSingleRegister(address, value)
+	})
+}
+
+// WriteMultipleRegistersAsync queues WriteMultipleRegisters.
+func (mb *AsyncClient) WriteMultipleRegistersAsync(address, quantity uint16, value []byte) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.WriteMultipleRegisters(address, quantity, value)
+	})
+}
+
+// ReadWriteMultipleRegistersAsync queues ReadWriteMultipleRegisters.
+func (mb *AsyncClient) ReadWriteMultipleRegistersAsync(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	})
+}
+
+// MaskWriteRegisterAsync queues MaskWriteRegister.
+func (mb *AsyncClient) MaskWriteRegisterAsync(address, andMask, orMask uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.MaskWriteRegister(address, andMask, orMask)
+	})
+}
+
+// ReadFIFOQueueAsync queues ReadFIFOQueue.
+func (mb *AsyncClient) ReadFIFOQueueAsync(address uint16) <-chan Result {
+	return mb.enqueue(func() ([]byte, error) {
+		return mb.client.ReadFIFOQueue(address)
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncClient(t *testing.T) {
+	memory := &memoryClient{}
+	client := NewAsyncClient(memory)
+
+	write := client.WriteMultipleRegistersAsync(10, 2, []byte{0, 1, 0, 2})
+	read := client.ReadHoldingRegistersAsync(10, 2)
+	invalid := client.ReadHoldingRegistersAsync(10, 200)
+
+	if result := <-write; result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	result := <-read
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if !bytes.Equal([]byte{0, 1, 0, 2}, result.Results) {
+		t.Fatalf("unexpected results %v", result.Results)
+	}
+	if result = <-invalid; result.Err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// Queued requests are sent before closing.
+	pending := client.ReadHoldingRegistersAsync(0, 1)
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if result = <-pending; result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result = <-client.ReadHoldingRegistersAsync(0, 1); result.Err != ErrClosed {
+		t.Fatalf("expected closed error, actual %v", result.Err)
+	}
+}
+
+func TestAsyncClientEnqueue(t *testing.T) {
+	client := NewAsyncClient(&memoryClient{})
+	defer client.Close()
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(name string, priority int, deadline time.Time, send func(Client) ([]byte, error)) {
+		wg.Add(1)
+		client.Enqueue(send, priority, deadline, func(result Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			if result.Err != nil {
+				name += ": " + result.Err.Error()
+			}
+			order = append(order, name)
+			wg.Done()
+		})
+	}
+	read := func(c Client) ([]byte, error) {
+		return c.ReadHoldingRegisters(0, 1)
+	}
+	// Block the worker until all work is queued.
+	gate, started := make(chan struct{}), make(chan struct{})
+	enqueue("gate", 0, time.Time{}, func(Client) ([]byte, error) {
+		close(started)
+		<-gate
+		return nil, nil
+	})
+	<-started
+	now := time.Now()
+	enqueue("low", 0, time.Time{}, read)
+	enqueue("late", 1, now.Add(time.Hour), read)
+	enqueue("early", 1, now.Add(time.Minute), read)
+	enqueue("expired", 1, now.Add(-time.Second), read)
+	enqueue("high", 2, time.Time{}, read)
+	close(gate)
+	wg.Wait()
+
+	expected := []string{"gate", "high", "expired: modbus: deadline exceeded", "early", "late", "low"}
+	if !reflect.DeepEqual(expected, order) {
+		t.Fatalf("expected %v, actual %v", expected, order)
+	}
+}
+
+func TestAsyncClientSchedule(t *testing.T) {
+	client := NewAsyncClient(&memoryClient{})
+	defer client.Close()
+	// Last in, first out
+	client.Schedule = func(queue []*Work) int {
+		return len(queue) - 1
+	}
+	gate, started := make(chan struct{}), make(chan struct{})
+	client.Enqueue(func(Client) ([]byte, error) {
+		close(started)
+		<-gate
+		return nil, nil
+	}, 0, time.Time{}, nil)
+	<-started
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		i := i
+		client.Enqueue(func(c Client) ([]byte, error) {
+			order <- i
+			return nil, nil
+		}, 0, time.Time{}, nil)
+	}
+	close(gate)
+	if first, second := <-order, <-order; first != 2 || second != 1 {
+		t.Fatalf("unexpected order %v, %v", first, second)
+	}
+}
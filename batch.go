@@ -0,0 +1,92 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrBatchAborted is the error of the requests of a batch not sent because
+// a previous request failed.
+var ErrBatchAborted = errors.New("modbus: batch aborted by a previous error")
+
+// Do sends the requests of batch in order, each one with the client it is
+// passed, and returns their results. Requests following a failed one are
+// not sent and fail with ErrBatchAborted:
+//  results := modbus.Do(client, []func(modbus.Client) ([]byte, error){
+//  	func(c modbus.Client) ([]byte, error) { return c.WriteSingleRegister(10, 1) },
+//  	func(c modbus.Client) ([]byte, error) { return c.ReadHoldingRegisters(0, 4) },
+//  })
+// The connection or port of built-in handlers is locked once for the
+// whole batch, so that the requests of other clients sharing it are not
+// interleaved. Requests must not use other clients of the handler, which
+// would wait for the end of the batch.
+func Do(c Client, batch []func(client Client) ([]byte, error)) (results []Result) {
+	results = make([]Result, len(batch))
+	run := func(client Client) {
+		for i, request := range batch {
+			r, err := request(client)
+			results[i] = Result{r, err}
+			if err != nil {
+				for j := i + 1; j < len(batch); j++ {
+					results[j].Err = ErrBatchAborted
+				}
+				return
+			}
+		}
+	}
+	mb, ok := c.(*client)
+	if !ok {
+		run(c)
+		return
+	}
+	transporter, pooled := mb.transporter.(bufferTransporter)
+	locker, ok := mb.transporter.(batchLocker)
+	if !ok || !pooled || transporter.buffered() != mb.transporter {
+		run(c)
+		return
+	}
+	mu := locker.batchMutex()
+	mu.Lock()
+	defer mu.Unlock()
+	ctx := mb.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	clone := *mb
+	clone.ctx = context.WithValue(ctx, batchKey{}, mu)
+	run(&clone)
+	return
+}
+
+// batchLocker is implemented by the built-in transporters whose mutex is
+// held by Do.
+type batchLocker interface {
+	batchMutex() *sync.Mutex
+}
+
+// batchKey is the key of the mutex held by Do in the context of requests.
+type batchKey struct{}
+
+// withBatch returns ctx with the mutex held by the batch of parent, if
+// any, so that requests with ctx do not wait for the batch.
+func withBatch(ctx, parent context.Context) context.Context {
+	if parent == nil {
+		return ctx
+	}
+	if mu, ok := parent.Value(batchKey{}).(*sync.Mutex); ok {
+		return context.WithValue(ctx, batchKey{}, mu)
+	}
+	return ctx
+}
+
+// heldByBatch returns true if mu is held by the batch of ctx, in which
+// case the requests must not lock it.
+func heldByBatch(ctx context.Context, mu *sync.Mutex) bool {
+	held, _ := ctx.Value(batchKey{}).(*sync.Mutex)
+	return held == mu
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	store := NewMemoryStore()
+	server := NewServer(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	conn := NewTCPConnection(listener.Addr().String())
+	conn.Timeout = time.Second
+	defer conn.Close()
+	client := NewClient(conn.Handler(1))
+	other := NewClient(conn.Handler(1))
+
+	otherDone := make(chan error, 1)
+	results := Do(client, []func(Client) ([]byte, error){
+		func(c Client) ([]byte, error) {
+			return c.WriteSingleRegister(0, 1)
+		},
+		func(c Client) ([]byte, error) {
+			// Waits for the end of the batch
+			go func() {
+				_, err := other.WriteSingleRegister(0, 2)
+				otherDone <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+			return c.ReadHoldingRegisters(0, 1)
+		},
+		func(c Client) ([]byte, error) {
+			return c.ReadHoldingRegisters(0, 126)
+		},
+		func(c Client) ([]byte, error) {
+			return c.ReadHoldingRegisters(0, 1)
+		},
+	})
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if v := registerValues(results[1].Results); len(v) != 1 || v[0] != 1 {
+		t.Fatalf("request of other client interleaved, read %v", v)
+	}
+	if results[2].Err == nil || results[3].Err != ErrBatchAborted {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if err = <-otherDone; err != nil {
+		t.Fatal(err)
+	}
+	if v := store.HoldingRegisters(0, 1); v[0] != 2 {
+		t.Fatalf("unexpected value %v", v)
+	}
+}
+
+func TestDoWithContext(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	// The handler is not closed on failure, Close would wait for the batch
+	handler := NewTCPClientHandler(listener.Addr().String())
+	handler.Timeout = time.Second
+	client := NewClient(handler)
+	done := make(chan []Result, 1)
+	go func() {
+		done <- Do(client, []func(Client) ([]byte, error){
+			func(c Client) ([]byte, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				return WithContext(c, ctx).ReadHoldingRegisters(0, 1)
+			},
+		})
+	}()
+	select {
+	case results := <-done:
+		handler.Close()
+		if results[0].Err != nil {
+			t.Fatal(results[0].Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request with context waits for the batch")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+)
+
+// UnpackBits converts the results of ReadCoils or ReadDiscreteInputs into
+// quantity values. The first value is the least significant bit of the
+// first byte.
+func UnpackBits(results []byte, quantity uint16) (values []bool, err error) {
+	if quantity < 1 || quantity > 2000 {
+		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
+		return
+	}
+	count := (int(quantity) + 7) / 8
+	if len(results) != count {
+		err = fmt.Errorf("modbus: results size '%v' does not match quantity '%v'", len(results), quantity)
+		return
+	}
+	values = make([]bool, quantity)
+	for i := range values {
+		values[i] = results[i/8]&(1<<uint(i%8)) != 0
+	}
+	return
+}
+
+// PackBits converts values into the quantity and byte layout expected by
+// WriteMultipleCoils:
+//  quantity, value, err := modbus.PackBits(coils)
+//  ...
+//  client.WriteMultipleCoils(address, quantity, value)
+func PackBits(values []bool) (quantity uint16, value []byte, err error) {
+	if len(values) < 1 || len(values) > 1968 {
+		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", len(values), 1, 1968)
+		return
+	}
+	quantity = uint16(len(values))
+	value = make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			value[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return
+}
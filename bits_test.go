@@ -0,0 +1,44 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestUnpackBits(t *testing.T) {
+	values, err := UnpackBits([]byte{0xCD, 0x01}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, false, true, true, false, false, true, true, true, false}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf("expected %v, actual %v", expected, values)
+	}
+	if _, err = UnpackBits([]byte{0xCD}, 10); err == nil {
+		t.Fatalf("expected error for short results")
+	}
+	if _, err = UnpackBits([]byte{0xCD}, 0); err == nil {
+		t.Fatalf("expected error for zero quantity")
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	quantity, value, err := PackBits([]bool{true, false, true, true, false, false, true, true, true, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quantity != 10 || !bytes.Equal([]byte{0xCD, 0x01}, value) {
+		t.Fatalf("unexpected quantity %v, value %x", quantity, value)
+	}
+	if _, _, err = PackBits(nil); err == nil {
+		t.Fatalf("expected error for no values")
+	}
+	if _, _, err = PackBits(make([]bool, 1969)); err == nil {
+		t.Fatalf("expected error for too many values")
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+// aduBuffer holds a frame of any of the protocols.
+type aduBuffer [asciiMaxSize]byte
+
+// aduPool reuses frame buffers, so that clients polling at high rates do
+// not allocate the request and the response of every transaction.
+var aduPool = sync.Pool{
+	New: func() interface{} {
+		return new(aduBuffer)
+	},
+}
+
+// bufferEncoder is implemented by packagers which can encode the ADU into
+// a buffer, appending to adu.
+type bufferEncoder interface {
+	encodeTo(adu []byte, pdu *ProtocolDataUnit) ([]byte, error)
+}
+
+// bufferTransporter is implemented by the handlers which can receive the
+// response into a buffer. They must not keep the request nor the buffer
+// after returning. buffered returns the handler itself, so that types
+// embedding a handler, which may override Send, are not taken for one.
+type bufferTransporter interface {
+	sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error)
+	buffered() Transporter
+}
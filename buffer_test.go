@@ -0,0 +1,76 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveCounter responds to read holding registers requests with the
+// number of the request in all registers.
+func serveCounter(ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var request [12]byte
+	var response [tcpMaxLength]byte
+	for counter := uint16(1); ; counter++ {
+		if _, err = io.ReadFull(conn, request[:]); err != nil {
+			return
+		}
+		quantity := int(binary.BigEndian.Uint16(request[10:]))
+		copy(response[:], request[:8])
+		binary.BigEndian.PutUint16(response[4:], uint16(3+2*quantity))
+		response[8] = byte(2 * quantity)
+		for i := 0; i < quantity; i++ {
+			binary.BigEndian.PutUint16(response[9+2*i:], counter)
+		}
+		if _, err = conn.Write(response[:9+2*quantity]); err != nil {
+			return
+		}
+	}
+}
+
+func TestPooledBuffers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveCounter(ln)
+
+	handler := NewTCPClientHandler(ln.Addr().String())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	client := NewClient(handler)
+	first, err := client.ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Results must not share the buffers
+	if !bytes.Equal([]byte{0, 1, 0, 1}, first) || !bytes.Equal([]byte{0, 2, 0, 2}, second) {
+		t.Fatalf("unexpected results: % x, % x", first, second)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := client.ReadHoldingRegisters(0, 10); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// PDUs of the request and the response, their data and the results
+	if allocs > 6 {
+		t.Fatalf("unexpected allocations per request: %v", allocs)
+	}
+}
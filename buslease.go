@@ -0,0 +1,183 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// busLeasePoll is the interval of the attempts to acquire a bus lease.
+const busLeasePoll = 10 * time.Millisecond
+
+// BusLease shares a serial bus between processes of the same host, e.g. a
+// poller and a commissioning tool, by holding an advisory lock on a file
+// while sending requests, so that their frames do not interleave. Each
+// process uses a lease of the same path as a Middleware:
+//  lease := &modbus.BusLease{Path: "/var/lock/modbus-ttyUSB0.lock", Idle: time.Second, Closer: handler}
+//  defer lease.Release()
+//  client := modbus.NewMiddlewareClient(handler, lease.Middleware)
+// The lease is acquired before a request and released once no request is
+// sent for Idle, or after the first request completing past Slice, giving
+// the other processes a turn. Requests of the same process, including of
+// several clients of a SerialPort, share the lease.
+type BusLease struct {
+	// Path is the lock file, created if it does not exist.
+	Path string
+	// Idle is the time the lease is kept after a request, it is released
+	// after each request if zero.
+	Idle time.Duration
+	// Slice bounds the time the lease is kept while requests keep being
+	// sent, it is not bounded if zero.
+	Slice time.Duration
+	// Timeout fails requests not acquiring the lease within that time, they
+	// wait for it if zero.
+	Timeout time.Duration
+	// Closer, if set, is closed when the lease is released, e.g. the client
+	// handler, as serial ports of Windows can be opened by one process only.
+	Closer io.Closer
+	// Clock times the lease, it defaults to the system time if nil.
+	Clock Clock
+
+	mu       sync.Mutex
+	file     *os.File
+	acquired time.Time
+	active   int
+	// yieldUntil is the end of the turn left to other processes after a
+	// slice.
+	yieldUntil time.Time
+	idleTimer  Timer
+}
+
+// Middleware implements Middleware.
+func (l *BusLease) Middleware(request *Request, next Sender) (response *Response, err error) {
+	if err = l.acquire(); err != nil {
+		return
+	}
+	defer l.done()
+	return next(request)
+}
+
+// Held returns true if the lease is held by this process.
+func (l *BusLease) Held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file != nil
+}
+
+// Release releases the lease if it is held and no request is in progress.
+func (l *BusLease) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active > 0 {
+		return nil
+	}
+	return l.release()
+}
+
+// acquire acquires the lease, waiting for the other processes to release
+// it.
+func (l *BusLease) acquire() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+		l.idleTimer = nil
+	}
+	if l.file == nil {
+		if err = l.lock(); err != nil {
+			return
+		}
+		l.acquired = clockOrSystem(l.Clock).Now()
+	}
+	l.active++
+	return
+}
+
+// lock waits for the lock of the file. Caller must hold the mutex.
+func (l *BusLease) lock() (err error) {
+	clock := clockOrSystem(l.Clock)
+	if wait := l.yieldUntil.Sub(clock.Now()); wait > 0 {
+		clock.Sleep(wait)
+	}
+	file, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return fmt.Errorf("modbus: opening bus lease '%v' failed: %v", l.Path, err)
+	}
+	start := clock.Now()
+	for {
+		var locked bool
+		if locked, err = lockFile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("modbus: locking bus lease '%v' failed: %v", l.Path, err)
+		}
+		if locked {
+			l.file = file
+			return
+		}
+		if l.Timeout > 0 && clock.Now().Sub(start) >= l.Timeout {
+			file.Close()
+			return fmt.Errorf("modbus: bus lease '%v' not acquired within '%v'", l.Path, l.Timeout)
+		}
+		clock.Sleep(busLeasePoll)
+	}
+}
+
+// done releases the lease after a request, now if the slice is over,
+// otherwise once idle.
+func (l *BusLease) done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active--; l.active > 0 {
+		return
+	}
+	clock := clockOrSystem(l.Clock)
+	if l.Slice > 0 && clock.Now().Sub(l.acquired) >= l.Slice {
+		l.release()
+		// Other processes poll the lock
+		l.yieldUntil = clock.Now().Add(2 * busLeasePoll)
+		return
+	}
+	if l.Idle <= 0 {
+		l.release()
+		return
+	}
+	l.idleTimer = clock.AfterFunc(l.Idle, l.releaseIdle)
+}
+
+// releaseIdle releases the lease if no request started since the idle
+// timer was set.
+func (l *BusLease) releaseIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == 0 && l.idleTimer != nil {
+		l.idleTimer = nil
+		l.release()
+	}
+}
+
+// release closes Closer and unlocks the file. Caller must hold the mutex.
+func (l *BusLease) release() (err error) {
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+		l.idleTimer = nil
+	}
+	if l.file == nil {
+		return
+	}
+	// The port is closed before other processes open it
+	if l.Closer != nil {
+		err = l.Closer.Close()
+	}
+	unlockFile(l.file)
+	if e := l.file.Close(); err == nil {
+		err = e
+	}
+	l.file = nil
+	return
+}
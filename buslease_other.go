@@ -0,0 +1,22 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func lockFile(file *os.File) (locked bool, err error) {
+	err = fmt.Errorf("modbus: bus lease is not supported on '%v'", runtime.GOOS)
+	return
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// closeCounter counts the calls of Close.
+type closeCounter int
+
+func (c *closeCounter) Close() error {
+	*c++
+	return nil
+}
+
+func TestBusLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus.lock")
+	var closed closeCounter
+	poller := &BusLease{Path: path, Idle: time.Hour, Closer: &closed}
+	tool := &BusLease{Path: path, Timeout: 30 * time.Millisecond}
+	defer poller.Release()
+	sent := 0
+	next := func(request *Request) (*Response, error) {
+		sent++
+		return &Response{PDU: request.PDU}, nil
+	}
+	request := &Request{PDU: &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters}}
+
+	if _, err := poller.Middleware(request, next); err != nil {
+		t.Fatal(err)
+	}
+	if !poller.Held() {
+		t.Fatal("lease released while idle")
+	}
+	_, err := tool.Middleware(request, next)
+	if err == nil || err.Error() != "modbus: bus lease '"+path+"' not acquired within '30ms'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = poller.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if poller.Held() || closed != 1 {
+		t.Fatalf("lease not released, closed %v", closed)
+	}
+	if _, err = tool.Middleware(request, next); err != nil {
+		t.Fatal(err)
+	}
+	if tool.Held() || sent != 2 {
+		t.Fatalf("lease held after request, sent %v", sent)
+	}
+}
+
+func TestBusLeaseSlice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus.lock")
+	lease := &BusLease{Path: path, Idle: time.Hour, Slice: 20 * time.Millisecond}
+	defer lease.Release()
+	next := func(request *Request) (*Response, error) {
+		return &Response{PDU: request.PDU}, nil
+	}
+	request := &Request{PDU: &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters}}
+
+	if _, err := lease.Middleware(request, next); err != nil {
+		t.Fatal(err)
+	}
+	if !lease.Held() {
+		t.Fatal("lease released within its slice")
+	}
+	time.Sleep(lease.Slice)
+	if _, err := lease.Middleware(request, next); err != nil {
+		t.Fatal(err)
+	}
+	if lease.Held() {
+		t.Fatal("lease held after its slice")
+	}
+}
+
+func TestBusLeaseIdle(t *testing.T) {
+	lease := &BusLease{Path: filepath.Join(t.TempDir(), "bus.lock"), Idle: 10 * time.Millisecond}
+	next := func(request *Request) (*Response, error) {
+		return &Response{PDU: request.PDU}, nil
+	}
+	if _, err := lease.Middleware(&Request{PDU: &ProtocolDataUnit{}}, next); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for lease.Held() {
+		if time.Now().After(deadline) {
+			t.Fatal("lease not released when idle")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package modbus
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes the exclusive lock of file, it returns false if another
+// open file holds it.
+func lockFile(file *os.File) (locked bool, err error) {
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
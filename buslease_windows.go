@@ -0,0 +1,46 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	leaseKernel32    = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = leaseKernel32.NewProc("LockFileEx")
+	procUnlockFileEx = leaseKernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = 33
+)
+
+// lockFile takes the exclusive lock of the first byte of file, it returns
+// false if another handle holds it.
+func lockFile(file *os.File) (locked bool, err error) {
+	var overlapped syscall.Overlapped
+	r, _, e := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return true, nil
+	}
+	if e == syscall.Errno(errorLockViolation) || e == syscall.ERROR_IO_PENDING {
+		return false, nil
+	}
+	return false, e
+}
+
+func unlockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	r, _, e := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return e
+	}
+	return nil
+}
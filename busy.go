@@ -0,0 +1,102 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"time"
+)
+
+// BusyRetry handles the Slave Device Busy (0x06) and Acknowledge (0x05)
+// exceptions of devices processing long-running commands, instead of
+// returning them to the caller. It is used as a Middleware:
+//  retry := &modbus.BusyRetry{Delay: 500 * time.Millisecond, Timeout: time.Minute}
+//  client := modbus.NewMiddlewareClient(handler, retry.Middleware)
+// Requests responded with Slave Device Busy are sent again after Delay.
+// Requests responded with Acknowledge are sent again as well, unless
+// PollComplete is set.
+type BusyRetry struct {
+	// Delay between attempts, one second if zero.
+	Delay time.Duration
+	// MaxAttempts is the maximum number of requests sent, including the
+	// first one and polls. Zero means no limit.
+	MaxAttempts int
+	// Timeout is the maximum time spent waiting for the device. Zero
+	// means no limit, MaxAttempts or Timeout should be set.
+	Timeout time.Duration
+	// PollComplete is called every Delay after a request is acknowledged
+	// until it returns true, e.g. to read a status register of the device
+	// with next. The response to the request is then the echo of the
+	// request for writes, other requests are sent again.
+	PollComplete func(next Sender) (complete bool, err error)
+	// Clock defaults to the system time if nil.
+	Clock Clock
+}
+
+// Middleware implements Middleware.
+func (mb *BusyRetry) Middleware(request *Request, next Sender) (response *Response, err error) {
+	clock := clockOrSystem(mb.Clock)
+	delay := mb.Delay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = clock.Now().Add(mb.Timeout)
+	}
+	attempts := 0
+	// wait returns an error if the device can not be waited for longer.
+	wait := func(exceptionCode byte) error {
+		attempts++
+		if (mb.MaxAttempts > 0 && attempts >= mb.MaxAttempts) ||
+			(!deadline.IsZero() && !clock.Now().Add(delay).Before(deadline)) {
+			return fmt.Errorf("modbus: device still not ready after '%v' attempts: %w", attempts,
+				&ModbusError{FunctionCode: request.FunctionCode | 0x80, ExceptionCode: exceptionCode})
+		}
+		clock.Sleep(delay)
+		return nil
+	}
+	for {
+		if response, err = next(request); err != nil {
+			return
+		}
+		switch response.ExceptionCode {
+		case ExceptionCodeServerDeviceBusy:
+		case ExceptionCodeAcknowledge:
+			if mb.PollComplete != nil {
+				return mb.poll(request, next, wait)
+			}
+		default:
+			return
+		}
+		if err = wait(response.ExceptionCode); err != nil {
+			response = nil
+			return
+		}
+	}
+}
+
+// poll calls PollComplete until the acknowledged request is complete.
+func (mb *BusyRetry) poll(request *Request, next Sender, wait func(byte) error) (response *Response, err error) {
+	for {
+		if err = wait(ExceptionCodeAcknowledge); err != nil {
+			return
+		}
+		var complete bool
+		if complete, err = mb.PollComplete(next); err != nil {
+			return
+		}
+		if complete {
+			break
+		}
+	}
+	switch request.FunctionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister:
+		return DecodeResponse(request, broadcastResponse(request.PDU))
+	}
+	return next(request)
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// busyServer responds with exceptionCode to the first busy requests.
+func busyServer(exceptionCode byte, busy int, requests *int) func(*ProtocolDataUnit) *ProtocolDataUnit {
+	return func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		*requests++
+		if *requests <= busy {
+			return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{exceptionCode}}
+		}
+		return serveRegisters(request)
+	}
+}
+
+func TestBusyRetry(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	requests := 0
+	retry := &BusyRetry{Delay: 100 * time.Millisecond, MaxAttempts: 5, Clock: clock}
+	client := NewMiddlewareClient(&pduHandler{serve: busyServer(ExceptionCodeServerDeviceBusy, 3, &requests)}, retry.Middleware)
+
+	results, err := client.ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0, 10}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if requests != 4 || clock.now != time.Unix(0, 0).Add(300*time.Millisecond) {
+		t.Fatalf("unexpected requests %v at %v", requests, clock.now)
+	}
+
+	requests = 0
+	retry.MaxAttempts = 3
+	_, err = client.ReadHoldingRegisters(10, 1)
+	if err == nil || !strings.Contains(err.Error(), "after '3' attempts") {
+		t.Fatalf("attempts error expected, actual %v", err)
+	}
+	var mbError *ModbusError
+	if !errors.As(err, &mbError) || mbError.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("busy exception expected, actual %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+
+	requests = 0
+	retry.MaxAttempts = 0
+	retry.Timeout = 250 * time.Millisecond
+	if _, err = client.ReadHoldingRegisters(10, 1); err == nil {
+		t.Fatalf("timeout error expected")
+	}
+	if requests != 3 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
+
+func TestBusyRetryAcknowledge(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	requests := 0
+	polls := 0
+	retry := &BusyRetry{
+		Delay: time.Second,
+		Clock: clock,
+		PollComplete: func(next Sender) (bool, error) {
+			polls++
+			return polls == 3, nil
+		},
+	}
+	client := NewMiddlewareClient(&pduHandler{serve: busyServer(ExceptionCodeAcknowledge, 1, &requests)}, retry.Middleware)
+
+	results, err := client.WriteMultipleRegisters(100, 1, []byte{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0, 1}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if requests != 1 || polls != 3 || clock.now != time.Unix(3, 0) {
+		t.Fatalf("unexpected requests %v and polls %v at %v", requests, polls, clock.now)
+	}
+
+	requests, polls = 0, 0
+	retry.PollComplete = nil
+	if _, err = client.WriteMultipleRegisters(100, 1, []byte{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CachingClient wraps a Client and keeps the last value read or written
+// at each address, so that dashboards re-reading the same registers do not
+// load the bus:
+//  cached := modbus.NewCachingClient(client, time.Second)
+//  cached.Events = events
+//  results, err := cached.ReadHoldingRegisters(0, 10)
+// Reads are served from the cache if all the values requested are younger
+// than TTL, otherwise the whole range is read from the device. Writes are
+// sent to the device and stored on success. Values which change are
+// published as EventValueChanged.
+type CachingClient struct {
+	Client
+
+	// TTL is the age of values after which they are read again.
+	TTL time.Duration
+	// Source is set in the events published.
+	Source string
+	// Events receives value change events, if set.
+	Events *EventBus
+	// Clock ages the values, it defaults to the system time if nil.
+	Clock Clock
+
+	mu      sync.Mutex
+	values  map[cacheKey]cacheEntry
+	pending map[uint16]uint16
+}
+
+type cacheKey struct {
+	table   Table
+	address uint16
+}
+
+type cacheEntry struct {
+	value uint16
+	time  time.Time
+}
+
+// NewCachingClient allocates a new CachingClient wrapping client.
+func NewCachingClient(client Client, ttl time.Duration) *CachingClient {
+	return &CachingClient{
+		Client:  client,
+		TTL:     ttl,
+		values:  make(map[cacheKey]cacheEntry),
+		pending: make(map[uint16]uint16),
+	}
+}
+
+// ReadCoils reads coils, see CachingClient.
+func (mb *CachingClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return mb.readBits(TableCoils, address, quantity, mb.Client.ReadCoils)
+}
+
+// ReadDiscreteInputs reads discrete inputs, see CachingClient.
+func (mb *CachingClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return mb.readBits(TableDiscreteInputs, address, quantity, mb.Client.ReadDiscreteInputs)
+}
+
+// ReadHoldingRegisters reads holding registers, see CachingClient.
+func (mb *CachingClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.readRegisters(TableHoldingRegisters, address, quantity, mb.Client.ReadHoldingRegisters)
+}
+
+// ReadInputRegisters reads input registers, see CachingClient.
+func (mb *CachingClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.readRegisters(TableInputRegisters, address, quantity, mb.Client.ReadInputRegisters)
+}
+
+// WriteSingleCoil writes a coil and stores its value.
+func (mb *CachingClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	if results, err = mb.Client.WriteSingleCoil(address, value); err != nil {
+		return
+	}
+	var bit uint16
+	if value == 0xFF00 {
+		bit = 1
+	}
+	mb.store(TableCoils, address, []uint16{bit})
+	return
+}
+
+// WriteMultipleCoils writes coils and stores their values.
+func (mb *CachingClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.Client.WriteMultipleCoils(address, quantity, value); err != nil {
+		return
+	}
+	mb.store(TableCoils, address, unpackCached(value, quantity))
+	return
+}
+
+// WriteSingleRegister writes a holding register and stores its value.
+func (mb *CachingClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	if results, err = mb.Client.WriteSingleRegister(address, value); err != nil {
+		return
+	}
+	mb.store(TableHoldingRegisters, address, []uint16{value})
+	return
+}
+
+// WriteMultipleRegisters writes holding registers and stores their values.
+func (mb *CachingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.Client.WriteMultipleRegisters(address, quantity, value); err != nil {
+		return
+	}
+	mb.store(TableHoldingRegisters, address, registerValues(value))
+	return
+}
+
+// MaskWriteRegister modifies a holding register, whose value is read again
+// on the next read.
+func (mb *CachingClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	results, err = mb.Client.MaskWriteRegister(address, andMask, orMask)
+	mb.invalidate(TableHoldingRegisters, address, 1)
+	return
+}
+
+// ReadWriteMultipleRegisters writes and reads holding registers and stores
+// the values read.
+func (mb *CachingClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	results, err = mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	if err != nil {
+		mb.invalidate(TableHoldingRegisters, writeAddress, writeQuantity)
+		return
+	}
+	mb.store(TableHoldingRegisters, writeAddress, registerValues(value))
+	mb.store(TableHoldingRegisters, readAddress, registerValues(results))
+	return
+}
+
+// QueueRegisters queues writes of holding registers starting at address,
+// sent by Flush. Values queued for the same address replace each other.
+func (mb *CachingClient) QueueRegisters(address uint16, values ...uint16) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for i, v := range values {
+		mb.pending[address+uint16(i)] = v
+	}
+}
+
+// Flush writes the queued holding registers, contiguous registers in one
+// request of up to 123 registers. Registers of failed requests stay
+// queued.
+func (mb *CachingClient) Flush() (err error) {
+	mb.mu.Lock()
+	addresses := make([]int, 0, len(mb.pending))
+	for address := range mb.pending {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	var blocks [][]uint16
+	var starts []uint16
+	for i, address := range addresses {
+		if i == 0 || address != addresses[i-1]+1 || len(blocks[len(blocks)-1]) == 123 {
+			starts = append(starts, uint16(address))
+			blocks = append(blocks, nil)
+		}
+		blocks[len(blocks)-1] = append(blocks[len(blocks)-1], mb.pending[uint16(address)])
+	}
+	mb.mu.Unlock()
+
+	for i, block := range blocks {
+		if _, e := mb.WriteMultipleRegisters(starts[i], uint16(len(block)), dataBlock(block...)); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		mb.mu.Lock()
+		for j, v := range block {
+			if mb.pending[starts[i]+uint16(j)] == v {
+				delete(mb.pending, starts[i]+uint16(j))
+			}
+		}
+		mb.mu.Unlock()
+	}
+	return
+}
+
+// Invalidate removes all values from the cache.
+func (mb *CachingClient) Invalidate() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.values = make(map[cacheKey]cacheEntry)
+}
+
+func (mb *CachingClient) readBits(table Table, address, quantity uint16, read func(address, quantity uint16) ([]byte, error)) (results []byte, err error) {
+	if values, ok := mb.cached(table, address, quantity); ok {
+		results = make([]byte, (int(quantity)+7)/8)
+		for i, v := range values {
+			if v != 0 {
+				results[i/8] |= 1 << uint(i%8)
+			}
+		}
+		return
+	}
+	if results, err = read(address, quantity); err != nil {
+		return
+	}
+	mb.store(table, address, unpackCached(results, quantity))
+	return
+}
+
+func (mb *CachingClient) readRegisters(table Table, address, quantity uint16, read func(address, quantity uint16) ([]byte, error)) (results []byte, err error) {
+	if values, ok := mb.cached(table, address, quantity); ok {
+		results = dataBlock(values...)
+		return
+	}
+	if results, err = read(address, quantity); err != nil {
+		return
+	}
+	mb.store(table, address, registerValues(results))
+	return
+}
+
+// cached returns the values of the range if they are all younger than
+// TTL.
+func (mb *CachingClient) cached(table Table, address, quantity uint16) (values []uint16, ok bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	now := clockOrSystem(mb.Clock).Now()
+	values = make([]uint16, quantity)
+	for i := range values {
+		entry, found := mb.values[cacheKey{table, address + uint16(i)}]
+		if !found || now.Sub(entry.time) >= mb.TTL {
+			return nil, false
+		}
+		values[i] = entry.value
+	}
+	return values, true
+}
+
+// store stores values starting at address and publishes the changes.
+func (mb *CachingClient) store(table Table, address uint16, values []uint16) {
+	var events []Event
+	mb.mu.Lock()
+	now := clockOrSystem(mb.Clock).Now()
+	for i, v := range values {
+		key := cacheKey{table, address + uint16(i)}
+		if old, found := mb.values[key]; found && old.value != v {
+			events = append(events, Event{
+				Type:     EventValueChanged,
+				Time:     now,
+				Source:   mb.Source,
+				Table:    table,
+				Address:  key.address,
+				Value:    v,
+				Previous: old.value,
+			})
+		}
+		mb.values[key] = cacheEntry{v, now}
+	}
+	mb.mu.Unlock()
+	for _, event := range events {
+		mb.Events.Publish(event)
+	}
+}
+
+func (mb *CachingClient) invalidate(table Table, address, quantity uint16) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for i := 0; i < int(quantity); i++ {
+		delete(mb.values, cacheKey{table, address + uint16(i)})
+	}
+}
+
+// unpackCached returns quantity bits of data as 0 or 1.
+func unpackCached(data []byte, quantity uint16) []uint16 {
+	values := make([]uint16, 0, quantity)
+	for i := 0; i < int(quantity) && i/8 < len(data); i++ {
+		values = append(values, uint16(data[i/8]>>uint(i%8)&1))
+	}
+	return values
+}
@@ -0,0 +1,84 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCachingClient(t *testing.T) {
+	memory := &memoryClient{}
+	memory.holding[10] = 1
+	memory.holding[11] = 2
+	clock := &simClock{now: time.Unix(0, 0)}
+	var events EventBus
+	var changes []Event
+	events.Subscribe(func(event Event) { changes = append(changes, event) })
+	client := NewCachingClient(memory, time.Second)
+	client.Events = &events
+	client.Clock = clock
+
+	read := func(expected ...byte) {
+		t.Helper()
+		results, err := client.ReadHoldingRegisters(10, 2)
+		if err != nil || !reflect.DeepEqual(results, expected) {
+			t.Fatalf("unexpected results %v, error %v", results, err)
+		}
+	}
+	read(0, 1, 0, 2)
+	read(0, 1, 0, 2)
+	if memory.requests != 1 {
+		t.Fatalf("unexpected requests %v", memory.requests)
+	}
+	// Expired values are read again
+	memory.holding[11] = 3
+	clock.Sleep(time.Second)
+	read(0, 1, 0, 3)
+	if memory.requests != 2 || len(changes) != 1 {
+		t.Fatalf("unexpected requests %v, changes %v", memory.requests, changes)
+	}
+	if c := changes[0]; c.Type != EventValueChanged || c.Table != TableHoldingRegisters || c.Address != 11 || c.Value != 3 || c.Previous != 2 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	// Writes are stored
+	if _, err := client.WriteMultipleRegisters(10, 1, []byte{0, 9}); err != nil {
+		t.Fatal(err)
+	}
+	read(0, 9, 0, 3)
+	if memory.requests != 3 || len(changes) != 2 {
+		t.Fatalf("unexpected requests %v, changes %v", memory.requests, changes)
+	}
+
+	// Coils
+	memory.coils[3] = true
+	if results, err := client.ReadCoils(0, 5); err != nil || !reflect.DeepEqual(results, []byte{0x08}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if results, err := client.ReadCoils(2, 2); err != nil || !reflect.DeepEqual(results, []byte{0x02}) || memory.requests != 4 {
+		t.Fatalf("unexpected results %v, error %v, requests %v", results, err, memory.requests)
+	}
+
+	// Queued writes
+	client.QueueRegisters(20, 1, 2)
+	client.QueueRegisters(22, 3)
+	client.QueueRegisters(30, 4)
+	client.QueueRegisters(20, 5)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if memory.requests != 6 || !reflect.DeepEqual(memory.holding[20:23], []uint16{5, 2, 3}) || memory.holding[30] != 4 {
+		t.Fatalf("unexpected requests %v, registers %v", memory.requests, memory.holding[20:31])
+	}
+	if err := client.Flush(); err != nil || memory.requests != 6 {
+		t.Fatalf("unexpected requests %v, error %v", memory.requests, err)
+	}
+	client.Invalidate()
+	read(0, 9, 0, 3)
+	if memory.requests != 7 {
+		t.Fatalf("unexpected requests %v", memory.requests)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+)
+
+// Capabilities is the support matrix of a remote device.
+type Capabilities struct {
+	// FunctionCodes contains probed function codes, true if supported.
+	FunctionCodes map[byte]bool
+
+	// Maximum quantities accepted by the device in one request,
+	// zero if the function is not supported.
+	MaxReadCoils            uint16
+	MaxReadDiscreteInputs   uint16
+	MaxReadHoldingRegisters uint16
+	MaxReadInputRegisters   uint16
+}
+
+// Supports returns true if the function code has been probed and is
+// supported by the device.
+func (c *Capabilities) Supports(functionCode byte) bool {
+	return c.FunctionCodes[functionCode]
+}
+
+// CapabilityProber probes the function codes and maximum quantities a
+// device supports and caches the result.
+// Support is detected by issuing requests and analysing exception
+// responses: Illegal Function means the function code is not supported,
+// Illegal Data Address or Illegal Data Value mean the quantity is too big.
+type CapabilityProber struct {
+	Client Client
+	// Address is the starting address of all probing requests.
+	Address uint16
+	// ProbeWrites enables probing of write function codes by writing back
+	// the values which have just been read. It is disabled by default as
+	// writes may have side effects on some devices.
+	ProbeWrites bool
+
+	mu           sync.Mutex
+	capabilities *Capabilities
+}
+
+// NewCapabilityProber allocates a new CapabilityProber for the client.
+func NewCapabilityProber(client Client) *CapabilityProber {
+	return &CapabilityProber{Client: client}
+}
+
+// Capabilities returns the cached support matrix, probing the device on
+// the first call.
+func (mb *CapabilityProber) Capabilities() (capabilities *Capabilities, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.capabilities != nil {
+		capabilities = mb.capabilities
+		return
+	}
+	if capabilities, err = mb.probe(); err != nil {
+		return
+	}
+	mb.capabilities = capabilities
+	return
+}
+
+// Reset clears the cached support matrix so that the next call to
+// Capabilities probes the device again.
+func (mb *CapabilityProber) Reset() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.capabilities = nil
+}
+
+func (mb *CapabilityProber) probe() (c *Capabilities, err error) {
+	c = &Capabilities{FunctionCodes: make(map[byte]bool)}
+	reads := []struct {
+		functionCode byte
+		limit        uint16
+		read         func(address, quantity uint16) ([]byte, error)
+		max          *uint16
+	}{
+		{FuncCodeReadCoils, 2000, mb.Client.ReadCoils, &c.MaxReadCoils},
+		{FuncCodeReadDiscreteInputs, 2000, mb.Client.ReadDiscreteInputs, &c.MaxReadDiscreteInputs},
+		{FuncCodeReadHoldingRegisters, 125, mb.Client.ReadHoldingRegisters, &c.MaxReadHoldingRegisters},
+		{FuncCodeReadInputRegisters, 125, mb.Client.ReadInputRegisters, &c.MaxReadInputRegisters},
+	}
+	for _, r := range reads {
+		var accepted bool
+		read := func(quantity uint16) (bool, error) {
+			_, err := r.read(mb.Address, quantity)
+			return probeResult(err)
+		}
+		_, err = r.read(mb.Address, 1)
+		c.FunctionCodes[r.functionCode] = !isIllegalFunction(err)
+		if accepted, err = probeResult(err); err != nil {
+			return
+		}
+		if !accepted {
+			continue
+		}
+		if *r.max, err = probeMaxQuantity(r.limit, read); err != nil {
+			return
+		}
+	}
+	if mb.ProbeWrites {
+		err = mb.probeWrites(c)
+	}
+	return
+}
+
+// probeWrites writes back the current values, which leaves the device
+// state unchanged.
+func (mb *CapabilityProber) probeWrites(c *Capabilities) (err error) {
+	if c.MaxReadHoldingRegisters > 0 {
+		var value []byte
+		if value, err = mb.Client.ReadHoldingRegisters(mb.Address, 1); err != nil {
+			return
+		}
+		if len(value) < 2 {
+			return
+		}
+		register := uint16(value[0])<<8 | uint16(value[1])
+		_, err = mb.Client.WriteSingleRegister(mb.Address, register)
+		if err = c.probed(FuncCodeWriteSingleRegister, err); err != nil {
+			return
+		}
+		_, err = mb.Client.WriteMultipleRegisters(mb.Address, 1, value[:2])
+		if err = c.probed(FuncCodeWriteMultipleRegisters, err); err != nil {
+			return
+		}
+		// Masks which keep the register untouched
+		_, err = mb.Client.MaskWriteRegister(mb.Address, 0xFFFF, 0x0000)
+		if err = c.probed(FuncCodeMaskWriteRegister, err); err != nil {
+			return
+		}
+		_, err = mb.Client.ReadWriteMultipleRegisters(mb.Address, 1, mb.Address, 1, value[:2])
+		if err = c.probed(FuncCodeReadWriteMultipleRegisters, err); err != nil {
+			return
+		}
+	}
+	if c.MaxReadCoils > 0 {
+		var value []byte
+		if value, err = mb.Client.ReadCoils(mb.Address, 1); err != nil {
+			return
+		}
+		if len(value) < 1 {
+			return
+		}
+		coil := uint16(0x0000)
+		if value[0]&1 != 0 {
+			coil = 0xFF00
+		}
+		_, err = mb.Client.WriteSingleCoil(mb.Address, coil)
+		if err = c.probed(FuncCodeWriteSingleCoil, err); err != nil {
+			return
+		}
+		_, err = mb.Client.WriteMultipleCoils(mb.Address, 1, value[:1])
+		if err = c.probed(FuncCodeWriteMultipleCoils, err); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// probed records the support of function code according to the result of
+// the probing request. Errors other than exceptions are returned.
+func (c *Capabilities) probed(functionCode byte, err error) error {
+	if _, e := probeResult(err); e != nil {
+		return e
+	}
+	c.FunctionCodes[functionCode] = !isIllegalFunction(err)
+	return nil
+}
+
+// probeMaxQuantity finds the biggest accepted quantity using binary search.
+func probeMaxQuantity(limit uint16, read func(quantity uint16) (bool, error)) (uint16, error) {
+	low, high := uint16(1), limit
+	for low < high {
+		mid := low + (high-low+1)/2
+		ok, err := read(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low, nil
+}
+
+// probeResult classifies result of a probing request. Exception responses
+// about the function, address or value mean the request is not accepted,
+// other errors are returned.
+func probeResult(err error) (accepted bool, e error) {
+	if err == nil {
+		accepted = true
+		return
+	}
+	var mbError *ModbusError
+	if errors.As(err, &mbError) {
+		switch mbError.ExceptionCode {
+		case ExceptionCodeIllegalFunction,
+			ExceptionCodeIllegalDataAddress,
+			ExceptionCodeIllegalDataValue:
+			return
+		}
+	}
+	e = err
+	return
+}
+
+func isIllegalFunction(err error) bool {
+	var mbError *ModbusError
+	return errors.As(err, &mbError) && mbError.ExceptionCode == ExceptionCodeIllegalFunction
+}
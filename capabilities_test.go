@@ -0,0 +1,90 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// limitedClient supports holding registers up to 50 per request and
+// coils, but not discrete inputs and input registers.
+type limitedClient struct {
+	Client
+	requests int
+}
+
+func (c *limitedClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	return make([]byte, (quantity+7)/8), nil
+}
+
+func (c *limitedClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	return nil, &ModbusError{FunctionCode: 0x82, ExceptionCode: ExceptionCodeIllegalFunction}
+}
+
+func (c *limitedClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	if quantity > 50 {
+		return nil, &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	return make([]byte, 2*quantity), nil
+}
+
+func (c *limitedClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	return nil, &ModbusError{FunctionCode: 0x84, ExceptionCode: ExceptionCodeIllegalFunction}
+}
+
+func TestCapabilityProber(t *testing.T) {
+	client := &limitedClient{}
+	prober := NewCapabilityProber(client)
+	c, err := prober.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Supports(FuncCodeReadCoils) || !c.Supports(FuncCodeReadHoldingRegisters) {
+		t.Fatalf("unexpected unsupported function codes: %v", c.FunctionCodes)
+	}
+	if c.Supports(FuncCodeReadDiscreteInputs) || c.Supports(FuncCodeReadInputRegisters) {
+		t.Fatalf("unexpected supported function codes: %v", c.FunctionCodes)
+	}
+	if c.MaxReadCoils != 2000 || c.MaxReadHoldingRegisters != 50 || c.MaxReadInputRegisters != 0 {
+		t.Fatalf("unexpected max quantities: %+v", c)
+	}
+	// Cached
+	requests := client.requests
+	if _, err = prober.Capabilities(); err != nil {
+		t.Fatal(err)
+	}
+	if client.requests != requests {
+		t.Fatalf("capabilities are not cached")
+	}
+}
+
+// gatewayClient answers requests with the gateway exception of an
+// unresponsive target device.
+type gatewayClient struct {
+	Client
+	requests int
+}
+
+func (c *gatewayClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	return nil, responseError(&ProtocolDataUnit{FunctionCode: 0x81, Data: []byte{ExceptionCodeGatewayTargetDeviceFailedToRespond}})
+}
+
+func TestCapabilityProberGatewayException(t *testing.T) {
+	client := &gatewayClient{}
+	prober := NewCapabilityProber(client)
+	if _, err := prober.Capabilities(); !errors.Is(err, ErrGatewayTargetDeviceFailedToRespond) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// The device is probed again once it responds
+	if _, err := prober.Capabilities(); err == nil || client.requests != 2 {
+		t.Fatalf("unexpected error %v, requests %v", err, client.requests)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ChangeReporter decodes the values of a poll group and reports only the
+// tags whose value changed by more than their deadband, e.g. to reduce the
+// messages forwarded to MQTT or a cloud service:
+//  reporter := &modbus.ChangeReporter{Map: registerMap, Deadband: 0.5, Heartbeat: time.Minute}
+//  reporter.Report = func(values map[string]modbus.TagValue, err error) {
+//  	publish(values)
+//  }
+//  err := poller.Add(&modbus.PollGroup{Interval: time.Second, Tags: registerMap.PlanTags(), Handler: reporter.Handle})
+// Deadbands are compared to the last reported value rather than the
+// previous poll, so that they also act as a hysteresis: noise around a value
+// is not reported, slow drifts are reported once they exceed the deadband.
+type ChangeReporter struct {
+	// Map decodes the values of the poll group, tags not in Map are not
+	// reported.
+	Map *RegisterMap
+	// Deadband is the minimum change of values to be reported, any change
+	// is reported if zero.
+	Deadband float64
+	// Deadbands overrides Deadband for the tags by name.
+	Deadbands map[string]float64
+	// Heartbeat is the maximum time without report, all values are
+	// reported when it elapses even if they did not change. Values are
+	// only reported on change if zero.
+	Heartbeat time.Duration
+	// Report is called with the changed values, or with the error of a
+	// failed poll. Errors are reported once, until a poll succeeds again
+	// and all values are reported.
+	Report func(values map[string]TagValue, err error)
+	// Clock defaults to the system time if nil.
+	Clock Clock
+
+	mu       sync.Mutex
+	reported map[string]TagValue
+	// last is the time of the last report.
+	last   time.Time
+	failed bool
+}
+
+// Handle implements the Handler of PollGroup.
+func (r *ChangeReporter) Handle(values map[string][]uint16, err error) {
+	r.mu.Lock()
+	changed, err := r.changes(values, err)
+	r.mu.Unlock()
+	if (changed != nil || err != nil) && r.Report != nil {
+		r.Report(changed, err)
+	}
+}
+
+// Reset forgets the reported values, all values are reported by the next
+// poll, e.g. after the downstream connection is restored.
+func (r *ChangeReporter) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reported = nil
+}
+
+// changes returns the values to report, nil if none. Caller must hold the
+// mutex.
+func (r *ChangeReporter) changes(values map[string][]uint16, err error) (changed map[string]TagValue, _ error) {
+	if err != nil {
+		if r.failed {
+			return nil, nil
+		}
+		r.failed = true
+		r.reported = nil
+		return nil, err
+	}
+	r.failed = false
+	decoded, err := r.Map.Decode(values)
+	if err != nil {
+		r.failed = true
+		r.reported = nil
+		return nil, err
+	}
+	clock := clockOrSystem(r.Clock)
+	now := clock.Now()
+	heartbeat := r.reported == nil || (r.Heartbeat > 0 && now.Sub(r.last) >= r.Heartbeat)
+	if r.reported == nil {
+		r.reported = make(map[string]TagValue, len(decoded))
+	}
+	for name, value := range decoded {
+		if !heartbeat && !r.exceeds(name, value) {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[string]TagValue)
+		}
+		changed[name] = value
+		r.reported[name] = value
+	}
+	if changed != nil {
+		r.last = now
+	}
+	return changed, nil
+}
+
+// exceeds returns true if value of the tag named name changed by more than
+// its deadband since its last report.
+func (r *ChangeReporter) exceeds(name string, value TagValue) bool {
+	reported, ok := r.reported[name]
+	if !ok || reported.Quality != value.Quality || reported.Unit != value.Unit {
+		return true
+	}
+	deadband, ok := r.Deadbands[name]
+	if !ok {
+		deadband = r.Deadband
+	}
+	if math.IsNaN(value.Value) || math.IsNaN(reported.Value) {
+		return math.IsNaN(value.Value) != math.IsNaN(reported.Value)
+	}
+	if deadband <= 0 {
+		return value.Value != reported.Value
+	}
+	return math.Abs(value.Value-reported.Value) > deadband
+}
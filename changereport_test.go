@@ -0,0 +1,79 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeReporter(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	var reports []map[string]float64
+	var errs []error
+	reporter := &ChangeReporter{
+		Map: &RegisterMap{Tags: []TagDef{
+			{Name: "level", Table: TableHoldingRegisters, Address: 0},
+			{Name: "pump", Table: TableCoils, Address: 0},
+		}},
+		Deadband:  5,
+		Deadbands: map[string]float64{"pump": 0},
+		Heartbeat: time.Minute,
+		Report: func(values map[string]TagValue, err error) {
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			report := make(map[string]float64)
+			for name, value := range values {
+				report[name] = value.Value
+			}
+			reports = append(reports, report)
+		},
+		Clock: clock,
+	}
+	polls := []struct {
+		level, pump uint16
+		err         error
+	}{
+		{level: 100},
+		{level: 104},
+		{level: 96},
+		{level: 106},
+		{level: 106, pump: 1},
+		{err: errors.New("timeout")},
+		{err: errors.New("timeout")},
+		{level: 106, pump: 1},
+	}
+	for _, poll := range polls {
+		clock.Sleep(10 * time.Second)
+		if poll.err != nil {
+			reporter.Handle(nil, poll.err)
+			continue
+		}
+		reporter.Handle(map[string][]uint16{"level": {poll.level}, "pump": {poll.pump}}, nil)
+	}
+	expected := []map[string]float64{
+		{"level": 100, "pump": 0},
+		{"level": 106},
+		{"pump": 1},
+		{"level": 106, "pump": 1},
+	}
+	if !reflect.DeepEqual(expected, reports) || len(errs) != 1 {
+		t.Fatalf("unexpected reports %v, errors %v", reports, errs)
+	}
+	// Heartbeat
+	clock.Sleep(time.Minute)
+	reporter.Handle(map[string][]uint16{"level": {107}, "pump": {1}}, nil)
+	if len(reports) != 5 || !reflect.DeepEqual(map[string]float64{"level": 107, "pump": 1}, reports[4]) {
+		t.Fatalf("unexpected heartbeat %v", reports[len(reports)-1])
+	}
+	reporter.Handle(map[string][]uint16{"level": {108}, "pump": {1}}, nil)
+	if len(reports) != 5 {
+		t.Fatalf("unexpected report %v", reports[len(reports)-1])
+	}
+}
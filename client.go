@@ -5,6 +5,7 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 )
@@ -18,6 +19,11 @@ type ClientHandler interface {
 type client struct {
 	packager    Packager
 	transporter Transporter
+	middleware  []Middleware
+	// limits are the limits of the specification if nil.
+	limits *Limits
+	// ctx is passed to ContextTransporter, if not nil.
+	ctx context.Context
 }
 
 // NewClient creates a new modbus client with given backend handler.
@@ -30,6 +36,58 @@ func NewClient2(packager Packager, transporter Transporter) Client {
 	return &client{packager: packager, transporter: transporter}
 }
 
+// WithContext returns a copy of client, created by NewClient, whose
+// requests are interrupted when ctx is done, or fail at the deadline of
+// ctx if it is earlier than the timeout of the handler:
+//  ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+//  defer cancel()
+//  results, err := modbus.WithContext(client, ctx).ReadHoldingRegisters(0, 10)
+// The handler must implement ContextTransporter, as serial handlers do,
+// other clients are returned unchanged. Clients of a batch, see Do, keep
+// the lock of the batch.
+func WithContext(c Client, ctx context.Context) Client {
+	mb, ok := c.(*client)
+	if !ok {
+		return c
+	}
+	clone := *mb
+	clone.ctx = withBatch(ctx, mb.ctx)
+	return &clone
+}
+
+// WithSlaveId returns a copy of client, created by NewClient, whose
+// requests are sent to slaveId instead of the slave id of its handler,
+// e.g. to address the serial slaves behind a Modbus TCP gateway through
+// one handler:
+//  meter := modbus.WithSlaveId(client, 3)
+//  results, err := meter.ReadHoldingRegisters(0, 10)
+// Responses must come from slaveId. Clients of handlers which are not
+// built-in are returned unchanged.
+func WithSlaveId(c Client, slaveId byte) Client {
+	mb, ok := c.(*client)
+	if !ok {
+		return c
+	}
+	packager, ok := mb.packager.(slavePackager)
+	if !ok {
+		return c
+	}
+	clone := *mb
+	clone.packager = packager.withSlaveId(slaveId)
+	return &clone
+}
+
+// slavePackager is implemented by built-in packagers, see WithSlaveId.
+type slavePackager interface {
+	withSlaveId(slaveId byte) Packager
+}
+
+// broadcastPackager is implemented by packagers of requests which can be
+// broadcast to all slaves, with slave id 0.
+type broadcastPackager interface {
+	isBroadcast(aduRequest []byte) bool
+}
+
 // Request:
 //  Function code         : 1 byte (0x01)
 //  Starting address      : 2 bytes
@@ -39,8 +97,7 @@ func NewClient2(packager Packager, transporter Transporter) Client {
 //  Byte count            : 1 byte
 //  Coil status           : N* bytes (=N or N+1)
 func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error) {
-	if quantity < 1 || quantity > 2000 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
+	if err = mb.checkReadRange(FuncCodeReadCoils, address, quantity); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -70,8 +127,7 @@ func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error
 //  Byte count            : 1 byte
 //  Input status          : N* bytes (=N or N+1)
 func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
-	if quantity < 1 || quantity > 2000 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
+	if err = mb.checkReadRange(FuncCodeReadDiscreteInputs, address, quantity); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -101,8 +157,7 @@ func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte,
 //  Byte count            : 1 byte
 //  Register value        : Nx2 bytes
 func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
-	if quantity < 1 || quantity > 125 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
+	if err = mb.checkReadRange(FuncCodeReadHoldingRegisters, address, quantity); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -132,8 +187,7 @@ func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte
 //  Byte count            : 1 byte
 //  Input registers       : N bytes
 func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
-	if quantity < 1 || quantity > 125 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
+	if err = mb.checkReadRange(FuncCodeReadInputRegisters, address, quantity); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -242,8 +296,7 @@ func (mb *client) WriteSingleRegister(address, value uint16) (results []byte, er
 //  Starting address      : 2 bytes
 //  Quantity of outputs   : 2 bytes
 func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
-	if quantity < 1 || quantity > 1968 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 1968)
+	if err = mb.checkWrite(FuncCodeWriteMultipleCoils, address, quantity, value); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -284,8 +337,7 @@ func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (re
 //  Starting address      : 2 bytes
 //  Quantity of registers : 2 bytes
 func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
-	if quantity < 1 || quantity > 123 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 123)
+	if err = mb.checkWrite(FuncCodeWriteMultipleRegisters, address, quantity, value); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -371,12 +423,10 @@ func (mb *client) MaskWriteRegister(address, andMask, orMask uint16) (results []
 //  Byte count            : 1 byte
 //  Read registers value  : Nx2 bytes
 func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
-	if readQuantity < 1 || readQuantity > 125 {
-		err = fmt.Errorf("modbus: quantity to read '%v' must be between '%v' and '%v',", readQuantity, 1, 125)
+	if err = mb.checkReadRange(FuncCodeReadWriteMultipleRegisters, readAddress, readQuantity); err != nil {
 		return
 	}
-	if writeQuantity < 1 || writeQuantity > 121 {
-		err = fmt.Errorf("modbus: quantity to write '%v' must be between '%v' and '%v',", writeQuantity, 1, 121)
+	if err = mb.checkWrite(FuncCodeReadWriteMultipleRegisters, writeAddress, writeQuantity, value); err != nil {
 		return
 	}
 	request := ProtocolDataUnit{
@@ -418,9 +468,10 @@ func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
 		err = fmt.Errorf("modbus: response data size '%v' is less than expected '%v'", len(response.Data), 4)
 		return
 	}
+	// Byte count includes the FIFO count but not itself
 	count := int(binary.BigEndian.Uint16(response.Data))
-	if count != (len(response.Data) - 1) {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+	if count != (len(response.Data) - 2) {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-2, count)
 		return
 	}
 	count = int(binary.BigEndian.Uint16(response.Data[2:]))
@@ -436,18 +487,11 @@ func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
 
 // send sends request and checks possible exception in the response.
 func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
-	aduRequest, err := mb.packager.Encode(request)
-	if err != nil {
-		return
-	}
-	aduResponse, err := mb.transporter.Send(aduRequest)
-	if err != nil {
-		return
-	}
-	if err = mb.packager.Verify(aduRequest, aduResponse); err != nil {
-		return
+	if len(mb.middleware) > 0 {
+		response, err = mb.sendMiddleware(request)
+	} else {
+		response, err = mb.roundTrip(request)
 	}
-	response, err = mb.packager.Decode(aduResponse)
 	if err != nil {
 		return
 	}
@@ -464,6 +508,72 @@ func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, e
 	return
 }
 
+// roundTrip encodes and sends request and decodes the response.
+func (mb *client) roundTrip(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	err = mb.exchange(request, func(r *ProtocolDataUnit, pooled bool) error {
+		response = r
+		if pooled {
+			// Data must outlive the buffer
+			response.Data = append([]byte(nil), response.Data...)
+		}
+		return nil
+	})
+	return
+}
+
+// exchange encodes and sends request and passes the decoded response to
+// handle. The frames of built-in handlers are encoded and received into
+// buffers of aduPool, the data of the response is then pooled and only
+// valid until handle returns.
+func (mb *client) exchange(request *ProtocolDataUnit, handle func(response *ProtocolDataUnit, pooled bool) error) (err error) {
+	transporter, pooled := mb.transporter.(bufferTransporter)
+	pooled = pooled && transporter.buffered() == mb.transporter
+	var aduRequest []byte
+	if encoder, ok := mb.packager.(bufferEncoder); ok && pooled {
+		buf := aduPool.Get().(*aduBuffer)
+		defer aduPool.Put(buf)
+		aduRequest, err = encoder.encodeTo(buf[:0], request)
+	} else {
+		aduRequest, err = mb.packager.Encode(request)
+	}
+	if err != nil {
+		return
+	}
+	var aduResponse []byte
+	if pooled {
+		ctx := mb.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		buf := aduPool.Get().(*aduBuffer)
+		defer aduPool.Put(buf)
+		aduResponse, err = transporter.sendBuffer(ctx, buf, aduRequest)
+	} else if transporter, ok := mb.transporter.(ContextTransporter); ok && mb.ctx != nil {
+		aduResponse, err = transporter.SendContext(mb.ctx, aduRequest)
+	} else {
+		aduResponse, err = mb.transporter.Send(aduRequest)
+	}
+	if err != nil {
+		return
+	}
+	if len(aduResponse) == 0 {
+		// Broadcast requests are not responded
+		if packager, ok := mb.packager.(broadcastPackager); ok && packager.isBroadcast(aduRequest) {
+			return handle(broadcastResponse(request), false)
+		}
+		err = fmt.Errorf("modbus: empty response to function '%v' which is not broadcast", request.FunctionCode)
+		return
+	}
+	if err = mb.packager.Verify(aduRequest, aduResponse); err != nil {
+		return
+	}
+	response, err := mb.packager.Decode(aduResponse)
+	if err != nil {
+		return
+	}
+	return handle(response, pooled)
+}
+
 // dataBlock creates a sequence of uint16 data.
 func dataBlock(value ...uint16) []byte {
 	data := make([]byte, 2*len(value))
@@ -485,10 +595,27 @@ func dataBlockSuffix(suffix []byte, value ...uint16) []byte {
 	return data
 }
 
+// broadcastResponse returns the response a device would have sent to the
+// broadcast write request.
+func broadcastResponse(request *ProtocolDataUnit) *ProtocolDataUnit {
+	response := &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: request.Data}
+	switch request.FunctionCode {
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		if len(request.Data) >= 4 {
+			response.Data = request.Data[:4]
+		}
+	}
+	return response
+}
+
 func responseError(response *ProtocolDataUnit) error {
 	mbError := &ModbusError{FunctionCode: response.FunctionCode}
 	if response.Data != nil && len(response.Data) > 0 {
 		mbError.ExceptionCode = response.Data[0]
 	}
+	switch mbError.ExceptionCode {
+	case ExceptionCodeGatewayPathUnavailable, ExceptionCodeGatewayTargetDeviceFailedToRespond:
+		return &GatewayError{*mbError}
+	}
 	return mbError
 }
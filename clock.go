@@ -0,0 +1,46 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"time"
+)
+
+// Clock provides time to the transporters, the poller and the other timing
+// sensitive types, which use package time if their Clock is nil. A
+// simulated clock tests timeouts, retries and schedules deterministically
+// and faster than real time. Deadlines of network connections always use
+// the system time.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After sends the time on the returned channel once d elapsed.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d elapsed, unless the
+	// returned timer is stopped before.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer of Clock.AfterFunc, see time.Timer.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// systemClock implements Clock using package time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                            { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                     { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// clockOrSystem returns clock, or the system clock if clock is nil.
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
@@ -0,0 +1,287 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// jobFile is the content of a batch job file:
+//  devices:
+//    plc:
+//      url: tcp://192.168.1.10:502
+//      slave: 1
+//      timeout: 2s
+//  steps:
+//    - name: set mode
+//      device: plc
+//      write: holding
+//      address: 10
+//      values: [1, 2]
+//    - name: check mode
+//      device: plc
+//      read: holding
+//      address: 10
+//      quantity: 2
+//      expect: [1, 2]
+type jobFile struct {
+	Devices map[string]*deviceConfig `yaml:"devices"`
+	Steps   []*jobStep               `yaml:"steps"`
+}
+
+// jobStep is a read or a write on one device. Tables are coils, discrete,
+// holding and input. Values are register values, or 0 and 1 for coils.
+type jobStep struct {
+	Name     string   `yaml:"name"`
+	Device   string   `yaml:"device"`
+	Read     string   `yaml:"read"`
+	Write    string   `yaml:"write"`
+	Address  uint16   `yaml:"address"`
+	Quantity uint16   `yaml:"quantity"`
+	Values   []uint16 `yaml:"values"`
+	// Expect is compared with values read.
+	Expect []uint16 `yaml:"expect"`
+	// Exception is the expected exception code, if any.
+	Exception byte `yaml:"exception"`
+}
+
+type stepResult struct {
+	step     *jobStep
+	err      error
+	duration time.Duration
+}
+
+func runBatch(args []string) error {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	failFast := flags.Bool("failfast", false, "stop at the first failed step")
+	verbose := flags.Bool("v", false, "log frames sent and received")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: modbus batch [flags] <jobs.yaml>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	job, err := loadJobFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	results, err := job.run(logger, *failFast)
+	if err != nil {
+		return err
+	}
+	if failed := report(os.Stdout, results); failed > 0 {
+		return fmt.Errorf("%d of %d steps failed", failed, len(results))
+	}
+	return nil
+}
+
+func loadJobFile(name string) (*jobFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var job jobFile
+	if err = yaml.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for i, step := range job.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if _, ok := job.Devices[step.Device]; !ok {
+			return nil, fmt.Errorf("%s: %s: unknown device %q", name, step.Name, step.Device)
+		}
+		if (step.Read == "") == (step.Write == "") {
+			return nil, fmt.Errorf("%s: %s: either read or write must be set", name, step.Name)
+		}
+	}
+	return &job, nil
+}
+
+// run executes all steps, connecting to each device once.
+func (job *jobFile) run(logger *log.Logger, failFast bool) (results []*stepResult, err error) {
+	handlers := make(map[string]handler)
+	defer func() {
+		for _, h := range handlers {
+			h.Close()
+		}
+	}()
+	names := make([]string, 0, len(job.Devices))
+	for name := range job.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var h handler
+		if h, err = newHandler(job.Devices[name], logger); err != nil {
+			err = fmt.Errorf("device %s: %v", name, err)
+			return
+		}
+		handlers[name] = h
+	}
+	for _, step := range job.Steps {
+		client := modbus.NewClient(handlers[step.Device])
+		start := time.Now()
+		err := step.run(client)
+		results = append(results, &stepResult{step, err, time.Since(start)})
+		if err != nil && failFast {
+			break
+		}
+	}
+	return
+}
+
+func (step *jobStep) run(client modbus.Client) (err error) {
+	var values []uint16
+	if step.Read != "" {
+		values, err = readTable(client, step.Read, step.Address, step.Quantity)
+	} else {
+		err = writeTable(client, step.Write, step.Address, step.Values)
+	}
+	if step.Exception != 0 {
+		var mbError *modbus.ModbusError
+		if errors.As(err, &mbError) && mbError.ExceptionCode == step.Exception {
+			return nil
+		}
+		if err == nil {
+			return fmt.Errorf("expected exception %d, no exception received", step.Exception)
+		}
+		return fmt.Errorf("expected exception %d: %v", step.Exception, err)
+	}
+	if err != nil {
+		return
+	}
+	if step.Expect != nil {
+		if len(values) != len(step.Expect) {
+			return fmt.Errorf("expected %v, actual %v", step.Expect, values)
+		}
+		for i := range values {
+			if values[i] != step.Expect[i] {
+				return fmt.Errorf("expected %v, actual %v", step.Expect, values)
+			}
+		}
+	}
+	return nil
+}
+
+// readTable reads quantity values from the table. Coils and discrete
+// inputs are returned as 0 or 1.
+func readTable(client modbus.Client, table string, address, quantity uint16) (values []uint16, err error) {
+	if quantity == 0 {
+		quantity = 1
+	}
+	var results []byte
+	switch table {
+	case "coils", "discrete":
+		if table == "coils" {
+			results, err = client.ReadCoils(address, quantity)
+		} else {
+			results, err = client.ReadDiscreteInputs(address, quantity)
+		}
+		if err != nil {
+			return
+		}
+		var bits []bool
+		if bits, err = modbus.UnpackBits(results, quantity); err != nil {
+			return
+		}
+		values = make([]uint16, quantity)
+		for i, bit := range bits {
+			if bit {
+				values[i] = 1
+			}
+		}
+	case "holding", "input":
+		if table == "holding" {
+			results, err = client.ReadHoldingRegisters(address, quantity)
+		} else {
+			results, err = client.ReadInputRegisters(address, quantity)
+		}
+		if err != nil {
+			return
+		}
+		values = make([]uint16, len(results)/2)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(results[2*i:])
+		}
+	default:
+		err = fmt.Errorf("unknown table %q", table)
+	}
+	return
+}
+
+// writeTable writes values to coils or holding registers, using the
+// single write functions for one value.
+func writeTable(client modbus.Client, table string, address uint16, values []uint16) (err error) {
+	if len(values) == 0 {
+		return fmt.Errorf("no values to write")
+	}
+	switch table {
+	case "coils":
+		if len(values) == 1 {
+			var value uint16
+			if values[0] != 0 {
+				value = 0xFF00
+			}
+			_, err = client.WriteSingleCoil(address, value)
+			return
+		}
+		bits := make([]bool, len(values))
+		for i, v := range values {
+			bits[i] = v != 0
+		}
+		var quantity uint16
+		var data []byte
+		if quantity, data, err = modbus.PackBits(bits); err != nil {
+			return
+		}
+		_, err = client.WriteMultipleCoils(address, quantity, data)
+	case "holding":
+		if len(values) == 1 {
+			_, err = client.WriteSingleRegister(address, values[0])
+			return
+		}
+		data := make([]byte, 2*len(values))
+		for i, v := range values {
+			binary.BigEndian.PutUint16(data[2*i:], v)
+		}
+		_, err = client.WriteMultipleRegisters(address, uint16(len(values)), data)
+	default:
+		err = fmt.Errorf("table %q is not writable", table)
+	}
+	return
+}
+
+// report prints results and returns the number of failed steps.
+func report(w io.Writer, results []*stepResult) (failed int) {
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s (%v): %v\n", r.step.Name, r.duration.Round(time.Millisecond), r.err)
+		} else {
+			fmt.Fprintf(w, "PASS  %s (%v)\n", r.step.Name, r.duration.Round(time.Millisecond))
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(results)-failed, failed)
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+const testJob = `
+devices:
+  sim:
+    url: tcp://%s
+    timeout: 1s
+steps:
+  - name: write registers
+    device: sim
+    write: holding
+    address: 10
+    values: [1, 2]
+  - name: read registers
+    device: sim
+    read: holding
+    address: 10
+    quantity: 2
+    expect: [1, 2]
+  - name: write coil
+    device: sim
+    write: coils
+    address: 3
+    values: [1]
+  - name: read coils
+    device: sim
+    read: coils
+    address: 2
+    quantity: 3
+    expect: [0, 1, 1]
+  - name: unsupported
+    device: sim
+    read: input
+    address: 0
+    exception: 1
+`
+
+func TestBatch(t *testing.T) {
+	device := modbustest.NewDevice()
+	device.SetCoils(4, true)
+	device.SetException(modbus.FuncCodeReadInputRegisters, modbus.ExceptionCodeIllegalFunction)
+	server := modbustest.NewServer(device)
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(name, []byte(strings.Replace(testJob, "%s", server.Addr(), 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job, err := loadJobFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := job.run(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if failed := report(&buf, results); failed != 0 {
+		t.Fatalf("unexpected failures:\n%s", buf.String())
+	}
+
+	// Failed assertion
+	job.Steps[1].Expect = []uint16{2, 1}
+	if results, err = job.run(nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].err == nil {
+		t.Fatalf("failed step expected")
+	}
+}
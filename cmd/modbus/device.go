@@ -0,0 +1,97 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// deviceConfig describes how to connect to a device. URL is one of
+//  tcp://host:port
+//  rtu:///dev/ttyUSB0
+//  ascii:///dev/ttyUSB0
+//  rtuovertcp://host:port
+//  asciiovertcp://host:port
+type deviceConfig struct {
+	URL      string        `yaml:"url"`
+	SlaveId  byte          `yaml:"slave"`
+	Timeout  time.Duration `yaml:"timeout"`
+	BaudRate int           `yaml:"baud"`
+	DataBits int           `yaml:"databits"`
+	StopBits int           `yaml:"stopbits"`
+	Parity   string        `yaml:"parity"`
+}
+
+// deviceFlags defines flags for a single device in the flag set.
+func deviceFlags(flags *flag.FlagSet) *deviceConfig {
+	config := &deviceConfig{SlaveId: 1}
+	flags.StringVar(&config.URL, "url", "tcp://localhost:502", "device url")
+	flags.Func("slave", "slave id (default 1)", func(s string) error {
+		id, err := strconv.ParseUint(s, 0, 8)
+		config.SlaveId = byte(id)
+		return err
+	})
+	flags.DurationVar(&config.Timeout, "timeout", 0, "response timeout")
+	flags.IntVar(&config.BaudRate, "baud", 0, "serial baud rate")
+	flags.IntVar(&config.DataBits, "databits", 0, "serial data bits")
+	flags.IntVar(&config.StopBits, "stopbits", 0, "serial stop bits")
+	flags.StringVar(&config.Parity, "parity", "", "serial parity (N, E or O)")
+	return config
+}
+
+// handler is implemented by all client handlers of package modbus.
+type handler interface {
+	modbus.ClientHandler
+	Connect() error
+	Close() error
+}
+
+// newHandler creates a client handler from the configuration.
+func newHandler(config *deviceConfig, logger *log.Logger) (h handler, err error) {
+	i := strings.Index(config.URL, "://")
+	if i < 0 {
+		err = fmt.Errorf("invalid device url %q", config.URL)
+		return
+	}
+	scheme, address := config.URL[:i], config.URL[i+3:]
+	switch scheme {
+	case "tcp":
+		handler := modbus.NewTCPClientHandler(address)
+		handler.SlaveId = config.SlaveId
+		handler.Logger = logger
+		if config.Timeout > 0 {
+			handler.Timeout = config.Timeout
+		}
+		h = handler
+	case "rtuovertcp":
+		handler := modbus.NewRTUOverTCPClientHandler(address)
+		handler.SlaveId = config.SlaveId
+		handler.Logger = logger
+		if config.Timeout > 0 {
+			handler.Timeout = config.Timeout
+		}
+		h = handler
+	case "asciiovertcp":
+		handler := modbus.NewASCIIOverTCPClientHandler(address)
+		handler.SlaveId = config.SlaveId
+		handler.Logger = logger
+		if config.Timeout > 0 {
+			handler.Timeout = config.Timeout
+		}
+		h = handler
+	case "rtu", "ascii":
+		h, err = newSerialHandler(scheme, address, config, logger)
+	default:
+		err = fmt.Errorf("unsupported device url scheme %q", scheme)
+	}
+	return
+}
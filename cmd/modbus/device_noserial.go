@@ -0,0 +1,19 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build modbus_noserial
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// newSerialHandler fails, serial support is left out by the
+// modbus_noserial build tag.
+func newSerialHandler(scheme, address string, config *deviceConfig, logger *log.Logger) (h handler, err error) {
+	err = fmt.Errorf("unsupported device url scheme %q, built without serial support", scheme)
+	return
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package main
+
+import (
+	"log"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+// newSerialHandler creates the client handler of the rtu or ascii scheme.
+func newSerialHandler(scheme, address string, config *deviceConfig, logger *log.Logger) (h handler, err error) {
+	switch scheme {
+	case "rtu":
+		handler := modbus.NewRTUClientHandler(address)
+		handler.SlaveId = config.SlaveId
+		handler.Logger = logger
+		setSerialConfig(&handler.Config, config)
+		h = handler
+	case "ascii":
+		handler := modbus.NewASCIIClientHandler(address)
+		handler.SlaveId = config.SlaveId
+		handler.Logger = logger
+		setSerialConfig(&handler.Config, config)
+		h = handler
+	}
+	return
+}
+
+func setSerialConfig(c *serial.Config, config *deviceConfig) {
+	if config.Timeout > 0 {
+		c.Timeout = config.Timeout
+	}
+	if config.BaudRate > 0 {
+		c.BaudRate = config.BaudRate
+	}
+	if config.DataBits > 0 {
+		c.DataBits = config.DataBits
+	}
+	if config.StopBits > 0 {
+		c.StopBits = config.StopBits
+	}
+	if config.Parity != "" {
+		c.Parity = config.Parity
+	}
+}
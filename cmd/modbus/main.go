@@ -0,0 +1,64 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command modbus is a command line tool for Modbus devices.
+//
+// Usage:
+//  modbus <command> [arguments]
+//
+// Commands:
+//  batch    run reads and writes from a job file and report pass/fail
+//  read     read and print typed values, once or periodically
+//  scan     find the slave ids responding on a bus
+//  soak     exercise a device continuously and report error statistics
+//  write    write coils or typed values to holding registers
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"batch": {runBatch, "run reads and writes from a job file and report pass/fail"},
+	"read":  {runRead, "read and print typed values, once or periodically"},
+	"scan":  {runScan, "find the slave ids responding on a bus"},
+	"soak":  {runSoak, "exercise a device continuously and report error statistics"},
+	"write": {runWrite, "write coils or typed values to holding registers"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "modbus: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "modbus %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: modbus <command> [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].usage)
+	}
+}
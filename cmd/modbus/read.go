@@ -0,0 +1,167 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// readRequest describes a read and how to print its values.
+type readRequest struct {
+	table    string
+	address  uint16
+	quantity uint16
+	typ      string
+	order    string
+	// writeAddress and writeValues make a read/write multiple registers
+	// request if writeValues is not empty.
+	writeAddress uint16
+	writeValues  []uint16
+}
+
+func runRead(args []string) error {
+	flags := flag.NewFlagSet("read", flag.ExitOnError)
+	config := deviceFlags(flags)
+	r := &readRequest{}
+	flags.StringVar(&r.table, "table", "holding", "table to read: coils, discrete, holding, input or fifo")
+	address := flags.Uint("address", 0, "starting address, or fifo pointer address")
+	quantity := flags.Uint("quantity", 1, "number of values to read")
+	flags.StringVar(&r.typ, "type", "uint16", "register value type: hex, uint16, int16, uint32, int32, float32, uint64, int64 or float64")
+	flags.StringVar(&r.order, "order", "abcd", "word order: abcd, badc, cdab or dcba")
+	writeAddress := flags.Uint("write-address", 0, "write address of a read/write multiple registers request")
+	writeValue := flags.String("write-value", "", "comma-separated registers written before reading holding registers")
+	poll := flags.Duration("poll", 0, "poll interval, 0 to read once")
+	verbose := flags.Bool("v", false, "log frames sent and received")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: modbus read [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	r.address, r.quantity, r.writeAddress = uint16(*address), uint16(*quantity), uint16(*writeAddress)
+	if *writeValue != "" {
+		values, err := parseValues("uint16", *writeValue)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if v.(uint64) > 0xFFFF {
+				return fmt.Errorf("invalid register value %v", v)
+			}
+			r.writeValues = append(r.writeValues, uint16(v.(uint64)))
+		}
+	}
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	h, err := newHandler(config, logger)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	client := modbus.NewClient(h)
+	if *poll <= 0 {
+		return r.run(client, os.Stdout)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(*poll)
+	defer ticker.Stop()
+	for {
+		fmt.Fprintf(os.Stdout, "%s\n", time.Now().Format(time.RFC3339Nano))
+		if err = r.run(client, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stdout, "error: %v\n", err)
+		}
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// run reads and prints one value per line, prefixed by its address.
+func (r *readRequest) run(client modbus.Client, w io.Writer) (err error) {
+	size := uint16(1)
+	if r.typ != "hex" && r.table != "coils" && r.table != "discrete" {
+		if size = modbus.TypeQuantity(r.typ); size == 0 {
+			return fmt.Errorf("unsupported type %q", r.typ)
+		}
+	}
+	quantity := r.quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	var results []byte
+	switch r.table {
+	case "coils", "discrete":
+		var values []uint16
+		if values, err = readTable(client, r.table, r.address, quantity); err != nil {
+			return
+		}
+		for i, v := range values {
+			fmt.Fprintf(w, "%d: %d\n", int(r.address)+i, v)
+		}
+		return
+	case "holding":
+		if len(r.writeValues) > 0 {
+			data := make([]byte, 2*len(r.writeValues))
+			for i, v := range r.writeValues {
+				binary.BigEndian.PutUint16(data[2*i:], v)
+			}
+			results, err = client.ReadWriteMultipleRegisters(r.address, quantity*size,
+				r.writeAddress, uint16(len(r.writeValues)), data)
+		} else {
+			results, err = client.ReadHoldingRegisters(r.address, quantity*size)
+		}
+	case "input":
+		results, err = client.ReadInputRegisters(r.address, quantity*size)
+	case "fifo":
+		results, err = client.ReadFIFOQueue(r.address)
+	default:
+		err = fmt.Errorf("unknown table %q", r.table)
+	}
+	if err != nil {
+		return
+	}
+	return printRegisters(w, r.address, results, r.typ, r.order, r.table == "fifo")
+}
+
+// printRegisters prints registers decoded as typ. FIFO values are indexed
+// from 0 instead of being prefixed by their address.
+func printRegisters(w io.Writer, address uint16, results []byte, typ, order string, fifo bool) error {
+	size := 2
+	if typ != "hex" {
+		size = 2 * int(modbus.TypeQuantity(typ))
+	}
+	for i := 0; i+size <= len(results); i += size {
+		index := int(address) + i/2
+		if fifo {
+			index = i / size
+		}
+		if typ == "hex" {
+			fmt.Fprintf(w, "%d: 0x%04x\n", index, binary.BigEndian.Uint16(results[i:]))
+			continue
+		}
+		value, err := modbus.DecodeValue(results[i:i+size], typ, order)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d: %v\n", index, value)
+	}
+	return nil
+}
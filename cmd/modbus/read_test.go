@@ -0,0 +1,44 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+func TestRead(t *testing.T) {
+	device := modbustest.NewDevice()
+	device.SetHoldingRegisters(10, 0x0000, 0x4246, 0xFFFF)
+	device.SetCoils(2, true, false, true)
+	client := modbus.NewClient(modbustest.NewClientHandler(device))
+
+	tests := []struct {
+		request  readRequest
+		expected string
+	}{
+		{readRequest{table: "holding", address: 10, quantity: 1, typ: "float32", order: "cdab"}, "10: 49.5\n"},
+		{readRequest{table: "holding", address: 11, quantity: 2, typ: "hex"}, "11: 0x4246\n12: 0xffff\n"},
+		{readRequest{table: "holding", address: 12, quantity: 1, typ: "int16"}, "12: -1\n"},
+		{readRequest{table: "coils", address: 2, quantity: 3}, "2: 1\n3: 0\n4: 1\n"},
+		{readRequest{table: "holding", address: 20, quantity: 1, typ: "uint16",
+			writeAddress: 20, writeValues: []uint16{7}}, "20: 7\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.request.run(client, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%+v: expected %q, actual %q", test.request, test.expected, buf.String())
+		}
+	}
+	if err := (&readRequest{table: "holding", quantity: 1, typ: "float16"}).run(client, &bytes.Buffer{}); err == nil {
+		t.Fatalf("unsupported type error expected")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func runScan(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	config := deviceFlags(flags)
+	from := flags.Uint("from", 1, "first slave id")
+	to := flags.Uint("to", 247, "last slave id")
+	address := flags.Uint("address", 0, "holding register read from each slave")
+	reportSlaveId := flags.Bool("report", false, "probe with report slave id (function 17) instead of a read, serial lines only")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: modbus scan [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if config.Timeout <= 0 {
+		config.Timeout = 200 * time.Millisecond
+	}
+	if *from > *to || *to > 255 {
+		return fmt.Errorf("invalid slave id range %d-%d", *from, *to)
+	}
+	h, err := newHandler(config, nil)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	scanner := modbus.NewScanner(h)
+	scanner.Address = uint16(*address)
+	if *reportSlaveId {
+		scanner.FunctionCode = modbus.FuncCodeReportSlaveId
+	}
+	found, err := scan(scanner, byte(*from), byte(*to), os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%d slaves found\n", found)
+	return nil
+}
+
+// scan prints the slaves responding, with data or an exception, as they
+// are found.
+func scan(scanner *modbus.Scanner, from, to byte, w io.Writer) (found int, err error) {
+	scanner.Found = func(result *modbus.ScanResult) {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(w, "%d: %v (%v)\n", result.SlaveId, result.Err, result.Latency.Round(time.Millisecond))
+		case result.Data != nil:
+			fmt.Fprintf(w, "%d: ok % x (%v)\n", result.SlaveId, result.Data, result.Latency.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(w, "%d: ok (%v)\n", result.SlaveId, result.Latency.Round(time.Millisecond))
+		}
+	}
+	results, err := scanner.Scan(from, to)
+	found = len(results)
+	return
+}
@@ -0,0 +1,35 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+func TestScan(t *testing.T) {
+	device := modbustest.NewDevice()
+	device.SetException(modbus.FuncCodeReadHoldingRegisters, modbus.ExceptionCodeIllegalDataAddress)
+	server := modbustest.NewServer(device)
+	defer server.Close()
+
+	h, err := newHandler(&deviceConfig{URL: "tcp://" + server.Addr(), Timeout: time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	var buf bytes.Buffer
+	found, err := scan(modbus.NewScanner(h), 1, 2, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 2 || !bytes.Contains(buf.Bytes(), []byte("2: modbus: exception '2'")) {
+		t.Fatalf("unexpected scan result %v:\n%s", found, buf.String())
+	}
+}
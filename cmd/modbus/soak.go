@@ -0,0 +1,251 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+// worstLatencies is the number of slowest requests kept in the report.
+const worstLatencies = 5
+
+func runSoak(args []string) error {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	config := deviceFlags(flags)
+	duration := flags.Duration("duration", time.Hour, "test duration, 0 to run until interrupted")
+	interval := flags.Duration("interval", 100*time.Millisecond, "delay between requests")
+	reportInterval := flags.Duration("report", time.Minute, "interval of intermediate reports, 0 to disable")
+	table := flags.String("read", "holding", "table to read: coils, discrete, holding or input")
+	address := flags.Uint("address", 0, "starting address")
+	quantity := flags.Uint("quantity", 1, "quantity to read")
+	verbose := flags.Bool("v", false, "log frames sent and received")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: modbus soak [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	h, err := newHandler(config, logger)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	client := modbus.NewClient(h)
+	s := newSoakStats()
+	read := func() error {
+		_, err := readTable(client, *table, uint16(*address), uint16(*quantity))
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+	var report <-chan time.Time
+	if *reportInterval > 0 {
+		ticker := time.NewTicker(*reportInterval)
+		defer ticker.Stop()
+		report = ticker.C
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		s.run(read)
+		select {
+		case <-deadline:
+			s.report(os.Stdout)
+			return s.result()
+		case <-interrupt:
+			s.report(os.Stdout)
+			return s.result()
+		case <-report:
+			s.report(os.Stdout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// soakStats collects results of a soak test.
+type soakStats struct {
+	start    time.Time
+	requests int
+	failures int
+	errors   map[string]int
+
+	minLatency   time.Duration
+	maxLatency   time.Duration
+	totalLatency time.Duration
+	worst        []latencySample
+
+	// outageStart is the time of the first failure of the current outage,
+	// zero when the last request succeeded.
+	outageStart   time.Time
+	outages       int
+	totalRecovery time.Duration
+	maxRecovery   time.Duration
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+func newSoakStats() *soakStats {
+	return &soakStats{
+		start:  time.Now(),
+		errors: make(map[string]int),
+	}
+}
+
+// run executes and records one request.
+func (s *soakStats) run(request func() error) {
+	start := time.Now()
+	err := request()
+	s.record(start, time.Since(start), err)
+}
+
+func (s *soakStats) record(at time.Time, latency time.Duration, err error) {
+	s.requests++
+	if err != nil {
+		s.failures++
+		s.errors[errorClass(err)]++
+		if s.outageStart.IsZero() {
+			s.outageStart = at
+			s.outages++
+		}
+		return
+	}
+	if !s.outageStart.IsZero() {
+		recovery := at.Add(latency).Sub(s.outageStart)
+		s.totalRecovery += recovery
+		if recovery > s.maxRecovery {
+			s.maxRecovery = recovery
+		}
+		s.outageStart = time.Time{}
+	}
+	if s.minLatency == 0 || latency < s.minLatency {
+		s.minLatency = latency
+	}
+	if latency > s.maxLatency {
+		s.maxLatency = latency
+	}
+	s.totalLatency += latency
+	if len(s.worst) < worstLatencies || latency > s.worst[len(s.worst)-1].latency {
+		s.worst = append(s.worst, latencySample{at, latency})
+		sort.SliceStable(s.worst, func(i, j int) bool {
+			return s.worst[i].latency > s.worst[j].latency
+		})
+		if len(s.worst) > worstLatencies {
+			s.worst = s.worst[:worstLatencies]
+		}
+	}
+}
+
+// report prints the statistics collected so far.
+func (s *soakStats) report(w io.Writer) {
+	succeeded := s.requests - s.failures
+	fmt.Fprintf(w, "elapsed %v: %d requests, %d failed", time.Since(s.start).Round(time.Second), s.requests, s.failures)
+	if s.requests > 0 {
+		fmt.Fprintf(w, " (%.3f%%)", 100*float64(s.failures)/float64(s.requests))
+	}
+	fmt.Fprintln(w)
+	if succeeded > 0 {
+		fmt.Fprintf(w, "  latency: min %v, avg %v, max %v\n",
+			s.minLatency, s.totalLatency/time.Duration(succeeded), s.maxLatency)
+		for _, sample := range s.worst {
+			fmt.Fprintf(w, "    %v at %s\n", sample.latency, sample.at.Format(time.RFC3339))
+		}
+	}
+	classes := make([]string, 0, len(s.errors))
+	for class := range s.errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "  %-24s %d\n", class+":", s.errors[class])
+	}
+	if s.outages > 0 {
+		recovered := s.outages
+		if !s.outageStart.IsZero() {
+			recovered--
+		}
+		fmt.Fprintf(w, "  outages: %d", s.outages)
+		if recovered > 0 {
+			fmt.Fprintf(w, ", recovery avg %v, max %v",
+				s.totalRecovery/time.Duration(recovered), s.maxRecovery)
+		}
+		if !s.outageStart.IsZero() {
+			fmt.Fprintf(w, ", ongoing since %s", s.outageStart.Format(time.RFC3339))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func (s *soakStats) result() error {
+	if s.failures > 0 {
+		return fmt.Errorf("%d of %d requests failed", s.failures, s.requests)
+	}
+	return nil
+}
+
+// errorClass groups errors for the report.
+func errorClass(err error) string {
+	var mbError *modbus.ModbusError
+	if errors.As(err, &mbError) {
+		name := modbus.ExceptionName(mbError.ExceptionCode)
+		if name == "unknown" {
+			name = strconv.Itoa(int(mbError.ExceptionCode))
+		}
+		return "exception " + name
+	}
+	var checksumError *modbus.ChecksumError
+	if errors.As(err, &checksumError) {
+		return "checksum"
+	}
+	var partialResponse *modbus.PartialResponseError
+	if errors.As(err, &partialResponse) {
+		return "partial response"
+	}
+	if errors.Is(err, serial.ErrTimeout) {
+		return "timeout"
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return "timeout"
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "connection closed"
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) {
+		return "connection " + opError.Op
+	}
+	if strings.HasPrefix(err.Error(), "modbus: response") {
+		return "invalid response"
+	}
+	return "other"
+}
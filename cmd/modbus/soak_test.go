@@ -0,0 +1,67 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+func TestSoakStats(t *testing.T) {
+	s := newSoakStats()
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+	s.record(at, 10*ms, nil)
+	s.record(at.Add(1*time.Second), 500*ms, serial.ErrTimeout)
+	s.record(at.Add(2*time.Second), 500*ms, serial.ErrTimeout)
+	s.record(at.Add(3*time.Second), 20*ms, nil)
+	s.record(at.Add(4*time.Second), 5*ms, &modbus.ModbusError{FunctionCode: 0x83, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy})
+	s.record(at.Add(5*time.Second), 30*ms, nil)
+
+	if s.requests != 6 || s.failures != 3 {
+		t.Fatalf("unexpected requests %v, failures %v", s.requests, s.failures)
+	}
+	if s.errors["timeout"] != 2 || s.errors["exception server device busy"] != 1 {
+		t.Fatalf("unexpected errors: %v", s.errors)
+	}
+	if s.minLatency != 10*ms || s.maxLatency != 30*ms {
+		t.Fatalf("unexpected latencies: min %v, max %v", s.minLatency, s.maxLatency)
+	}
+	if s.outages != 2 || s.maxRecovery != 2020*ms || s.totalRecovery != 3050*ms {
+		t.Fatalf("unexpected outages %v, max recovery %v, total %v", s.outages, s.maxRecovery, s.totalRecovery)
+	}
+	var buf bytes.Buffer
+	s.report(&buf)
+	if !strings.Contains(buf.String(), "outages: 2, recovery avg 1.525s, max 2.02s") {
+		t.Fatalf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{serial.ErrTimeout, "timeout"},
+		{io.EOF, "connection closed"},
+		{&modbus.ChecksumError{Checksum: "crc", Received: 1, Expected: 2}, "checksum"},
+		{&modbus.PartialResponseError{Received: 3, Err: serial.ErrTimeout}, "partial response"},
+		{fmt.Errorf("modbus: response data size '1' does not match count '2'"), "invalid response"},
+		{&modbus.ModbusError{ExceptionCode: 0x55}, "exception 85"},
+		{modbus.ErrGatewayTargetDeviceFailedToRespond, "exception gateway target device failed to respond"},
+	}
+	for _, test := range tests {
+		if class := errorClass(test.err); class != test.class {
+			t.Errorf("%v: expected class %q, actual %q", test.err, test.class, class)
+		}
+	}
+}
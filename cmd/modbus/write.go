@@ -0,0 +1,152 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goburrow/modbus"
+)
+
+func runWrite(args []string) error {
+	flags := flag.NewFlagSet("write", flag.ExitOnError)
+	config := deviceFlags(flags)
+	w := &writeRequest{}
+	flags.StringVar(&w.table, "table", "holding", "table to write: coils or holding")
+	address := flags.Uint("address", 0, "starting address")
+	flags.StringVar(&w.typ, "type", "uint16", "register value type: uint16, int16, uint32, int32, float32, uint64, int64 or float64")
+	flags.StringVar(&w.order, "order", "abcd", "word order: abcd, badc, cdab or dcba")
+	value := flags.String("value", "", "comma-separated values to write, 0 or 1 for coils")
+	flags.BoolVar(&w.single, "single", false, "write a single coil or register (function 5 or 6)")
+	andMask := flags.Int("and", -1, "AND mask of a mask write register request (function 22)")
+	orMask := flags.Uint("or", 0, "OR mask of a mask write register request")
+	verbose := flags.Bool("v", false, "log frames sent and received")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: modbus write [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	w.address = uint16(*address)
+	if *andMask >= 0 {
+		w.mask = true
+		w.andMask, w.orMask = uint16(*andMask), uint16(*orMask)
+	} else {
+		typ := w.typ
+		if w.table == "coils" {
+			typ = "uint16"
+		}
+		var err error
+		if w.values, err = parseValues(typ, *value); err != nil {
+			return err
+		}
+	}
+	var logger *log.Logger
+	if *verbose {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	h, err := newHandler(config, logger)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	return w.run(modbus.NewClient(h))
+}
+
+// writeRequest describes a write of typed values.
+type writeRequest struct {
+	table   string
+	address uint16
+	typ     string
+	order   string
+	values  []interface{}
+	single  bool
+	// mask makes a mask write register request.
+	mask    bool
+	andMask uint16
+	orMask  uint16
+}
+
+func (w *writeRequest) run(client modbus.Client) (err error) {
+	switch {
+	case w.mask:
+		if w.table != "holding" {
+			return fmt.Errorf("table %q does not support mask write", w.table)
+		}
+		_, err = client.MaskWriteRegister(w.address, w.andMask, w.orMask)
+	case w.table == "coils":
+		bits := make([]bool, len(w.values))
+		for i, v := range w.values {
+			bits[i] = v.(uint64) != 0
+		}
+		if w.single {
+			if len(bits) != 1 {
+				return fmt.Errorf("single write requires one value")
+			}
+			var value uint16
+			if bits[0] {
+				value = 0xFF00
+			}
+			_, err = client.WriteSingleCoil(w.address, value)
+			return
+		}
+		var quantity uint16
+		var data []byte
+		if quantity, data, err = modbus.PackBits(bits); err != nil {
+			return
+		}
+		_, err = client.WriteMultipleCoils(w.address, quantity, data)
+	case w.table == "holding":
+		if w.single {
+			if len(w.values) != 1 || modbus.TypeQuantity(w.typ) != 1 {
+				return fmt.Errorf("single write requires one 16-bit value")
+			}
+			var data []byte
+			if data, err = modbus.EncodeValue(w.values[0], w.typ, w.order); err != nil {
+				return
+			}
+			_, err = client.WriteSingleRegister(w.address, binary.BigEndian.Uint16(data))
+			return
+		}
+		_, err = modbus.WriteValues(client, w.address, w.typ, w.order, w.values...)
+	default:
+		err = fmt.Errorf("table %q is not writable", w.table)
+	}
+	return
+}
+
+// parseValues parses comma-separated values of the register type, keeping
+// the precision of 64-bit integers.
+func parseValues(typ, s string) (values []interface{}, err error) {
+	if strings.TrimSpace(s) == "" {
+		err = fmt.Errorf("no values to write")
+		return
+	}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		var value interface{}
+		switch {
+		case strings.HasPrefix(typ, "float"):
+			value, err = strconv.ParseFloat(field, 64)
+		case strings.HasPrefix(typ, "uint"):
+			value, err = strconv.ParseUint(field, 0, 64)
+		default:
+			value, err = strconv.ParseInt(field, 0, 64)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid %s value %q", typ, field)
+			return
+		}
+		values = append(values, value)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+func TestWriteValues(t *testing.T) {
+	device := modbustest.NewDevice()
+	client := modbus.NewClient(modbustest.NewClientHandler(device))
+
+	values, err := parseValues("float32", "49.5, -1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = modbus.WriteValues(client, 10, "float32", "cdab", values...); err != nil {
+		t.Fatal(err)
+	}
+	registers := device.HoldingRegisters(10, 4)
+	if registers[0] != 0 || registers[1] != 0x4246 || registers[2] != 0 || registers[3] != 0xBF80 {
+		t.Fatalf("unexpected registers %x", registers)
+	}
+
+	if _, err = parseValues("uint16", "1,x"); err == nil {
+		t.Fatalf("parse error expected")
+	}
+	if values, err = parseValues("int64", "-9223372036854775808"); err != nil || values[0] != int64(-9223372036854775808) {
+		t.Fatalf("unexpected values %v, error %v", values, err)
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	device := modbustest.NewDevice()
+	client := modbus.NewClient(modbustest.NewClientHandler(device))
+
+	requests := []writeRequest{
+		{table: "coils", address: 1, values: []interface{}{uint64(1), uint64(0), uint64(1)}},
+		{table: "coils", address: 5, values: []interface{}{uint64(1)}, single: true},
+		{table: "holding", address: 1, typ: "int16", values: []interface{}{int64(-2)}, single: true},
+		{table: "holding", address: 2, mask: true, andMask: 0x00F0, orMask: 0x0001},
+	}
+	device.SetHoldingRegisters(2, 0x1234)
+	for _, request := range requests {
+		if err := request.run(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coils := device.Coils(1, 5)
+	if !coils[0] || coils[1] || !coils[2] || coils[3] || !coils[4] {
+		t.Fatalf("unexpected coils %v", coils)
+	}
+	if registers := device.HoldingRegisters(1, 2); registers[0] != 0xFFFE || registers[1] != 0x0031 {
+		t.Fatalf("unexpected registers %x", registers)
+	}
+	if err := (&writeRequest{table: "holding", typ: "float32", values: []interface{}{1.0}, single: true}).run(client); err == nil {
+		t.Fatalf("single write error expected")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnLimits restricts the clients of a TCP server, as Modbus servers
+// often end up reachable from whole plant networks:
+//  server := modbus.NewServer(store)
+//  server.Allow = []string{"10.1.0.0/16"}
+//  server.MaxConnsPerIP = 2
+//  server.IdleTimeout = time.Minute
+//  server.FrameTimeout = time.Second
+// Connections which are not allowed or exceed the limits are closed when
+// accepted, without reading them, and counted by Rejected. Zero values do
+// not restrict.
+type ConnLimits struct {
+	// Allow, if not empty, accepts only the clients of its IP addresses
+	// or CIDR blocks, and Deny rejects those of its own, even if allowed.
+	// They are read when serving starts.
+	Allow []string
+	Deny  []string
+	// MaxConns bounds the connections served at the same time and
+	// MaxConnsPerIP those of each client address.
+	MaxConns      int
+	MaxConnsPerIP int
+	// IdleTimeout closes connections without request for that long.
+	IdleTimeout time.Duration
+	// FrameTimeout closes connections not sending the rest of a request
+	// within that time after its first byte, or not reading the response,
+	// so that slow clients do not hold connections.
+	FrameTimeout time.Duration
+
+	mu       sync.Mutex
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	conns    int
+	ipConns  map[string]int
+	rejected atomic.Uint64
+}
+
+// Rejected returns the number of connections rejected.
+func (l *ConnLimits) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+// parseLimits parses Allow and Deny.
+func (l *ConnLimits) parseLimits() (err error) {
+	allow, err := parseIPNets(l.Allow)
+	if err != nil {
+		return
+	}
+	deny, err := parseIPNets(l.Deny)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.allow, l.deny = allow, deny
+	l.mu.Unlock()
+	return
+}
+
+// parseIPNets parses IP addresses and CIDR blocks.
+func parseIPNets(addresses []string) (nets []*net.IPNet, err error) {
+	for _, address := range addresses {
+		if strings.Contains(address, "/") {
+			var ipNet *net.IPNet
+			if _, ipNet, err = net.ParseCIDR(address); err != nil {
+				err = fmt.Errorf("modbus: invalid CIDR block '%v'", address)
+				return
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			err = fmt.Errorf("modbus: invalid IP address '%v'", address)
+			return
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+	}
+	return
+}
+
+// admit returns the host of the client of conn if it is allowed and within
+// the limits, it must be released when the connection is closed.
+func (l *ConnLimits) admit(conn net.Conn) (host string, err error) {
+	host = conn.RemoteAddr().String()
+	if h, _, e := net.SplitHostPort(host); e == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case len(l.allow) > 0 && !containsIP(l.allow, ip):
+		err = fmt.Errorf("modbus: client '%v' is not allowed", host)
+	case containsIP(l.deny, ip):
+		err = fmt.Errorf("modbus: client '%v' is denied", host)
+	case l.MaxConns > 0 && l.conns >= l.MaxConns:
+		err = fmt.Errorf("modbus: '%v' connections already served", l.conns)
+	case l.MaxConnsPerIP > 0 && l.ipConns[host] >= l.MaxConnsPerIP:
+		err = fmt.Errorf("modbus: '%v' connections of client '%v' already served", l.ipConns[host], host)
+	}
+	if err != nil {
+		l.rejected.Add(1)
+		return
+	}
+	if l.ipConns == nil {
+		l.ipConns = make(map[string]int)
+	}
+	l.conns++
+	l.ipConns[host]++
+	return
+}
+
+// release releases the connection of host admitted.
+func (l *ConnLimits) release(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns--
+	if l.ipConns[host]--; l.ipConns[host] <= 0 {
+		delete(l.ipConns, host)
+	}
+}
+
+// containsIP returns true if one of nets contains ip, false if ip is nil.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitRequest sets the read deadline of conn waiting for a request.
+func (l *ConnLimits) awaitRequest(conn net.Conn) {
+	if l.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.IdleTimeout))
+	} else if l.FrameTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// receiveFrame sets the read deadline of conn once a request started.
+func (l *ConnLimits) receiveFrame(conn net.Conn) {
+	if l.FrameTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.FrameTimeout))
+	}
+}
+
+// sendFrame sets the write deadline of conn before writing a response, so
+// that the time spent serving the request, e.g. forwarding it to a serial
+// slave, does not count.
+func (l *ConnLimits) sendFrame(conn net.Conn) {
+	if l.FrameTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(l.FrameTimeout))
+	}
+}
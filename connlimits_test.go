@@ -0,0 +1,143 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimitsParse(t *testing.T) {
+	if _, err := parseIPNets([]string{"10.0.0.1", "192.168.0.0/24", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseIPNets([]string{"10.0.0.0/33"}); err == nil || err.Error() != "modbus: invalid CIDR block '10.0.0.0/33'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server := NewServer(NewMemoryStore())
+	server.Deny = []string{"localhost"}
+	if err := server.Serve(nil); err == nil || err.Error() != "modbus: invalid IP address 'localhost'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestServerAllowDeny(t *testing.T) {
+	for _, limits := range []struct {
+		Allow, Deny []string
+	}{
+		{Allow: []string{"10.0.0.0/8"}},
+		{Deny: []string{"127.0.0.1"}},
+		{Allow: []string{"127.0.0.0/8"}, Deny: []string{"127.0.0.1"}},
+	} {
+		server := NewServer(NewMemoryStore())
+		server.Allow, server.Deny = limits.Allow, limits.Deny
+		client := startServer(t, server, 1)
+		if _, err := client.ReadHoldingRegisters(0, 1); err == nil {
+			t.Fatalf("%+v: error expected", limits)
+		}
+		if server.Rejected() != 1 {
+			t.Fatalf("%+v: rejected %v", limits, server.Rejected())
+		}
+	}
+	server := NewServer(NewMemoryStore())
+	server.Allow = []string{"127.0.0.1", "::1"}
+	client := startServer(t, server, 1)
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerMaxConnsPerIP(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	server.MaxConnsPerIP = 1
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	first := NewTCPClientHandler(listener.Addr().String())
+	first.Timeout = time.Second
+	defer first.Close()
+	if _, err = NewClient(first).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	second := NewTCPClientHandler(listener.Addr().String())
+	second.Timeout = time.Second
+	defer second.Close()
+	if _, err = NewClient(second).ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+	if server.Rejected() != 1 {
+		t.Fatalf("rejected %v", server.Rejected())
+	}
+	// The first client is still served
+	if _, err = NewClient(first).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	server.IdleTimeout = 50 * time.Millisecond
+	server.FrameTimeout = 20 * time.Millisecond
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	for _, request := range [][]byte{
+		// Idle
+		nil,
+		// Slow header
+		{0, 1, 0},
+	} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		conn.SetReadDeadline(start.Add(time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%v: connection not closed, error %v", request, err)
+		}
+		conn.Close()
+		limit := server.IdleTimeout
+		if len(request) > 0 {
+			limit = server.FrameTimeout
+		}
+		if elapsed := time.Since(start); elapsed < limit || elapsed >= 10*limit {
+			t.Fatalf("%v: connection closed after %v", request, elapsed)
+		}
+	}
+}
+
+// slowHandler delays reads of holding registers.
+type slowHandler struct {
+	*MemoryStore
+	delay time.Duration
+}
+
+func (h *slowHandler) OnReadHoldingRegisters(unitId byte, address, quantity uint16) ([]uint16, error) {
+	time.Sleep(h.delay)
+	return h.MemoryStore.OnReadHoldingRegisters(unitId, address, quantity)
+}
+
+func TestServerFrameTimeoutSlowHandler(t *testing.T) {
+	server := NewServer(&slowHandler{NewMemoryStore(), 60 * time.Millisecond})
+	server.FrameTimeout = 20 * time.Millisecond
+	client := startServer(t, server, 1)
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatalf("response of slow handler not sent: %v", err)
+	}
+}
@@ -17,3 +17,21 @@ func TestCRC(t *testing.T) {
 		t.Fatalf("crc expected %v, actual %v", 0x1241, crc.value())
 	}
 }
+
+func TestCRC16(t *testing.T) {
+	// Read holding registers request of the specification
+	if crc := CRC16([]byte{0x01, 0x03, 0x00, 0x6B, 0x00, 0x03}); crc != 0x1774 {
+		t.Fatalf("crc expected %x, actual %x", 0x1774, crc)
+	}
+	if crc := CRC16(nil); crc != 0xFFFF {
+		t.Fatalf("crc expected %x, actual %x", 0xFFFF, crc)
+	}
+}
+
+func BenchmarkCRC16(b *testing.B) {
+	data := make([]byte, rtuMaxSize-2)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		CRC16(data)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+const detectTimeout = 200 * time.Millisecond
+
+// SerialDetector finds the serial settings of a RTU slave by probing it
+// with each combination of candidate baud rates, parities and stop bits
+// until it responds with a frame having a valid CRC:
+//  detector := modbus.NewSerialDetector("/dev/ttyUSB0", 1)
+//  config, err := detector.Detect()
+// An exception response is a valid response, the settings are correct.
+type SerialDetector struct {
+	Address string
+	SlaveId byte
+
+	// Candidates, tried in order. Baud rates are tried first.
+	BaudRates []int
+	Parities  []string
+	StopBits  []int
+	DataBits  int
+	// Timeout of each probe.
+	Timeout time.Duration
+	// Probe sends the request probing the slave, reading holding
+	// register 0 if nil.
+	Probe  func(client Client) error
+	Logger *log.Logger
+
+	// connect opens the port of the handler if set, for tests.
+	connect func(handler *RTUClientHandler) error
+}
+
+// NewSerialDetector allocates a new SerialDetector of the slave with the
+// most common settings as candidates.
+func NewSerialDetector(address string, slaveId byte) *SerialDetector {
+	return &SerialDetector{
+		Address:   address,
+		SlaveId:   slaveId,
+		BaudRates: []int{19200, 9600, 38400, 57600, 115200, 4800, 2400, 1200},
+		Parities:  []string{"E", "N", "O"},
+		StopBits:  []int{1, 2},
+		DataBits:  8,
+		Timeout:   detectTimeout,
+	}
+}
+
+// Detect returns the first serial configuration the slave responds to.
+func (d *SerialDetector) Detect() (config serial.Config, err error) {
+	for _, baudRate := range d.BaudRates {
+		for _, parity := range d.Parities {
+			for _, stopBits := range d.StopBits {
+				config = serial.Config{
+					Address:  d.Address,
+					BaudRate: baudRate,
+					DataBits: d.DataBits,
+					StopBits: stopBits,
+					Parity:   parity,
+					Timeout:  d.Timeout,
+				}
+				var probeErr error
+				if probeErr = d.probe(&config); probeErr == nil {
+					d.logf("modbus: slave id '%v' responds at %v %v%v%v\n", d.SlaveId, baudRate, d.DataBits, parity, stopBits)
+					return
+				}
+				d.logf("modbus: no response at %v %v%v%v: %v\n", baudRate, d.DataBits, parity, stopBits, probeErr)
+			}
+		}
+	}
+	config = serial.Config{}
+	err = fmt.Errorf("modbus: slave id '%v' on '%v' did not respond to any serial configuration", d.SlaveId, d.Address)
+	return
+}
+
+// probe returns nil if the slave responds with the configuration.
+func (d *SerialDetector) probe(config *serial.Config) (err error) {
+	handler := NewRTUClientHandler(d.Address)
+	handler.Config = *config
+	handler.SlaveId = d.SlaveId
+	handler.IdleTimeout = 0
+	if d.connect != nil {
+		err = d.connect(handler)
+	} else {
+		err = handler.Connect()
+	}
+	if err != nil {
+		return
+	}
+	defer handler.Close()
+
+	client := NewClient(handler)
+	if d.Probe != nil {
+		err = d.Probe(client)
+	} else {
+		_, err = client.ReadHoldingRegisters(0, 1)
+	}
+	var mbError *ModbusError
+	if errors.As(err, &mbError) {
+		err = nil
+	}
+	return
+}
+
+func (d *SerialDetector) logf(format string, v ...interface{}) {
+	if d.Logger != nil {
+		d.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"testing"
+)
+
+func TestSerialDetector(t *testing.T) {
+	detector := NewSerialDetector("sim", 1)
+	attempts := 0
+	detector.connect = func(handler *RTUClientHandler) error {
+		attempts++
+		slave := rtuSlave(ExceptionCodeIllegalDataAddress)
+		if handler.BaudRate != 9600 || handler.Parity != "N" || handler.StopBits != 2 {
+			// Settings mismatch garble the response
+			slave = func(request []byte) []byte {
+				return []byte{0x01, 0x83, 0x55, 0xAA, 0x12}
+			}
+		}
+		line := newSimLine(handler.BaudRate, slave)
+		line.timeout = handler.Timeout
+		handler.port = line
+		handler.Clock = line.clock
+		return nil
+	}
+	config, err := detector.Detect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BaudRate != 9600 || config.Parity != "N" || config.StopBits != 2 || config.DataBits != 8 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	// 19200 with 6 framings, then 9600 E1, E2, N1, N2
+	if attempts != 10 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+
+	detector.BaudRates = []int{4800}
+	if _, err = detector.Detect(); err == nil {
+		t.Fatalf("detection error expected")
+	}
+
+	// Gateways answering for an unresponsive device are detected
+	detector.connect = func(handler *RTUClientHandler) error {
+		line := newSimLine(handler.BaudRate, rtuSlave(ExceptionCodeGatewayTargetDeviceFailedToRespond))
+		line.timeout = handler.Timeout
+		handler.port = line
+		handler.Clock = line.clock
+		return nil
+	}
+	detector.BaudRates = []int{19200}
+	if config, err = detector.Detect(); err != nil {
+		t.Fatal(err)
+	}
+	if config.BaudRate != 19200 || config.Parity != "E" || config.StopBits != 1 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+)
+
+// Device reads and writes the tags of a register map by name, so that
+// applications do not depend on the addresses of the device:
+//  m, err := modbus.LoadRegisterMap(file)
+//  device, err := modbus.NewDevice(client, m)
+//  flow, err := device.ReadTag("flow_rate")
+//  err = device.WriteTag("setpoint", 12.5)
+// Values are engineering values, scaled and converted to the unit of the
+// tag as TagDef.Decode does.
+type Device struct {
+	Client Client
+	Map    *RegisterMap
+
+	// plan reads all tags.
+	plan *ReadPlan
+}
+
+// NewDevice validates the register map and returns a device reading its
+// tags with client. Clients of paged register maps are wrapped in a
+// PagedClient.
+func NewDevice(client Client, m *RegisterMap) (d *Device, err error) {
+	if err = m.Validate(); err != nil {
+		return
+	}
+	if m.Paging != nil {
+		client = NewPagedClient(client, *m.Paging)
+	}
+	d = &Device{Client: client, Map: m}
+	planner := ReadPlanner{Paging: m.Paging}
+	if d.plan, err = planner.Plan(m.PlanTags()); err != nil {
+		d = nil
+	}
+	return
+}
+
+// ReadTag reads the value of the tag named name.
+func (d *Device) ReadTag(name string) (value TagValue, err error) {
+	def := d.Map.tag(name)
+	if def == nil {
+		err = fmt.Errorf("modbus: tag '%v' not found", name)
+		return
+	}
+	planner := ReadPlanner{Paging: d.Map.Paging}
+	plan, err := planner.Plan([]Tag{def.planTag()})
+	if err != nil {
+		return
+	}
+	values, err := plan.Read(d.Client)
+	if err != nil {
+		return
+	}
+	return def.Decode(values[name])
+}
+
+// ReadAll reads the values of all tags with as few requests as possible.
+func (d *Device) ReadAll() (values map[string]TagValue, err error) {
+	return d.Map.Read(d.Client, d.plan, false)
+}
+
+// WriteTag writes the value of the tag named name, which must be a coil
+// or holding registers. The value is converted back to the unit and
+// scale of the device and rounded for integer types.
+func (d *Device) WriteTag(name string, value float64) (err error) {
+	def := d.Map.tag(name)
+	if def == nil {
+		return fmt.Errorf("modbus: tag '%v' not found", name)
+	}
+	write, err := def.encodeWrite(value)
+	if err != nil {
+		return
+	}
+	paged, ok := d.Client.(*PagedClient)
+	if ok && paged.Paging.InWindow(def.Address, def.Quantity()) {
+		return paged.Do(def.Page, write)
+	}
+	if ok && paged.Paging.overlaps(def.Address, def.Quantity()) {
+		return fmt.Errorf("modbus: tag '%v' crosses the bounds of the paging window", name)
+	}
+	if def.Page != 0 {
+		return fmt.Errorf("modbus: tag '%v' of page '%v' requires a paged client", name, def.Page)
+	}
+	return write(d.Client)
+}
+
+// encodeWrite returns the request writing the engineering value of the
+// tag.
+func (t *TagDef) encodeWrite(value float64) (write func(client Client) error, err error) {
+	switch t.Table {
+	case TableCoils:
+		state := uint16(0)
+		if value != 0 {
+			state = 0xFF00
+		}
+		write = func(client Client) (err error) {
+			_, err = client.WriteSingleCoil(t.Address, state)
+			return
+		}
+		return
+	case TableHoldingRegisters:
+	default:
+		err = fmt.Errorf("modbus: tag '%v' in %v can not be written", t.Name, t.Table)
+		return
+	}
+	if t.TargetUnit != "" && t.TargetUnit != t.Unit {
+		var transform Transform
+		if transform, err = ConvertUnit(t.Unit, t.TargetUnit); err != nil {
+			err = fmt.Errorf("modbus: tag '%v': %v", t.Name, err)
+			return
+		}
+		value = transform.Write(value)
+	}
+	if t.Scale != 0 {
+		value /= t.Scale
+	}
+	transforms, err := parseTransforms(t.Transforms)
+	if err != nil {
+		err = fmt.Errorf("modbus: tag '%v': %v", t.Name, err)
+		return
+	}
+	for i := len(transforms) - 1; i >= 0; i-- {
+		value = transforms[i].Write(value)
+	}
+	if math.IsNaN(value) {
+		err = fmt.Errorf("modbus: tag '%v' has no raw value for '%v'", t.Name, value)
+		return
+	}
+	typ := t.Type
+	if typ == "" {
+		typ = "uint16"
+	}
+	data, err := EncodeValue(value, typ, t.Order)
+	if err != nil {
+		err = fmt.Errorf("modbus: tag '%v': %v", t.Name, err)
+		return
+	}
+	write = func(client Client) (err error) {
+		if len(data) == 2 {
+			_, err = client.WriteSingleRegister(t.Address, uint16(data[0])<<8|uint16(data[1]))
+		} else {
+			_, err = client.WriteMultipleRegisters(t.Address, uint16(len(data)/2), data)
+		}
+		return
+	}
+	return
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"math"
+	"testing"
+)
+
+// singleWriteClient adds single writes to memoryClient.
+type singleWriteClient struct {
+	*memoryClient
+}
+
+func (c singleWriteClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	c.requests++
+	c.coils[address] = value == 0xFF00
+	return []byte{byte(value >> 8), byte(value)}, nil
+}
+
+func (c singleWriteClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	c.requests++
+	c.holding[address] = value
+	return []byte{byte(value >> 8), byte(value)}, nil
+}
+
+func TestDevice(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "flow_rate", Table: TableHoldingRegisters, Address: 10, Type: "float32", Order: "cdab", Unit: "m3/h"},
+		{Name: "setpoint", Table: TableHoldingRegisters, Address: 12, Type: "int16", Scale: 0.1, Unit: "degC", TargetUnit: "degF"},
+		{Name: "pump", Table: TableCoils, Address: 3},
+		{Name: "level", Table: TableInputRegisters, Address: 0},
+	}}
+	memory := &memoryClient{}
+	device, err := NewDevice(singleWriteClient{memory}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = device.WriteTag("flow_rate", 49.5); err != nil {
+		t.Fatal(err)
+	}
+	if err = device.WriteTag("setpoint", 68); err != nil {
+		t.Fatal(err)
+	}
+	if err = device.WriteTag("pump", 1); err != nil {
+		t.Fatal(err)
+	}
+	if memory.holding[12] != 200 || !memory.coils[3] {
+		t.Fatalf("unexpected memory: %v, %v", memory.holding[12], memory.coils[3])
+	}
+	value, err := device.ReadTag("flow_rate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Value != 49.5 || value.Unit != "m3/h" {
+		t.Fatalf("unexpected flow rate: %+v", value)
+	}
+	if err = device.WriteTag("level", 1); err == nil {
+		t.Fatal("input registers written")
+	}
+	if _, err = device.ReadTag("missing"); err == nil {
+		t.Fatal("missing tag read")
+	}
+
+	m.Tags = m.Tags[:3]
+	if device, err = NewDevice(singleWriteClient{memory}, m); err != nil {
+		t.Fatal(err)
+	}
+	memory.requests = 0
+	values, err := device.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory.requests != 2 {
+		t.Fatalf("unexpected requests: %v", memory.requests)
+	}
+	if math.Abs(values["setpoint"].Value-68) > 1e-9 || values["setpoint"].Unit != "degF" {
+		t.Fatalf("unexpected setpoint: %+v", values["setpoint"])
+	}
+	if values["pump"].Value != 1 || values["flow_rate"].Value != 49.5 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestDevicePaged(t *testing.T) {
+	m := &RegisterMap{
+		Paging: &Paging{Register: 0, WindowAddress: 100, WindowSize: 10},
+		Tags: []TagDef{
+			{Name: "a", Table: TableHoldingRegisters, Address: 100, Page: 2},
+		},
+	}
+	memory := &memoryClient{}
+	device, err := NewDevice(singleWriteClient{memory}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = device.WriteTag("a", 7); err != nil {
+		t.Fatal(err)
+	}
+	if memory.holding[0] != 2 || memory.holding[100] != 7 {
+		t.Fatalf("unexpected memory: %v, %v", memory.holding[0], memory.holding[100])
+	}
+	value, err := device.ReadTag("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Value != 7 {
+		t.Fatalf("unexpected value: %+v", value)
+	}
+}
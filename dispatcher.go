@@ -0,0 +1,187 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by requests rejected because the queue of their
+// priority is full.
+var ErrQueueFull = errors.New("modbus: request queue is full")
+
+// Request priorities of PriorityDispatcher by default.
+const (
+	PriorityRead  = 0
+	PriorityWrite = 10
+)
+
+// PriorityDispatcher sends the requests of the clients sharing a handler
+// one at a time, the waiting request of highest priority first, so that
+// operator writes are not delayed by polling reads on a slow bus. It is
+// used as a Middleware of all the clients:
+//  dispatcher := modbus.NewPriorityDispatcher()
+//  defer dispatcher.Close()
+//  dispatcher.MaxQueued[modbus.PriorityRead] = 10
+//  poller := modbus.NewMiddlewareClient(handler, dispatcher.Middleware)
+//  operator := modbus.NewMiddlewareClient(handler, dispatcher.Middleware)
+// Requests are queued in an AsyncClient and sent from its worker, those
+// of the same priority in the order they arrived.
+type PriorityDispatcher struct {
+	// Priority returns the priority of request, PriorityWrite for writes
+	// and PriorityRead for other requests if nil.
+	Priority func(request *Request) int
+	// MaxQueued limits the number of requests waiting by priority, those
+	// above fail with ErrQueueFull. Priorities not set are not limited.
+	MaxQueued map[int]int
+	// Clock measures the waits, it defaults to the system time if nil. It
+	// must not be changed once requests are sent.
+	Clock Clock
+
+	once  sync.Once
+	async *AsyncClient
+
+	mu sync.Mutex
+	// pending is the number of requests queued or being sent.
+	pending int
+	stats   map[int]*DispatchStats
+}
+
+// DispatchStats are the statistics of a priority of PriorityDispatcher.
+type DispatchStats struct {
+	// Queued is the number of requests currently waiting.
+	Queued int
+	// Sent and Rejected are the number of requests sent and rejected
+	// because the queue was full.
+	Sent     uint64
+	Rejected uint64
+	// Wait is the total and MaxWait the longest time requests waited
+	// before being sent.
+	Wait    time.Duration
+	MaxWait time.Duration
+}
+
+// NewPriorityDispatcher allocates a new PriorityDispatcher.
+func NewPriorityDispatcher() *PriorityDispatcher {
+	return &PriorityDispatcher{
+		MaxQueued: make(map[int]int),
+		stats:     make(map[int]*DispatchStats),
+	}
+}
+
+// Middleware implements Middleware.
+func (d *PriorityDispatcher) Middleware(request *Request, next Sender) (response *Response, err error) {
+	priority := d.priority(request)
+	waiting, err := d.queue(priority)
+	if err != nil {
+		return
+	}
+	start := clockOrSystem(d.Clock).Now()
+	sent := false
+	result := make(chan Result, 1)
+	d.client().Enqueue(func(Client) ([]byte, error) {
+		sent = true
+		d.started(priority, waiting, start)
+		response, err = next(request)
+		return nil, err
+	}, priority, time.Time{}, func(r Result) {
+		result <- r
+	})
+	err = (<-result).Err
+	d.finished(priority, waiting && !sent)
+	return
+}
+
+// Close stops the worker sending the requests, after the queued ones
+// have been sent. Requests dispatched after Close fail with ErrClosed.
+func (d *PriorityDispatcher) Close() error {
+	return d.client().Close()
+}
+
+// Stats returns the statistics by priority.
+func (d *PriorityDispatcher) Stats() map[int]DispatchStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := make(map[int]DispatchStats, len(d.stats))
+	for priority, s := range d.stats {
+		stats[priority] = *s
+	}
+	return stats
+}
+
+func (d *PriorityDispatcher) priority(request *Request) int {
+	if d.Priority != nil {
+		return d.Priority(request)
+	}
+	switch request.FunctionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister, FuncCodeReadWriteMultipleRegisters:
+		return PriorityWrite
+	}
+	return PriorityRead
+}
+
+// client returns the AsyncClient queueing the requests, its client is
+// not used as requests are sent by their own Sender.
+func (d *PriorityDispatcher) client() *AsyncClient {
+	d.once.Do(func() {
+		d.async = NewAsyncClient(nil)
+		d.async.Clock = d.Clock
+	})
+	return d.async
+}
+
+// queue counts a request of priority, it returns whether the request
+// waits for others to be sent or ErrQueueFull.
+func (d *PriorityDispatcher) queue(priority int) (waiting bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats[priority]
+	if stats == nil {
+		stats = &DispatchStats{}
+		d.stats[priority] = stats
+	}
+	if waiting = d.pending > 0; waiting {
+		if max, ok := d.MaxQueued[priority]; ok && stats.Queued >= max {
+			stats.Rejected++
+			return false, ErrQueueFull
+		}
+		stats.Queued++
+	}
+	d.pending++
+	return
+}
+
+// finished uncounts a request of priority, which is still counted as
+// queued if it was not sent.
+func (d *PriorityDispatcher) finished(priority int, queued bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending--
+	if queued {
+		d.stats[priority].Queued--
+	}
+}
+
+// started counts a request of priority being sent and its wait since
+// start, if it was waiting.
+func (d *PriorityDispatcher) started(priority int, waiting bool, start time.Time) {
+	wait := clockOrSystem(d.Clock).Now().Sub(start)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := d.stats[priority]
+	stats.Sent++
+	if !waiting {
+		return
+	}
+	stats.Queued--
+	stats.Wait += wait
+	if wait > stats.MaxWait {
+		stats.MaxWait = wait
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityDispatcher(t *testing.T) {
+	d := NewPriorityDispatcher()
+	defer d.Close()
+	d.MaxQueued[PriorityRead] = 2
+
+	var mu sync.Mutex
+	var order []uint16
+	block := make(chan struct{})
+	send := func(request *Request) (*Response, error) {
+		if request.Address == 0 {
+			<-block
+		}
+		mu.Lock()
+		order = append(order, request.Address)
+		mu.Unlock()
+		return &Response{}, nil
+	}
+	var wg sync.WaitGroup
+	dispatch := func(functionCode byte, address uint16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Middleware(&Request{FunctionCode: functionCode, Address: address}, send)
+		}()
+	}
+	// waitQueued waits until n requests of priority are waiting.
+	waitQueued := func(priority, n int) {
+		for d.Stats()[priority].Queued != n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	dispatch(FuncCodeReadHoldingRegisters, 0)
+	for d.Stats()[PriorityRead].Sent != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	dispatch(FuncCodeReadHoldingRegisters, 1)
+	waitQueued(PriorityRead, 1)
+	dispatch(FuncCodeReadHoldingRegisters, 2)
+	waitQueued(PriorityRead, 2)
+	dispatch(FuncCodeWriteSingleRegister, 3)
+	waitQueued(PriorityWrite, 1)
+	if _, err := d.Middleware(&Request{FunctionCode: FuncCodeReadCoils, Address: 4}, send); err != ErrQueueFull {
+		t.Fatalf("unexpected error %v", err)
+	}
+	close(block)
+	wg.Wait()
+
+	if len(order) != 4 || order[0] != 0 || order[1] != 3 || order[2] != 1 || order[3] != 2 {
+		t.Fatalf("unexpected order %v", order)
+	}
+	stats := d.Stats()
+	if s := stats[PriorityRead]; s.Sent != 3 || s.Rejected != 1 || s.Queued != 0 || s.MaxWait <= 0 {
+		t.Fatalf("unexpected read stats %+v", s)
+	}
+	if s := stats[PriorityWrite]; s.Sent != 1 || s.Rejected != 0 {
+		t.Fatalf("unexpected write stats %+v", s)
+	}
+}
+
+func TestPriorityDispatcherClose(t *testing.T) {
+	d := NewPriorityDispatcher()
+	send := func(request *Request) (*Response, error) {
+		return &Response{}, nil
+	}
+	if response, err := d.Middleware(&Request{FunctionCode: FuncCodeReadCoils}, send); err != nil || response == nil {
+		t.Fatalf("unexpected response %v, error %v", response, err)
+	}
+	d.Close()
+	if _, err := d.Middleware(&Request{FunctionCode: FuncCodeReadCoils}, send); err != ErrClosed {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := d.Stats()[PriorityRead]; s.Sent != 1 || s.Queued != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DutyCycle limits the fraction of time a device is busy with
+// transactions, for battery-powered devices which throttle or brown out
+// under continuous polling. After a transaction lasting d, the next one
+// is delayed until the device has been idle for d*(1-Limit)/Limit.
+// It is used as a Middleware of the client of the device:
+//  duty := modbus.NewDutyCycle(0.3)
+//  client := modbus.NewMiddlewareClient(handler, duty.Middleware)
+// or by a Poller, see Poller.DutyCycle.
+type DutyCycle struct {
+	// Limit is the maximum busy fraction of time, between 0 and 1.
+	Limit float64
+	// Clock defaults to the system time if nil.
+	Clock Clock
+
+	mu sync.Mutex
+	// next is the earliest start of the next transaction.
+	next time.Time
+	// busy is the total time spent in transactions.
+	busy time.Duration
+}
+
+// NewDutyCycle allocates a new DutyCycle with the given limit.
+func NewDutyCycle(limit float64) *DutyCycle {
+	return &DutyCycle{Limit: limit}
+}
+
+// Do waits until the device may be accessed and calls fn. Other users of
+// the DutyCycle wait until fn returns.
+func (mb *DutyCycle) Do(fn func() error) error {
+	if mb.Limit <= 0 || mb.Limit > 1 {
+		return fmt.Errorf("modbus: duty cycle '%v' must be between '%v' and '%v'", mb.Limit, 0, 1)
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	clock := clockOrSystem(mb.Clock)
+	if wait := mb.next.Sub(clock.Now()); wait > 0 {
+		clock.Sleep(wait)
+	}
+	start := clock.Now()
+	err := fn()
+	end := clock.Now()
+	busy := end.Sub(start)
+	mb.busy += busy
+	mb.next = end.Add(time.Duration(float64(busy) * (1 - mb.Limit) / mb.Limit))
+	return err
+}
+
+// Busy returns the total time spent in transactions.
+func (mb *DutyCycle) Busy() time.Duration {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.busy
+}
+
+// Middleware implements Middleware.
+func (mb *DutyCycle) Middleware(request *Request, next Sender) (response *Response, err error) {
+	err = mb.Do(func() (err error) {
+		response, err = next(request)
+		return
+	})
+	return
+}
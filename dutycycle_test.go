@@ -0,0 +1,68 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDutyCycle(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	duty := NewDutyCycle(0.3)
+	duty.Clock = clock
+
+	var starts []time.Duration
+	for i := 0; i < 3; i++ {
+		err := duty.Do(func() error {
+			starts = append(starts, clock.now.Sub(time.Unix(0, 0)))
+			clock.Sleep(30 * time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, start := range starts {
+		if expected := time.Duration(i) * 100 * time.Millisecond; start != expected {
+			t.Fatalf("transaction %v expected to start at %v, actual %v", i, expected, start)
+		}
+	}
+	if busy := duty.Busy(); busy != 90*time.Millisecond {
+		t.Fatalf("unexpected busy time %v", busy)
+	}
+
+	// Time idle counts towards the next transaction.
+	clock.Sleep(time.Second)
+	start := clock.now
+	duty.Do(func() error { return nil })
+	if clock.now != start {
+		t.Fatalf("unexpected wait %v", clock.now.Sub(start))
+	}
+
+	duty.Limit = 0
+	if err := duty.Do(func() error { return nil }); err == nil {
+		t.Fatal("invalid limit error expected")
+	}
+}
+
+func TestDutyCycleMiddleware(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	duty := NewDutyCycle(0.5)
+	duty.Clock = clock
+	client := NewMiddlewareClient(&pduHandler{serve: func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		clock.Sleep(10 * time.Millisecond)
+		return serveRegisters(request)
+	}}, duty.Middleware)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := clock.now.Sub(time.Unix(0, 0)); elapsed != 30*time.Millisecond {
+		t.Fatalf("unexpected elapsed time %v", elapsed)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// EEPROMClient wraps a Client and keeps track of writes to holding
+// registers which are persisted in EEPROM by the remote device.
+// EEPROM cells only survive a limited number of write cycles, so writes
+// to marked registers are counted and rate limited. Registers are marked
+// with Mark or by the EEPROM tags of a register map with MarkRegisterMap.
+type EEPROMClient struct {
+	Client
+
+	// MinWriteInterval is the minimum time between two writes to the same
+	// EEPROM register, unless the register has its own interval. Zero
+	// disables the check.
+	MinWriteInterval time.Duration
+	// Enforce rejects writes violating MinWriteInterval instead of only
+	// logging a warning.
+	Enforce bool
+	// Logger receives warnings about frequent writes.
+	Logger *log.Logger
+	// Clock times the writes, it defaults to the system time if nil.
+	Clock Clock
+
+	mu        sync.Mutex
+	registers map[uint16]*eepromRegister
+}
+
+type eepromRegister struct {
+	writes    uint64
+	lastWrite time.Time
+	// minInterval overrides MinWriteInterval if not zero.
+	minInterval time.Duration
+}
+
+// NewEEPROMClient creates a new EEPROMClient wrapping the given client.
+func NewEEPROMClient(client Client) *EEPROMClient {
+	return &EEPROMClient{
+		Client:    client,
+		registers: make(map[uint16]*eepromRegister),
+	}
+}
+
+// Mark declares quantity registers starting at address as EEPROM-backed.
+func (mb *EEPROMClient) Mark(address, quantity uint16) {
+	mb.mark(address, quantity, 0)
+}
+
+// MarkRegisterMap declares the holding registers of the EEPROM tags of the
+// register map as EEPROM-backed, limited by the MinWriteInterval of their
+// tag. Registers are tracked by address, tags of different pages at the
+// same address share their counts.
+func (mb *EEPROMClient) MarkRegisterMap(m *RegisterMap) {
+	for i := range m.Tags {
+		tag := &m.Tags[i]
+		if tag.EEPROM && tag.Table == TableHoldingRegisters {
+			mb.mark(tag.Address, tag.Quantity(), time.Duration(tag.MinWriteInterval*float64(time.Second)))
+		}
+	}
+}
+
+func (mb *EEPROMClient) mark(address, quantity uint16, minInterval time.Duration) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for i := int(address); i < storeEnd(address, quantity); i++ {
+		r, ok := mb.registers[uint16(i)]
+		if !ok {
+			r = &eepromRegister{}
+			mb.registers[uint16(i)] = r
+		}
+		if minInterval != 0 {
+			r.minInterval = minInterval
+		}
+	}
+}
+
+// IsEEPROM returns true if register at address is EEPROM-backed.
+func (mb *EEPROMClient) IsEEPROM(address uint16) bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	_, ok := mb.registers[address]
+	return ok
+}
+
+// WriteCount returns cumulative number of successful writes to the
+// EEPROM register at address.
+func (mb *EEPROMClient) WriteCount(address uint16) uint64 {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if r, ok := mb.registers[address]; ok {
+		return r.writes
+	}
+	return 0
+}
+
+// WriteSingleRegister checks the write frequency before writing.
+func (mb *EEPROMClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	if err = mb.checkWrite(address, 1); err != nil {
+		return
+	}
+	if results, err = mb.Client.WriteSingleRegister(address, value); err != nil {
+		return
+	}
+	mb.countWrite(address, 1)
+	return
+}
+
+// WriteMultipleRegisters checks the write frequency before writing.
+func (mb *EEPROMClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	if err = mb.checkWrite(address, quantity); err != nil {
+		return
+	}
+	if results, err = mb.Client.WriteMultipleRegisters(address, quantity, value); err != nil {
+		return
+	}
+	mb.countWrite(address, quantity)
+	return
+}
+
+// ReadWriteMultipleRegisters checks the write frequency before writing.
+func (mb *EEPROMClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	if err = mb.checkWrite(writeAddress, writeQuantity); err != nil {
+		return
+	}
+	if results, err = mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value); err != nil {
+		return
+	}
+	mb.countWrite(writeAddress, writeQuantity)
+	return
+}
+
+// MaskWriteRegister checks the write frequency before writing.
+func (mb *EEPROMClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	if err = mb.checkWrite(address, 1); err != nil {
+		return
+	}
+	if results, err = mb.Client.MaskWriteRegister(address, andMask, orMask); err != nil {
+		return
+	}
+	mb.countWrite(address, 1)
+	return
+}
+
+// checkWrite returns error if Enforce is set and one of the registers has
+// been written within its minimum interval.
+func (mb *EEPROMClient) checkWrite(address, quantity uint16) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	now := clockOrSystem(mb.Clock).Now()
+	for i := int(address); i < storeEnd(address, quantity); i++ {
+		r, ok := mb.registers[uint16(i)]
+		if !ok || r.lastWrite.IsZero() {
+			continue
+		}
+		minInterval := r.minInterval
+		if minInterval == 0 {
+			minInterval = mb.MinWriteInterval
+		}
+		elapsed := now.Sub(r.lastWrite)
+		if minInterval <= 0 || elapsed >= minInterval {
+			continue
+		}
+		if mb.Enforce {
+			return fmt.Errorf("modbus: eeprom register '%v' written '%v' ago, minimum interval is '%v'", i, elapsed, minInterval)
+		}
+		mb.logf("modbus: warning: eeprom register '%v' written '%v' ago, minimum interval is '%v'", i, elapsed, minInterval)
+	}
+	return nil
+}
+
+func (mb *EEPROMClient) countWrite(address, quantity uint16) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	now := clockOrSystem(mb.Clock).Now()
+	for i := int(address); i < storeEnd(address, quantity); i++ {
+		if r, ok := mb.registers[uint16(i)]; ok {
+			r.writes++
+			r.lastWrite = now
+		}
+	}
+}
+
+func (mb *EEPROMClient) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Printf(format, v...)
+	}
+}
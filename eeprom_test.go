@@ -0,0 +1,116 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+type writeCountingClient struct {
+	Client
+	writes int
+}
+
+func (c *writeCountingClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	c.writes++
+	return dataBlock(value), nil
+}
+
+func (c *writeCountingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	c.writes++
+	return dataBlock(quantity), nil
+}
+
+func TestEEPROMClientWriteCount(t *testing.T) {
+	inner := &writeCountingClient{}
+	client := NewEEPROMClient(inner)
+	client.Mark(10, 2)
+
+	if _, err := client.WriteMultipleRegisters(9, 3, []byte{0, 1, 0, 2, 0, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleRegister(11, 4); err != nil {
+		t.Fatal(err)
+	}
+	if client.WriteCount(9) != 0 || client.WriteCount(10) != 1 || client.WriteCount(11) != 2 {
+		t.Fatalf("unexpected write counts: %v %v %v", client.WriteCount(9), client.WriteCount(10), client.WriteCount(11))
+	}
+	if !client.IsEEPROM(10) || client.IsEEPROM(12) {
+		t.Fatalf("unexpected eeprom registers")
+	}
+}
+
+func TestEEPROMClientEnforce(t *testing.T) {
+	inner := &writeCountingClient{}
+	client := NewEEPROMClient(inner)
+	client.MinWriteInterval = time.Hour
+	client.Enforce = true
+	client.Mark(1, 1)
+
+	if _, err := client.WriteSingleRegister(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleRegister(1, 2); err == nil {
+		t.Fatal("error expected for frequent eeprom write")
+	}
+	// Non EEPROM registers are not limited
+	if _, err := client.WriteSingleRegister(2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if inner.writes != 2 {
+		t.Fatalf("writes expected %v, actual %v", 2, inner.writes)
+	}
+}
+
+func TestEEPROMClientMarkRegisterMap(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "setpoint", Table: TableHoldingRegisters, Address: 1, Type: "float32", EEPROM: true, MinWriteInterval: 60},
+		{Name: "mode", Table: TableHoldingRegisters, Address: 3, EEPROM: true},
+		{Name: "output", Table: TableHoldingRegisters, Address: 4},
+	}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	clock := &simClock{now: time.Unix(0, 0)}
+	client := NewEEPROMClient(&writeCountingClient{})
+	client.MinWriteInterval = time.Hour
+	client.Enforce = true
+	client.Clock = clock
+	client.MarkRegisterMap(m)
+	if !client.IsEEPROM(1) || !client.IsEEPROM(2) || !client.IsEEPROM(3) || client.IsEEPROM(4) {
+		t.Fatalf("unexpected eeprom registers")
+	}
+	for _, address := range []uint16{2, 3} {
+		if _, err := client.WriteSingleRegister(address, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Sleep(time.Minute)
+	// The setpoint has its own interval, the mode the one of the client
+	if _, err := client.WriteSingleRegister(2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleRegister(3, 2); err == nil {
+		t.Fatal("error expected for frequent eeprom write")
+	}
+	if client.WriteCount(2) != 2 || client.WriteCount(3) != 1 {
+		t.Fatalf("unexpected write counts: %v %v", client.WriteCount(2), client.WriteCount(3))
+	}
+}
+
+func TestEEPROMClientLastRegister(t *testing.T) {
+	client := NewEEPROMClient(&writeCountingClient{})
+	client.Mark(65535, 2)
+	if !client.IsEEPROM(65535) || client.IsEEPROM(0) {
+		t.Fatalf("unexpected eeprom registers")
+	}
+	if _, err := client.WriteMultipleRegisters(65535, 2, []byte{0, 1, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if client.WriteCount(65535) != 1 || client.WriteCount(0) != 0 {
+		t.Fatalf("unexpected write counts: %v %v", client.WriteCount(65535), client.WriteCount(0))
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package encoding converts register slices to and from Go values of one or
+several registers, in the byte and word order of the device:
+
+	results, err := client.ReadHoldingRegisters(100, 2)
+	if err != nil {
+		return err
+	}
+	temperature := encoding.Float32(encoding.Registers(results), encoding.CDAB)
+
+Orders are named by the bytes of a 32-bit value ABCD, A being the most
+significant byte. Word orders apply to all the registers of a value, e.g.
+CDAB reverses the 4 registers of a 64-bit value. Functions panic if the
+slice is too short for the value, as the ones of encoding/binary.
+*/
+package encoding
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Order is the byte and word order of values in registers.
+type Order int
+
+// Orders of values in registers.
+const (
+	// ABCD is big-endian, the order of the Modbus specification.
+	ABCD Order = iota
+	// CDAB swaps the words of big-endian values.
+	CDAB
+	// BADC swaps the bytes of each word of big-endian values.
+	BADC
+	// DCBA is little-endian.
+	DCBA
+)
+
+// ParseOrder returns the order named s, case insensitive. An empty s is
+// ABCD.
+func ParseOrder(s string) (Order, error) {
+	switch strings.ToLower(s) {
+	case "", "abcd":
+		return ABCD, nil
+	case "cdab":
+		return CDAB, nil
+	case "badc":
+		return BADC, nil
+	case "dcba":
+		return DCBA, nil
+	}
+	return ABCD, fmt.Errorf("modbus: unsupported order '%v'", s)
+}
+
+// String returns the name of the order.
+func (o Order) String() string {
+	switch o {
+	case ABCD:
+		return "ABCD"
+	case CDAB:
+		return "CDAB"
+	case BADC:
+		return "BADC"
+	case DCBA:
+		return "DCBA"
+	}
+	return fmt.Sprintf("Order(%d)", int(o))
+}
+
+func (o Order) wordSwap() bool { return o == CDAB || o == DCBA }
+func (o Order) byteSwap() bool { return o == BADC || o == DCBA }
+
+// Registers converts the data of a read response to registers.
+func Registers(data []byte) []uint16 {
+	registers := make([]uint16, len(data)/2)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return registers
+}
+
+// Bytes converts registers to the data of a write request.
+func Bytes(registers []uint16) []byte {
+	data := make([]byte, 2*len(registers))
+	for i, r := range registers {
+		binary.BigEndian.PutUint16(data[2*i:], r)
+	}
+	return data
+}
+
+// bigEndian returns the big-endian bytes of the first n registers in the
+// order o.
+func bigEndian(registers []uint16, o Order, n int) []byte {
+	b := Bytes(registers[:n])
+	reorder(b, o)
+	return b
+}
+
+// putBigEndian stores the big-endian bytes b in registers in the order o.
+func putBigEndian(registers []uint16, o Order, b []byte) {
+	_ = registers[len(b)/2-1]
+	reorder(b, o)
+	for i := 0; i < len(b); i += 2 {
+		registers[i/2] = binary.BigEndian.Uint16(b[i:])
+	}
+}
+
+// reorder converts b between big-endian and the order o. It is its own
+// inverse.
+func reorder(b []byte, o Order) {
+	if o.wordSwap() {
+		for i, j := 0, len(b)-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	if o.byteSwap() {
+		for i := 0; i < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+}
+
+// Uint16 returns the uint16 of the first register.
+func Uint16(registers []uint16, o Order) uint16 {
+	return binary.BigEndian.Uint16(bigEndian(registers, o, 1))
+}
+
+// Int16 returns the int16 of the first register.
+func Int16(registers []uint16, o Order) int16 {
+	return int16(Uint16(registers, o))
+}
+
+// Uint32 returns the uint32 of the first 2 registers.
+func Uint32(registers []uint16, o Order) uint32 {
+	return binary.BigEndian.Uint32(bigEndian(registers, o, 2))
+}
+
+// Int32 returns the int32 of the first 2 registers.
+func Int32(registers []uint16, o Order) int32 {
+	return int32(Uint32(registers, o))
+}
+
+// Float32 returns the float32 of the first 2 registers.
+func Float32(registers []uint16, o Order) float32 {
+	return math.Float32frombits(Uint32(registers, o))
+}
+
+// Uint64 returns the uint64 of the first 4 registers.
+func Uint64(registers []uint16, o Order) uint64 {
+	return binary.BigEndian.Uint64(bigEndian(registers, o, 4))
+}
+
+// Int64 returns the int64 of the first 4 registers.
+func Int64(registers []uint16, o Order) int64 {
+	return int64(Uint64(registers, o))
+}
+
+// Float64 returns the float64 of the first 4 registers.
+func Float64(registers []uint16, o Order) float64 {
+	return math.Float64frombits(Uint64(registers, o))
+}
+
+// String returns the string of 2 characters per register, without its
+// trailing NUL characters and spaces. Only the byte order of o applies to
+// strings, CDAB is ABCD and DCBA is BADC.
+func String(registers []uint16, o Order) string {
+	b := Bytes(registers)
+	if o.byteSwap() {
+		reorder(b, BADC)
+	}
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+// PutUint16 stores v in the first register.
+func PutUint16(registers []uint16, o Order, v uint16) {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	putBigEndian(registers, o, b)
+}
+
+// PutInt16 stores v in the first register.
+func PutInt16(registers []uint16, o Order, v int16) {
+	PutUint16(registers, o, uint16(v))
+}
+
+// PutUint32 stores v in the first 2 registers.
+func PutUint32(registers []uint16, o Order, v uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	putBigEndian(registers, o, b)
+}
+
+// PutInt32 stores v in the first 2 registers.
+func PutInt32(registers []uint16, o Order, v int32) {
+	PutUint32(registers, o, uint32(v))
+}
+
+// PutFloat32 stores v in the first 2 registers.
+func PutFloat32(registers []uint16, o Order, v float32) {
+	PutUint32(registers, o, math.Float32bits(v))
+}
+
+// PutUint64 stores v in the first 4 registers.
+func PutUint64(registers []uint16, o Order, v uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	putBigEndian(registers, o, b)
+}
+
+// PutInt64 stores v in the first 4 registers.
+func PutInt64(registers []uint16, o Order, v int64) {
+	PutUint64(registers, o, uint64(v))
+}
+
+// PutFloat64 stores v in the first 4 registers.
+func PutFloat64(registers []uint16, o Order, v float64) {
+	PutUint64(registers, o, math.Float64bits(v))
+}
+
+// PutString stores s in registers, 2 characters per register, padded with
+// NUL characters. It returns an error if s does not fit in registers. See
+// String for the order.
+func PutString(registers []uint16, o Order, s string) error {
+	if len(s) > 2*len(registers) {
+		return fmt.Errorf("modbus: string of '%v' bytes does not fit in '%v' registers", len(s), len(registers))
+	}
+	b := make([]byte, 2*len(registers))
+	copy(b, s)
+	if o.byteSwap() {
+		reorder(b, BADC)
+	}
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return nil
+}
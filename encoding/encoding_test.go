@@ -0,0 +1,81 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package encoding
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestOrders(t *testing.T) {
+	tests := []struct {
+		order     Order
+		registers []uint16
+	}{
+		{ABCD, []uint16{0x0102, 0x0304}},
+		{CDAB, []uint16{0x0304, 0x0102}},
+		{BADC, []uint16{0x0201, 0x0403}},
+		{DCBA, []uint16{0x0403, 0x0201}},
+	}
+	for _, test := range tests {
+		if v := Uint32(test.registers, test.order); v != 0x01020304 {
+			t.Errorf("%v: unexpected value %x", test.order, v)
+		}
+		registers := make([]uint16, 2)
+		PutUint32(registers, test.order, 0x01020304)
+		if !reflect.DeepEqual(test.registers, registers) {
+			t.Errorf("%v: unexpected registers %x", test.order, registers)
+		}
+		if o, err := ParseOrder(test.order.String()); err != nil || o != test.order {
+			t.Errorf("%v: unexpected order %v, error %v", test.order, o, err)
+		}
+	}
+	if _, err := ParseOrder("xyz"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestValues(t *testing.T) {
+	registers := make([]uint16, 4)
+	PutInt16(registers, BADC, -2)
+	if registers[0] != 0xFEFF || Int16(registers, BADC) != -2 {
+		t.Fatalf("unexpected int16 %x", registers[0])
+	}
+	PutFloat32(registers, CDAB, 49.5)
+	if registers[0] != 0 || registers[1] != 0x4246 || Float32(registers, CDAB) != 49.5 {
+		t.Fatalf("unexpected float32 %x", registers[:2])
+	}
+	PutInt64(registers, CDAB, -2)
+	if !reflect.DeepEqual([]uint16{0xFFFE, 0xFFFF, 0xFFFF, 0xFFFF}, registers) || Int64(registers, CDAB) != -2 {
+		t.Fatalf("unexpected int64 %x", registers)
+	}
+	PutFloat64(registers, DCBA, math.Pi)
+	if Float64(registers, DCBA) != math.Pi || Uint64(registers, DCBA) != math.Float64bits(math.Pi) {
+		t.Fatalf("unexpected float64 %x", registers)
+	}
+	if data := Bytes([]uint16{0x0102, 0x0304}); !reflect.DeepEqual(Registers(data), []uint16{0x0102, 0x0304}) {
+		t.Fatalf("unexpected data %x", data)
+	}
+}
+
+func TestString(t *testing.T) {
+	registers := make([]uint16, 3)
+	if err := PutString(registers, ABCD, "ABC"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]uint16{0x4142, 0x4300, 0}, registers) || String(registers, ABCD) != "ABC" {
+		t.Fatalf("unexpected registers %x", registers)
+	}
+	if s := String(registers, DCBA); s != "BA\x00C" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if err := PutString(registers, BADC, "ABC"); err != nil || registers[0] != 0x4241 || String(registers, BADC) != "ABC" {
+		t.Fatalf("unexpected registers %x, error %v", registers, err)
+	}
+	if err := PutString(registers, ABCD, "ABCDEFG"); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// enronMaxRead and enronMaxWrite are the quantities of 32-bit registers
+	// fitting in the byte count of read and write requests.
+	enronMaxRead  = 62
+	enronMaxWrite = 61
+)
+
+// EnronRange is a range of 32-bit holding registers of Enron (or Daniel)
+// Modbus devices, from Start to End inclusive.
+type EnronRange struct {
+	Start, End uint16
+}
+
+// DefaultEnronRanges are the 32-bit registers of most flow computers: long
+// integers from 5000 to 5999 and floats from 7000 to 7999.
+var DefaultEnronRanges = []EnronRange{{5000, 5999}, {7000, 7999}}
+
+// EnronClient wraps a Client talking to an Enron Modbus device, whose
+// holding registers in Ranges are 32-bit: each register of the quantity
+// is 4 bytes. Other registers are 16-bit as in standard Modbus:
+//  client := modbus.NewEnronClient(modbus.RTUClient("/dev/ttyUSB0"))
+//  volumes, err := client.ReadFloat32s(7001, 4)
+// RTU handlers recognize responses of 4 bytes per register, TCP and
+// ASCII handlers need no change.
+type EnronClient struct {
+	Client
+
+	// Ranges are the 32-bit registers, DefaultEnronRanges if nil.
+	Ranges []EnronRange
+}
+
+// NewEnronClient creates a new EnronClient wrapping the client.
+func NewEnronClient(client Client) *EnronClient {
+	return &EnronClient{Client: client}
+}
+
+// Is32Bit returns true if the holding register at address is 32-bit.
+func (mb *EnronClient) Is32Bit(address uint16) bool {
+	return mb.enronRange(address) != nil
+}
+
+// ReadHoldingRegisters reads holding registers, 4 bytes per register in
+// the 32-bit ranges.
+func (mb *EnronClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	if !mb.Is32Bit(address) {
+		return mb.Client.ReadHoldingRegisters(address, quantity)
+	}
+	if err = mb.check32Bit(address, quantity, enronMaxRead); err != nil {
+		return
+	}
+	if results, err = mb.Client.ReadHoldingRegisters(address, quantity); err != nil {
+		return
+	}
+	if len(results) != 4*int(quantity) {
+		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(results), 4*int(quantity))
+	}
+	return
+}
+
+// WriteMultipleRegisters writes holding registers, value has 4 bytes per
+// register in the 32-bit ranges.
+func (mb *EnronClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	if mb.Is32Bit(address) {
+		if err = mb.check32Bit(address, quantity, enronMaxWrite); err != nil {
+			return
+		}
+		if len(value) != 4*int(quantity) {
+			err = fmt.Errorf("modbus: value size '%v' does not match expected '%v'", len(value), 4*int(quantity))
+			return
+		}
+	}
+	return mb.Client.WriteMultipleRegisters(address, quantity, value)
+}
+
+// ReadInt32s reads 32-bit long registers.
+func (mb *EnronClient) ReadInt32s(address, quantity uint16) (values []int32, err error) {
+	results, err := mb.read32Bit(address, quantity)
+	if err != nil {
+		return
+	}
+	values = make([]int32, quantity)
+	for i := range values {
+		values[i] = int32(binary.BigEndian.Uint32(results[4*i:]))
+	}
+	return
+}
+
+// ReadFloat32s reads 32-bit float registers.
+func (mb *EnronClient) ReadFloat32s(address, quantity uint16) (values []float32, err error) {
+	results, err := mb.read32Bit(address, quantity)
+	if err != nil {
+		return
+	}
+	values = make([]float32, quantity)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.BigEndian.Uint32(results[4*i:]))
+	}
+	return
+}
+
+// WriteInt32s writes 32-bit long registers.
+func (mb *EnronClient) WriteInt32s(address uint16, values ...int32) (err error) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	return mb.write32Bit(address, data)
+}
+
+// WriteFloat32s writes 32-bit float registers.
+func (mb *EnronClient) WriteFloat32s(address uint16, values ...float32) (err error) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return mb.write32Bit(address, data)
+}
+
+func (mb *EnronClient) read32Bit(address, quantity uint16) (results []byte, err error) {
+	if !mb.Is32Bit(address) {
+		err = fmt.Errorf("modbus: register '%v' is not a 32-bit register", address)
+		return
+	}
+	return mb.ReadHoldingRegisters(address, quantity)
+}
+
+func (mb *EnronClient) write32Bit(address uint16, data []byte) (err error) {
+	if !mb.Is32Bit(address) {
+		return fmt.Errorf("modbus: register '%v' is not a 32-bit register", address)
+	}
+	_, err = mb.WriteMultipleRegisters(address, uint16(len(data)/4), data)
+	return
+}
+
+// check32Bit checks the quantity and that all the registers are in the
+// range of address.
+func (mb *EnronClient) check32Bit(address, quantity, max uint16) error {
+	if quantity < 1 || quantity > max {
+		return fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, max)
+	}
+	if r := mb.enronRange(address); int(address)+int(quantity)-1 > int(r.End) {
+		return fmt.Errorf("modbus: registers '%v' to '%v' exceed the 32-bit range '%v' to '%v'",
+			address, int(address)+int(quantity)-1, r.Start, r.End)
+	}
+	return nil
+}
+
+func (mb *EnronClient) enronRange(address uint16) *EnronRange {
+	ranges := mb.Ranges
+	if ranges == nil {
+		ranges = DefaultEnronRanges
+	}
+	for i := range ranges {
+		if address >= ranges[i].Start && address <= ranges[i].End {
+			return &ranges[i]
+		}
+	}
+	return nil
+}
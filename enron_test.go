@@ -0,0 +1,105 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// enronDevice serves 16-bit holding registers and Enron 32-bit registers
+// from 5000 to 5999 and 7000 to 7999.
+type enronDevice struct {
+	registers map[uint16]uint32
+}
+
+func (d *enronDevice) serve(request *ProtocolDataUnit) *ProtocolDataUnit {
+	address := binary.BigEndian.Uint16(request.Data)
+	quantity := binary.BigEndian.Uint16(request.Data[2:])
+	size := 2
+	if (address >= 5000 && address < 6000) || (address >= 7000 && address < 8000) {
+		size = 4
+	}
+	switch request.FunctionCode {
+	case FuncCodeReadHoldingRegisters:
+		data := make([]byte, 1+size*int(quantity))
+		data[0] = byte(size * int(quantity))
+		for i := 0; i < int(quantity); i++ {
+			v := d.registers[address+uint16(i)]
+			if size == 4 {
+				binary.BigEndian.PutUint32(data[1+4*i:], v)
+			} else {
+				binary.BigEndian.PutUint16(data[1+2*i:], uint16(v))
+			}
+		}
+		return &ProtocolDataUnit{request.FunctionCode, data}
+	case FuncCodeWriteMultipleRegisters:
+		if int(request.Data[4]) != size*int(quantity) {
+			return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalDataValue}}
+		}
+		for i := 0; i < int(quantity); i++ {
+			if size == 4 {
+				d.registers[address+uint16(i)] = binary.BigEndian.Uint32(request.Data[5+4*i:])
+			} else {
+				d.registers[address+uint16(i)] = uint32(binary.BigEndian.Uint16(request.Data[5+2*i:]))
+			}
+		}
+		return &ProtocolDataUnit{request.FunctionCode, request.Data[:4]}
+	}
+	return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalFunction}}
+}
+
+func TestEnronClient(t *testing.T) {
+	device := &enronDevice{registers: map[uint16]uint32{100: 0x1234}}
+	client := NewEnronClient(NewClient(&pduHandler{serve: device.serve}))
+
+	if err := client.WriteInt32s(5001, -1, 100000); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteFloat32s(7001, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	longs, err := client.ReadInt32s(5001, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]int32{-1, 100000}, longs) {
+		t.Fatalf("unexpected values %v", longs)
+	}
+	floats, err := client.ReadFloat32s(7001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if floats[0] != 1.5 || device.registers[7001] != math.Float32bits(1.5) {
+		t.Fatalf("unexpected values %v", floats)
+	}
+	results, err := client.ReadHoldingRegisters(100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0x12, 0x34}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	if _, err = client.ReadHoldingRegisters(5990, 11); err == nil {
+		t.Fatal("read across the range end succeeded")
+	}
+	if _, err = client.ReadHoldingRegisters(5000, enronMaxRead+1); err == nil {
+		t.Fatal("read of too many registers succeeded")
+	}
+	if _, err = client.WriteMultipleRegisters(5000, 1, []byte{0, 1}); err == nil {
+		t.Fatal("write of 16-bit value succeeded")
+	}
+	if _, err = client.ReadInt32s(100, 1); err == nil {
+		t.Fatal("read of 16-bit register as int32 succeeded")
+	}
+
+	client.Ranges = []EnronRange{{100, 199}}
+	if client.Is32Bit(5000) || !client.Is32Bit(199) {
+		t.Fatal("unexpected ranges")
+	}
+}
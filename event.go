@@ -0,0 +1,105 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+// Event types.
+const (
+	// EventDeviceDown is published when a device stops responding.
+	EventDeviceDown EventType = iota + 1
+	// EventDeviceUp is published when a device responds again after an
+	// outage.
+	EventDeviceUp
+	// EventValueChanged is published when a value read or written differs
+	// from the previous one, see CachingClient.
+	EventValueChanged
+)
+
+// String returns name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventDeviceDown:
+		return "device down"
+	case EventDeviceUp:
+		return "device up"
+	case EventValueChanged:
+		return "value changed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// Event is a notification published on an EventBus.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Source identifies the device or client the event is about.
+	Source string
+	// Since is the start of the outage for device events.
+	Since time.Time
+	// Failures is the number of failed requests of the outage.
+	Failures int
+	// Err is the error which caused the event, if any.
+	Err error
+	// Table, Address, Value and Previous describe value change events,
+	// coils and discrete inputs are 0 or 1.
+	Table    Table
+	Address  uint16
+	Value    uint16
+	Previous uint16
+}
+
+// EventBus dispatches events to subscribers synchronously, in the order
+// they subscribed. The zero value is ready to use.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []*eventSubscriber
+}
+
+type eventSubscriber struct {
+	handler func(Event)
+}
+
+// Subscribe registers handler to receive all events published from now on
+// and returns a function cancelling the subscription.
+func (b *EventBus) Subscribe(handler func(Event)) (unsubscribe func()) {
+	s := &eventSubscriber{handler}
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, subscriber := range b.subscribers {
+			if subscriber == s {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish sends event to all subscribers. A nil bus discards the event.
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		s.handler(event)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command mqttbridge publishes the tags of a Modbus TCP device, described
+// by a JSON register map, to a MQTT broker and writes the values published
+// to topic/<tag>/set to the device:
+//  mqttbridge -device localhost:502 -map pump.json -broker tcp://localhost:1883 -topic plant/pump1
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/mqttmodbus"
+)
+
+func main() {
+	address := flag.String("device", "localhost:502", "address of the Modbus TCP device")
+	slaveId := flag.Int("slave", 1, "slave id")
+	mapFile := flag.String("map", "", "JSON register map of the device")
+	broker := flag.String("broker", "tcp://localhost:1883", "URL of the MQTT broker")
+	topic := flag.String("topic", "modbus", "topic prefix of the tags")
+	interval := flag.Duration("interval", time.Second, "poll interval")
+	flag.Parse()
+
+	f, err := os.Open(*mapFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registerMap, err := modbus.LoadRegisterMap(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handler := modbus.NewTCPClientHandler(*address)
+	handler.SlaveId = byte(*slaveId)
+	handler.Timeout = 2 * time.Second
+	defer handler.Close()
+
+	options := mqtt.NewClientOptions().AddBroker(*broker).SetClientID("mqttbridge")
+	options.SetWill(*topic+"/status", mqttmodbus.StatusOffline, 1, true)
+	client := mqtt.NewClient(options)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatal(token.Error())
+	}
+	defer client.Disconnect(250)
+
+	bridge, err := newBridge(handler, registerMap, pahoClient{client}, *topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bridge.Interval = *interval
+	bridge.Logger = log.New(os.Stderr, "mqttbridge: ", log.LstdFlags)
+	if err = bridge.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer bridge.Stop()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// newBridge returns a bridge of the tags of the register map, read and
+// written through handler, to the MQTT client.
+func newBridge(handler modbus.ClientHandler, m *modbus.RegisterMap, client mqttmodbus.Client, topic string) (*mqttmodbus.Bridge, error) {
+	device, err := modbus.NewDevice(modbus.NewClient(handler), m)
+	if err != nil {
+		return nil, err
+	}
+	return mqttmodbus.NewBridge(device, client, topic), nil
+}
+
+// pahoClient adapts a paho client to mqttmodbus.Client.
+type pahoClient struct {
+	mqtt.Client
+}
+
+func (c pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	token := c.Client.Publish(topic, 1, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (c pahoClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	token := c.Client.Subscribe(topic, 1, func(_ mqtt.Client, m mqtt.Message) {
+		handler(m.Topic(), m.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (c pahoClient) Unsubscribe(topic string) error {
+	token := c.Client.Unsubscribe(topic)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+// fakeClient sends the payloads published by topic and keeps the handlers
+// of the subscriptions.
+type fakeClient struct {
+	mu            sync.Mutex
+	published     chan [2]string
+	subscriptions map[string]func(topic string, payload []byte)
+}
+
+func (c *fakeClient) Publish(topic string, retained bool, payload []byte) error {
+	c.published <- [2]string{topic, string(payload)}
+	return nil
+}
+
+func (c *fakeClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[topic] = handler
+	return nil
+}
+
+func (c *fakeClient) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subscriptions, topic)
+	return nil
+}
+
+// expect waits until payload is published to topic.
+func (c *fakeClient) expect(t *testing.T, topic, payload string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-c.published:
+			if m == [2]string{topic, payload} {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q on '%v'", payload, topic)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	device := modbustest.NewDevice()
+	device.SetInputRegisters(0, 215)
+	registerMap := &modbus.RegisterMap{
+		Tags: []modbus.TagDef{
+			{Name: "temperature", Table: modbus.TableInputRegisters, Address: 0, Scale: 0.1, Unit: "C"},
+			{Name: "setpoint", Table: modbus.TableHoldingRegisters, Address: 10},
+		},
+	}
+	client := &fakeClient{
+		published:     make(chan [2]string, 100),
+		subscriptions: make(map[string]func(topic string, payload []byte)),
+	}
+	bridge, err := newBridge(modbustest.NewClientHandler(device), registerMap, client, "plant/boiler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge.Interval = 10 * time.Millisecond
+	if err = bridge.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Stop()
+	client.expect(t, "plant/boiler/status", "online")
+	client.expect(t, "plant/boiler/temperature", `{"value":21.5,"unit":"C","quality":"good"}`)
+
+	client.mu.Lock()
+	write := client.subscriptions["plant/boiler/+/set"]
+	client.mu.Unlock()
+	if write == nil {
+		t.Fatal("writes are not subscribed")
+	}
+	write("plant/boiler/setpoint/set", []byte("60"))
+	if v := device.HoldingRegisters(10, 1)[0]; v != 60 {
+		t.Fatalf("setpoint expected 60, actual %v", v)
+	}
+	client.expect(t, "plant/boiler/setpoint", `{"value":60,"quality":"good"}`)
+}
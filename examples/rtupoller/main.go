@@ -0,0 +1,83 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+// Command rtupoller polls holding registers of a RTU slave periodically,
+// retrying requests the slave is too busy to serve:
+//  rtupoller -port /dev/ttyUSB0 -slave 1 -address 100 -quantity 4
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func main() {
+	port := flag.String("port", "/dev/ttyUSB0", "serial port")
+	baudRate := flag.Int("baud", 19200, "baud rate")
+	slaveId := flag.Int("slave", 1, "slave id")
+	address := flag.Uint("address", 0, "first holding register")
+	quantity := flag.Uint("quantity", 1, "number of holding registers")
+	interval := flag.Duration("interval", time.Second, "poll interval")
+	flag.Parse()
+
+	handler := modbus.NewRTUClientHandler(*port)
+	handler.BaudRate = *baudRate
+	handler.SlaveId = byte(*slaveId)
+	handler.ReadTimeout = 500 * time.Millisecond
+	defer handler.Close()
+
+	tags := []modbus.Tag{{
+		Name:     "registers",
+		Table:    modbus.TableHoldingRegisters,
+		Address:  uint16(*address),
+		Quantity: uint16(*quantity),
+	}}
+	poller, err := newPoller(handler, *interval, tags, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	poller.Start()
+	defer poller.Stop()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// newPoller returns a poller printing the values of tags to w. Requests
+// answered with Slave Device Busy are retried.
+func newPoller(handler modbus.ClientHandler, interval time.Duration, tags []modbus.Tag, w io.Writer) (*modbus.Poller, error) {
+	retry := &modbus.BusyRetry{Delay: 100 * time.Millisecond, MaxAttempts: 10}
+	poller := modbus.NewPoller(modbus.NewMiddlewareClient(handler, retry.Middleware))
+	err := poller.Add(&modbus.PollGroup{
+		Name:     "rtupoller",
+		Interval: interval,
+		Tags:     tags,
+		Handler: func(values map[string][]uint16, err error) {
+			if err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+				return
+			}
+			names := make([]string, 0, len(values))
+			for name := range values {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, "%v: %v\n", name, values[name])
+			}
+		},
+	})
+	return poller, err
+}
@@ -0,0 +1,50 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+// lines sends each write as a line.
+type lines chan string
+
+func (l lines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func TestPoller(t *testing.T) {
+	device := modbustest.NewDevice()
+	device.SetHoldingRegisters(100, 1, 2)
+	// The slave is busy at first
+	device.SetException(modbus.FuncCodeReadHoldingRegisters, modbus.ExceptionCodeServerDeviceBusy)
+	time.AfterFunc(50*time.Millisecond, func() {
+		device.SetException(modbus.FuncCodeReadHoldingRegisters, 0)
+	})
+
+	output := make(lines, 1)
+	tags := []modbus.Tag{{Name: "registers", Table: modbus.TableHoldingRegisters, Address: 100, Quantity: 2}}
+	poller, err := newPoller(modbustest.NewClientHandler(device), time.Hour, tags, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller.Start()
+	defer poller.Stop()
+	select {
+	case line := <-output:
+		if line != "registers: [1 2]\n" {
+			t.Fatalf("unexpected output %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
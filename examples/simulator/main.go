@@ -0,0 +1,93 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command simulator serves a simulated device over Modbus TCP, whose input
+// registers are updated by generators:
+//  simulator -listen :5020 -generators 0=sine,1=counter,2=sawtooth
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus/modbustest"
+)
+
+// generator returns the value of a register after elapsed time.
+type generator func(elapsed time.Duration) uint16
+
+// generators by name, values change with a period of one minute.
+var generators = map[string]generator{
+	"counter": func(elapsed time.Duration) uint16 {
+		return uint16(elapsed / time.Second)
+	},
+	"sawtooth": func(elapsed time.Duration) uint16 {
+		return uint16(elapsed % time.Minute * 1000 / time.Minute)
+	},
+	"sine": func(elapsed time.Duration) uint16 {
+		return uint16(1000 + 1000*math.Sin(2*math.Pi*elapsed.Seconds()/60))
+	},
+}
+
+func main() {
+	listen := flag.String("listen", ":5020", "TCP address to listen on")
+	spec := flag.String("generators", "0=sine", "comma separated generators of input registers, e.g. 0=sine,1=counter")
+	interval := flag.Duration("interval", 100*time.Millisecond, "update interval")
+	flag.Parse()
+
+	sim, err := newSimulation(modbustest.NewDevice(), *spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &modbustest.Server{Device: sim.device}
+	if err = server.Start(*listen); err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+	log.Printf("simulator: listening on %v", server.Addr())
+
+	start := time.Now()
+	for range time.Tick(*interval) {
+		sim.update(time.Since(start))
+	}
+}
+
+// simulation updates input registers of a device with generators.
+type simulation struct {
+	device     *modbustest.Device
+	generators map[uint16]generator
+}
+
+// newSimulation parses the generators of spec, e.g. "0=sine,1=counter".
+func newSimulation(device *modbustest.Device, spec string) (*simulation, error) {
+	s := &simulation{device: device, generators: make(map[uint16]generator)}
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("simulator: invalid generator '%v'", field)
+		}
+		address, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: invalid address '%v'", parts[0])
+		}
+		g, ok := generators[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("simulator: unknown generator '%v'", parts[1])
+		}
+		s.generators[uint16(address)] = g
+	}
+	return s, nil
+}
+
+// update sets the registers to their values after elapsed time.
+func (s *simulation) update(elapsed time.Duration) {
+	for address, g := range s.generators {
+		s.device.SetInputRegisters(address, g(elapsed))
+	}
+}
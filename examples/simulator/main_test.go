@@ -0,0 +1,44 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+func TestSimulation(t *testing.T) {
+	sim, err := newSimulation(modbustest.NewDevice(), "10=counter, 11=sawtooth, 12=sine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := modbustest.NewServer(sim.device)
+	defer server.Close()
+	sim.update(15 * time.Second)
+
+	handler := modbus.NewTCPClientHandler(server.Addr())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	results, err := modbus.NewClient(handler).ReadInputRegisters(10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 15, 250 and 2000
+	if !bytes.Equal([]byte{0x00, 0x0F, 0x00, 0xFA, 0x07, 0xD0}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+}
+
+func TestSimulationInvalid(t *testing.T) {
+	for _, spec := range []string{"", "1", "x=sine", "1=square"} {
+		if _, err := newSimulation(modbustest.NewDevice(), spec); err == nil {
+			t.Errorf("%q: error expected", spec)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Command structdevice reads the registers of a power meter over Modbus
+// TCP into a struct, and writes its settings from a struct:
+//  structdevice -address localhost:502 -slave 1 -ct-ratio 200
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// Meter is the measurements block of the meter.
+type Meter struct {
+	Voltage float32 `modbus:"addr=100,type=float32"`
+	Current float64 `modbus:"addr=102,type=int16,scale=0.01"`
+	Energy  uint32  `modbus:"addr=103,type=uint32,order=cdab"`
+}
+
+// Settings is the configuration block of the meter.
+type Settings struct {
+	CTRatio      uint16 `modbus:"addr=200"`
+	DemandPeriod uint16 `modbus:"addr=201"`
+}
+
+func main() {
+	address := flag.String("address", "localhost:502", "TCP address of the meter")
+	slaveId := flag.Int("slave", 1, "slave id")
+	ctRatio := flag.Uint("ct-ratio", 0, "current transformer ratio to write, unchanged if zero")
+	flag.Parse()
+
+	handler := modbus.NewTCPClientHandler(*address)
+	handler.SlaveId = byte(*slaveId)
+	handler.ReadTimeout = time.Second
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	if *ctRatio > 0 {
+		if err := writeSettings(client, &Settings{CTRatio: uint16(*ctRatio), DemandPeriod: 15}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var meter Meter
+	if err := modbus.ReadStruct(client, &meter); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("voltage: %.1f V, current: %.2f A, energy: %v Wh\n", meter.Voltage, meter.Current, meter.Energy)
+}
+
+// writeSettings writes all fields of settings in one request.
+func writeSettings(client modbus.Client, settings *Settings) error {
+	address, data, err := modbus.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = client.WriteMultipleRegisters(address, uint16(len(data)/2), data)
+	return err
+}
@@ -0,0 +1,41 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+func TestStructDevice(t *testing.T) {
+	device := modbustest.NewDevice()
+	voltage := math.Float32bits(230.5)
+	device.SetHoldingRegisters(100, uint16(voltage>>16), uint16(voltage), 1234, 0x0001, 0x0002)
+	server := modbustest.NewServer(device)
+	defer server.Close()
+
+	handler := modbus.NewTCPClientHandler(server.Addr())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	var meter Meter
+	if err := modbus.ReadStruct(client, &meter); err != nil {
+		t.Fatal(err)
+	}
+	if meter.Voltage != 230.5 || meter.Current != 12.34 || meter.Energy != 0x00020001 {
+		t.Fatalf("unexpected meter: %+v", meter)
+	}
+	if err := writeSettings(client, &Settings{CTRatio: 200, DemandPeriod: 15}); err != nil {
+		t.Fatal(err)
+	}
+	if registers := device.HoldingRegisters(200, 2); registers[0] != 200 || registers[1] != 15 {
+		t.Fatalf("unexpected settings: %v", registers)
+	}
+}
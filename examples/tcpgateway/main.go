@@ -0,0 +1,62 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+// Command tcpgateway forwards requests of Modbus TCP clients to the RTU
+// slaves of a serial bus:
+//  tcpgateway -listen :502 -port /dev/ttyUSB0 -units 1,2,3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func main() {
+	listen := flag.String("listen", ":502", "TCP address to listen on")
+	port := flag.String("port", "/dev/ttyUSB0", "serial port")
+	baudRate := flag.Int("baud", 19200, "baud rate")
+	units := flag.String("units", "1", "comma separated unit ids of the slaves")
+	flag.Parse()
+
+	unitIds, err := parseUnits(*units)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bus := modbus.NewRTUClientHandler(*port)
+	bus.BaudRate = *baudRate
+	bus.ReadTimeout = 500 * time.Millisecond
+	defer bus.Close()
+
+	gateway := newGateway(bus, unitIds)
+	gateway.Logger = log.New(os.Stderr, "tcpgateway: ", log.LstdFlags)
+	log.Fatal(gateway.ListenAndServe(*listen))
+}
+
+// newGateway returns a gateway routing the unit ids to the bus.
+func newGateway(bus modbus.Transporter, unitIds []byte) *modbus.Gateway {
+	gateway := modbus.NewGateway()
+	gateway.Route(bus, unitIds...)
+	return gateway
+}
+
+// parseUnits parses comma separated unit ids, e.g. "1,2,3".
+func parseUnits(s string) (unitIds []byte, err error) {
+	for _, field := range strings.Split(s, ",") {
+		var id uint64
+		if id, err = strconv.ParseUint(strings.TrimSpace(field), 10, 8); err != nil || id == 0 || id > 247 {
+			return nil, fmt.Errorf("tcpgateway: invalid unit id '%v'", field)
+		}
+		unitIds = append(unitIds, byte(id))
+	}
+	return
+}
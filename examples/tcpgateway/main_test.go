@@ -0,0 +1,76 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+// rtuBus serves RTU frames with a simulated device.
+type rtuBus struct {
+	device *modbustest.Device
+}
+
+func (b *rtuBus) Send(aduRequest []byte) ([]byte, error) {
+	response := b.device.Serve(&modbus.ProtocolDataUnit{
+		FunctionCode: aduRequest[1],
+		Data:         aduRequest[2 : len(aduRequest)-2],
+	})
+	adu := append([]byte{aduRequest[0], response.FunctionCode}, response.Data...)
+	crc := modbus.CRC16(adu)
+	return append(adu, byte(crc), byte(crc>>8)), nil
+}
+
+func TestGateway(t *testing.T) {
+	unitIds, err := parseUnits("1, 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := modbustest.NewDevice()
+	device.SetHoldingRegisters(10, 0x1234)
+	gateway := newGateway(&rtuBus{device}, unitIds)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gateway.Serve(listener)
+	defer gateway.Close()
+
+	handler := modbus.NewTCPClientHandler(listener.Addr().String())
+	handler.Timeout = time.Second
+	handler.SlaveId = 2
+	defer handler.Close()
+	results, err := modbus.NewClient(handler).ReadHoldingRegisters(10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x12, 0x34}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+
+	// Unit without route
+	handler.SlaveId = 3
+	_, err = modbus.NewClient(handler).ReadHoldingRegisters(10, 1)
+	if !errors.Is(err, modbus.ErrGatewayPathUnavailable) {
+		t.Fatalf("gateway path unavailable expected, actual %v", err)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	for _, s := range []string{"", "0", "1,x", "300"} {
+		if _, err := parseUnits(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Failover configures the redundant endpoints of a TCP transporter, such
+// as the standby CPU or the second network interface of a PLC:
+//  handler := modbus.NewTCPClientHandler("10.0.0.10:502")
+//  handler.FailoverAddresses = []string{"10.0.0.11:502"}
+//  handler.FailbackInterval = time.Minute
+//  handler.OnEndpoint = func(address string) { log.Printf("using %v", address) }
+// Address is the primary endpoint.
+type Failover struct {
+	// FailoverAddresses are connected in order when Address can not be.
+	// Connections failing a request are then closed, so that the next
+	// request connects to an endpoint which is up.
+	FailoverAddresses []string
+	// FailbackInterval is the interval of probing Address while connected
+	// to another endpoint, the connection switches back to Address once
+	// it accepts connections. Zero disables failback.
+	FailbackInterval time.Duration
+	// OnEndpoint is called with the address connected to when the active
+	// endpoint changes, with the transporter locked.
+	OnEndpoint func(address string)
+
+	// endpoint is the address of the last connection.
+	endpoint string
+	// probed is the time Address was last tried.
+	probed time.Time
+}
+
+// dialEndpoints connects to primary or to the first failover address
+// accepting the connection, primary is probed at now.
+func (f *Failover) dialEndpoints(primary string, now time.Time, dial func(address string) (net.Conn, error)) (conn net.Conn, err error) {
+	f.probed = now
+	if conn, err = dial(primary); err == nil || len(f.FailoverAddresses) == 0 {
+		if err == nil {
+			f.setEndpoint(primary)
+		}
+		return
+	}
+	primaryErr := err
+	for _, address := range f.FailoverAddresses {
+		if conn, err = dial(address); err == nil {
+			f.setEndpoint(address)
+			return
+		}
+	}
+	err = fmt.Errorf("modbus: connecting to '%v' and failover addresses failed: %v", primary, primaryErr)
+	return
+}
+
+// failback returns true if primary should be probed at now.
+func (f *Failover) failback(primary string, now time.Time) bool {
+	return f.FailbackInterval > 0 && f.endpoint != "" && f.endpoint != primary &&
+		now.Sub(f.probed) >= f.FailbackInterval
+}
+
+// failing returns true if connections failing a request must be closed.
+func (f *Failover) failing(err error) bool {
+	return err != nil && len(f.FailoverAddresses) > 0
+}
+
+func (f *Failover) setEndpoint(address string) {
+	if address == f.endpoint {
+		return
+	}
+	f.endpoint = address
+	if f.OnEndpoint != nil {
+		f.OnEndpoint(address)
+	}
+}
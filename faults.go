@@ -0,0 +1,144 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultInjector wraps a ClientHandler and injects faults at configurable
+// probabilities (0 to 1), to verify retry and alarm logic against serial
+// bus misbehavior:
+//  handler := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  faults := modbus.NewFaultInjector(handler)
+//  faults.TimeoutRate = 0.1
+//  client := modbus.NewClient(faults)
+type FaultInjector struct {
+	Handler ClientHandler
+
+	// TimeoutRate is the probability of a response being lost.
+	TimeoutRate float64
+	// TruncateRate is the probability of a response being cut short.
+	TruncateRate float64
+	// CorruptRate is the probability of a bit flip in the last byte of
+	// the response, which is the CRC in RTU frames.
+	CorruptRate float64
+	// DelayRate is the probability of a response being delayed by Delay.
+	DelayRate float64
+	Delay     time.Duration
+	// ExceptionRate is the probability of a response being replaced by an
+	// exception with a random code from ExceptionCodes.
+	ExceptionRate  float64
+	ExceptionCodes []byte
+	// Clock waits Delay, it defaults to the system time if nil.
+	Clock Clock
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaultInjector allocates a new FaultInjector wrapping handler with
+// all fault rates set to zero.
+func NewFaultInjector(handler ClientHandler) *FaultInjector {
+	return &FaultInjector{
+		Handler: handler,
+		ExceptionCodes: []byte{
+			ExceptionCodeIllegalDataAddress,
+			ExceptionCodeServerDeviceFailure,
+			ExceptionCodeServerDeviceBusy,
+		},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Seed makes the injected faults reproducible.
+func (mb *FaultInjector) Seed(seed int64) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.rand = rand.New(rand.NewSource(seed))
+}
+
+// Encode calls the underlying packager.
+func (mb *FaultInjector) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.Handler.Encode(pdu)
+}
+
+// isBroadcast implements broadcastPackager.
+func (mb *FaultInjector) isBroadcast(aduRequest []byte) bool {
+	packager, ok := mb.Handler.(broadcastPackager)
+	return ok && packager.isBroadcast(aduRequest)
+}
+
+// Verify calls the underlying packager.
+func (mb *FaultInjector) Verify(aduRequest []byte, aduResponse []byte) (err error) {
+	return mb.Handler.Verify(aduRequest, aduResponse)
+}
+
+// Decode calls the underlying packager and may replace the decoded PDU
+// with an exception response.
+func (mb *FaultInjector) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
+	if pdu, err = mb.Handler.Decode(adu); err != nil {
+		return
+	}
+	if len(mb.ExceptionCodes) > 0 && mb.chance(mb.ExceptionRate) {
+		mb.mu.Lock()
+		exceptionCode := mb.ExceptionCodes[mb.rand.Intn(len(mb.ExceptionCodes))]
+		mb.mu.Unlock()
+		pdu = &ProtocolDataUnit{
+			FunctionCode: pdu.FunctionCode | 0x80,
+			Data:         []byte{exceptionCode},
+		}
+	}
+	return
+}
+
+// Send sends the request with the underlying transporter and may lose,
+// delay, truncate or corrupt the response.
+func (mb *FaultInjector) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	if aduResponse, err = mb.Handler.Send(aduRequest); err != nil {
+		return
+	}
+	if mb.chance(mb.TimeoutRate) {
+		aduResponse = nil
+		err = &faultTimeoutError{}
+		return
+	}
+	if mb.Delay > 0 && mb.chance(mb.DelayRate) {
+		clockOrSystem(mb.Clock).Sleep(mb.Delay)
+	}
+	if len(aduResponse) > 1 && mb.chance(mb.TruncateRate) {
+		mb.mu.Lock()
+		aduResponse = aduResponse[:1+mb.rand.Intn(len(aduResponse)-1)]
+		mb.mu.Unlock()
+	}
+	if len(aduResponse) > 0 && mb.chance(mb.CorruptRate) {
+		// Do not modify buffer of the underlying transporter
+		aduResponse = append([]byte(nil), aduResponse...)
+		mb.mu.Lock()
+		aduResponse[len(aduResponse)-1] ^= 1 << uint(mb.rand.Intn(8))
+		mb.mu.Unlock()
+	}
+	return
+}
+
+func (mb *FaultInjector) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return mb.rand.Float64() < rate
+}
+
+// faultTimeoutError implements net.Error.
+type faultTimeoutError struct{}
+
+func (e *faultTimeoutError) Error() string   { return "modbus: injected timeout" }
+func (e *faultTimeoutError) Timeout() bool   { return true }
+func (e *faultTimeoutError) Temporary() bool { return true }
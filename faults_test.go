@@ -0,0 +1,54 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"net"
+	"testing"
+)
+
+// echoRTUHandler responds to read holding registers requests with zeros.
+type echoRTUHandler struct {
+	rtuPackager
+}
+
+func (mb *echoRTUHandler) Send(aduRequest []byte) ([]byte, error) {
+	count := int(aduRequest[5])
+	pdu := ProtocolDataUnit{
+		FunctionCode: aduRequest[1],
+		Data:         make([]byte, 1+2*count),
+	}
+	pdu.Data[0] = byte(2 * count)
+	return mb.Encode(&pdu)
+}
+
+func TestFaultInjector(t *testing.T) {
+	faults := NewFaultInjector(&echoRTUHandler{})
+	faults.Seed(1)
+	client := NewClient(faults)
+	if _, err := client.ReadHoldingRegisters(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	faults.TimeoutRate = 1
+	_, err := client.ReadHoldingRegisters(1, 2)
+	if netError, ok := err.(net.Error); !ok || !netError.Timeout() {
+		t.Fatalf("timeout error expected, actual %v", err)
+	}
+	faults.TimeoutRate = 0
+
+	faults.CorruptRate = 1
+	if _, err = client.ReadHoldingRegisters(1, 2); err == nil {
+		t.Fatal("crc error expected")
+	}
+	faults.CorruptRate = 0
+
+	faults.ExceptionRate = 1
+	faults.ExceptionCodes = []byte{ExceptionCodeServerDeviceBusy}
+	_, err = client.ReadHoldingRegisters(1, 2)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("exception expected, actual %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+)
+
+// FunctionCodeMap sends requests with the alternate function codes of a
+// device, e.g. vendor functions in place of the standard ones, or single
+// writes for devices which do not support multiple writes. It is used as a
+// Middleware:
+//  codes := &modbus.FunctionCodeMap{Codes: map[byte]byte{
+//  	modbus.FuncCodeReadInputRegisters:     0x41,
+//  	modbus.FuncCodeWriteMultipleRegisters: modbus.FuncCodeWriteSingleRegister,
+//  }}
+//  client := modbus.NewMiddlewareClient(handler, codes.Middleware)
+// Requests and responses of other codes keep the layout of the standard
+// function, only their function code is replaced. The following mappings
+// convert the requests instead:
+//  WriteMultipleRegisters to WriteSingleRegister, one request per register
+//  WriteMultipleCoils to WriteSingleCoil, one request per coil
+//  WriteSingleRegister to WriteMultipleRegisters of one register
+//  WriteSingleCoil to WriteMultipleCoils of one coil
+// Converted multiple writes are not atomic: if a request fails, the values
+// before it have been written.
+type FunctionCodeMap struct {
+	// Codes maps the standard function codes to the codes of the device.
+	Codes map[byte]byte
+}
+
+// Middleware implements Middleware.
+func (m *FunctionCodeMap) Middleware(request *Request, next Sender) (response *Response, err error) {
+	code, ok := m.Codes[request.FunctionCode]
+	if !ok || code == request.FunctionCode {
+		return next(request)
+	}
+	switch {
+	case request.FunctionCode == FuncCodeWriteMultipleRegisters && code == FuncCodeWriteSingleRegister,
+		request.FunctionCode == FuncCodeWriteMultipleCoils && code == FuncCodeWriteSingleCoil:
+		return m.writeSingles(request, code, next)
+	case request.FunctionCode == FuncCodeWriteSingleRegister && code == FuncCodeWriteMultipleRegisters,
+		request.FunctionCode == FuncCodeWriteSingleCoil && code == FuncCodeWriteMultipleCoils:
+		return m.writeMultiple(request, code, next)
+	}
+	mapped := *request
+	mapped.FunctionCode = code
+	mapped.PDU = &ProtocolDataUnit{FunctionCode: code, Data: request.PDU.Data}
+	if response, err = next(&mapped); err != nil {
+		return
+	}
+	pdu := &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: response.PDU.Data}
+	switch response.PDU.FunctionCode {
+	case code:
+	case code | 0x80:
+		pdu.FunctionCode |= 0x80
+	default:
+		err = fmt.Errorf("modbus: response function code '%v' does not match request '%v'", response.PDU.FunctionCode, code)
+		return
+	}
+	return DecodeResponse(request, pdu)
+}
+
+// writeSingles writes the values of a multiple write request one by one
+// with the single write function code.
+func (m *FunctionCodeMap) writeSingles(request *Request, code byte, next Sender) (response *Response, err error) {
+	for i, value := range request.Values {
+		if code == FuncCodeWriteSingleCoil && value != 0 {
+			value = 0xFF00
+		}
+		single := &Request{
+			PDU:          &ProtocolDataUnit{FunctionCode: code, Data: dataBlock(request.Address+uint16(i), value)},
+			FunctionCode: code,
+			Address:      request.Address + uint16(i),
+			Quantity:     1,
+			Values:       request.Values[i : i+1],
+		}
+		if response, err = next(single); err != nil {
+			return
+		}
+		if response.ExceptionCode != 0 {
+			return exceptionResponse(request, response.ExceptionCode), nil
+		}
+	}
+	return DecodeResponse(request, &ProtocolDataUnit{
+		FunctionCode: request.FunctionCode,
+		Data:         dataBlock(request.Address, request.Quantity),
+	})
+}
+
+// writeMultiple writes the value of a single write request with the
+// multiple write function code.
+func (m *FunctionCodeMap) writeMultiple(request *Request, code byte, next Sender) (response *Response, err error) {
+	multiple := &Request{
+		FunctionCode: code,
+		Address:      request.Address,
+		Quantity:     1,
+		Values:       request.Values,
+	}
+	if code == FuncCodeWriteMultipleCoils {
+		multiple.PDU = &ProtocolDataUnit{FunctionCode: code, Data: dataBlockSuffix([]byte{byte(request.Values[0])}, request.Address, 1)}
+	} else {
+		multiple.PDU = &ProtocolDataUnit{FunctionCode: code, Data: dataBlockSuffix(dataBlock(request.Values...), request.Address, 1)}
+	}
+	if response, err = next(multiple); err != nil {
+		return
+	}
+	if response.ExceptionCode != 0 {
+		return exceptionResponse(request, response.ExceptionCode), nil
+	}
+	// Responses to single writes echo the request
+	return DecodeResponse(request, &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: request.PDU.Data})
+}
+
+// exceptionResponse returns the exception response to request.
+func exceptionResponse(request *Request, exceptionCode byte) *Response {
+	return &Response{
+		PDU:           &ProtocolDataUnit{FunctionCode: request.FunctionCode | 0x80, Data: []byte{exceptionCode}},
+		ExceptionCode: exceptionCode,
+	}
+}
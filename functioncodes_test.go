@@ -0,0 +1,66 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFunctionCodeMap(t *testing.T) {
+	var requests []ProtocolDataUnit
+	serve := func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		requests = append(requests, *request)
+		switch request.FunctionCode {
+		case 0x41:
+			response := serveRegisters(&ProtocolDataUnit{FuncCodeReadHoldingRegisters, request.Data})
+			response.FunctionCode = 0x41
+			return response
+		case FuncCodeWriteSingleRegister:
+			if request.Data[1] >= 200 {
+				return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalDataAddress}}
+			}
+			return request
+		case FuncCodeWriteMultipleCoils:
+			return &ProtocolDataUnit{request.FunctionCode, request.Data[:4]}
+		}
+		return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalFunction}}
+	}
+	codes := &FunctionCodeMap{Codes: map[byte]byte{
+		FuncCodeReadHoldingRegisters:   0x41,
+		FuncCodeWriteMultipleRegisters: FuncCodeWriteSingleRegister,
+		FuncCodeWriteSingleCoil:        FuncCodeWriteMultipleCoils,
+	}}
+	client := NewMiddlewareClient(&pduHandler{serve: serve}, codes.Middleware)
+
+	results, err := client.ReadHoldingRegisters(10, 2)
+	if err != nil || !bytes.Equal([]byte{0, 10, 0, 11}, results) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if _, err = client.WriteMultipleRegisters(10, 2, []byte{0, 1, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteSingleCoil(3, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProtocolDataUnit{
+		{0x41, []byte{0, 10, 0, 2}},
+		{FuncCodeWriteSingleRegister, []byte{0, 10, 0, 1}},
+		{FuncCodeWriteSingleRegister, []byte{0, 11, 0, 2}},
+		{FuncCodeWriteMultipleCoils, []byte{0, 3, 0, 1, 1, 1}},
+	}
+	if !reflect.DeepEqual(expected, requests) {
+		t.Fatalf("expected requests %v, actual %v", expected, requests)
+	}
+	_, err = client.WriteMultipleRegisters(199, 2, []byte{0, 1, 0, 2})
+	if mbError, ok := err.(*ModbusError); !ok || mbError.FunctionCode != FuncCodeWriteMultipleRegisters|0x80 ||
+		mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = client.ReadInputRegisters(0, 1); err == nil {
+		t.Fatal("illegal function expected")
+	}
+}
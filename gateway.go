@@ -0,0 +1,211 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// Gateway forwards requests of Modbus TCP clients to serial RTU buses,
+// routing them by unit id:
+//  bus := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  gateway := modbus.NewGateway()
+//  gateway.Route(bus, 1, 2, 3)
+//  err := gateway.ListenAndServe(":502")
+// Requests to the same bus are queued and sent one at a time. Requests to
+// unit ids without a route are answered with the exception gateway path
+// unavailable, and requests the bus fails to answer with the exception
+// gateway target device failed to respond.
+type Gateway struct {
+	// Logger logs forwarding failures if set.
+	Logger *log.Logger
+	// Limits of the clients
+	ConnLimits
+
+	mu       sync.Mutex
+	routes   map[byte]*gatewayBus
+	listener net.Listener
+	conns    connTracker
+	wg       sync.WaitGroup
+}
+
+// gatewayBus serializes the requests sent to a bus.
+type gatewayBus struct {
+	mu          sync.Mutex
+	transporter Transporter
+}
+
+// NewGateway allocates a new Gateway without routes.
+func NewGateway() *Gateway {
+	return &Gateway{
+		routes: make(map[byte]*gatewayBus),
+	}
+}
+
+// Route forwards requests to the unit ids to the bus, which sends RTU
+// frames, usually a RTUClientHandler. Its slave id is not used, frames are
+// addressed to the unit id of the request. Routes of a unit id are
+// replaced, units routed to the same bus share its queue.
+func (g *Gateway) Route(bus Transporter, unitIds ...byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var b *gatewayBus
+	for _, route := range g.routes {
+		if route.transporter == bus {
+			b = route
+			break
+		}
+	}
+	if b == nil {
+		b = &gatewayBus{transporter: bus}
+	}
+	for _, unitId := range unitIds {
+		g.routes[unitId] = b
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves clients until
+// Close is called.
+func (g *Gateway) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return g.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each of them in its
+// own goroutine until Close is called. It always returns a non-nil error.
+func (g *Gateway) Serve(listener net.Listener) error {
+	if err := g.parseLimits(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.listener = listener
+	g.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if g.conns.isDraining() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		host, err := g.admit(conn)
+		if err != nil {
+			g.logf("modbus: gateway rejected connection: %v", err)
+			conn.Close()
+			continue
+		}
+		if !g.conns.add(conn) {
+			g.release(host)
+			conn.Close()
+			continue
+		}
+		g.wg.Add(1)
+		go g.serveConn(conn, host)
+	}
+}
+
+// Close stops listening, closes client connections and waits for requests
+// in progress to complete.
+func (g *Gateway) Close() (err error) {
+	g.mu.Lock()
+	if g.listener != nil {
+		err = g.listener.Close()
+	}
+	g.conns.closeAll()
+	g.mu.Unlock()
+	g.wg.Wait()
+	return
+}
+
+// Shutdown stops listening and waits for the requests in progress to be
+// forwarded, up to the deadline of ctx, closing connections once idle.
+// If ctx is done first, Shutdown closes them without waiting for the
+// requests in progress and returns the error of ctx.
+func (g *Gateway) Shutdown(ctx context.Context) (err error) {
+	g.conns.drain()
+	g.mu.Lock()
+	if g.listener != nil {
+		err = g.listener.Close()
+	}
+	g.mu.Unlock()
+	if e := waitContext(ctx, &g.wg); e != nil {
+		g.conns.closeAll()
+		return e
+	}
+	return
+}
+
+func (g *Gateway) serveConn(conn net.Conn, host string) {
+	defer g.wg.Done()
+	defer g.release(host)
+	defer func() {
+		g.conns.remove(conn)
+		conn.Close()
+	}()
+	serveTCP(conn, &g.ConnLimits, &g.conns, false, g.logf, g.forward)
+}
+
+// forward sends the request to the bus of the unit and returns its
+// response, a gateway exception on failure, or nil for broadcasts.
+func (g *Gateway) forward(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+	g.mu.Lock()
+	bus := g.routes[unitId]
+	g.mu.Unlock()
+	if bus == nil {
+		g.logf("modbus: gateway has no route to unit id '%v'", unitId)
+		return gatewayException(request, ExceptionCodeGatewayPathUnavailable), nil
+	}
+	response, err := bus.send(unitId, request)
+	if err != nil {
+		g.logf("modbus: gateway failed to forward request to unit id '%v': %v", unitId, err)
+		return gatewayException(request, ExceptionCodeGatewayTargetDeviceFailedToRespond), nil
+	}
+	return response, nil
+}
+
+func (b *gatewayBus) send(unitId byte, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	packager := &rtuPackager{SlaveId: unitId}
+	aduRequest, err := packager.Encode(request)
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	aduResponse, err := b.transporter.Send(aduRequest)
+	b.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if len(aduResponse) == 0 {
+		if unitId != 0 {
+			err = fmt.Errorf("modbus: response data is empty")
+		}
+		return
+	}
+	if err = packager.Verify(aduRequest, aduResponse); err != nil {
+		return
+	}
+	return packager.Decode(aduResponse)
+}
+
+func gatewayException(request *ProtocolDataUnit, exceptionCode byte) *ProtocolDataUnit {
+	return &ProtocolDataUnit{
+		FunctionCode: request.FunctionCode | 0x80,
+		Data:         []byte{exceptionCode},
+	}
+}
+
+func (g *Gateway) logf(format string, v ...interface{}) {
+	if g.Logger != nil {
+		g.Logger.Printf(format, v...)
+	}
+}
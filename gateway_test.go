@@ -0,0 +1,70 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := NewGateway()
+	gateway.Route(&pduHandler{rtuPackager{SlaveId: 1}, serveRegisters}, 1)
+	gateway.Route(transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		return nil, fmt.Errorf("timeout")
+	}), 3)
+	done := make(chan error, 1)
+	go func() { done <- gateway.Serve(listener) }()
+
+	newClient := func(unitId byte) Client {
+		handler := NewTCPClientHandler(listener.Addr().String())
+		handler.SlaveId = unitId
+		handler.Timeout = time.Second
+		t.Cleanup(func() { handler.Close() })
+		return NewClient(handler)
+	}
+	results, err := newClient(1).ReadHoldingRegisters(10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0, 10, 0, 11}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	tests := []struct {
+		unitId byte
+		err    error
+	}{
+		{2, ErrGatewayPathUnavailable},
+		{3, ErrGatewayTargetDeviceFailedToRespond},
+	}
+	for _, test := range tests {
+		_, err = newClient(test.unitId).ReadHoldingRegisters(10, 2)
+		if _, ok := err.(*GatewayError); !ok || !errors.Is(err, test.err) {
+			t.Fatalf("unit %v: expected %v, actual %v", test.unitId, test.err, err)
+		}
+		var modbusError *ModbusError
+		if !errors.As(err, &modbusError) || modbusError.FunctionCode != FuncCodeReadHoldingRegisters|0x80 {
+			t.Fatalf("unit %v: unexpected exception %v", test.unitId, modbusError)
+		}
+	}
+	if errors.Is(ErrGatewayPathUnavailable, ErrGatewayTargetDeviceFailedToRespond) {
+		t.Fatal("gateway errors are not distinct")
+	}
+
+	if err = gateway.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err == nil {
+		t.Fatalf("serve error expected after close")
+	}
+}
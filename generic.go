@@ -0,0 +1,123 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build go1.18
+
+package modbus
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Value is a Go type stored in holding registers, whose register type is
+// the one of the same name, e.g. two registers for float32.
+type Value interface {
+	~uint16 | ~int16 | ~uint32 | ~int32 | ~float32 | ~uint64 | ~int64 | ~float64
+}
+
+// ReadValue reads the holding registers of a value of type T at address,
+// in the word order of the "modbus" struct tag, "" being big-endian:
+//  temperature, err := modbus.ReadValue[float32](client, 100, "cdab")
+func ReadValue[T Value](client RegisterReader, address uint16, order string) (value T, err error) {
+	values, err := ReadValues[T](client, address, 1, order)
+	if err != nil {
+		return
+	}
+	value = values[0]
+	return
+}
+
+// ReadConsistentValue reads the value of type T at address until two
+// consecutive reads return the same value, up to attempts reads. It is
+// meant for counters of several registers, such as the energy counter of a
+// meter, which the device may update between the registers of a read, e.g.
+// a rollover from 0x0000FFFF to 0x00010000 read as 0x0001FFFF:
+//  energy, err := modbus.ReadConsistentValue[uint64](client, 100, "", 3)
+func ReadConsistentValue[T Value](client RegisterReader, address uint16, order string, attempts int) (value T, err error) {
+	if attempts < 2 {
+		err = fmt.Errorf("modbus: attempts '%v' must be at least '%v'", attempts, 2)
+		return
+	}
+	if value, err = ReadValue[T](client, address, order); err != nil {
+		return
+	}
+	for i := 1; i < attempts; i++ {
+		var next T
+		if next, err = ReadValue[T](client, address, order); err != nil {
+			return
+		}
+		// NaN differs from itself
+		if next == value || next != next && value != value {
+			value = next
+			return
+		}
+		value = next
+	}
+	err = fmt.Errorf("modbus: value at address '%v' changed in each of '%v' reads", address, attempts)
+	return
+}
+
+// ReadValues reads count consecutive values of type T starting at address
+// in one request, see ReadValue.
+func ReadValues[T Value](client RegisterReader, address uint16, count int, order string) (values []T, err error) {
+	f, err := valueField[T](order)
+	if err != nil {
+		return
+	}
+	size := f.quantity()
+	if max := int(readRegistersLimit(client)) / size; count < 1 || count > max {
+		err = &RequestError{FunctionCode: FuncCodeReadHoldingRegisters, Field: "count of " + f.typ, Value: count, Min: 1, Max: max}
+		return
+	}
+	results, err := client.ReadHoldingRegisters(address, uint16(count*size))
+	if err != nil {
+		return
+	}
+	if len(results) != 2*count*size {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(results), 2*count*size)
+		return
+	}
+	values = make([]T, count)
+	for i := range values {
+		if err = f.decode(reflect.ValueOf(&values[i]).Elem(), results[2*i*size:2*(i+1)*size]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// WriteValue writes value in the holding registers at address, see
+// ReadValue:
+//  err := modbus.WriteValue(client, 100, "cdab", float32(49.5))
+func WriteValue[T Value](client RegisterWriter, address uint16, order string, value T) error {
+	return WriteValuesOf(client, address, order, value)
+}
+
+// WriteValuesOf writes consecutive values starting at address in one
+// request, see ReadValue.
+func WriteValuesOf[T Value](client RegisterWriter, address uint16, order string, values ...T) (err error) {
+	f, err := valueField[T](order)
+	if err != nil {
+		return
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("modbus: no values to write")
+	}
+	size := f.quantity()
+	data := make([]byte, 2*len(values)*size)
+	for i := range values {
+		if err = f.encode(reflect.ValueOf(values[i]), data[2*i*size:2*(i+1)*size]); err != nil {
+			return
+		}
+	}
+	_, err = client.WriteMultipleRegisters(address, uint16(len(values)*size), data)
+	return
+}
+
+// valueField returns the field encoding values of type T in the order.
+func valueField[T Value](order string) (*registerField, error) {
+	var value T
+	return newValueField(reflect.TypeOf(value).Kind().String(), order)
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build go1.18
+
+package modbus
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestGenericValues(t *testing.T) {
+	client := &memoryClient{}
+	if err := WriteValue(client, 100, "cdab", float32(49.5)); err != nil {
+		t.Fatal(err)
+	}
+	bits := math.Float32bits(49.5)
+	if client.holding[100] != uint16(bits) || client.holding[101] != uint16(bits>>16) {
+		t.Fatalf("unexpected registers: %x", client.holding[100:102])
+	}
+	f, err := ReadValue[float32](client, 100, "cdab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != 49.5 {
+		t.Fatalf("unexpected value: %v", f)
+	}
+
+	type level int16
+	if err = WriteValuesOf(client, 200, "", level(-2), level(3)); err != nil {
+		t.Fatal(err)
+	}
+	levels, err := ReadValues[level](client, 200, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels[0] != -2 || levels[1] != 3 {
+		t.Fatalf("unexpected values: %v", levels)
+	}
+
+	if err = WriteValue(client, 300, "", uint64(math.MaxUint64)); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := ReadValue[uint64](client, 300, ""); err != nil || u != math.MaxUint64 {
+		t.Fatalf("unexpected value: %v, %v", u, err)
+	}
+
+	if _, err = ReadValue[float64](client, 0, "abdc"); err == nil {
+		t.Fatal("error expected for invalid order")
+	}
+	if _, err = ReadValues[float64](client, 0, 32, ""); err == nil {
+		t.Fatal("error expected for too many values")
+	}
+
+	registers := NewClient(&pduHandler{serve: serveRegisters})
+	var requestError *RequestError
+	if _, err = ReadValues[uint32](registers, 0, 63, ""); !errors.As(err, &requestError) || requestError.Max != 62 {
+		t.Fatalf("count error expected, actual %v", err)
+	}
+	if _, err = ReadValues[uint32](WithLimits(registers, Limits{ReadRegisters: 10}), 0, 6, ""); !errors.As(err, &requestError) || requestError.Max != 5 {
+		t.Fatalf("count error expected, actual %v", err)
+	}
+	if values, err := ReadValues[uint32](registers, 0, 62, ""); err != nil || values[61] != 122<<16|123 {
+		t.Fatalf("unexpected values: %v, %v", values, err)
+	}
+}
+
+func TestValueHelpers(t *testing.T) {
+	client := &memoryClient{}
+	if err := WriteFloat32(client, 100, "cdab", 49.5); err != nil {
+		t.Fatal(err)
+	}
+	bits := math.Float32bits(49.5)
+	if client.holding[100] != uint16(bits) || client.holding[101] != uint16(bits>>16) {
+		t.Fatalf("unexpected registers: %x", client.holding[100:102])
+	}
+	if f, err := ReadFloat32(client, 100, "cdab"); err != nil || f != 49.5 {
+		t.Fatalf("unexpected value %v, error %v", f, err)
+	}
+	if err := WriteInt64(client, 200, "dcba", -2); err != nil {
+		t.Fatal(err)
+	}
+	if client.holding[200] != 0xFEFF || client.holding[203] != 0xFFFF {
+		t.Fatalf("unexpected registers: %x", client.holding[200:204])
+	}
+	if i, err := ReadInt64(client, 200, "dcba"); err != nil || i != -2 {
+		t.Fatalf("unexpected value %v, error %v", i, err)
+	}
+	if err := WriteUint32(client, 300, "", 0x12345678); err != nil {
+		t.Fatal(err)
+	}
+	if client.holding[300] != 0x1234 || client.holding[301] != 0x5678 {
+		t.Fatalf("unexpected registers: %x", client.holding[300:302])
+	}
+	if _, err := ReadFloat64(client, 0, "xyz"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+// tornClient returns the registers of reads in sequence, the last one
+// repeatedly.
+type tornClient struct {
+	Client
+	reads [][]uint16
+}
+
+func (c *tornClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	registers := c.reads[0]
+	if len(c.reads) > 1 {
+		c.reads = c.reads[1:]
+	}
+	data := make([]byte, 2*len(registers))
+	for i, r := range registers {
+		data[2*i] = byte(r >> 8)
+		data[2*i+1] = byte(r)
+	}
+	return data, nil
+}
+
+func TestReadConsistentValue(t *testing.T) {
+	// The counter rolls over from 0x0000FFFF to 0x00010000 during the first read
+	client := &tornClient{reads: [][]uint16{{0, 0, 1, 0xFFFF}, {0, 0, 1, 0}}}
+	if value, err := ReadConsistentUint64(client, 0, ""); err != nil || value != 0x10000 {
+		t.Fatalf("unexpected value %x, error %v", value, err)
+	}
+	client = &tornClient{reads: [][]uint16{{1}, {2}, {3}, {4}}}
+	if _, err := ReadConsistentValue[uint16](client, 0, "", 3); err == nil {
+		t.Fatal("error expected")
+	}
+	nan := math.Float64bits(math.NaN())
+	client = &tornClient{reads: [][]uint16{{uint16(nan >> 48), uint16(nan >> 32), uint16(nan >> 16), uint16(nan)}}}
+	if value, err := ReadConsistentFloat64(client, 0, ""); err != nil || !math.IsNaN(value) {
+		t.Fatalf("unexpected value %v, error %v", value, err)
+	}
+	if _, err := ReadConsistentValue[uint64](client, 0, "", 1); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ReadHoldingRegistersInto reads quantity holding registers starting at
+// address into dst, which must have room for them:
+//  values := make([]uint16, 10)
+//  err := modbus.ReadHoldingRegistersInto(client, 100, 10, values)
+// Clients created by NewClient without middleware decode the values from
+// their frame buffers, so that polling does not allocate the results.
+func ReadHoldingRegistersInto(client Client, address, quantity uint16, dst []uint16) error {
+	return readRegistersInto(client, FuncCodeReadHoldingRegisters, address, quantity, dst)
+}
+
+// ReadInputRegistersInto reads quantity input registers starting at
+// address into dst, see ReadHoldingRegistersInto.
+func ReadInputRegistersInto(client Client, address, quantity uint16, dst []uint16) error {
+	return readRegistersInto(client, FuncCodeReadInputRegisters, address, quantity, dst)
+}
+
+// ReadCoilsInto reads quantity coils starting at address into dst, see
+// ReadHoldingRegistersInto.
+func ReadCoilsInto(client Client, address, quantity uint16, dst []bool) error {
+	return readBitsInto(client, FuncCodeReadCoils, address, quantity, dst)
+}
+
+// ReadDiscreteInputsInto reads quantity discrete inputs starting at
+// address into dst, see ReadHoldingRegistersInto.
+func ReadDiscreteInputsInto(client Client, address, quantity uint16, dst []bool) error {
+	return readBitsInto(client, FuncCodeReadDiscreteInputs, address, quantity, dst)
+}
+
+func readRegistersInto(client Client, functionCode byte, address, quantity uint16, dst []uint16) error {
+	if int(quantity) > len(dst) {
+		return fmt.Errorf("modbus: quantity '%v' exceeds destination length '%v'", quantity, len(dst))
+	}
+	return readInto(client, functionCode, address, quantity, func(results []byte) error {
+		if len(results) != 2*int(quantity) {
+			return fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(results), quantity)
+		}
+		for i := range dst[:quantity] {
+			dst[i] = binary.BigEndian.Uint16(results[2*i:])
+		}
+		return nil
+	})
+}
+
+func readBitsInto(client Client, functionCode byte, address, quantity uint16, dst []bool) error {
+	if int(quantity) > len(dst) {
+		return fmt.Errorf("modbus: quantity '%v' exceeds destination length '%v'", quantity, len(dst))
+	}
+	return readInto(client, functionCode, address, quantity, func(results []byte) error {
+		if len(results) != (int(quantity)+7)/8 {
+			return fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(results), quantity)
+		}
+		for i := range dst[:quantity] {
+			dst[i] = results[i/8]&(1<<uint(i%8)) != 0
+		}
+		return nil
+	})
+}
+
+// readInto reads with the function and passes the results to decode.
+func readInto(c Client, functionCode byte, address, quantity uint16, decode func(results []byte) error) (err error) {
+	if mb, ok := c.(*client); ok && len(mb.middleware) == 0 {
+		return mb.readInto(functionCode, address, quantity, decode)
+	}
+	var results []byte
+	switch functionCode {
+	case FuncCodeReadCoils:
+		results, err = c.ReadCoils(address, quantity)
+	case FuncCodeReadDiscreteInputs:
+		results, err = c.ReadDiscreteInputs(address, quantity)
+	case FuncCodeReadHoldingRegisters:
+		results, err = c.ReadHoldingRegisters(address, quantity)
+	case FuncCodeReadInputRegisters:
+		results, err = c.ReadInputRegisters(address, quantity)
+	}
+	if err != nil {
+		return
+	}
+	return decode(results)
+}
+
+// readInto is a read request of ReadCoils, ReadDiscreteInputs,
+// ReadHoldingRegisters or ReadInputRegisters, whose results are passed to
+// decode before the frame buffer is released.
+func (mb *client) readInto(functionCode byte, address, quantity uint16, decode func(results []byte) error) error {
+	if err := mb.checkReadRange(functionCode, address, quantity); err != nil {
+		return err
+	}
+	var data [4]byte
+	binary.BigEndian.PutUint16(data[:], address)
+	binary.BigEndian.PutUint16(data[2:], quantity)
+	request := ProtocolDataUnit{FunctionCode: functionCode, Data: data[:]}
+	return mb.exchange(&request, func(response *ProtocolDataUnit, pooled bool) error {
+		if response.FunctionCode != functionCode {
+			return responseError(response)
+		}
+		if len(response.Data) == 0 {
+			return fmt.Errorf("modbus: response data is empty")
+		}
+		count := int(response.Data[0])
+		length := len(response.Data) - 1
+		if count != length {
+			return fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		}
+		return decode(response.Data[1:])
+	})
+}
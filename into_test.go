@@ -0,0 +1,95 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadInto(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveCounter(ln)
+
+	handler := NewTCPClientHandler(ln.Addr().String())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	client := NewClient(handler)
+	values := make([]uint16, 4)
+	if err = ReadHoldingRegistersInto(client, 0, 3, values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1 || values[2] != 1 || values[3] != 0 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if err = ReadHoldingRegistersInto(client, 0, 5, values); err == nil {
+		t.Fatal("error expected for quantity exceeding destination")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := ReadHoldingRegistersInto(client, 0, 4, values); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// The PDUs of the request and the response, not the results
+	if allocs > 3 {
+		t.Fatalf("unexpected allocations per request: %v", allocs)
+	}
+}
+
+func TestReadIntoClient(t *testing.T) {
+	// Other clients
+	memory := &memoryClient{}
+	memory.coils[3] = true
+	memory.holding[1] = 0x1234
+	registers := make([]uint16, 2)
+	if err := ReadHoldingRegistersInto(memory, 0, 2, registers); err != nil {
+		t.Fatal(err)
+	}
+	if registers[1] != 0x1234 {
+		t.Fatalf("unexpected registers: %v", registers)
+	}
+	coils := make([]bool, 10)
+	if err := ReadCoilsInto(memory, 0, 10, coils); err != nil {
+		t.Fatal(err)
+	}
+	for i, coil := range coils {
+		if coil != (i == 3) {
+			t.Fatalf("unexpected coils: %v", coils)
+		}
+	}
+
+	// Clients with middleware
+	client := NewMiddlewareClient(&pduHandler{serve: serveRegisters}, StrictValidation)
+	if err := ReadHoldingRegistersInto(client, 10, 2, registers); err != nil {
+		t.Fatal(err)
+	}
+	if registers[0] != 10 || registers[1] != 11 {
+		t.Fatalf("unexpected registers: %v", registers)
+	}
+}
+
+func TestReadIntoLimits(t *testing.T) {
+	client := NewClient(&pduHandler{serve: serveRegisters})
+	registers := make([]uint16, 126)
+	var requestError *RequestError
+	err := ReadHoldingRegistersInto(client, 0xFFFF, 2, registers)
+	if !errors.As(err, &requestError) || requestError.Field != "address" {
+		t.Fatalf("address error expected, actual %v", err)
+	}
+	err = ReadHoldingRegistersInto(client, 0, 126, registers)
+	if !errors.As(err, &requestError) || requestError.Max != 125 {
+		t.Fatalf("quantity error expected, actual %v", err)
+	}
+	err = ReadHoldingRegistersInto(WithLimits(client, Limits{ReadRegisters: 10}), 0, 11, registers)
+	if !errors.As(err, &requestError) || requestError.Max != 10 {
+		t.Fatalf("quantity error expected, actual %v", err)
+	}
+}
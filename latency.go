@@ -0,0 +1,65 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBudget reports requests whose response takes longer than their
+// budget, without failing them, to spot degrading links before requests
+// start timing out. It is used as a Middleware:
+//  budget := &modbus.LatencyBudget{Budget: 200 * time.Millisecond}
+//  budget.Slow = func(request *modbus.Request, latency time.Duration) {
+//  	log.Printf("slow response to function %v: %v", request.FunctionCode, latency)
+//  }
+//  client := modbus.NewMiddlewareClient(handler, budget.Middleware)
+// Requests failing without response are not reported, they are reported
+// by their error.
+type LatencyBudget struct {
+	// Budget is the expected maximum latency of responses, requests are
+	// not checked if zero.
+	Budget time.Duration
+	// Budgets overrides Budget for the requests of function codes, e.g.
+	// for slow writes to EEPROM.
+	Budgets map[byte]time.Duration
+	// Slow is called with the requests exceeding their budget, if set.
+	Slow func(request *Request, latency time.Duration)
+	// Clock defaults to the system time if nil.
+	Clock Clock
+
+	slow atomic.Uint64
+}
+
+// Middleware implements Middleware.
+func (b *LatencyBudget) Middleware(request *Request, next Sender) (response *Response, err error) {
+	budget, ok := b.Budgets[request.FunctionCode]
+	if !ok {
+		budget = b.Budget
+	}
+	if budget <= 0 {
+		return next(request)
+	}
+	clock := clockOrSystem(b.Clock)
+	start := clock.Now()
+	response, err = next(request)
+	if err != nil {
+		return
+	}
+	if latency := clock.Now().Sub(start); latency > budget {
+		b.slow.Add(1)
+		if b.Slow != nil {
+			b.Slow(request, latency)
+		}
+	}
+	return
+}
+
+// SlowResponses returns the number of responses which exceeded their
+// budget.
+func (b *LatencyBudget) SlowResponses() uint64 {
+	return b.slow.Load()
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyBudget(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	latency := 150 * time.Millisecond
+	serve := func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		clock.Sleep(latency)
+		return serveRegisters(request)
+	}
+	var slow []time.Duration
+	budget := &LatencyBudget{
+		Budget:  100 * time.Millisecond,
+		Budgets: map[byte]time.Duration{FuncCodeWriteMultipleRegisters: time.Second},
+		Slow: func(request *Request, latency time.Duration) {
+			slow = append(slow, latency)
+		},
+		Clock: clock,
+	}
+	client := NewMiddlewareClient(&pduHandler{serve: serve}, budget.Middleware)
+
+	if _, err := client.ReadHoldingRegisters(10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteMultipleRegisters(10, 1, []byte{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	latency = 50 * time.Millisecond
+	if _, err := client.ReadHoldingRegisters(10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(slow) != 1 || slow[0] != 150*time.Millisecond || budget.SlowResponses() != 1 {
+		t.Fatalf("unexpected slow responses %v, %v", slow, budget.SlowResponses())
+	}
+}
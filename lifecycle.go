@@ -0,0 +1,38 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+// Lifecycle holds callbacks notified of the state of the link of a
+// transporter, e.g. to update a health status or raise an alarm when the
+// link to a PLC drops or recovers. Callbacks are called with the
+// transporter locked and must not call its methods.
+type Lifecycle struct {
+	// OnConnect is called when the connection or serial port is opened.
+	OnConnect func()
+	// OnDisconnect is called when the connection or serial port is
+	// closed, by Close or after the idle timeout.
+	OnDisconnect func()
+	// OnError is called when connecting or sending a request fails.
+	OnError func(err error)
+}
+
+func (l *Lifecycle) connected() {
+	if l.OnConnect != nil {
+		l.OnConnect()
+	}
+}
+
+func (l *Lifecycle) disconnected() {
+	if l.OnDisconnect != nil {
+		l.OnDisconnect()
+	}
+}
+
+// notifyError calls OnError if *err is not nil, it is deferred by Send.
+func (l *Lifecycle) notifyError(err *error) {
+	if *err != nil && l.OnError != nil {
+		l.OnError(*err)
+	}
+}
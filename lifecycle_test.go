@@ -0,0 +1,61 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPLifecycle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// Close connections without responding
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	var events []string
+	var lastErr error
+	handler := NewTCPClientHandler(listener.Addr().String())
+	handler.Timeout = time.Second
+	handler.OnConnect = func() { events = append(events, "connect") }
+	handler.OnDisconnect = func() { events = append(events, "disconnect") }
+	handler.OnError = func(err error) {
+		events = append(events, "error")
+		lastErr = err
+	}
+	client := NewClient(handler)
+	if _, err = client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatalf("expected error")
+	}
+	if lastErr != err {
+		t.Fatalf("expected error %v, actual %v", err, lastErr)
+	}
+	handler.Close()
+	handler.Close()
+	if len(events) != 3 || events[0] != "connect" || events[1] != "error" || events[2] != "disconnect" {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	events = nil
+	handler.Address = "127.0.0.1:1"
+	if err = handler.Connect(); err == nil {
+		t.Fatalf("expected connect error")
+	}
+	if len(events) != 1 || events[0] != "error" {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+)
+
+// Limits are the largest quantities of the requests of a client. Zero
+// fields are the limits of the specification, which conforming devices
+// accept. Devices accepting larger blocks may be read with fewer requests,
+// up to what the byte count of the frames can encode, 2040 bits or 127
+// registers:
+//  client = modbus.WithLimits(client, modbus.Limits{ReadRegisters: 127})
+type Limits struct {
+	// ReadBits bounds reads of coils and discrete inputs, 2000.
+	ReadBits uint16
+	// WriteBits bounds writes of multiple coils, 1968.
+	WriteBits uint16
+	// ReadRegisters bounds reads of holding and input registers, including
+	// the read of ReadWriteMultipleRegisters, 125.
+	ReadRegisters uint16
+	// WriteRegisters bounds writes of multiple registers, 123.
+	WriteRegisters uint16
+	// ReadWriteRegisters bounds the write of ReadWriteMultipleRegisters,
+	// 121.
+	ReadWriteRegisters uint16
+}
+
+// RequestError is a request rejected by the client before it is sent, as
+// a field is out of range or the value does not match the quantity:
+//  var requestError *modbus.RequestError
+//  if errors.As(err, &requestError) && requestError.Field == "quantity" {
+type RequestError struct {
+	FunctionCode byte
+	// Field is the name of the field, e.g. "quantity to read" or "value
+	// size", whose Value must be between Min and Max.
+	Field string
+	Value int
+	Min   int
+	Max   int
+}
+
+// Error implements error interface.
+func (e *RequestError) Error() string {
+	if e.Min == e.Max {
+		return fmt.Sprintf("modbus: %v '%v' must be '%v'", e.Field, e.Value, e.Min)
+	}
+	return fmt.Sprintf("modbus: %v '%v' must be between '%v' and '%v'", e.Field, e.Value, e.Min, e.Max)
+}
+
+// WithLimits returns a copy of client, created by NewClient, whose
+// requests are checked against limits instead of the limits of the
+// specification, see Limits. Other clients are returned unchanged.
+func WithLimits(c Client, limits Limits) Client {
+	mb, ok := c.(*client)
+	if !ok {
+		return c
+	}
+	clone := *mb
+	clone.limits = &limits
+	return &clone
+}
+
+// orSpec returns the limit of the field, or the limit of the
+// specification if it is not set.
+func orSpec(field, spec uint16) uint16 {
+	if field > 0 {
+		return field
+	}
+	return spec
+}
+
+// checkRange checks the quantity of the request against max and the range
+// against the address space.
+func checkRange(functionCode byte, field string, address, quantity, max uint16) error {
+	if quantity < 1 || quantity > max {
+		return &RequestError{FunctionCode: functionCode, Field: field, Value: int(quantity), Min: 1, Max: int(max)}
+	}
+	if int(address)+int(quantity) > 65536 {
+		return &RequestError{FunctionCode: functionCode, Field: "address", Value: int(address), Min: 0, Max: 65536 - int(quantity)}
+	}
+	return nil
+}
+
+// checkReadRange checks the range of a read request against the limits of
+// the client.
+func (mb *client) checkReadRange(functionCode byte, address, quantity uint16) error {
+	var limits Limits
+	if mb.limits != nil {
+		limits = *mb.limits
+	}
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		return checkRange(functionCode, "quantity", address, quantity, orSpec(limits.ReadBits, maxReadBits))
+	case FuncCodeReadWriteMultipleRegisters:
+		return checkRange(functionCode, "quantity to read", address, quantity, orSpec(limits.ReadRegisters, maxReadRegisters))
+	}
+	return checkRange(functionCode, "quantity", address, quantity, orSpec(limits.ReadRegisters, maxReadRegisters))
+}
+
+// readRegistersLimit returns the largest read of registers of the client,
+// the one of the specification for clients not created by NewClient.
+func readRegistersLimit(c interface{}) uint16 {
+	if mb, ok := c.(*client); ok && mb.limits != nil {
+		return orSpec(mb.limits.ReadRegisters, maxReadRegisters)
+	}
+	return maxReadRegisters
+}
+
+// checkWrite checks the range and the value of a write request against
+// the limits of the client, and that the value has the bits of the
+// quantity, or 2 or 4 bytes per register, as the byte count of the frame
+// has at most 255.
+func (mb *client) checkWrite(functionCode byte, address, quantity uint16, value []byte) error {
+	var limits Limits
+	if mb.limits != nil {
+		limits = *mb.limits
+	}
+	var err error
+	size := 2 * int(quantity)
+	switch functionCode {
+	case FuncCodeWriteMultipleCoils:
+		err = checkRange(functionCode, "quantity", address, quantity, orSpec(limits.WriteBits, maxWriteBits))
+		size = (int(quantity) + 7) / 8
+	case FuncCodeReadWriteMultipleRegisters:
+		err = checkRange(functionCode, "quantity to write", address, quantity, orSpec(limits.ReadWriteRegisters, maxReadWriteRegisters))
+	default:
+		err = checkRange(functionCode, "quantity", address, quantity, orSpec(limits.WriteRegisters, maxWriteRegisters))
+	}
+	if err != nil {
+		return err
+	}
+	if functionCode == FuncCodeWriteMultipleCoils {
+		if len(value) != size {
+			return &RequestError{FunctionCode: functionCode, Field: "value size", Value: len(value), Min: size, Max: size}
+		}
+		return nil
+	}
+	// 32-bit registers of some devices, e.g. Enron, have 4 bytes
+	if (len(value) != size && len(value) != 2*size) || len(value) > 255 {
+		max := 2 * size
+		if max > 255 {
+			max = 255
+		}
+		return &RequestError{FunctionCode: functionCode, Field: "value size", Value: len(value), Min: size, Max: max}
+	}
+	return nil
+}
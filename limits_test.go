@@ -0,0 +1,68 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClientLimits(t *testing.T) {
+	sent := 0
+	handler := &pduHandler{serve: func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		sent++
+		return serveRegisters(request)
+	}}
+	client := NewClient(handler)
+
+	tests := []struct {
+		request func() error
+		err     RequestError
+	}{
+		{func() (err error) { _, err = client.ReadCoils(0, 2001); return },
+			RequestError{FuncCodeReadCoils, "quantity", 2001, 1, 2000}},
+		{func() (err error) { _, err = client.ReadHoldingRegisters(0, 0); return },
+			RequestError{FuncCodeReadHoldingRegisters, "quantity", 0, 1, 125}},
+		{func() (err error) { _, err = client.ReadInputRegisters(65500, 40); return },
+			RequestError{FuncCodeReadInputRegisters, "address", 65500, 0, 65496}},
+		{func() (err error) { _, err = client.WriteMultipleCoils(0, 10, []byte{1}); return },
+			RequestError{FuncCodeWriteMultipleCoils, "value size", 1, 2, 2}},
+		{func() (err error) { _, err = client.WriteMultipleRegisters(0, 2, []byte{0, 1, 0}); return },
+			RequestError{FuncCodeWriteMultipleRegisters, "value size", 3, 4, 8}},
+		{func() (err error) {
+			_, err = client.ReadWriteMultipleRegisters(0, 1, 0, 122, make([]byte, 244))
+			return
+		},
+			RequestError{FuncCodeReadWriteMultipleRegisters, "quantity to write", 122, 1, 121}},
+	}
+	for i, test := range tests {
+		var requestError *RequestError
+		if err := test.request(); !errors.As(err, &requestError) || *requestError != test.err {
+			t.Fatalf("%v: unexpected error %v", i, err)
+		}
+	}
+	if sent != 0 {
+		t.Fatalf("'%v' invalid requests sent", sent)
+	}
+	if err := (&RequestError{Field: "quantity", Value: 0, Min: 1, Max: 125}).Error(); err != "modbus: quantity '0' must be between '1' and '125'" {
+		t.Fatalf("unexpected message %v", err)
+	}
+
+	limited := WithLimits(client, Limits{ReadRegisters: 10, ReadBits: 2040})
+	if _, err := limited.ReadHoldingRegisters(0, 11); err == nil {
+		t.Fatal("error expected")
+	}
+	if _, err := limited.ReadHoldingRegisters(0, 10); err != nil {
+		t.Fatal(err)
+	}
+	// Sent, the device does not serve coils
+	var mbError *ModbusError
+	if _, err := limited.ReadCoils(0, 2040); !errors.As(err, &mbError) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := client.ReadHoldingRegisters(0, 11); err != nil {
+		t.Fatal(err)
+	}
+}
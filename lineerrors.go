@@ -0,0 +1,45 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+// LineErrors are the errors of the UART counted by the driver of a serial
+// port. Unlike CRCErrors, they point to the line rather than to the
+// devices: framing and parity errors to a wrong baud rate or character
+// format, noise or missing termination, breaks to a disconnected line and
+// overruns to the host not reading the port in time.
+type LineErrors struct {
+	Framing uint64
+	Parity  uint64
+	// Overrun counts characters lost by the UART, BufferOverrun those lost
+	// by the buffer of the driver.
+	Overrun       uint64
+	BufferOverrun uint64
+	Break         uint64
+}
+
+// lineErrorCounter is implemented by ports counting the errors of their
+// UART.
+type lineErrorCounter interface {
+	LineErrors() (LineErrors, error)
+}
+
+// LineErrors returns the errors of the UART counted by the driver, opening
+// the port if needed. On Linux, they are counted since the driver was
+// loaded, on Windows since the port was opened. It fails on platforms and
+// drivers not counting them, e.g. most USB adapters on Linux.
+func (mb *serialPort) LineErrors() (errors LineErrors, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err = mb.connect(); err != nil {
+		return
+	}
+	if counter, ok := mb.port.(lineErrorCounter); ok {
+		return counter.LineErrors()
+	}
+	return readLineErrors(mb.address)
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// serialICounter is the serial_icounter_struct of TIOCGICOUNT.
+type serialICounter struct {
+	cts, dsr, rng, dcd int32
+	rx, tx             int32
+	frame, overrun     int32
+	parity, brk        int32
+	bufOverrun         int32
+	reserved           [9]int32
+}
+
+// readLineErrors reads the error counters of the UART of the tty at
+// address. The port of github.com/goburrow/serial does not expose its
+// file descriptor, the tty is opened again, which does not change its
+// settings.
+func readLineErrors(address string) (errors LineErrors, err error) {
+	fd, err := syscall.Open(address, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		err = fmt.Errorf("modbus: opening '%v' failed: %v", address, err)
+		return
+	}
+	defer syscall.Close(fd)
+	var counter serialICounter
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGICOUNT, uintptr(unsafe.Pointer(&counter))); errno != 0 {
+		err = fmt.Errorf("modbus: reading line errors of '%v' failed: %v", address, errno)
+		return
+	}
+	errors = LineErrors{
+		Framing:       uint64(uint32(counter.frame)),
+		Parity:        uint64(uint32(counter.parity)),
+		Overrun:       uint64(uint32(counter.overrun)),
+		BufferOverrun: uint64(uint32(counter.bufOverrun)),
+		Break:         uint64(uint32(counter.brk)),
+	}
+	return
+}
@@ -0,0 +1,19 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !linux && !modbus_noserial
+
+package modbus
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// readLineErrors fails, the ports of Windows count their line errors, see
+// windowsPort.LineErrors.
+func readLineErrors(address string) (errors LineErrors, err error) {
+	err = fmt.Errorf("modbus: line errors of '%v' are not supported on '%v'", address, runtime.GOOS)
+	return
+}
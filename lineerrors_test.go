@@ -0,0 +1,57 @@
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/goburrow/serial"
+)
+
+// countingPort counts the errors of its UART.
+type countingPort struct {
+	nopCloser
+	errors LineErrors
+}
+
+func (p *countingPort) LineErrors() (LineErrors, error) {
+	return p.errors, nil
+}
+
+func TestSerialLineErrors(t *testing.T) {
+	handler := NewRTUClientHandler("/dev/ttyUSB0")
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &countingPort{nopCloser{ReadWriter: &bytes.Buffer{}}, LineErrors{Framing: 3, Break: 1}}, nil
+	}
+	defer handler.Close()
+
+	errors, err := handler.LineErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errors != (LineErrors{Framing: 3, Break: 1}) {
+		t.Fatalf("unexpected line errors %+v", errors)
+	}
+	if !handler.IsConnected() {
+		t.Fatal("port is not opened")
+	}
+}
+
+func TestSerialLineErrorsUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ports of Windows count their line errors")
+	}
+	handler := NewRTUClientHandler("/dev/null")
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &nopCloser{ReadWriter: &bytes.Buffer{}}, nil
+	}
+	defer handler.Close()
+
+	// /dev/null is not a tty
+	if _, err := handler.LineErrors(); err == nil {
+		t.Fatal("error expected")
+	}
+}
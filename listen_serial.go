@@ -0,0 +1,77 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"context"
+
+	"github.com/goburrow/serial"
+)
+
+// Listen reads the port between requests and calls unsolicited with each
+// frame received outside a request, e.g. pushed by devices reporting
+// events, instead of leaving it to corrupt the next response. It returns
+// when ctx is done or reading fails:
+//  ctx, cancel := context.WithCancel(context.Background())
+//  defer cancel()
+//  go handler.Listen(ctx, func(frame []byte) {
+//  	log.Printf("unsolicited frame % x", frame)
+//  })
+// Frames are delimited by the silence of the line and passed as received,
+// without checking their CRC or LRC. Requests wait for the frame being
+// received, or for one read of the port, which lasts 50ms at most.
+// unsolicited is called without the port locked, it can send requests
+// with the handler but must not keep frame.
+func (mb *serialPort) Listen(ctx context.Context, unsolicited func(frame []byte)) error {
+	var buf aduBuffer
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frame, err := mb.receiveFrame(buf[:])
+		if err != nil {
+			return err
+		}
+		if len(frame) > 0 {
+			unsolicited(frame)
+		}
+	}
+}
+
+// receiveFrame reads one read of the port and, if data is received, the
+// rest of the frame until the line is silent.
+func (mb *serialPort) receiveFrame(buf []byte) (frame []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	defer mb.notifyError(&err)
+
+	if err = mb.connect(); err != nil {
+		return
+	}
+	// The port is not idle while listening
+	mb.lastActivity = mb.now()
+	n := 0
+	for n < len(buf) {
+		var n1 int
+		n1, err = mb.port.Read(buf[n:])
+		n += n1
+		if err == serial.ErrTimeout {
+			err = nil
+			break
+		}
+		if err != nil {
+			mb.exchanged(&err)
+			return
+		}
+	}
+	frame = buf[:n]
+	if n > 0 {
+		mb.lastReceive = mb.now()
+		mb.logf("modbus: received unsolicited frame of '%v' bytes\n", n)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+func TestSerialListen(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 1)
+	server := NewServer(nil)
+	server.Handle(1, store)
+	serverPort, clientPort := net.Pipe()
+	go server.ServeRTU(&pipePort{serverPort})
+	defer server.Close()
+
+	handler := NewRTUClientHandler("pipe")
+	handler.SlaveId = 1
+	handler.Timeout = 200 * time.Millisecond
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &pipePort{clientPort}, nil
+	}
+	defer handler.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	frames := make(chan []byte, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Listen(ctx, func(frame []byte) {
+			frames <- append([]byte(nil), frame...)
+		})
+	}()
+
+	event := []byte{0x05, 0x41, 0x01, 0x02, 0x93, 0x5D}
+	if _, err := serverPort.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	if frame := <-frames; !bytes.Equal(frame, event) {
+		t.Fatalf("unexpected frame % x", frame)
+	}
+	// Requests are exchanged while listening
+	results, err := NewClient(handler).ReadHoldingRegisters(0, 1)
+	if err != nil || !bytes.Equal(results, []byte{0, 1}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(frames) != 0 {
+		t.Fatalf("response delivered as unsolicited frame")
+	}
+}
@@ -4,6 +4,13 @@
 
 package modbus
 
+// LRC returns the Longitudinal Redundancy Check of data as used by ASCII
+// frames, where it is sent as two hexadecimal characters before CRLF.
+func LRC(data []byte) byte {
+	var lrc lrc
+	return lrc.reset().pushBytes(data).value()
+}
+
 // Longitudinal Redundancy Checking
 type lrc struct {
 	sum uint8
@@ -17,3 +17,9 @@ func TestLRC(t *testing.T) {
 		t.Fatalf("lrc expected %v, actual %v", 0xF1, lrc.value())
 	}
 }
+
+func TestLRCFunc(t *testing.T) {
+	if v := LRC([]byte{0x01, 0x03, 0x01, 0x0A}); v != 0xF1 {
+		t.Fatalf("lrc expected %v, actual %v", 0xF1, v)
+	}
+}
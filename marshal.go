@@ -0,0 +1,380 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/goburrow/modbus/encoding"
+)
+
+// Struct fields are mapped to registers using the "modbus" tag:
+//  type Meter struct {
+//  	Voltage float32 `modbus:"addr=100,type=float32,order=cdab"`
+//  	Current float64 `modbus:"addr=102,type=int16,scale=0.01,clamp=0:20"`
+//  	Energy  uint64  `modbus:"addr=104"`
+//  }
+// Options are:
+//  addr   register address, required
+//  type   uint16, int16, uint32, int32, float32, uint64, int64 or float64;
+//         defaults to the type matching the field kind
+//  order  abcd (default, big-endian), cdab (word swapped),
+//         badc (byte swapped) or dcba (little-endian); for 64-bit types
+//         the swaps apply to all words and bytes, see encoding.ParseOrder
+// Transforms, such as scale, clamp, unit and round, may follow and are
+// applied in the declared order on read and in reverse order on write,
+// see RegisterTransform.
+// Fields without the tag are ignored.
+
+// registerField is a struct field mapped to registers.
+type registerField struct {
+	name    string
+	index   int
+	address uint16
+	typ     string
+	order   encoding.Order
+	// transforms convert raw values to field values.
+	transforms []Transform
+}
+
+// registerTypeSizes is the number of registers of each type.
+var registerTypeSizes = map[string]int{
+	"uint16": 1, "int16": 1,
+	"uint32": 2, "int32": 2, "float32": 2,
+	"uint64": 4, "int64": 4, "float64": 4,
+}
+
+func (f *registerField) quantity() int {
+	return registerTypeSizes[f.typ]
+}
+
+// Unmarshal decodes the register block data, which starts at address,
+// into the tagged fields of the struct pointed to by v.
+func Unmarshal(address uint16, data []byte, v interface{}) error {
+	if err := checkStructPointer(v); err != nil {
+		return err
+	}
+	value, fields, err := registerFields(v)
+	if err != nil {
+		return err
+	}
+	for i := range fields {
+		f := &fields[i]
+		start := (int(f.address) - int(address)) * 2
+		end := start + f.quantity()*2
+		if start < 0 || end > len(data) {
+			return fmt.Errorf("modbus: field '%v' address '%v' is out of data range", f.name, f.address)
+		}
+		if err = f.decode(value.Field(f.index), data[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the tagged fields of the struct v into a register block
+// and returns its starting address. Registers between fields are zero.
+func Marshal(v interface{}) (address uint16, data []byte, err error) {
+	value, fields, err := registerFields(v)
+	if err != nil {
+		return
+	}
+	address, quantity := registerSpan(fields)
+	data = make([]byte, quantity*2)
+	for i := range fields {
+		f := &fields[i]
+		start := (int(f.address) - int(address)) * 2
+		if err = f.encode(value.Field(f.index), data[start:start+f.quantity()*2]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ReadStruct reads the holding registers spanning all tagged fields of
+// the struct pointed to by v in one request and decodes them into v.
+func ReadStruct(client Client, v interface{}) error {
+	if err := checkStructPointer(v); err != nil {
+		return err
+	}
+	_, fields, err := registerFields(v)
+	if err != nil {
+		return err
+	}
+	address, quantity := registerSpan(fields)
+	if quantity > 125 {
+		return fmt.Errorf("modbus: struct spans '%v' registers, more than maximum '%v'", quantity, 125)
+	}
+	results, err := client.ReadHoldingRegisters(address, uint16(quantity))
+	if err != nil {
+		return err
+	}
+	return Unmarshal(address, results, v)
+}
+
+// registerSpan returns the smallest block of registers containing all fields.
+func registerSpan(fields []registerField) (address uint16, quantity int) {
+	if len(fields) == 0 {
+		return
+	}
+	first, last := int(fields[0].address), 0
+	for i := range fields {
+		if int(fields[i].address) < first {
+			first = int(fields[i].address)
+		}
+		if end := int(fields[i].address) + fields[i].quantity(); end > last {
+			last = end
+		}
+	}
+	address, quantity = uint16(first), last-first
+	return
+}
+
+// checkStructPointer returns an error if v is not a non-nil pointer, to
+// the struct decoded by Unmarshal.
+func checkStructPointer(v interface{}) error {
+	if value := reflect.ValueOf(v); value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("modbus: '%T' is not a non-nil pointer to struct", v)
+	}
+	return nil
+}
+
+// registerFields parses the tagged fields of the struct v points to, or of
+// v itself when it is a struct.
+func registerFields(v interface{}) (value reflect.Value, fields []registerField, err error) {
+	value = reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		err = fmt.Errorf("modbus: '%T' is not a struct or pointer to struct", v)
+		return
+	}
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("modbus")
+		if !ok || tag == "-" {
+			continue
+		}
+		f := registerField{name: sf.Name, index: i}
+		if err = f.parse(tag, sf.Type.Kind()); err != nil {
+			return
+		}
+		fields = append(fields, f)
+	}
+	return
+}
+
+func (f *registerField) parse(tag string, kind reflect.Kind) (err error) {
+	hasAddress := false
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		i := strings.IndexByte(option, '=')
+		if i < 0 {
+			return fmt.Errorf("modbus: field '%v' has invalid option '%v'", f.name, option)
+		}
+		key, val := option[:i], option[i+1:]
+		switch key {
+		case "addr":
+			var address uint64
+			if address, err = strconv.ParseUint(val, 0, 16); err != nil {
+				return fmt.Errorf("modbus: field '%v' has invalid address '%v'", f.name, val)
+			}
+			f.address = uint16(address)
+			hasAddress = true
+		case "type":
+			if _, ok := registerTypeSizes[val]; !ok {
+				return fmt.Errorf("modbus: field '%v' has unsupported type '%v'", f.name, val)
+			}
+			f.typ = val
+		case "order":
+			if f.order, err = encoding.ParseOrder(val); err != nil {
+				return fmt.Errorf("modbus: field '%v' has unsupported order '%v'", f.name, val)
+			}
+		default:
+			factory := lookupTransform(key)
+			if factory == nil {
+				return fmt.Errorf("modbus: field '%v' has unknown option '%v'", f.name, key)
+			}
+			var t Transform
+			if t, err = factory(val); err != nil {
+				return fmt.Errorf("modbus: field '%v' has invalid %v '%v': %v", f.name, key, val, err)
+			}
+			f.transforms = append(f.transforms, t)
+		}
+	}
+	if !hasAddress {
+		return fmt.Errorf("modbus: field '%v' has no address", f.name)
+	}
+	if !isNumericKind(kind) {
+		return fmt.Errorf("modbus: field '%v' has unsupported kind '%v'", f.name, kind)
+	}
+	if f.typ == "" {
+		f.typ = defaultRegisterType(kind)
+	}
+	return nil
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func defaultRegisterType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int8, reflect.Int16:
+		return "int16"
+	case reflect.Int32:
+		return "int32"
+	case reflect.Int, reflect.Int64:
+		return "int64"
+	case reflect.Uint32:
+		return "uint32"
+	case reflect.Uint, reflect.Uint64:
+		return "uint64"
+	case reflect.Float32:
+		return "float32"
+	case reflect.Float64:
+		return "float64"
+	}
+	return "uint16"
+}
+
+func (f *registerField) decode(field reflect.Value, data []byte) error {
+	registers := encoding.Registers(data)
+	var raw float64
+	var integer int64
+	var unsigned uint64
+	isFloat, isSigned := false, false
+	switch f.typ {
+	case "uint16":
+		unsigned = uint64(encoding.Uint16(registers, f.order))
+	case "int16":
+		integer, isSigned = int64(encoding.Int16(registers, f.order)), true
+	case "uint32":
+		unsigned = uint64(encoding.Uint32(registers, f.order))
+	case "int32":
+		integer, isSigned = int64(encoding.Int32(registers, f.order)), true
+	case "uint64":
+		unsigned = encoding.Uint64(registers, f.order)
+	case "int64":
+		integer, isSigned = encoding.Int64(registers, f.order), true
+	case "float32":
+		raw, isFloat = float64(encoding.Float32(registers, f.order)), true
+	case "float64":
+		raw, isFloat = encoding.Float64(registers, f.order), true
+	}
+	if !isFloat {
+		if isSigned {
+			raw = float64(integer)
+		} else {
+			raw = float64(unsigned)
+		}
+	}
+	transformed := len(f.transforms) > 0
+	for _, t := range f.transforms {
+		raw = t.Read(raw)
+	}
+	switch field.Kind() {
+	case reflect.Bool:
+		field.SetBool(raw != 0)
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if transformed || isFloat {
+			integer = int64(math.Round(raw))
+		} else if !isSigned {
+			integer = int64(unsigned)
+		}
+		if field.OverflowInt(integer) {
+			return fmt.Errorf("modbus: field '%v' overflows with value '%v'", f.name, integer)
+		}
+		field.SetInt(integer)
+	default:
+		if transformed || isFloat {
+			unsigned = uint64(math.Round(raw))
+		} else if isSigned {
+			unsigned = uint64(integer)
+		}
+		if field.OverflowUint(unsigned) {
+			return fmt.Errorf("modbus: field '%v' overflows with value '%v'", f.name, unsigned)
+		}
+		field.SetUint(unsigned)
+	}
+	return nil
+}
+
+func (f *registerField) encode(field reflect.Value, data []byte) error {
+	var raw float64
+	var integer int64
+	var unsigned uint64
+	switch field.Kind() {
+	case reflect.Bool:
+		if field.Bool() {
+			raw, integer, unsigned = 1, 1, 1
+		}
+	case reflect.Float32, reflect.Float64:
+		raw = field.Float()
+		integer, unsigned = int64(math.Round(raw)), uint64(math.Round(raw))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer = field.Int()
+		raw, unsigned = float64(integer), uint64(integer)
+	default:
+		unsigned = field.Uint()
+		raw, integer = float64(unsigned), int64(unsigned)
+	}
+	if len(f.transforms) > 0 {
+		for i := len(f.transforms) - 1; i >= 0; i-- {
+			raw = f.transforms[i].Write(raw)
+		}
+		integer, unsigned = int64(math.Round(raw)), uint64(math.Round(raw))
+	}
+	registers := make([]uint16, len(data)/2)
+	switch f.typ {
+	case "uint16":
+		if unsigned > math.MaxUint16 || integer < 0 {
+			return fmt.Errorf("modbus: field '%v' value '%v' overflows '%v'", f.name, raw, f.typ)
+		}
+		encoding.PutUint16(registers, f.order, uint16(unsigned))
+	case "int16":
+		if integer < math.MinInt16 || integer > math.MaxInt16 {
+			return fmt.Errorf("modbus: field '%v' value '%v' overflows '%v'", f.name, raw, f.typ)
+		}
+		encoding.PutInt16(registers, f.order, int16(integer))
+	case "uint32":
+		if unsigned > math.MaxUint32 || integer < 0 {
+			return fmt.Errorf("modbus: field '%v' value '%v' overflows '%v'", f.name, raw, f.typ)
+		}
+		encoding.PutUint32(registers, f.order, uint32(unsigned))
+	case "int32":
+		if integer < math.MinInt32 || integer > math.MaxInt32 {
+			return fmt.Errorf("modbus: field '%v' value '%v' overflows '%v'", f.name, raw, f.typ)
+		}
+		encoding.PutInt32(registers, f.order, int32(integer))
+	case "uint64":
+		encoding.PutUint64(registers, f.order, unsigned)
+	case "int64":
+		encoding.PutInt64(registers, f.order, integer)
+	case "float32":
+		encoding.PutFloat32(registers, f.order, float32(raw))
+	case "float64":
+		encoding.PutFloat64(registers, f.order, raw)
+	}
+	copy(data, encoding.Bytes(registers))
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+type testMeter struct {
+	Voltage  float32 `modbus:"addr=100,type=float32,order=cdab"`
+	Current  float64 `modbus:"addr=102,type=int16,scale=0.01"`
+	Status   uint16  `modbus:"addr=103"`
+	Energy   uint32  `modbus:"addr=104,order=dcba"`
+	Enabled  bool    `modbus:"addr=107"`
+	Comment  string
+	Counter  int64 `modbus:"addr=108"`
+	Internal int   `modbus:"-"`
+}
+
+var testMeterData = []byte{
+	0x00, 0x00, 0x43, 0x48, // 100: 200.0 word swapped
+	0xFF, 0x9C, // 102: -100
+	0x12, 0x34, // 103
+	0x78, 0x56, 0x34, 0x12, // 104: 0x12345678 little-endian
+	0x00, 0x00, // 106: gap
+	0x00, 0x01, // 107
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, // 108
+}
+
+func TestUnmarshal(t *testing.T) {
+	var meter testMeter
+	if err := Unmarshal(100, testMeterData, &meter); err != nil {
+		t.Fatal(err)
+	}
+	expected := testMeter{Voltage: 200, Current: -1, Status: 0x1234, Energy: 0x12345678, Enabled: true, Counter: 256}
+	if meter != expected {
+		t.Fatalf("expected %+v, actual %+v", expected, meter)
+	}
+	if err := Unmarshal(101, testMeterData, &meter); err == nil {
+		t.Fatalf("expected out of range error")
+	}
+	if err := Unmarshal(100, testMeterData, meter); err == nil {
+		t.Fatalf("expected struct value error")
+	}
+	if err := Unmarshal(100, testMeterData, (*testMeter)(nil)); err == nil {
+		t.Fatalf("expected nil pointer error")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	meter := testMeter{Voltage: 200, Current: -1, Status: 0x1234, Energy: 0x12345678, Enabled: true, Counter: 256}
+	address, data, err := Marshal(&meter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != 100 || !bytes.Equal(data, testMeterData) {
+		t.Fatalf("unexpected address %v, data %x", address, data)
+	}
+	meter.Current = 400
+	if _, _, err = Marshal(meter); err == nil {
+		t.Fatalf("expected overflow error")
+	}
+}
+
+func TestUnmarshalOrderCase(t *testing.T) {
+	var meter struct {
+		Voltage float32 `modbus:"addr=100,order=CDAB"`
+		Energy  uint32  `modbus:"addr=102,order=Dcba"`
+	}
+	if err := Unmarshal(100, []byte{0x00, 0x00, 0x43, 0x48, 0x78, 0x56, 0x34, 0x12}, &meter); err != nil {
+		t.Fatal(err)
+	}
+	if meter.Voltage != 200 || meter.Energy != 0x12345678 {
+		t.Fatalf("unexpected %+v", meter)
+	}
+}
+
+func TestMarshalInvalidTags(t *testing.T) {
+	tests := []interface{}{
+		&struct {
+			A uint16 `modbus:"type=uint16"`
+		}{},
+		&struct {
+			A uint16 `modbus:"addr=1,type=uint8"`
+		}{},
+		&struct {
+			A uint16 `modbus:"addr=1,order=abdc"`
+		}{},
+		&struct {
+			A string `modbus:"addr=1"`
+		}{},
+		0,
+	}
+	for _, test := range tests {
+		if _, _, err := Marshal(test); err == nil {
+			t.Errorf("%T: expected error", test)
+		}
+	}
+}
+
+type testSensor struct {
+	// 0.1 degC per unit, reported in degF
+	Temperature float64 `modbus:"addr=0,type=int16,scale=0.1,unit=degC:degF,round=1"`
+	Level       float32 `modbus:"addr=1,type=uint16,scale=0.5,clamp=0:100"`
+	Pressure    int     `modbus:"addr=2,type=uint16,unit=kPa:mbar"`
+}
+
+func TestMarshalTransforms(t *testing.T) {
+	var sensor testSensor
+	data := []byte{0x00, 0xFA, 0x01, 0x00, 0x00, 0x65}
+	if err := Unmarshal(0, data, &sensor); err != nil {
+		t.Fatal(err)
+	}
+	expected := testSensor{Temperature: 77, Level: 100, Pressure: 1010}
+	if sensor != expected {
+		t.Fatalf("expected %+v, actual %+v", expected, sensor)
+	}
+	sensor.Level = 150
+	_, results, err := Marshal(&sensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedData := []byte{0x00, 0xFA, 0x00, 0xC8, 0x00, 0x65}
+	if !bytes.Equal(expectedData, results) {
+		t.Fatalf("expected %x, actual %x", expectedData, results)
+	}
+}
+
+func TestMarshalBitEnumBCD(t *testing.T) {
+	var v struct {
+		Alarm   uint8   `modbus:"addr=0,bit=3"`
+		Mode    uint8   `modbus:"addr=0,bit=4:7"`
+		State   float64 `modbus:"addr=1,type=uint16,enum=0:0;1:50;2:100"`
+		Counter uint32  `modbus:"addr=2,type=uint32,bcd="`
+	}
+	data := []byte{0x00, 0x58, 0x00, 0x02, 0x00, 0x12, 0x34, 0x56}
+	if err := Unmarshal(0, data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Alarm != 1 || v.Mode != 5 || v.State != 100 || v.Counter != 123456 {
+		t.Fatalf("unexpected values %+v", v)
+	}
+	v.Alarm, v.Mode, v.State = 0, 2, 50
+	_, results, err := Marshal(&struct {
+		Mode    uint8   `modbus:"addr=0,bit=4:7"`
+		State   float64 `modbus:"addr=1,type=uint16,enum=0:0;1:50;2:100"`
+		Counter uint32  `modbus:"addr=2,type=uint32,bcd="`
+	}{v.Mode, v.State, v.Counter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x12, 0x34, 0x56}
+	if !bytes.Equal(expected, results) {
+		t.Fatalf("expected %x, actual %x", expected, results)
+	}
+
+	transform, err := newBCDTransform("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(transform.Read(0x1A)) {
+		t.Fatal("invalid BCD digit decoded")
+	}
+	for _, arg := range []string{"8:3", "64", "x"} {
+		if _, err = newBitTransform(arg); err == nil {
+			t.Errorf("bit=%v: expected error", arg)
+		}
+	}
+}
+
+type offsetTransform float64
+
+func (t offsetTransform) Read(value float64) float64  { return value + float64(t) }
+func (t offsetTransform) Write(value float64) float64 { return value - float64(t) }
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("test_add", func(arg string) (Transform, error) {
+		return offsetTransform(5), nil
+	})
+	var v struct {
+		A int16 `modbus:"addr=0,test_add=5"`
+	}
+	if err := Unmarshal(0, []byte{0x00, 0x01}, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 6 {
+		t.Fatalf("expected 6, actual %v", v.A)
+	}
+	var invalid struct {
+		A int16 `modbus:"addr=0,unit=degC:kPa"`
+	}
+	if err := Unmarshal(0, []byte{0x00, 0x01}, &invalid); err == nil {
+		t.Fatalf("expected error for incompatible units")
+	}
+}
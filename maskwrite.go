@@ -0,0 +1,98 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// MaskWriteClient wraps a Client and modifies bits of holding registers
+// with MaskWriteRegister, or by reading the register and writing the
+// modified value if the device responds Illegal Function to it:
+//  bits := modbus.NewMaskWriteClient(client)
+//  err := bits.SetRegisterBits(100, 0x0004)
+// Writes of holding registers through the client are serialized with the
+// fallback, so that it does not overwrite them, but writes by other
+// clients of the device are not.
+type MaskWriteClient struct {
+	Client
+
+	mu sync.Mutex
+	// unsupported is true once the device responded Illegal Function to
+	// MaskWriteRegister.
+	unsupported bool
+}
+
+// NewMaskWriteClient allocates a new MaskWriteClient wrapping client.
+func NewMaskWriteClient(client Client) *MaskWriteClient {
+	return &MaskWriteClient{Client: client}
+}
+
+// SetRegisterBits sets the bits of the holding register at address.
+func (mb *MaskWriteClient) SetRegisterBits(address, bits uint16) (err error) {
+	_, err = mb.MaskWriteRegister(address, ^bits, bits)
+	return
+}
+
+// ClearRegisterBits clears the bits of the holding register at address.
+func (mb *MaskWriteClient) ClearRegisterBits(address, bits uint16) (err error) {
+	_, err = mb.MaskWriteRegister(address, ^bits, 0)
+	return
+}
+
+// MaskWriteRegister sets the register to (value AND andMask) OR (orMask
+// AND NOT andMask), falling back to a read and a write if the device does
+// not support the function.
+func (mb *MaskWriteClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if !mb.unsupported {
+		results, err = mb.Client.MaskWriteRegister(address, andMask, orMask)
+		if !isIllegalFunction(err) {
+			return
+		}
+		mb.unsupported = true
+	}
+	if results, err = mb.Client.ReadHoldingRegisters(address, 1); err != nil {
+		return
+	}
+	if len(results) != 2 {
+		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(results), 2)
+		return
+	}
+	value := binary.BigEndian.Uint16(results)&andMask | orMask&^andMask
+	if _, err = mb.Client.WriteSingleRegister(address, value); err != nil {
+		return
+	}
+	results = dataBlock(andMask, orMask)
+	return
+}
+
+// WriteSingleRegister writes the register, serialized with the fallback
+// of MaskWriteRegister.
+func (mb *MaskWriteClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.Client.WriteSingleRegister(address, value)
+}
+
+// WriteMultipleRegisters writes the registers, serialized with the
+// fallback of MaskWriteRegister.
+func (mb *MaskWriteClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.Client.WriteMultipleRegisters(address, quantity, value)
+}
+
+// ReadWriteMultipleRegisters writes and reads the registers, serialized
+// with the fallback of MaskWriteRegister.
+func (mb *MaskWriteClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+)
+
+// maskWriteMemoryClient adds MaskWriteRegister to singleWriteClient, or
+// responds Illegal Function to it if unsupported.
+type maskWriteMemoryClient struct {
+	singleWriteClient
+	unsupported bool
+	maskWrites  int
+}
+
+func (c *maskWriteMemoryClient) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	c.maskWrites++
+	if c.unsupported {
+		return nil, &ModbusError{FunctionCode: 0x96, ExceptionCode: ExceptionCodeIllegalFunction}
+	}
+	c.holding[address] = c.holding[address]&andMask | orMask&^andMask
+	return dataBlock(andMask, orMask), nil
+}
+
+func TestMaskWriteClient(t *testing.T) {
+	for _, unsupported := range []bool{false, true} {
+		memory := &maskWriteMemoryClient{singleWriteClient: singleWriteClient{&memoryClient{}}, unsupported: unsupported}
+		memory.holding[100] = 0x1234
+		client := NewMaskWriteClient(memory)
+
+		if err := client.SetRegisterBits(100, 0x0009); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.ClearRegisterBits(100, 0x0030); err != nil {
+			t.Fatal(err)
+		}
+		if memory.holding[100] != 0x120D {
+			t.Fatalf("unsupported %v: unexpected value %#04x", unsupported, memory.holding[100])
+		}
+		results, err := client.MaskWriteRegister(100, 0xFF00, 0x0042)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if memory.holding[100] != 0x1242 || !equalValues(registerValues(results), []uint16{0xFF00, 0x0042}) {
+			t.Fatalf("unsupported %v: unexpected value %#04x, results % x", unsupported, memory.holding[100], results)
+		}
+		// Illegal Function is responded once
+		expected := 3
+		if unsupported {
+			expected = 1
+		}
+		if memory.maskWrites != expected {
+			t.Fatalf("unsupported %v: mask writes expected %v, actual %v", unsupported, expected, memory.maskWrites)
+		}
+	}
+}
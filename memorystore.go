@@ -0,0 +1,266 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"sync"
+)
+
+// MemoryStore is a thread-safe Handler keeping the coils, discrete inputs
+// and registers of all addresses in memory. The application updates the
+// values served and is notified of the writes of clients:
+//  store := modbus.NewMemoryStore()
+//  store.OnChange = func(unitId byte, table modbus.Table, address, quantity uint16) {
+//  	if table == modbus.TableHoldingRegisters {
+//  		applySetpoints(store.HoldingRegisters(address, quantity))
+//  	}
+//  }
+// Values are shared by all unit ids. Emulated devices may also subscribe
+// to the writes of ranges, see Subscribe.
+type MemoryStore struct {
+	// OnChange, if not nil, is called after clients write coils or holding
+	// registers, with the range written.
+	OnChange func(unitId byte, table Table, address, quantity uint16)
+
+	mu               sync.RWMutex
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+	// subscriptions are replaced, not modified, when subscribing.
+	subscriptions []*storeSubscription
+}
+
+// StoreChange is a write of a client to the range of a subscription of a
+// MemoryStore, see Subscribe.
+type StoreChange struct {
+	UnitId byte
+	Table  Table
+	// Address is the first address written in the range subscribed, Values
+	// the values written from there and Previous those they replaced, coils
+	// are 0 or 1. They must not be modified.
+	Address  uint16
+	Values   []uint16
+	Previous []uint16
+}
+
+// Changed returns true if a value written differs from the previous one.
+func (c *StoreChange) Changed() bool {
+	for i, v := range c.Values {
+		if v != c.Previous[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type storeSubscription struct {
+	table      Table
+	start, end int
+	handler    func(StoreChange)
+}
+
+// NewMemoryStore allocates a new MemoryStore with all values cleared.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		coils:            make([]bool, 65536),
+		discreteInputs:   make([]bool, 65536),
+		holdingRegisters: make([]uint16, 65536),
+		inputRegisters:   make([]uint16, 65536),
+	}
+}
+
+// SetCoils sets coils starting at address.
+func (s *MemoryStore) SetCoils(address uint16, values ...bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.coils[address:], values)
+}
+
+// Coils returns quantity coils starting at address.
+func (s *MemoryStore) Coils(address, quantity uint16) []bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]bool(nil), s.coils[address:storeEnd(address, quantity)]...)
+}
+
+// SetDiscreteInputs sets discrete inputs starting at address.
+func (s *MemoryStore) SetDiscreteInputs(address uint16, values ...bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.discreteInputs[address:], values)
+}
+
+// DiscreteInputs returns quantity discrete inputs starting at address.
+func (s *MemoryStore) DiscreteInputs(address, quantity uint16) []bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]bool(nil), s.discreteInputs[address:storeEnd(address, quantity)]...)
+}
+
+// SetHoldingRegisters sets holding registers starting at address.
+func (s *MemoryStore) SetHoldingRegisters(address uint16, values ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.holdingRegisters[address:], values)
+}
+
+// HoldingRegisters returns quantity holding registers starting at address.
+func (s *MemoryStore) HoldingRegisters(address, quantity uint16) []uint16 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]uint16(nil), s.holdingRegisters[address:storeEnd(address, quantity)]...)
+}
+
+// SetInputRegisters sets input registers starting at address.
+func (s *MemoryStore) SetInputRegisters(address uint16, values ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copy(s.inputRegisters[address:], values)
+}
+
+// InputRegisters returns quantity input registers starting at address.
+func (s *MemoryStore) InputRegisters(address, quantity uint16) []uint16 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]uint16(nil), s.inputRegisters[address:storeEnd(address, quantity)]...)
+}
+
+// OnReadCoils implements Handler.
+func (s *MemoryStore) OnReadCoils(unitId byte, address, quantity uint16) ([]bool, error) {
+	return s.Coils(address, quantity), nil
+}
+
+// OnReadDiscreteInputs implements Handler.
+func (s *MemoryStore) OnReadDiscreteInputs(unitId byte, address, quantity uint16) ([]bool, error) {
+	return s.DiscreteInputs(address, quantity), nil
+}
+
+// OnReadHoldingRegisters implements Handler.
+func (s *MemoryStore) OnReadHoldingRegisters(unitId byte, address, quantity uint16) ([]uint16, error) {
+	return s.HoldingRegisters(address, quantity), nil
+}
+
+// OnReadInputRegisters implements Handler.
+func (s *MemoryStore) OnReadInputRegisters(unitId byte, address, quantity uint16) ([]uint16, error) {
+	return s.InputRegisters(address, quantity), nil
+}
+
+// Subscribe calls handler after clients write coils or holding registers
+// in the range of table, with the values written in the range, and
+// returns a function cancelling the subscription:
+//  unsubscribe := store.Subscribe(modbus.TableCoils, 0, 8, func(c modbus.StoreChange) {
+//  	if c.Changed() {
+//  		drive.Apply(store.Coils(0, 8))
+//  	}
+//  })
+// Every write is delivered, even if it does not change the values, as
+// commands may be written again. Values set by the application are not.
+// handler is called synchronously, before the response is sent, in the
+// order of the subscriptions.
+func (s *MemoryStore) Subscribe(table Table, address, quantity uint16, handler func(StoreChange)) (unsubscribe func()) {
+	subscription := &storeSubscription{table, int(address), storeEnd(address, quantity), handler}
+	s.mu.Lock()
+	s.subscriptions = append(s.subscriptions[:len(s.subscriptions):len(s.subscriptions)], subscription)
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, sub := range s.subscriptions {
+			if sub == subscription {
+				s.subscriptions = append(s.subscriptions[:i:i], s.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// OnWriteCoils implements Handler.
+func (s *MemoryStore) OnWriteCoils(unitId byte, address uint16, values []bool) error {
+	s.mu.Lock()
+	subscriptions := s.subscriptions
+	var previous []uint16
+	if len(subscriptions) > 0 {
+		previous = bitValues(s.coils[address:storeEnd(address, uint16(len(values)))])
+	}
+	copy(s.coils[address:], values)
+	s.mu.Unlock()
+	s.changed(unitId, TableCoils, address, len(values))
+	if len(subscriptions) > 0 {
+		notifySubscriptions(subscriptions, StoreChange{unitId, TableCoils, address, bitValues(values), previous})
+	}
+	return nil
+}
+
+// OnWriteHoldingRegisters implements Handler.
+func (s *MemoryStore) OnWriteHoldingRegisters(unitId byte, address uint16, values []uint16) error {
+	s.mu.Lock()
+	subscriptions := s.subscriptions
+	var previous []uint16
+	if len(subscriptions) > 0 {
+		previous = append(previous, s.holdingRegisters[address:storeEnd(address, uint16(len(values)))]...)
+	}
+	copy(s.holdingRegisters[address:], values)
+	s.mu.Unlock()
+	s.changed(unitId, TableHoldingRegisters, address, len(values))
+	if len(subscriptions) > 0 {
+		notifySubscriptions(subscriptions, StoreChange{unitId, TableHoldingRegisters, address, values, previous})
+	}
+	return nil
+}
+
+// notifySubscriptions calls the handlers of the subscriptions whose range
+// overlaps the change, with the part of the change in their range.
+func notifySubscriptions(subscriptions []*storeSubscription, change StoreChange) {
+	// Values written past the address space are not stored
+	end := int(change.Address) + len(change.Previous)
+	for _, sub := range subscriptions {
+		start, stop := int(change.Address), end
+		if sub.table != change.Table || sub.start >= stop || sub.end <= start {
+			continue
+		}
+		if sub.start > start {
+			start = sub.start
+		}
+		if sub.end < stop {
+			stop = sub.end
+		}
+		i, j := start-int(change.Address), stop-int(change.Address)
+		sub.handler(StoreChange{
+			UnitId:   change.UnitId,
+			Table:    change.Table,
+			Address:  uint16(start),
+			Values:   change.Values[i:j:j],
+			Previous: change.Previous[i:j:j],
+		})
+	}
+}
+
+// bitValues returns the bits as 0 or 1.
+func bitValues(bits []bool) []uint16 {
+	values := make([]uint16, len(bits))
+	for i, bit := range bits {
+		if bit {
+			values[i] = 1
+		}
+	}
+	return values
+}
+
+func (s *MemoryStore) changed(unitId byte, table Table, address uint16, quantity int) {
+	if s.OnChange != nil {
+		s.OnChange(unitId, table, address, uint16(quantity))
+	}
+}
+
+// storeEnd returns the end of the range, truncated to the address space.
+func storeEnd(address, quantity uint16) int {
+	end := int(address) + int(quantity)
+	if end > 65536 {
+		end = 65536
+	}
+	return end
+}
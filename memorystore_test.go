@@ -0,0 +1,119 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStoreSubscribe(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(10, 1, 2, 3)
+	var registers, coils []StoreChange
+	unsubscribe := store.Subscribe(TableHoldingRegisters, 11, 4, func(c StoreChange) {
+		registers = append(registers, c)
+	})
+	store.Subscribe(TableCoils, 0, 8, func(c StoreChange) {
+		coils = append(coils, c)
+	})
+	client := startServer(t, NewServer(store), 2)
+
+	if _, err := client.WriteMultipleRegisters(9, 4, []byte{0, 9, 0, 1, 0, 7, 0, 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Outside the range
+	if _, err := client.WriteSingleRegister(20, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleCoil(3, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteSingleCoil(3, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	// Not delivered, set by the application
+	store.SetHoldingRegisters(12, 5)
+	unsubscribe()
+	if _, err := client.WriteSingleRegister(12, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []StoreChange{{UnitId: 2, Table: TableHoldingRegisters, Address: 11, Values: []uint16{7, 3}, Previous: []uint16{2, 3}}}
+	if !reflect.DeepEqual(registers, expected) {
+		t.Fatalf("unexpected changes %+v", registers)
+	}
+	if !registers[0].Changed() {
+		t.Fatal("change expected")
+	}
+	expected = []StoreChange{
+		{UnitId: 2, Table: TableCoils, Address: 3, Values: []uint16{1}, Previous: []uint16{0}},
+		{UnitId: 2, Table: TableCoils, Address: 3, Values: []uint16{1}, Previous: []uint16{1}},
+	}
+	if !reflect.DeepEqual(coils, expected) {
+		t.Fatalf("unexpected changes %+v", coils)
+	}
+	if coils[1].Changed() {
+		t.Fatal("unexpected change")
+	}
+}
+
+func TestMemoryStoreSubscribeEnd(t *testing.T) {
+	store := NewMemoryStore()
+	var changes []StoreChange
+	store.Subscribe(TableHoldingRegisters, 65534, 10, func(c StoreChange) {
+		changes = append(changes, c)
+	})
+	store.OnWriteHoldingRegisters(1, 65533, []uint16{1, 2, 3, 4})
+	if len(changes) != 1 || changes[0].Address != 65534 || !reflect.DeepEqual(changes[0].Values, []uint16{2, 3}) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+}
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetCoils(3, true, false, true)
+	store.SetHoldingRegisters(100, 230, 50)
+	store.SetHoldingRegisters(109, 7)
+	store.SetHoldingRegisters(65535, 1)
+	store.SetInputRegisters(0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 2)
+	var buf bytes.Buffer
+	if err := store.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := store.snapshot()
+	expected := StoreSnapshot{
+		Coils:            []StoreBlock{{3, []uint16{1, 0, 1}}},
+		HoldingRegisters: []StoreBlock{{100, []uint16{230, 50, 0, 0, 0, 0, 0, 0, 0, 7}}, {65535, []uint16{1}}},
+		InputRegisters:   []StoreBlock{{0, []uint16{1}}, {9, []uint16{2}}},
+	}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	restored := NewMemoryStore()
+	restored.SetDiscreteInputs(7, true)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.snapshot(), expected) {
+		t.Fatalf("unexpected snapshot %+v", restored.snapshot())
+	}
+
+	for _, fixture := range []string{
+		`{"coils": [{"address": 0, "values": [2]}]}`,
+		`{"holding_registers": [{"address": 65535, "values": [1, 2]}]}`,
+		`{"registers": []}`,
+	} {
+		if err := restored.Restore(strings.NewReader(fixture)); err == nil {
+			t.Fatalf("%v: error expected", fixture)
+		}
+	}
+	if values := restored.HoldingRegisters(100, 2); !reflect.DeepEqual(values, []uint16{230, 50}) {
+		t.Fatalf("invalid snapshot restored, values %v", values)
+	}
+}
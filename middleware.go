@@ -0,0 +1,245 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Request is a decoded view of a request PDU.
+type Request struct {
+	PDU *ProtocolDataUnit
+
+	FunctionCode byte
+	// Address and Quantity are the range read or written. For
+	// ReadWriteMultipleRegisters they describe the read, for ReadFIFOQueue
+	// Address is the FIFO pointer address and Quantity is zero.
+	Address  uint16
+	Quantity uint16
+	// WriteAddress and WriteQuantity describe the write of
+	// ReadWriteMultipleRegisters.
+	WriteAddress  uint16
+	WriteQuantity uint16
+	// Values are the values written: register values, or 0 and 1 for
+	// coils. For MaskWriteRegister they are the AND-mask and the OR-mask.
+	Values []uint16
+}
+
+// Response is a decoded view of a response PDU.
+type Response struct {
+	PDU *ProtocolDataUnit
+
+	// ExceptionCode is not zero if the device responded with an exception.
+	ExceptionCode byte
+	// Values are the values read: register values, 0 and 1 for coils and
+	// discrete inputs, or FIFO queue values. Responses to writes contain
+	// the values echoed by the device, if any.
+	Values []uint16
+}
+
+// Sender sends a request and returns the response of the device.
+type Sender func(request *Request) (*Response, error)
+
+// Middleware intercepts requests sent by a client. It usually calls next
+// to send the request and inspects the response, but can also return an
+// error or a response without calling next, e.g. to deny a request or to
+// serve it from a cache. The request must not be modified and a response
+// returned must have its PDU set.
+type Middleware func(request *Request, next Sender) (*Response, error)
+
+// NewMiddlewareClient creates a new modbus client whose requests pass
+// through middleware. The first middleware is the outermost one.
+func NewMiddlewareClient(handler ClientHandler, middleware ...Middleware) Client {
+	return &client{packager: handler, transporter: handler, middleware: middleware}
+}
+
+// sendMiddleware sends the request through the middleware chain.
+func (mb *client) sendMiddleware(pdu *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+	request, err := DecodeRequest(pdu)
+	if err != nil {
+		return
+	}
+	next := func(request *Request) (*Response, error) {
+		response, err := mb.roundTrip(request.PDU)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeResponse(request, response)
+	}
+	for i := len(mb.middleware) - 1; i >= 0; i-- {
+		middleware, inner := mb.middleware[i], next
+		next = func(request *Request) (*Response, error) {
+			return middleware(request, inner)
+		}
+	}
+	resp, err := next(request)
+	if err != nil {
+		return
+	}
+	if resp == nil || resp.PDU == nil {
+		err = fmt.Errorf("modbus: middleware returned no response")
+		return
+	}
+	response = resp.PDU
+	return
+}
+
+// DecodeRequest decodes a request PDU. Requests of unknown function codes
+// only have FunctionCode and PDU set.
+func DecodeRequest(pdu *ProtocolDataUnit) (request *Request, err error) {
+	request = &Request{PDU: pdu, FunctionCode: pdu.FunctionCode}
+	data := pdu.Data
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		if err = checkRequestSize(pdu, 4); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = binary.BigEndian.Uint16(data[2:])
+	case FuncCodeWriteSingleCoil:
+		if err = checkRequestSize(pdu, 4); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = 1
+		request.Values = []uint16{0}
+		if binary.BigEndian.Uint16(data[2:]) == 0xFF00 {
+			request.Values[0] = 1
+		}
+	case FuncCodeWriteSingleRegister:
+		if err = checkRequestSize(pdu, 4); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = 1
+		request.Values = []uint16{binary.BigEndian.Uint16(data[2:])}
+	case FuncCodeWriteMultipleCoils:
+		if err = checkRequestSize(pdu, 5); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = binary.BigEndian.Uint16(data[2:])
+		request.Values, err = decodeBits(data[4:], request.Quantity)
+	case FuncCodeWriteMultipleRegisters:
+		if err = checkRequestSize(pdu, 5); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = binary.BigEndian.Uint16(data[2:])
+		request.Values, err = decodeRegisters(data[4:], request.Quantity)
+	case FuncCodeMaskWriteRegister:
+		if err = checkRequestSize(pdu, 6); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = 1
+		request.Values = []uint16{binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:])}
+	case FuncCodeReadWriteMultipleRegisters:
+		if err = checkRequestSize(pdu, 9); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+		request.Quantity = binary.BigEndian.Uint16(data[2:])
+		request.WriteAddress = binary.BigEndian.Uint16(data[4:])
+		request.WriteQuantity = binary.BigEndian.Uint16(data[6:])
+		request.Values, err = decodeRegisters(data[8:], request.WriteQuantity)
+	case FuncCodeReadFIFOQueue:
+		if err = checkRequestSize(pdu, 2); err != nil {
+			return
+		}
+		request.Address = binary.BigEndian.Uint16(data)
+	}
+	return
+}
+
+// DecodeResponse decodes the response PDU of the request.
+func DecodeResponse(request *Request, pdu *ProtocolDataUnit) (response *Response, err error) {
+	response = &Response{PDU: pdu}
+	if pdu.FunctionCode != request.FunctionCode {
+		if pdu.FunctionCode == request.FunctionCode|0x80 && len(pdu.Data) > 0 {
+			response.ExceptionCode = pdu.Data[0]
+		}
+		return
+	}
+	data := pdu.Data
+	switch pdu.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		if len(data) < 1 {
+			err = fmt.Errorf("modbus: response data is empty")
+			return
+		}
+		response.Values, err = decodeBits(data, request.Quantity)
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 1 {
+			err = fmt.Errorf("modbus: response data is empty")
+			return
+		}
+		response.Values, err = decodeRegisters(data, uint16(len(data)-1)/2)
+	case FuncCodeWriteSingleCoil:
+		if len(data) == 4 {
+			response.Values = []uint16{0}
+			if binary.BigEndian.Uint16(data[2:]) == 0xFF00 {
+				response.Values[0] = 1
+			}
+		}
+	case FuncCodeWriteSingleRegister:
+		if len(data) == 4 {
+			response.Values = []uint16{binary.BigEndian.Uint16(data[2:])}
+		}
+	case FuncCodeReadFIFOQueue:
+		if len(data) < 4 {
+			err = fmt.Errorf("modbus: response data size '%v' is less than expected '%v'", len(data), 4)
+			return
+		}
+		count := binary.BigEndian.Uint16(data[2:])
+		if len(data)-4 != 2*int(count) {
+			err = fmt.Errorf("modbus: response data size '%v' does not match fifo count '%v'", len(data)-4, count)
+			return
+		}
+		response.Values = make([]uint16, count)
+		for i := range response.Values {
+			response.Values[i] = binary.BigEndian.Uint16(data[4+2*i:])
+		}
+	}
+	return
+}
+
+func checkRequestSize(pdu *ProtocolDataUnit, size int) error {
+	if len(pdu.Data) < size {
+		return fmt.Errorf("modbus: request data size '%v' of function '%v' is less than expected '%v'", len(pdu.Data), pdu.FunctionCode, size)
+	}
+	return nil
+}
+
+// decodeBits decodes quantity bits prefixed by their byte count.
+func decodeBits(data []byte, quantity uint16) (values []uint16, err error) {
+	count := int(data[0])
+	if count != len(data)-1 || count != (int(quantity)+7)/8 {
+		err = fmt.Errorf("modbus: data size '%v' does not match count '%v' and quantity '%v'", len(data)-1, count, quantity)
+		return
+	}
+	values = make([]uint16, quantity)
+	for i := range values {
+		values[i] = uint16(data[1+i/8]>>uint(i%8)) & 1
+	}
+	return
+}
+
+// decodeRegisters decodes quantity registers prefixed by their byte count.
+func decodeRegisters(data []byte, quantity uint16) (values []uint16, err error) {
+	count := int(data[0])
+	if count != len(data)-1 || count != 2*int(quantity) {
+		err = fmt.Errorf("modbus: data size '%v' does not match count '%v' and quantity '%v'", len(data)-1, count, quantity)
+		return
+	}
+	values = make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[1+2*i:])
+	}
+	return
+}
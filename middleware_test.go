@@ -0,0 +1,122 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// pduHandler passes request PDUs to serve and returns its response in an
+// RTU frame.
+type pduHandler struct {
+	rtuPackager
+	serve func(request *ProtocolDataUnit) *ProtocolDataUnit
+}
+
+func (mb *pduHandler) Send(aduRequest []byte) ([]byte, error) {
+	request := &ProtocolDataUnit{
+		FunctionCode: aduRequest[1],
+		Data:         aduRequest[2 : len(aduRequest)-2],
+	}
+	return mb.Encode(mb.serve(request))
+}
+
+// serveRegisters responds to reads of holding registers with their
+// addresses and to writes with the echo of the request.
+func serveRegisters(request *ProtocolDataUnit) *ProtocolDataUnit {
+	switch request.FunctionCode {
+	case FuncCodeReadHoldingRegisters:
+		address := uint16(request.Data[0])<<8 | uint16(request.Data[1])
+		quantity := int(request.Data[3])
+		values := make([]uint16, quantity)
+		for i := range values {
+			values[i] = address + uint16(i)
+		}
+		return &ProtocolDataUnit{request.FunctionCode, append([]byte{byte(2 * quantity)}, dataBlock(values...)...)}
+	case FuncCodeWriteMultipleRegisters:
+		return &ProtocolDataUnit{request.FunctionCode, request.Data[:4]}
+	}
+	return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalFunction}}
+}
+
+func TestMiddleware(t *testing.T) {
+	var requests []*Request
+	var responses []*Response
+	audit := func(request *Request, next Sender) (*Response, error) {
+		response, err := next(request)
+		requests = append(requests, request)
+		responses = append(responses, response)
+		return response, err
+	}
+	readOnly := func(request *Request, next Sender) (*Response, error) {
+		if request.FunctionCode == FuncCodeWriteMultipleRegisters && request.Address < 100 {
+			return nil, errors.New("denied")
+		}
+		return next(request)
+	}
+	client := NewMiddlewareClient(&pduHandler{serve: serveRegisters}, audit, readOnly)
+
+	results, err := client.ReadHoldingRegisters(10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0, 10, 0, 11}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if _, err = client.WriteMultipleRegisters(10, 2, []byte{0, 1, 0, 2}); err == nil || err.Error() != "denied" {
+		t.Fatalf("expected denied error, actual %v", err)
+	}
+	if _, err = client.WriteMultipleRegisters(100, 2, []byte{0, 1, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ReadCoils(0, 1)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Fatalf("expected illegal function exception, actual %v", err)
+	}
+
+	if len(requests) != 4 {
+		t.Fatalf("unexpected number of requests %v", len(requests))
+	}
+	if r := requests[0]; r.Address != 10 || r.Quantity != 2 || !reflect.DeepEqual([]uint16{10, 11}, responses[0].Values) {
+		t.Fatalf("unexpected request %+v, response %+v", r, responses[0])
+	}
+	if r := requests[1]; responses[1] != nil || !reflect.DeepEqual([]uint16{1, 2}, r.Values) {
+		t.Fatalf("unexpected request %+v, response %+v", r, responses[1])
+	}
+	if responses[3].ExceptionCode != ExceptionCodeIllegalFunction {
+		t.Fatalf("unexpected response %+v", responses[3])
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		pdu      ProtocolDataUnit
+		expected Request
+	}{
+		{ProtocolDataUnit{FuncCodeWriteSingleCoil, []byte{0, 5, 0xFF, 0}},
+			Request{FunctionCode: FuncCodeWriteSingleCoil, Address: 5, Quantity: 1, Values: []uint16{1}}},
+		{ProtocolDataUnit{FuncCodeWriteMultipleCoils, []byte{0, 5, 0, 10, 2, 0xCD, 0x01}},
+			Request{FunctionCode: FuncCodeWriteMultipleCoils, Address: 5, Quantity: 10, Values: []uint16{1, 0, 1, 1, 0, 0, 1, 1, 1, 0}}},
+		{ProtocolDataUnit{FuncCodeMaskWriteRegister, []byte{0, 4, 0, 0xF2, 0, 0x25}},
+			Request{FunctionCode: FuncCodeMaskWriteRegister, Address: 4, Quantity: 1, Values: []uint16{0xF2, 0x25}}},
+		{ProtocolDataUnit{FuncCodeReadWriteMultipleRegisters, []byte{0, 3, 0, 6, 0, 14, 0, 1, 2, 0, 0xFF}},
+			Request{FunctionCode: FuncCodeReadWriteMultipleRegisters, Address: 3, Quantity: 6, WriteAddress: 14, WriteQuantity: 1, Values: []uint16{0xFF}}},
+	}
+	for _, test := range tests {
+		request, err := DecodeRequest(&test.pdu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.expected.PDU = &test.pdu
+		if !reflect.DeepEqual(&test.expected, request) {
+			t.Errorf("expected %+v, actual %+v", test.expected, request)
+		}
+	}
+	if _, err := DecodeRequest(&ProtocolDataUnit{FuncCodeWriteMultipleRegisters, []byte{0, 1, 0, 2, 4, 0}}); err == nil {
+		t.Fatalf("expected error for short request")
+	}
+}
@@ -4,10 +4,14 @@
 
 /*
 Package modbus provides a client for MODBUS TCP and RTU/ASCII.
+
+Serial transporters, RTUSniffer and SerialDetector are not built with the
+modbus_noserial build tag, for programs using only TCP.
 */
 package modbus
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -26,6 +30,39 @@ const (
 	FuncCodeReadWriteMultipleRegisters = 23
 	FuncCodeMaskWriteRegister          = 22
 	FuncCodeReadFIFOQueue              = 24
+
+	// Diagnostics (serial line only)
+	FuncCodeDiagnostics   = 8
+	FuncCodeReportSlaveId = 17
+
+	// Encapsulated interface transport
+	FuncCodeEncapsulatedInterfaceTransport = 43
+)
+
+// Sub-function of FuncCodeDiagnostics echoing the data of the request
+const DiagnosticReturnQueryData = 0
+
+// MEI type of FuncCodeEncapsulatedInterfaceTransport
+const MEITypeReadDeviceIdentification = 14
+
+// Read device ID codes of MEITypeReadDeviceIdentification
+const (
+	ReadDeviceIdBasic      = 1
+	ReadDeviceIdRegular    = 2
+	ReadDeviceIdExtended   = 3
+	ReadDeviceIdIndividual = 4
+)
+
+// Device identification object IDs. Basic objects are mandatory, object
+// IDs from 0x80 are vendor specific extended objects.
+const (
+	ObjectIdVendorName          = 0x00
+	ObjectIdProductCode         = 0x01
+	ObjectIdMajorMinorRevision  = 0x02
+	ObjectIdVendorUrl           = 0x03
+	ObjectIdProductName         = 0x04
+	ObjectIdModelName           = 0x05
+	ObjectIdUserApplicationName = 0x06
 )
 
 const (
@@ -48,30 +85,60 @@ type ModbusError struct {
 
 // Error converts known modbus exception code to error message.
 func (e *ModbusError) Error() string {
-	var name string
-	switch e.ExceptionCode {
+	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, ExceptionName(e.ExceptionCode), e.FunctionCode)
+}
+
+// GatewayError is the error of the gateway exceptions, returned by a
+// gateway instead of the target device when the request could not be
+// forwarded or the device did not respond. It unwraps to its ModbusError.
+type GatewayError struct {
+	ModbusError
+}
+
+// Gateway exceptions, to be compared with errors.Is:
+//  if errors.Is(err, modbus.ErrGatewayTargetDeviceFailedToRespond) {
+//  	// The gateway is up but the device behind it is not
+//  }
+var (
+	ErrGatewayPathUnavailable             = &GatewayError{ModbusError{ExceptionCode: ExceptionCodeGatewayPathUnavailable}}
+	ErrGatewayTargetDeviceFailedToRespond = &GatewayError{ModbusError{ExceptionCode: ExceptionCodeGatewayTargetDeviceFailedToRespond}}
+)
+
+// Is returns true if target is a GatewayError of the same exception code.
+func (e *GatewayError) Is(target error) bool {
+	t, ok := target.(*GatewayError)
+	return ok && t.ExceptionCode == e.ExceptionCode
+}
+
+// Unwrap returns the ModbusError of the exception.
+func (e *GatewayError) Unwrap() error {
+	return &e.ModbusError
+}
+
+// ExceptionName returns the name of the exception code, "unknown" if it
+// is not defined by the specification.
+func ExceptionName(exceptionCode byte) string {
+	switch exceptionCode {
 	case ExceptionCodeIllegalFunction:
-		name = "illegal function"
+		return "illegal function"
 	case ExceptionCodeIllegalDataAddress:
-		name = "illegal data address"
+		return "illegal data address"
 	case ExceptionCodeIllegalDataValue:
-		name = "illegal data value"
+		return "illegal data value"
 	case ExceptionCodeServerDeviceFailure:
-		name = "server device failure"
+		return "server device failure"
 	case ExceptionCodeAcknowledge:
-		name = "acknowledge"
+		return "acknowledge"
 	case ExceptionCodeServerDeviceBusy:
-		name = "server device busy"
+		return "server device busy"
 	case ExceptionCodeMemoryParityError:
-		name = "memory parity error"
+		return "memory parity error"
 	case ExceptionCodeGatewayPathUnavailable:
-		name = "gateway path unavailable"
+		return "gateway path unavailable"
 	case ExceptionCodeGatewayTargetDeviceFailedToRespond:
-		name = "gateway target device failed to respond"
-	default:
-		name = "unknown"
+		return "gateway target device failed to respond"
 	}
-	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, name, e.FunctionCode)
+	return "unknown"
 }
 
 // ProtocolDataUnit (PDU) is independent of underlying communication layers.
@@ -91,3 +158,33 @@ type Packager interface {
 type Transporter interface {
 	Send(aduRequest []byte) (aduResponse []byte, err error)
 }
+
+// ContextTransporter is implemented by transporters whose Send can be
+// interrupted, it returns the error of ctx once ctx is done. See
+// WithContext.
+type ContextTransporter interface {
+	SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error)
+}
+
+// ConnectionState is the state of the connection of a transporter.
+type ConnectionState int
+
+const (
+	// StateDisconnected means no connection has been established or the
+	// connection has been closed.
+	StateDisconnected ConnectionState = iota
+	// StateConnected means the connection is open.
+	StateConnected
+)
+
+// String returns name of the state.
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbusmock
+
+import (
+	"github.com/goburrow/modbus"
+)
+
+// functionCodes are the function codes of the methods of Client.
+var functionCodes = map[string]byte{
+	"ReadCoils":                  modbus.FuncCodeReadCoils,
+	"ReadDiscreteInputs":         modbus.FuncCodeReadDiscreteInputs,
+	"WriteSingleCoil":            modbus.FuncCodeWriteSingleCoil,
+	"WriteMultipleCoils":         modbus.FuncCodeWriteMultipleCoils,
+	"ReadInputRegisters":         modbus.FuncCodeReadInputRegisters,
+	"ReadHoldingRegisters":       modbus.FuncCodeReadHoldingRegisters,
+	"WriteSingleRegister":        modbus.FuncCodeWriteSingleRegister,
+	"WriteMultipleRegisters":     modbus.FuncCodeWriteMultipleRegisters,
+	"ReadWriteMultipleRegisters": modbus.FuncCodeReadWriteMultipleRegisters,
+	"MaskWriteRegister":          modbus.FuncCodeMaskWriteRegister,
+	"ReadFIFOQueue":              modbus.FuncCodeReadFIFOQueue,
+}
+
+// Client is a mock of modbus.Client. Its calls are expected with the name
+// of the method and its arguments, e.g. the address, quantity and value
+// of WriteMultipleRegisters.
+type Client struct {
+	Mock
+}
+
+var _ modbus.Client = (*Client)(nil)
+
+// NewClient allocates a new Client expecting no calls.
+func NewClient() *Client {
+	return &Client{}
+}
+
+func (c *Client) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return c.called("ReadCoils", address, quantity)
+}
+
+func (c *Client) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return c.called("ReadDiscreteInputs", address, quantity)
+}
+
+func (c *Client) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return c.called("WriteSingleCoil", address, value)
+}
+
+func (c *Client) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return c.called("WriteMultipleCoils", address, quantity, value)
+}
+
+func (c *Client) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return c.called("ReadInputRegisters", address, quantity)
+}
+
+func (c *Client) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return c.called("ReadHoldingRegisters", address, quantity)
+}
+
+func (c *Client) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return c.called("WriteSingleRegister", address, value)
+}
+
+func (c *Client) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return c.called("WriteMultipleRegisters", address, quantity, value)
+}
+
+func (c *Client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return c.called("ReadWriteMultipleRegisters", readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (c *Client) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return c.called("MaskWriteRegister", address, andMask, orMask)
+}
+
+func (c *Client) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	return c.called("ReadFIFOQueue", address)
+}
+
+// Transporter is a mock of modbus.Transporter, whose calls of Send are
+// expected with the request frame:
+//  transporter.On("Send", []byte{1, 3, 0, 100, 0, 1, 0xC5, 0xD5}).Return(response, nil)
+type Transporter struct {
+	Mock
+}
+
+var _ modbus.Transporter = (*Transporter)(nil)
+
+// NewTransporter allocates a new Transporter expecting no calls.
+func NewTransporter() *Transporter {
+	return &Transporter{}
+}
+
+func (t *Transporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return t.called("Send", append([]byte(nil), aduRequest...))
+}
@@ -0,0 +1,212 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package modbusmock provides mocks of modbus.Client and modbus.Transporter
+for unit tests of code built on package modbus, scripted with the
+expected calls and their results:
+
+	client := modbusmock.NewClient()
+	client.On("ReadHoldingRegisters", 100, 2).ReturnRegisters(0x1234, 0x5678)
+	client.On("WriteSingleCoil", modbusmock.Anything, 0xFF00).ReturnException(modbus.ExceptionCodeServerDeviceBusy).Once()
+
+	err := codeUnderTest(client)
+	client.AssertExpectations(t)
+
+Unlike modbustest.Device, which simulates the memory of a device, a mock
+checks which requests are sent. *testing.T and testify's assert.TestingT
+implement TestingT.
+*/
+package modbusmock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/goburrow/modbus"
+)
+
+// Anything matches any argument of an expected call.
+const Anything = "modbusmock.Anything"
+
+// TestingT is the part of *testing.T used to report unmet expectations.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Call is an expected or actual call of a mock method.
+type Call struct {
+	Method string
+	Args   []interface{}
+	// Results and Err are returned by the call.
+	Results []byte
+	Err     error
+
+	// repeat is the number of calls expected, zero for any.
+	repeat int
+	count  int
+}
+
+// Return sets the results of the call.
+func (c *Call) Return(results []byte, err error) *Call {
+	c.Results, c.Err = results, err
+	return c
+}
+
+// ReturnRegisters sets the results of the call to values, big-endian.
+func (c *Call) ReturnRegisters(values ...uint16) *Call {
+	results := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(results[2*i:], v)
+	}
+	return c.Return(results, nil)
+}
+
+// ReturnBits sets the results of the call to values packed 8 per byte,
+// starting with the least significant bit.
+func (c *Call) ReturnBits(values ...bool) *Call {
+	results := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			results[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return c.Return(results, nil)
+}
+
+// ReturnException sets the error of the call to the exception, as
+// returned by the clients of package modbus.
+func (c *Call) ReturnException(exceptionCode byte) *Call {
+	err := &modbus.ModbusError{FunctionCode: functionCodes[c.Method] | 0x80, ExceptionCode: exceptionCode}
+	switch exceptionCode {
+	case modbus.ExceptionCodeGatewayPathUnavailable, modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond:
+		return c.Return(nil, &modbus.GatewayError{ModbusError: *err})
+	}
+	return c.Return(nil, err)
+}
+
+// Once expects the call once, see Times.
+func (c *Call) Once() *Call {
+	return c.Times(1)
+}
+
+// Times expects the call n times. Further calls match the next expected
+// calls. By default, a call is expected at least once and repeated.
+func (c *Call) Times(n int) *Call {
+	c.repeat = n
+	return c
+}
+
+// String returns the call as written in Go.
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return fmt.Sprintf("%v(%v)", c.Method, strings.Join(args, ", "))
+}
+
+// matches returns true if the call of method with args matches c.
+func (c *Call) matches(method string, args []interface{}) bool {
+	if c.Method != method || len(c.Args) != len(args) {
+		return false
+	}
+	for i := range args {
+		if !argMatches(c.Args[i], args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// argMatches compares the expected argument with the actual one. Integers
+// are compared by value, so that untyped constants match uint16.
+func argMatches(expected, actual interface{}) bool {
+	if expected == Anything {
+		return true
+	}
+	e, a := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	switch e.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.CanUint() && e.Int() >= 0 && uint64(e.Int()) == a.Uint()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.CanUint() && e.Uint() == a.Uint()
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// Mock records the calls of a mock and returns the results of the
+// expected calls they match, it is embedded in Client and Transporter.
+type Mock struct {
+	mu         sync.Mutex
+	expected   []*Call
+	calls      []Call
+	unexpected []Call
+}
+
+// On expects a call of method with args, which may be Anything. The
+// results are set on the call returned.
+func (m *Mock) On(method string, args ...interface{}) *Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	call := &Call{Method: method, Args: args}
+	m.expected = append(m.expected, call)
+	return call
+}
+
+// Calls returns the calls received, in order.
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// AssertExpectations reports calls not expected, and expected calls not
+// received or not received the number of times expected, to t. It returns
+// true if there are none.
+func (m *Mock) AssertExpectations(t TestingT) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for i := range m.unexpected {
+		t.Errorf("modbusmock: unexpected call %v", &m.unexpected[i])
+		ok = false
+	}
+	for _, call := range m.expected {
+		if call.count == 0 || call.repeat > 0 && call.count != call.repeat {
+			t.Errorf("modbusmock: call %v expected '%v' times, received '%v' times", call, expectedTimes(call.repeat), call.count)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func expectedTimes(repeat int) interface{} {
+	if repeat == 0 {
+		return "1+"
+	}
+	return repeat
+}
+
+// called records the call of method with args and returns the results of
+// the first expected call matching it.
+func (m *Mock) called(method string, args ...interface{}) (results []byte, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	call := Call{Method: method, Args: args}
+	m.calls = append(m.calls, call)
+	for _, expected := range m.expected {
+		if expected.repeat > 0 && expected.count >= expected.repeat || !expected.matches(method, args) {
+			continue
+		}
+		expected.count++
+		// Results are copied so that callers can modify them
+		return append([]byte(nil), expected.Results...), expected.Err
+	}
+	m.unexpected = append(m.unexpected, call)
+	return nil, fmt.Errorf("modbusmock: unexpected call %v", &call)
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbusmock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+// recorder records the errors reported.
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestClient(t *testing.T) {
+	client := NewClient()
+	client.On("ReadHoldingRegisters", 100, 2).ReturnRegisters(0x1234, 0x5678)
+	client.On("ReadCoils", Anything, 3).ReturnBits(true, false, true)
+	client.On("WriteSingleRegister", 10, 1).ReturnException(modbus.ExceptionCodeServerDeviceBusy).Once()
+	client.On("WriteSingleRegister", 10, 1).Return([]byte{0, 10, 0, 1}, nil)
+
+	value, err := modbus.ReadValue[uint32](client, 100, "")
+	if err != nil || value != 0x12345678 {
+		t.Fatalf("unexpected value %x, error %v", value, err)
+	}
+	results, err := client.ReadCoils(7, 3)
+	if err != nil || !bytes.Equal(results, []byte{5}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	_, err = client.WriteSingleRegister(10, 1)
+	var mbError *modbus.ModbusError
+	if !errors.As(err, &mbError) || mbError.ExceptionCode != modbus.ExceptionCodeServerDeviceBusy || mbError.FunctionCode != 0x86 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = client.WriteSingleRegister(10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !client.AssertExpectations(t) {
+		t.FailNow()
+	}
+	if calls := client.Calls(); len(calls) != 4 || calls[1].String() != "ReadCoils(7, 3)" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+
+	var r recorder
+	client.On("ReadInputRegisters", 0, 1).Return(nil, nil)
+	if _, err = client.ReadFIFOQueue(5); err == nil {
+		t.Fatal("unexpected call error expected")
+	}
+	if client.AssertExpectations(&r) || len(r.errors) != 2 {
+		t.Fatalf("unexpected errors %q", r.errors)
+	}
+}
+
+func TestTransporter(t *testing.T) {
+	transporter := NewTransporter()
+	client := modbus.NewClient2(modbus.NewRTUPackager(1), transporter)
+	transporter.On("Send", []byte{1, 3, 0, 100, 0, 1, 0xC5, 0xD5}).Return([]byte{1, 3, 2, 0, 7, 0xF9, 0x86}, nil)
+
+	results, err := client.ReadHoldingRegisters(100, 1)
+	if err != nil || !bytes.Equal(results, []byte{0, 7}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	transporter.AssertExpectations(t)
+}
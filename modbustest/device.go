@@ -0,0 +1,485 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package modbustest provides a simulated Modbus device for testing code
+built on package modbus without hardware.
+
+A Device can be used in-process through ClientHandler or over the network
+through Server:
+
+	device := modbustest.NewDevice()
+	device.SetHoldingRegisters(100, 1, 2, 3)
+
+	client := modbus.NewClient(modbustest.NewClientHandler(device))
+	results, err := client.ReadHoldingRegisters(100, 3)
+*/
+package modbustest
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+const addressSpace = 65536
+
+// Device is a programmable simulated Modbus slave.
+type Device struct {
+	// Delay is applied before every response.
+	Delay time.Duration
+
+	mu               sync.Mutex
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+	fifoQueues       map[uint16][]uint16
+	exceptions       map[byte]byte
+	errors           []error
+	identification   map[byte]string
+}
+
+// NewDevice allocates a new Device with all coils, inputs and registers
+// cleared.
+func NewDevice() *Device {
+	return &Device{
+		coils:            make([]bool, addressSpace),
+		discreteInputs:   make([]bool, addressSpace),
+		holdingRegisters: make([]uint16, addressSpace),
+		inputRegisters:   make([]uint16, addressSpace),
+		fifoQueues:       make(map[uint16][]uint16),
+		exceptions:       make(map[byte]byte),
+		identification:   make(map[byte]string),
+	}
+}
+
+// SetCoils sets coils starting at address.
+func (d *Device) SetCoils(address uint16, values ...bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.coils[address:], values)
+}
+
+// Coils returns quantity coils starting at address, truncated to the
+// address space.
+func (d *Device) Coils(address, quantity uint16) []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]bool(nil), d.coils[address:rangeEnd(address, quantity)]...)
+}
+
+// SetDiscreteInputs sets discrete inputs starting at address.
+func (d *Device) SetDiscreteInputs(address uint16, values ...bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.discreteInputs[address:], values)
+}
+
+// DiscreteInputs returns quantity discrete inputs starting at address,
+// truncated to the address space.
+func (d *Device) DiscreteInputs(address, quantity uint16) []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]bool(nil), d.discreteInputs[address:rangeEnd(address, quantity)]...)
+}
+
+// SetHoldingRegisters sets holding registers starting at address.
+func (d *Device) SetHoldingRegisters(address uint16, values ...uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.holdingRegisters[address:], values)
+}
+
+// HoldingRegisters returns quantity holding registers starting at
+// address, truncated to the address space.
+func (d *Device) HoldingRegisters(address, quantity uint16) []uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]uint16(nil), d.holdingRegisters[address:rangeEnd(address, quantity)]...)
+}
+
+// SetInputRegisters sets input registers starting at address.
+func (d *Device) SetInputRegisters(address uint16, values ...uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.inputRegisters[address:], values)
+}
+
+// InputRegisters returns quantity input registers starting at address,
+// truncated to the address space.
+func (d *Device) InputRegisters(address, quantity uint16) []uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]uint16(nil), d.inputRegisters[address:rangeEnd(address, quantity)]...)
+}
+
+// SetFIFOQueue sets content of the FIFO queue at the pointer address.
+func (d *Device) SetFIFOQueue(address uint16, values ...uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fifoQueues[address] = append([]uint16(nil), values...)
+}
+
+// SetDeviceIdentification sets the device identification object served
+// with function code 43 / MEI type 14, e.g.:
+//  device.SetDeviceIdentification(modbus.ObjectIdVendorName, "Acme")
+// Empty value removes the object. The conformity level reported follows
+// the highest category of objects set: basic (0x00-0x02), regular
+// (0x03-0x7F) or extended (0x80-0xFF).
+func (d *Device) SetDeviceIdentification(objectId byte, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if value == "" {
+		delete(d.identification, objectId)
+	} else {
+		d.identification[objectId] = value
+	}
+}
+
+// SetException makes the device respond to functionCode with the given
+// exception code. Zero exceptionCode removes the exception.
+func (d *Device) SetException(functionCode, exceptionCode byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if exceptionCode == 0 {
+		delete(d.exceptions, functionCode)
+	} else {
+		d.exceptions[functionCode] = exceptionCode
+	}
+}
+
+// InjectError makes the next request fail with err instead of being
+// served. Errors are queued and consumed one per request.
+func (d *Device) InjectError(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errors = append(d.errors, err)
+}
+
+// nextError returns the next injected error, if any.
+func (d *Device) nextError() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.errors) == 0 {
+		return nil
+	}
+	err := d.errors[0]
+	d.errors = d.errors[1:]
+	return err
+}
+
+// Serve processes request and returns the response PDU, which is an
+// exception response if the request can not be served.
+func (d *Device) Serve(request *modbus.ProtocolDataUnit) *modbus.ProtocolDataUnit {
+	if d.Delay > 0 {
+		time.Sleep(d.Delay)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if exceptionCode, ok := d.exceptions[request.FunctionCode]; ok {
+		return exception(request, exceptionCode)
+	}
+	var data []byte
+	var exceptionCode byte
+	switch request.FunctionCode {
+	case modbus.FuncCodeReadCoils:
+		data, exceptionCode = readBits(d.coils, request.Data, 2000)
+	case modbus.FuncCodeReadDiscreteInputs:
+		data, exceptionCode = readBits(d.discreteInputs, request.Data, 2000)
+	case modbus.FuncCodeReadHoldingRegisters:
+		data, exceptionCode = readRegisters(d.holdingRegisters, request.Data, 125)
+	case modbus.FuncCodeReadInputRegisters:
+		data, exceptionCode = readRegisters(d.inputRegisters, request.Data, 125)
+	case modbus.FuncCodeWriteSingleCoil:
+		data, exceptionCode = d.writeSingleCoil(request.Data)
+	case modbus.FuncCodeWriteSingleRegister:
+		data, exceptionCode = d.writeSingleRegister(request.Data)
+	case modbus.FuncCodeWriteMultipleCoils:
+		data, exceptionCode = d.writeMultipleCoils(request.Data)
+	case modbus.FuncCodeWriteMultipleRegisters:
+		data, exceptionCode = d.writeMultipleRegisters(request.Data)
+	case modbus.FuncCodeMaskWriteRegister:
+		data, exceptionCode = d.maskWriteRegister(request.Data)
+	case modbus.FuncCodeReadWriteMultipleRegisters:
+		data, exceptionCode = d.readWriteMultipleRegisters(request.Data)
+	case modbus.FuncCodeReadFIFOQueue:
+		data, exceptionCode = d.readFIFOQueue(request.Data)
+	case modbus.FuncCodeEncapsulatedInterfaceTransport:
+		data, exceptionCode = d.encapsulatedInterfaceTransport(request.Data)
+	default:
+		exceptionCode = modbus.ExceptionCodeIllegalFunction
+	}
+	if exceptionCode != 0 {
+		return exception(request, exceptionCode)
+	}
+	return &modbus.ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: data}
+}
+
+// rangeEnd returns the end of quantity values starting at address, truncated
+// to the address space.
+func rangeEnd(address, quantity uint16) int {
+	if n := int(address) + int(quantity); n < addressSpace {
+		return n
+	}
+	return addressSpace
+}
+
+func exception(request *modbus.ProtocolDataUnit, exceptionCode byte) *modbus.ProtocolDataUnit {
+	return &modbus.ProtocolDataUnit{
+		FunctionCode: request.FunctionCode | 0x80,
+		Data:         []byte{exceptionCode},
+	}
+}
+
+// checkRange validates address and quantity in request data.
+func checkRange(data []byte, max int) (address, quantity int, exceptionCode byte) {
+	if len(data) < 4 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	address = int(binary.BigEndian.Uint16(data))
+	quantity = int(binary.BigEndian.Uint16(data[2:]))
+	if quantity < 1 || quantity > max {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	if address+quantity > addressSpace {
+		exceptionCode = modbus.ExceptionCodeIllegalDataAddress
+	}
+	return
+}
+
+func readBits(bits []bool, request []byte, max int) (data []byte, exceptionCode byte) {
+	address, quantity, exceptionCode := checkRange(request, max)
+	if exceptionCode != 0 {
+		return
+	}
+	count := (quantity + 7) / 8
+	data = make([]byte, 1+count)
+	data[0] = byte(count)
+	for i := 0; i < quantity; i++ {
+		if bits[address+i] {
+			data[1+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return
+}
+
+func readRegisters(registers []uint16, request []byte, max int) (data []byte, exceptionCode byte) {
+	address, quantity, exceptionCode := checkRange(request, max)
+	if exceptionCode != 0 {
+		return
+	}
+	data = make([]byte, 1+2*quantity)
+	data[0] = byte(2 * quantity)
+	for i := 0; i < quantity; i++ {
+		binary.BigEndian.PutUint16(data[1+2*i:], registers[address+i])
+	}
+	return
+}
+
+func (d *Device) writeSingleCoil(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) != 4 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	address := binary.BigEndian.Uint16(request)
+	switch binary.BigEndian.Uint16(request[2:]) {
+	case 0xFF00:
+		d.coils[address] = true
+	case 0x0000:
+		d.coils[address] = false
+	default:
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	data = request
+	return
+}
+
+func (d *Device) writeSingleRegister(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) != 4 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	d.holdingRegisters[binary.BigEndian.Uint16(request)] = binary.BigEndian.Uint16(request[2:])
+	data = request
+	return
+}
+
+func (d *Device) writeMultipleCoils(request []byte) (data []byte, exceptionCode byte) {
+	address, quantity, exceptionCode := checkRange(request, 1968)
+	if exceptionCode != 0 {
+		return
+	}
+	if len(request) < 5 || int(request[4]) != (quantity+7)/8 || len(request)-5 != int(request[4]) {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	values := request[5:]
+	for i := 0; i < quantity; i++ {
+		d.coils[address+i] = values[i/8]&(1<<uint(i%8)) != 0
+	}
+	data = request[:4]
+	return
+}
+
+func (d *Device) writeMultipleRegisters(request []byte) (data []byte, exceptionCode byte) {
+	address, quantity, exceptionCode := checkRange(request, 123)
+	if exceptionCode != 0 {
+		return
+	}
+	if len(request) < 5 || int(request[4]) != 2*quantity || len(request)-5 != int(request[4]) {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	for i := 0; i < quantity; i++ {
+		d.holdingRegisters[address+i] = binary.BigEndian.Uint16(request[5+2*i:])
+	}
+	data = request[:4]
+	return
+}
+
+func (d *Device) maskWriteRegister(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) != 6 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	address := binary.BigEndian.Uint16(request)
+	andMask := binary.BigEndian.Uint16(request[2:])
+	orMask := binary.BigEndian.Uint16(request[4:])
+	current := d.holdingRegisters[address]
+	d.holdingRegisters[address] = (current & andMask) | (orMask &^ andMask)
+	data = request
+	return
+}
+
+func (d *Device) readWriteMultipleRegisters(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) < 9 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	// Write is performed before read
+	if _, exceptionCode = d.writeMultipleRegisters(request[4:]); exceptionCode != 0 {
+		return
+	}
+	return readRegisters(d.holdingRegisters, request[:4], 125)
+}
+
+func (d *Device) readFIFOQueue(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) != 2 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	queue := d.fifoQueues[binary.BigEndian.Uint16(request)]
+	if len(queue) > 31 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	data = make([]byte, 4+2*len(queue))
+	binary.BigEndian.PutUint16(data, uint16(2+2*len(queue)))
+	binary.BigEndian.PutUint16(data[2:], uint16(len(queue)))
+	for i, v := range queue {
+		binary.BigEndian.PutUint16(data[4+2*i:], v)
+	}
+	return
+}
+
+func (d *Device) encapsulatedInterfaceTransport(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) < 1 || request[0] != modbus.MEITypeReadDeviceIdentification {
+		exceptionCode = modbus.ExceptionCodeIllegalFunction
+		return
+	}
+	return d.readDeviceIdentification(request)
+}
+
+// maxObjectsSize is the space left for objects in a response PDU of 253
+// bytes after function code and read device identification header.
+const maxObjectsSize = 253 - 7
+
+// readDeviceIdentification serves stream and individual access to the
+// identification objects. Streams not fitting in one response are split,
+// the response then has more follows set and the ID of the next object
+// to request.
+func (d *Device) readDeviceIdentification(request []byte) (data []byte, exceptionCode byte) {
+	if len(request) != 3 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	code, objectId := request[1], request[2]
+
+	// Conformity level is the highest category implemented, individual
+	// access is always supported.
+	var conformity byte = modbus.ReadDeviceIdBasic
+	for id := range d.identification {
+		if c := objectCategory(id); c > conformity {
+			conformity = c
+		}
+	}
+	var ids []int
+	switch code {
+	case modbus.ReadDeviceIdBasic, modbus.ReadDeviceIdRegular, modbus.ReadDeviceIdExtended:
+		for id := range d.identification {
+			if objectCategory(id) <= code {
+				ids = append(ids, int(id))
+			}
+		}
+		sort.Ints(ids)
+		// Unknown object restarts the stream at the beginning.
+		start := sort.SearchInts(ids, int(objectId))
+		if start == len(ids) || ids[start] != int(objectId) {
+			start = 0
+		}
+		ids = ids[start:]
+	case modbus.ReadDeviceIdIndividual:
+		if _, ok := d.identification[objectId]; !ok {
+			exceptionCode = modbus.ExceptionCodeIllegalDataAddress
+			return
+		}
+		ids = []int{int(objectId)}
+	default:
+		exceptionCode = modbus.ExceptionCodeIllegalDataValue
+		return
+	}
+	if len(ids) == 0 {
+		exceptionCode = modbus.ExceptionCodeIllegalDataAddress
+		return
+	}
+	data = []byte{modbus.MEITypeReadDeviceIdentification, code, conformity | 0x80, 0x00, 0x00, 0}
+	size := 0
+	for i, id := range ids {
+		value := d.identification[byte(id)]
+		if len(value) > maxObjectsSize-2 {
+			value = value[:maxObjectsSize-2]
+		}
+		if size+2+len(value) > maxObjectsSize {
+			data[3] = 0xFF
+			data[4] = byte(id)
+			break
+		}
+		size += 2 + len(value)
+		data = append(data, byte(id), byte(len(value)))
+		data = append(data, value...)
+		data[5] = byte(i + 1)
+	}
+	return
+}
+
+// objectCategory returns the read device ID code needed to stream the
+// object.
+func objectCategory(objectId byte) byte {
+	switch {
+	case objectId <= modbus.ObjectIdMajorMinorRevision:
+		return modbus.ReadDeviceIdBasic
+	case objectId < 0x80:
+		return modbus.ReadDeviceIdRegular
+	default:
+		return modbus.ReadDeviceIdExtended
+	}
+}
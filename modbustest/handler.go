@@ -0,0 +1,71 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbustest
+
+import (
+	"fmt"
+
+	"github.com/goburrow/modbus"
+)
+
+// ClientHandler implements modbus.ClientHandler serving requests
+// in-process with a Device. Its frame is the slave id followed by the PDU.
+type ClientHandler struct {
+	Device  *Device
+	SlaveId byte
+}
+
+// NewClientHandler allocates a new ClientHandler for the device.
+func NewClientHandler(device *Device) *ClientHandler {
+	return &ClientHandler{Device: device}
+}
+
+// Encode prepends slave id to the PDU.
+func (mb *ClientHandler) Encode(pdu *modbus.ProtocolDataUnit) (adu []byte, err error) {
+	adu = make([]byte, 2+len(pdu.Data))
+	adu[0] = mb.SlaveId
+	adu[1] = pdu.FunctionCode
+	copy(adu[2:], pdu.Data)
+	return
+}
+
+// Decode extracts PDU from the frame.
+func (mb *ClientHandler) Decode(adu []byte) (pdu *modbus.ProtocolDataUnit, err error) {
+	if len(adu) < 2 {
+		err = fmt.Errorf("modbustest: frame length '%v' does not meet minimum '%v'", len(adu), 2)
+		return
+	}
+	pdu = &modbus.ProtocolDataUnit{FunctionCode: adu[1], Data: adu[2:]}
+	return
+}
+
+// Verify confirms slave id.
+func (mb *ClientHandler) Verify(aduRequest []byte, aduResponse []byte) (err error) {
+	if len(aduResponse) < 2 {
+		err = fmt.Errorf("modbustest: response length '%v' does not meet minimum '%v'", len(aduResponse), 2)
+		return
+	}
+	if aduResponse[0] != aduRequest[0] {
+		err = fmt.Errorf("modbustest: response slave id '%v' does not match request '%v'", aduResponse[0], aduRequest[0])
+	}
+	return
+}
+
+// Send serves the request with the device, or returns the injected error.
+func (mb *ClientHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	if err = mb.Device.nextError(); err != nil {
+		return
+	}
+	request, err := mb.Decode(aduRequest)
+	if err != nil {
+		return
+	}
+	response := mb.Device.Serve(request)
+	aduResponse = make([]byte, 2+len(response.Data))
+	aduResponse[0] = aduRequest[0]
+	aduResponse[1] = response.FunctionCode
+	copy(aduResponse[2:], response.Data)
+	return
+}
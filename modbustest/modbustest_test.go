@@ -0,0 +1,167 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbustest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func testClient(t *testing.T, client modbus.Client, device *Device) {
+	device.SetHoldingRegisters(100, 0x1234, 0x5678)
+	results, err := client.ReadHoldingRegisters(100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x12, 0x34, 0x56, 0x78}, results) {
+		t.Fatalf("unexpected registers: % x", results)
+	}
+	if _, err = client.WriteMultipleCoils(10, 10, []byte{0xCD, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	coils := device.Coils(10, 10)
+	expected := []bool{true, false, true, true, false, false, true, true, true, false}
+	for i := range expected {
+		if coils[i] != expected[i] {
+			t.Fatalf("unexpected coils: %v", coils)
+		}
+	}
+	if _, err = client.MaskWriteRegister(100, 0x00F2, 0x0025); err != nil {
+		t.Fatal(err)
+	}
+	if v := device.HoldingRegisters(100, 1)[0]; v != (0x1234&0x00F2)|(0x0025&^0x00F2) {
+		t.Fatalf("unexpected masked register: %x", v)
+	}
+	device.SetException(modbus.FuncCodeReadInputRegisters, modbus.ExceptionCodeServerDeviceBusy)
+	_, err = client.ReadInputRegisters(0, 1)
+	if mbError, ok := err.(*modbus.ModbusError); !ok || mbError.ExceptionCode != modbus.ExceptionCodeServerDeviceBusy {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = client.ReadHoldingRegisters(0xFFFF, 2); err == nil {
+		t.Fatal("illegal data address expected")
+	}
+}
+
+func TestDeviceGettersTruncate(t *testing.T) {
+	device := NewDevice()
+	device.SetHoldingRegisters(0xFFFE, 1, 2, 3)
+	device.SetCoils(0xFFFF, true, true)
+	if values := device.HoldingRegisters(0xFFFE, 4); len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Fatalf("unexpected holding registers: %v", values)
+	}
+	if values := device.InputRegisters(0xFFFF, 0xFFFF); len(values) != 1 {
+		t.Fatalf("unexpected input registers: %v", values)
+	}
+	if values := device.Coils(0xFFFF, 2); len(values) != 1 || !values[0] {
+		t.Fatalf("unexpected coils: %v", values)
+	}
+	if values := device.DiscreteInputs(0xFFF0, 0x20); len(values) != 0x10 {
+		t.Fatalf("unexpected discrete inputs: %v", values)
+	}
+}
+
+func TestClientHandler(t *testing.T) {
+	device := NewDevice()
+	client := modbus.NewClient(NewClientHandler(device))
+	testClient(t, client, device)
+
+	injected := errors.New("injected")
+	device.InjectError(injected)
+	if _, err := client.ReadCoils(0, 1); err != injected {
+		t.Fatalf("injected error expected, actual %v", err)
+	}
+	if _, err := client.ReadCoils(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	device := NewDevice()
+	server := NewServer(device)
+	defer server.Close()
+
+	handler := modbus.NewTCPClientHandler(server.Addr())
+	handler.Timeout = time.Second
+	defer handler.Close()
+	testClient(t, modbus.NewClient(handler), device)
+}
+
+func TestDeviceIdentification(t *testing.T) {
+	device := NewDevice()
+	device.SetDeviceIdentification(modbus.ObjectIdVendorName, "Acme")
+	device.SetDeviceIdentification(modbus.ObjectIdProductCode, "P1")
+	device.SetDeviceIdentification(modbus.ObjectIdMajorMinorRevision, "V1.0")
+	read := func(code, objectId byte) *modbus.ProtocolDataUnit {
+		return device.Serve(&modbus.ProtocolDataUnit{
+			FunctionCode: modbus.FuncCodeEncapsulatedInterfaceTransport,
+			Data:         []byte{modbus.MEITypeReadDeviceIdentification, code, objectId},
+		})
+	}
+	response := read(modbus.ReadDeviceIdBasic, 0)
+	expected := []byte{0x0E, 0x01, 0x81, 0x00, 0x00, 0x03,
+		0x00, 0x04, 'A', 'c', 'm', 'e', 0x01, 0x02, 'P', '1', 0x02, 0x04, 'V', '1', '.', '0'}
+	if !bytes.Equal(expected, response.Data) {
+		t.Fatalf("unexpected response: % x", response.Data)
+	}
+
+	long := string(bytes.Repeat([]byte{'x'}, 200))
+	device.SetDeviceIdentification(modbus.ObjectIdVendorUrl, long)
+	device.SetDeviceIdentification(0x80, long)
+	response = read(modbus.ReadDeviceIdExtended, 0)
+	if response.Data[2] != 0x83 || response.Data[3] != 0xFF || response.Data[4] != 0x80 || response.Data[5] != 4 {
+		t.Fatalf("unexpected header: % x", response.Data[:6])
+	}
+	response = read(modbus.ReadDeviceIdExtended, response.Data[4])
+	if response.Data[3] != 0x00 || response.Data[5] != 1 || response.Data[6] != 0x80 {
+		t.Fatalf("unexpected header: % x", response.Data[:7])
+	}
+	// Regular stream leaves out extended objects
+	if response = read(modbus.ReadDeviceIdRegular, 0); response.Data[3] != 0x00 || response.Data[5] != 4 {
+		t.Fatalf("unexpected header: % x", response.Data[:6])
+	}
+
+	response = read(modbus.ReadDeviceIdIndividual, modbus.ObjectIdProductCode)
+	if !bytes.Equal([]byte{0x0E, 0x04, 0x83, 0x00, 0x00, 0x01, 0x01, 0x02, 'P', '1'}, response.Data) {
+		t.Fatalf("unexpected response: % x", response.Data)
+	}
+	if response = read(modbus.ReadDeviceIdIndividual, 0x10); response.FunctionCode != 0xAB ||
+		response.Data[0] != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected response: %v", response)
+	}
+	if response = read(5, 0); response.Data[0] != modbus.ExceptionCodeIllegalDataValue {
+		t.Fatalf("unexpected response: %v", response)
+	}
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	device := NewDevice()
+	device.SetHoldingRegisters(0, 0x1234)
+	server := NewServer(device)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte{0, 0, 0, 0, 0, 0})
+	conn.Write([]byte{0, 0, 0, 0, 0, 1, 1})
+	conn.Write([]byte{0, 1, 0, 5, 0, 6, 1, 3, 0, 0, 0, 1})
+	conn.Write([]byte{0, 2, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	var response [11]byte
+	if _, err = io.ReadFull(conn, response[:]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response[:]) {
+		t.Fatalf("unexpected response: % x", response)
+	}
+}
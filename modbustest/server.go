@@ -0,0 +1,76 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbustest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/goburrow/modbus"
+)
+
+// Server serves a Device over Modbus TCP on a local address.
+// When an error has been injected to the device, the connection which
+// receives the next request is closed without a response.
+type Server struct {
+	Device *Device
+	// Listener is the network listener, available after Start.
+	Listener net.Listener
+	// StrictFraming closes connections receiving keep-alive frames without
+	// PDU or frames with a non-zero protocol id, which are otherwise
+	// skipped.
+	StrictFraming bool
+
+	server *modbus.Server
+	wg     sync.WaitGroup
+}
+
+// NewServer starts and returns a new Server listening on a loopback
+// address. The caller should call Close when finished.
+func NewServer(device *Device) *Server {
+	s := &Server{Device: device}
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		panic("modbustest: failed to listen: " + err.Error())
+	}
+	return s
+}
+
+// Start listens on the given address and serves connections in
+// background.
+func (s *Server) Start(address string) (err error) {
+	if s.Listener, err = net.Listen("tcp", address); err != nil {
+		return
+	}
+	s.server = modbus.NewServer(nil)
+	s.server.StrictFraming = s.StrictFraming
+	s.server.PDUHandler = s.serve
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.server.Serve(s.Listener)
+	}()
+	return
+}
+
+// Addr returns the listening address, e.g. 127.0.0.1:5020.
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Close stops listening and closes all connections.
+func (s *Server) Close() error {
+	err := s.Listener.Close()
+	s.wg.Wait()
+	s.server.Close()
+	return err
+}
+
+// serve serves the request with the device, or returns the injected error.
+func (s *Server) serve(unitId byte, request *modbus.ProtocolDataUnit) (*modbus.ProtocolDataUnit, error) {
+	if err := s.Device.nextError(); err != nil {
+		return nil, err
+	}
+	return s.Device.Serve(request), nil
+}
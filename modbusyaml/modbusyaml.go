@@ -0,0 +1,53 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package modbusyaml reads and writes modbus register maps as YAML, with the
+fields of JSON register maps:
+
+	name: flow meter
+	tags:
+	  - {name: flow_rate, table: input, address: 0, type: float32, order: cdab, unit: m3/h}
+	  - {name: pump, table: coils, address: 3}
+
+It is a separate package so that users of package modbus do not depend on
+the YAML library.
+*/
+package modbusyaml
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/goburrow/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadRegisterMap reads a YAML register map and validates it. Unknown
+// fields are reported with their line.
+func LoadRegisterMap(r io.Reader) (m *modbus.RegisterMap, err error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	m = &modbus.RegisterMap{}
+	if err = decoder.Decode(m); err != nil {
+		m = nil
+		err = fmt.Errorf("modbus: register map: %v", err)
+		return
+	}
+	if err = m.Validate(); err != nil {
+		m = nil
+	}
+	return
+}
+
+// WriteRegisterMap writes the register map as YAML, which can be read by
+// LoadRegisterMap.
+func WriteRegisterMap(w io.Writer, m *modbus.RegisterMap) (err error) {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(m); err != nil {
+		return
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbusyaml
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestRegisterMap(t *testing.T) {
+	m, err := LoadRegisterMap(strings.NewReader(`name: meter
+paging: {register: 0, window_address: 1000, window_size: 100}
+tags:
+  - {name: flow, table: input, address: 0, type: float32, order: cdab, unit: m3/h}
+  - {name: temperature, table: holding, address: 1000, page: 2, type: int16, scale: 0.1, unit: degC, target_unit: degF, max: 200}
+  - {name: pump, table: coils, address: 3}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "meter" || m.Paging.WindowSize != 100 || len(m.Tags) != 3 ||
+		m.Tags[0].Table != modbus.TableInputRegisters || m.Tags[1].TargetUnit != "degF" || *m.Tags[1].Max != 200 {
+		t.Fatalf("unexpected register map %+v", m)
+	}
+	var buf bytes.Buffer
+	if err = WriteRegisterMap(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "table: holding\n") {
+		t.Fatalf("unexpected YAML:\n%s", buf.String())
+	}
+	loaded, err := LoadRegisterMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, loaded) {
+		t.Fatalf("expected %+v, actual %+v", m, loaded)
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"tags:\n  - {name: a, table: holding, adress: 1}\n", "modbus: register map: yaml: unmarshal errors:\n  line 2: field adress not found"},
+		{"tags:\n  - {name: a, table: holdings}\n", "modbus: register map: modbus: unknown table 'holdings'"},
+		{"tags:\n  - {name: a, table: holding, type: int}\n", "modbus: tags[0].type: invalid type 'int' for table 'holding'"},
+	}
+	for _, test := range tests {
+		_, err = LoadRegisterMap(strings.NewReader(test.data))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("expected error %q, actual %v", test.err, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package mqttmodbus bridges the tags of a modbus device to MQTT topics, as a
+minimal Modbus to MQTT gateway.
+
+Tags of the register map of the device are polled and published on change
+to topic/<tag>, and values published to topic/<tag>/set are written to the
+device:
+
+	device, err := modbus.NewDevice(client, registerMap)
+	bridge := mqttmodbus.NewBridge(device, mqttClient, "plant/pump1")
+	bridge.Deadband = 0.1
+	bridge.Heartbeat = time.Minute
+	err = bridge.Start()
+	defer bridge.Stop()
+
+The package does not depend on a MQTT library, the Client interface is
+implemented by a small adapter, e.g. for github.com/eclipse/paho.mqtt.golang:
+
+	type pahoClient struct {
+		mqtt.Client
+	}
+
+	func (c pahoClient) Publish(topic string, retained bool, payload []byte) error {
+		token := c.Client.Publish(topic, 1, retained, payload)
+		token.Wait()
+		return token.Error()
+	}
+
+	func (c pahoClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+		token := c.Client.Subscribe(topic, 1, func(_ mqtt.Client, m mqtt.Message) {
+			handler(m.Topic(), m.Payload())
+		})
+		token.Wait()
+		return token.Error()
+	}
+
+	func (c pahoClient) Unsubscribe(topic string) error {
+		token := c.Client.Unsubscribe(topic)
+		token.Wait()
+		return token.Error()
+	}
+*/
+package mqttmodbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// Client is a connected MQTT client.
+type Client interface {
+	Publish(topic string, retained bool, payload []byte) error
+	// Subscribe calls handler with the messages of the topic filter.
+	Subscribe(topic string, handler func(topic string, payload []byte)) error
+	Unsubscribe(topic string) error
+}
+
+// Status payloads published to topic/status.
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// Value is the JSON payload of the tags published, e.g.
+//
+//	{"value": 12.5, "unit": "m3/h", "quality": "good"}
+//
+// Value is null if it is not a number.
+type Value struct {
+	Value   *float64 `json:"value"`
+	Unit    string   `json:"unit,omitempty"`
+	Quality string   `json:"quality"`
+}
+
+// Bridge publishes the tags of a device to MQTT and writes the values
+// received from MQTT to the device.
+type Bridge struct {
+	Device *modbus.Device
+	MQTT   Client
+	// Topic is the prefix of the topics of the tags, which must not
+	// contain wildcards.
+	Topic string
+	// Interval is the poll interval of the tags.
+	Interval time.Duration
+	// Deadband, Deadbands and Heartbeat select the values published, see
+	// modbus.ChangeReporter.
+	Deadband  float64
+	Deadbands map[string]float64
+	Heartbeat time.Duration
+	// Retain publishes the values as retained messages.
+	Retain bool
+	// ReadOnly ignores the values published to topic/<tag>/set.
+	ReadOnly bool
+	// Logger logs failed polls, publications and writes if set.
+	Logger *log.Logger
+
+	mu     sync.Mutex
+	poller *modbus.Poller
+	// online is true if the last poll succeeded.
+	online bool
+}
+
+// NewBridge allocates a new Bridge polling device every second.
+func NewBridge(device *modbus.Device, client Client, topic string) *Bridge {
+	return &Bridge{
+		Device:   device,
+		MQTT:     client,
+		Topic:    topic,
+		Interval: time.Second,
+	}
+}
+
+// Start subscribes to the writes of the tags and starts polling them. It
+// does nothing if the bridge is already started.
+func (b *Bridge) Start() (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.poller != nil {
+		return
+	}
+	reporter := &modbus.ChangeReporter{
+		Map:       b.Device.Map,
+		Deadband:  b.Deadband,
+		Deadbands: b.Deadbands,
+		Heartbeat: b.Heartbeat,
+		Report:    b.report,
+	}
+	poller := modbus.NewPoller(b.Device.Client)
+	err = poller.Add(&modbus.PollGroup{
+		Name:     b.Topic,
+		Interval: b.Interval,
+		Tags:     b.Device.Map.PlanTags(),
+		Handler:  reporter.Handle,
+	})
+	if err != nil {
+		return
+	}
+	if !b.ReadOnly {
+		if err = b.MQTT.Subscribe(b.Topic+"/+/set", b.write); err != nil {
+			return
+		}
+	}
+	b.online = false
+	b.poller = poller
+	poller.Start()
+	return
+}
+
+// Stop stops polling, unsubscribes from the writes and publishes the
+// offline status.
+func (b *Bridge) Stop() (err error) {
+	b.mu.Lock()
+	poller := b.poller
+	b.poller = nil
+	b.mu.Unlock()
+
+	if poller == nil {
+		return
+	}
+	poller.Stop()
+	if !b.ReadOnly {
+		err = b.MQTT.Unsubscribe(b.Topic + "/+/set")
+	}
+	if e := b.publish(b.Topic+"/status", true, []byte(StatusOffline)); err == nil {
+		err = e
+	}
+	return
+}
+
+// report publishes the changed values of a poll, or the offline status if
+// it failed.
+func (b *Bridge) report(values map[string]modbus.TagValue, err error) {
+	b.mu.Lock()
+	changed := b.online != (err == nil)
+	b.online = err == nil
+	b.mu.Unlock()
+
+	if err != nil {
+		b.logf("mqttmodbus: poll of '%v' failed: %v", b.Topic, err)
+		b.publish(b.Topic+"/status", true, []byte(StatusOffline))
+		return
+	}
+	if changed {
+		b.publish(b.Topic+"/status", true, []byte(StatusOnline))
+	}
+	for name, value := range values {
+		payload := Value{Unit: value.Unit, Quality: value.Quality.String()}
+		if !math.IsNaN(value.Value) && !math.IsInf(value.Value, 0) {
+			payload.Value = &value.Value
+		}
+		data, err := json.Marshal(&payload)
+		if err != nil {
+			b.logf("mqttmodbus: tag '%v': %v", name, err)
+			continue
+		}
+		b.publish(b.Topic+"/"+name, b.Retain, data)
+	}
+}
+
+// write writes the value published to topic/<tag>/set to the tag.
+func (b *Bridge) write(topic string, payload []byte) {
+	name := strings.TrimSuffix(strings.TrimPrefix(topic, b.Topic+"/"), "/set")
+	value, err := ParseValue(payload)
+	if err == nil {
+		err = b.Device.WriteTag(name, value)
+	}
+	if err != nil {
+		b.logf("mqttmodbus: write of tag '%v' failed: %v", name, err)
+	}
+}
+
+func (b *Bridge) publish(topic string, retained bool, payload []byte) (err error) {
+	if err = b.MQTT.Publish(topic, retained, payload); err != nil {
+		b.logf("mqttmodbus: publish to '%v' failed: %v", topic, err)
+	}
+	return
+}
+
+func (b *Bridge) logf(format string, v ...interface{}) {
+	if b.Logger != nil {
+		b.Logger.Printf(format, v...)
+	}
+}
+
+// ParseValue parses the payload of a write, a number, true, false, on or
+// off, or a JSON Value.
+func ParseValue(payload []byte) (value float64, err error) {
+	s := strings.TrimSpace(string(payload))
+	switch strings.ToLower(s) {
+	case "true", "on":
+		return 1, nil
+	case "false", "off":
+		return 0, nil
+	}
+	if strings.HasPrefix(s, "{") {
+		var v Value
+		if err = json.Unmarshal([]byte(s), &v); err != nil {
+			return
+		}
+		if v.Value == nil {
+			err = fmt.Errorf("mqttmodbus: value of '%v' must not be null", s)
+			return
+		}
+		return *v.Value, nil
+	}
+	if value, err = strconv.ParseFloat(s, 64); err != nil {
+		err = fmt.Errorf("mqttmodbus: invalid value '%v'", s)
+	}
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package mqttmodbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/modbus/modbustest"
+)
+
+type message struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+// testClient records the messages published and the subscriptions.
+type testClient struct {
+	mu            sync.Mutex
+	published     chan message
+	subscriptions map[string]func(topic string, payload []byte)
+}
+
+func (c *testClient) Publish(topic string, retained bool, payload []byte) error {
+	c.published <- message{topic, retained, string(payload)}
+	return nil
+}
+
+func (c *testClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[topic] = handler
+	return nil
+}
+
+func (c *testClient) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subscriptions, topic)
+	return nil
+}
+
+func (c *testClient) expect(t *testing.T, expected ...message) {
+	t.Helper()
+	received := make(map[message]bool)
+	for range expected {
+		select {
+		case m := <-c.published:
+			received[m] = true
+		case <-time.After(time.Second):
+			t.Fatalf("messages expected %v, received %v", expected, received)
+		}
+	}
+	for _, m := range expected {
+		if !received[m] {
+			t.Fatalf("messages expected %v, received %v", expected, received)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	simulator := modbustest.NewDevice()
+	simulator.SetHoldingRegisters(0, 125)
+	device, err := modbus.NewDevice(modbus.NewClient(modbustest.NewClientHandler(simulator)), &modbus.RegisterMap{
+		Tags: []modbus.TagDef{
+			{Name: "level", Table: modbus.TableHoldingRegisters, Address: 0, Scale: 0.1, Unit: "m"},
+			{Name: "pump", Table: modbus.TableCoils, Address: 3},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{
+		published:     make(chan message, 100),
+		subscriptions: make(map[string]func(topic string, payload []byte)),
+	}
+	bridge := NewBridge(device, client, "plant/pump1")
+	bridge.Interval = 10 * time.Millisecond
+	if err = bridge.Start(); err != nil {
+		t.Fatal(err)
+	}
+	client.expect(t,
+		message{"plant/pump1/status", true, "online"},
+		message{"plant/pump1/level", false, `{"value":12.5,"unit":"m","quality":"good"}`},
+		message{"plant/pump1/pump", false, `{"value":0,"quality":"good"}`},
+	)
+	client.mu.Lock()
+	write := client.subscriptions["plant/pump1/+/set"]
+	client.mu.Unlock()
+	write("plant/pump1/pump/set", []byte("on"))
+	if !simulator.Coils(3, 1)[0] {
+		t.Fatal("pump is not written")
+	}
+	client.expect(t, message{"plant/pump1/pump", false, `{"value":1,"quality":"good"}`})
+
+	if err = bridge.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	client.expect(t, message{"plant/pump1/status", true, "offline"})
+	if len(client.subscriptions) != 0 {
+		t.Fatalf("unexpected subscriptions %v", client.subscriptions)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		payload string
+		value   float64
+		valid   bool
+	}{
+		{"12.5", 12.5, true},
+		{" -3 ", -3, true},
+		{"ON", 1, true},
+		{"false", 0, true},
+		{`{"value": 7}`, 7, true},
+		{`{"value": null}`, 0, false},
+		{"open", 0, false},
+	}
+	for _, test := range tests {
+		value, err := ParseValue([]byte(test.payload))
+		if (err == nil) != test.valid || value != test.value {
+			t.Errorf("%q: unexpected value %v, error %v", test.payload, value, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package otelmodbus provides OpenTelemetry tracing for modbus clients.
+
+Each Modbus transaction is recorded as a client span carrying the slave id,
+function code, address range and outcome:
+
+	handler := modbus.NewTCPClientHandler("localhost:502")
+	client := otelmodbus.NewClient(modbus.NewClient(handler), handler.SlaveId)
+	results, err := client.WithContext(ctx).ReadHoldingRegisters(1, 2)
+*/
+package otelmodbus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goburrow/modbus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/goburrow/modbus/otelmodbus"
+
+// Attribute keys of the transaction spans.
+const (
+	SlaveIdKey       = attribute.Key("modbus.slave_id")
+	FunctionCodeKey  = attribute.Key("modbus.function_code")
+	AddressKey       = attribute.Key("modbus.address")
+	QuantityKey      = attribute.Key("modbus.quantity")
+	ExceptionCodeKey = attribute.Key("modbus.exception_code")
+)
+
+// Client wraps a modbus.Client and creates a span for every transaction.
+type Client struct {
+	modbus.Client
+
+	// SlaveId is recorded in the span attributes.
+	SlaveId byte
+	// Tracer creates spans, defaults to the global tracer provider.
+	Tracer trace.Tracer
+
+	ctx context.Context
+}
+
+// NewClient creates a new traced client using the global tracer provider.
+func NewClient(client modbus.Client, slaveId byte) *Client {
+	return &Client{
+		Client:  client,
+		SlaveId: slaveId,
+		Tracer:  otel.Tracer(instrumentationName),
+		ctx:     context.Background(),
+	}
+}
+
+// WithContext returns a shallow copy of the client whose spans are
+// children of the span in ctx.
+func (mb *Client) WithContext(ctx context.Context) *Client {
+	c := *mb
+	c.ctx = ctx
+	return &c
+}
+
+// ReadCoils traces modbus.Client.ReadCoils.
+func (mb *Client) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	span := mb.start("ReadCoils", modbus.FuncCodeReadCoils, address, quantity)
+	results, err = mb.Client.ReadCoils(address, quantity)
+	end(span, err)
+	return
+}
+
+// ReadDiscreteInputs traces modbus.Client.ReadDiscreteInputs.
+func (mb *Client) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	span := mb.start("ReadDiscreteInputs", modbus.FuncCodeReadDiscreteInputs, address, quantity)
+	results, err = mb.Client.ReadDiscreteInputs(address, quantity)
+	end(span, err)
+	return
+}
+
+// WriteSingleCoil traces modbus.Client.WriteSingleCoil.
+func (mb *Client) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	span := mb.start("WriteSingleCoil", modbus.FuncCodeWriteSingleCoil, address, 1)
+	results, err = mb.Client.WriteSingleCoil(address, value)
+	end(span, err)
+	return
+}
+
+// WriteMultipleCoils traces modbus.Client.WriteMultipleCoils.
+func (mb *Client) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	span := mb.start("WriteMultipleCoils", modbus.FuncCodeWriteMultipleCoils, address, quantity)
+	results, err = mb.Client.WriteMultipleCoils(address, quantity, value)
+	end(span, err)
+	return
+}
+
+// ReadInputRegisters traces modbus.Client.ReadInputRegisters.
+func (mb *Client) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	span := mb.start("ReadInputRegisters", modbus.FuncCodeReadInputRegisters, address, quantity)
+	results, err = mb.Client.ReadInputRegisters(address, quantity)
+	end(span, err)
+	return
+}
+
+// ReadHoldingRegisters traces modbus.Client.ReadHoldingRegisters.
+func (mb *Client) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	span := mb.start("ReadHoldingRegisters", modbus.FuncCodeReadHoldingRegisters, address, quantity)
+	results, err = mb.Client.ReadHoldingRegisters(address, quantity)
+	end(span, err)
+	return
+}
+
+// WriteSingleRegister traces modbus.Client.WriteSingleRegister.
+func (mb *Client) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	span := mb.start("WriteSingleRegister", modbus.FuncCodeWriteSingleRegister, address, 1)
+	results, err = mb.Client.WriteSingleRegister(address, value)
+	end(span, err)
+	return
+}
+
+// WriteMultipleRegisters traces modbus.Client.WriteMultipleRegisters.
+func (mb *Client) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	span := mb.start("WriteMultipleRegisters", modbus.FuncCodeWriteMultipleRegisters, address, quantity)
+	results, err = mb.Client.WriteMultipleRegisters(address, quantity, value)
+	end(span, err)
+	return
+}
+
+// ReadWriteMultipleRegisters traces modbus.Client.ReadWriteMultipleRegisters.
+// The read range is recorded in the span attributes.
+func (mb *Client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	span := mb.start("ReadWriteMultipleRegisters", modbus.FuncCodeReadWriteMultipleRegisters, readAddress, readQuantity)
+	span.SetAttributes(
+		attribute.Int("modbus.write_address", int(writeAddress)),
+		attribute.Int("modbus.write_quantity", int(writeQuantity)))
+	results, err = mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	end(span, err)
+	return
+}
+
+// MaskWriteRegister traces modbus.Client.MaskWriteRegister.
+func (mb *Client) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	span := mb.start("MaskWriteRegister", modbus.FuncCodeMaskWriteRegister, address, 1)
+	results, err = mb.Client.MaskWriteRegister(address, andMask, orMask)
+	end(span, err)
+	return
+}
+
+// ReadFIFOQueue traces modbus.Client.ReadFIFOQueue.
+func (mb *Client) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	span := mb.start("ReadFIFOQueue", modbus.FuncCodeReadFIFOQueue, address, 1)
+	results, err = mb.Client.ReadFIFOQueue(address)
+	end(span, err)
+	return
+}
+
+func (mb *Client) start(name string, functionCode byte, address, quantity uint16) trace.Span {
+	ctx := mb.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := mb.Tracer.Start(ctx, "modbus."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			SlaveIdKey.Int(int(mb.SlaveId)),
+			FunctionCodeKey.Int(int(functionCode)),
+			AddressKey.Int(int(address)),
+			QuantityKey.Int(int(quantity)),
+		))
+	return span
+}
+
+// end records the outcome of the transaction and ends the span.
+func end(span trace.Span, err error) {
+	if err != nil {
+		var mbError *modbus.ModbusError
+		if errors.As(err, &mbError) {
+			span.SetAttributes(ExceptionCodeKey.Int(int(mbError.ExceptionCode)))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
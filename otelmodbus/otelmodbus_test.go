@@ -0,0 +1,101 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package otelmodbus
+
+import (
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type exceptionClient struct {
+	modbus.Client
+}
+
+func (c *exceptionClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return nil, &modbus.ModbusError{
+		FunctionCode:  0x83,
+		ExceptionCode: modbus.ExceptionCodeIllegalDataAddress,
+	}
+}
+
+func TestClientSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client := NewClient(&exceptionClient{}, 17)
+	client.Tracer = provider.Tracer(instrumentationName)
+	if _, err := client.ReadHoldingRegisters(100, 2); err == nil {
+		t.Fatal("error expected")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans expected %v, actual %v", 1, len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "modbus.ReadHoldingRegisters" {
+		t.Fatalf("unexpected span name: %v", span.Name())
+	}
+	if span.Status().Code != codes.Error {
+		t.Fatalf("unexpected span status: %v", span.Status())
+	}
+	expected := map[string]int64{
+		string(SlaveIdKey):       17,
+		string(FunctionCodeKey):  modbus.FuncCodeReadHoldingRegisters,
+		string(AddressKey):       100,
+		string(QuantityKey):      2,
+		string(ExceptionCodeKey): modbus.ExceptionCodeIllegalDataAddress,
+	}
+	for _, kv := range span.Attributes() {
+		if v, ok := expected[string(kv.Key)]; ok {
+			if kv.Value.AsInt64() != v {
+				t.Fatalf("attribute %v expected %v, actual %v", kv.Key, v, kv.Value.AsInt64())
+			}
+			delete(expected, string(kv.Key))
+		}
+	}
+	if len(expected) != 0 {
+		t.Fatalf("missing attributes: %v", expected)
+	}
+}
+
+type gatewayClient struct {
+	modbus.Client
+}
+
+func (c *gatewayClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return nil, &modbus.GatewayError{ModbusError: modbus.ModbusError{
+		FunctionCode:  0x83,
+		ExceptionCode: modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond,
+	}}
+}
+
+func TestClientSpanGatewayException(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client := NewClient(&gatewayClient{}, 17)
+	client.Tracer = provider.Tracer(instrumentationName)
+	if _, err := client.ReadHoldingRegisters(100, 2); err == nil {
+		t.Fatal("error expected")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans expected %v, actual %v", 1, len(spans))
+	}
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == ExceptionCodeKey {
+			if kv.Value.AsInt64() != modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond {
+				t.Fatalf("attribute %v expected %v, actual %v", kv.Key,
+					modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond, kv.Value.AsInt64())
+			}
+			return
+		}
+	}
+	t.Fatalf("missing attribute: %v", ExceptionCodeKey)
+}
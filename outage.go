@@ -0,0 +1,204 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// OutageStats are the statistics of an OutageClient.
+type OutageStats struct {
+	// Down is true during an outage, which started at Since.
+	Down  bool
+	Since time.Time
+	// Failures is the number of failed requests of the current outage.
+	Failures int
+	// Outages is the number of outages so far and Downtime their total
+	// duration, excluding the current outage.
+	Outages  int
+	Downtime time.Duration
+}
+
+// OutageClient wraps a Client and aggregates consecutive failed requests
+// into one outage, published as EventDeviceDown when it starts and
+// EventDeviceUp when the device responds again, instead of reporting every
+// failed request. Exception responses are not failures since the device
+// is responding.
+type OutageClient struct {
+	Client
+
+	// Source is set in the events published.
+	Source string
+	// Threshold is the number of consecutive failures starting an outage,
+	// 1 if zero.
+	Threshold int
+	// Events receives outage events, if set.
+	Events *EventBus
+	// Logger receives a line per outage start and end.
+	Logger *log.Logger
+	// Clock times the outages, it defaults to the system time if nil.
+	Clock Clock
+
+	mu          sync.Mutex
+	consecutive int
+	firstFail   time.Time
+	stats       OutageStats
+}
+
+// NewOutageClient allocates a new OutageClient wrapping client.
+func NewOutageClient(client Client, events *EventBus) *OutageClient {
+	return &OutageClient{Client: client, Events: events}
+}
+
+// Stats returns the current outage statistics.
+func (mb *OutageClient) Stats() OutageStats {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.stats
+}
+
+// observe records the result of a request and publishes outage events.
+func (mb *OutageClient) observe(results []byte, err error) ([]byte, error) {
+	now := clockOrSystem(mb.Clock).Now()
+	var mbError *ModbusError
+	if err == nil || errors.As(err, &mbError) {
+		mb.succeeded(now)
+	} else {
+		mb.failed(now, err)
+	}
+	return results, err
+}
+
+func (mb *OutageClient) succeeded(now time.Time) {
+	mb.mu.Lock()
+	mb.consecutive = 0
+	if !mb.stats.Down {
+		mb.mu.Unlock()
+		return
+	}
+	event := Event{
+		Type:     EventDeviceUp,
+		Time:     now,
+		Source:   mb.Source,
+		Since:    mb.stats.Since,
+		Failures: mb.stats.Failures,
+	}
+	mb.stats.Down = false
+	mb.stats.Outages++
+	mb.stats.Downtime += now.Sub(mb.stats.Since)
+	mb.stats.Failures = 0
+	mb.mu.Unlock()
+
+	mb.logf("modbus: %sdevice up after %v, %v failed requests", mb.prefix(), now.Sub(event.Since), event.Failures)
+	mb.Events.Publish(event)
+}
+
+func (mb *OutageClient) failed(now time.Time, err error) {
+	mb.mu.Lock()
+	if mb.consecutive == 0 {
+		mb.firstFail = now
+	}
+	mb.consecutive++
+	if mb.stats.Down {
+		mb.stats.Failures++
+		mb.mu.Unlock()
+		return
+	}
+	threshold := mb.Threshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if mb.consecutive < threshold {
+		mb.mu.Unlock()
+		return
+	}
+	mb.stats.Down = true
+	mb.stats.Since = mb.firstFail
+	mb.stats.Failures = mb.consecutive
+	event := Event{
+		Type:     EventDeviceDown,
+		Time:     now,
+		Source:   mb.Source,
+		Since:    mb.firstFail,
+		Failures: mb.consecutive,
+		Err:      err,
+	}
+	mb.mu.Unlock()
+
+	mb.logf("modbus: %sdevice down since %v: %v", mb.prefix(), event.Since.Format(time.RFC3339), err)
+	mb.Events.Publish(event)
+}
+
+func (mb *OutageClient) prefix() string {
+	if mb.Source == "" {
+		return ""
+	}
+	return mb.Source + ": "
+}
+
+func (mb *OutageClient) logf(format string, v ...interface{}) {
+	if mb.Logger != nil {
+		mb.Logger.Printf(format, v...)
+	}
+}
+
+// ReadCoils reads coils and observes the result.
+func (mb *OutageClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadCoils(address, quantity))
+}
+
+// ReadDiscreteInputs reads discrete inputs and observes the result.
+func (mb *OutageClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadDiscreteInputs(address, quantity))
+}
+
+// WriteSingleCoil writes a coil and observes the result.
+func (mb *OutageClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.WriteSingleCoil(address, value))
+}
+
+// WriteMultipleCoils writes coils and observes the result.
+func (mb *OutageClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.observe(mb.Client.WriteMultipleCoils(address, quantity, value))
+}
+
+// ReadInputRegisters reads input registers and observes the result.
+func (mb *OutageClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadInputRegisters(address, quantity))
+}
+
+// ReadHoldingRegisters reads holding registers and observes the result.
+func (mb *OutageClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadHoldingRegisters(address, quantity))
+}
+
+// WriteSingleRegister writes a register and observes the result.
+func (mb *OutageClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.WriteSingleRegister(address, value))
+}
+
+// WriteMultipleRegisters writes registers and observes the result.
+func (mb *OutageClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.observe(mb.Client.WriteMultipleRegisters(address, quantity, value))
+}
+
+// ReadWriteMultipleRegisters reads and writes registers and observes the
+// result.
+func (mb *OutageClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value))
+}
+
+// MaskWriteRegister modifies a register and observes the result.
+func (mb *OutageClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.MaskWriteRegister(address, andMask, orMask))
+}
+
+// ReadFIFOQueue reads a FIFO queue and observes the result.
+func (mb *OutageClient) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	return mb.observe(mb.Client.ReadFIFOQueue(address))
+}
@@ -0,0 +1,65 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingClient fails read holding registers requests with err.
+type failingClient struct {
+	Client
+	err error
+}
+
+func (c *failingClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return make([]byte, 2*quantity), nil
+}
+
+func TestOutageClient(t *testing.T) {
+	var events EventBus
+	var received []Event
+	unsubscribe := events.Subscribe(func(e Event) {
+		received = append(received, e)
+	})
+	defer unsubscribe()
+
+	device := &failingClient{}
+	client := NewOutageClient(device, &events)
+	client.Threshold = 2
+
+	device.err = errors.New("timeout")
+	for i := 0; i < 100; i++ {
+		client.ReadHoldingRegisters(0, 1)
+	}
+	if stats := client.Stats(); !stats.Down || stats.Failures != 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// Exceptions mean the device is up, gateway exceptions the gateway.
+	device.err = &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataAddress}
+	client.ReadHoldingRegisters(0, 1)
+	device.err = responseError(&ProtocolDataUnit{FunctionCode: 0x83, Data: []byte{ExceptionCodeGatewayTargetDeviceFailedToRespond}})
+	client.ReadHoldingRegisters(0, 1)
+	client.ReadHoldingRegisters(0, 1)
+	device.err = nil
+	client.ReadHoldingRegisters(0, 1)
+
+	if len(received) != 2 {
+		t.Fatalf("unexpected events %+v", received)
+	}
+	if received[0].Type != EventDeviceDown || received[0].Failures != 2 || received[0].Err == nil {
+		t.Fatalf("unexpected down event %+v", received[0])
+	}
+	if received[1].Type != EventDeviceUp || received[1].Failures != 100 || received[1].Since != received[0].Since {
+		t.Fatalf("unexpected up event %+v", received[1])
+	}
+	if stats := client.Stats(); stats.Down || stats.Outages != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
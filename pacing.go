@@ -0,0 +1,71 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"time"
+)
+
+// Pacing spaces the requests sent by a transporter, for slow slaves and
+// radio links which need quiet time between transactions:
+//  handler := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  handler.MinDelayBetweenRequests = 50 * time.Millisecond
+// Requests waiting for their turn hold the transporter.
+type Pacing struct {
+	// MinDelayBetweenRequests is the minimum time between the end of a
+	// transaction and the next request.
+	MinDelayBetweenRequests time.Duration
+	// RateLimit is the maximum average number of requests per second,
+	// zero means no limit. Up to RateBurst requests may be sent without
+	// waiting after the transporter has been idle.
+	RateLimit float64
+	RateBurst int
+
+	// lastEnd is the end of the last transaction.
+	lastEnd time.Time
+	// tokens available at lastFill.
+	tokens   float64
+	lastFill time.Time
+}
+
+// pace waits until the next request can be sent. Caller must hold the
+// mutex of the transporter.
+func (p *Pacing) pace(clock Clock) {
+	if p.MinDelayBetweenRequests > 0 && !p.lastEnd.IsZero() {
+		if wait := p.lastEnd.Add(p.MinDelayBetweenRequests).Sub(clock.Now()); wait > 0 {
+			clock.Sleep(wait)
+		}
+	}
+	if p.RateLimit <= 0 {
+		return
+	}
+	burst := float64(p.RateBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	t := clock.Now()
+	if p.lastFill.IsZero() {
+		p.tokens = burst
+	} else {
+		p.tokens += t.Sub(p.lastFill).Seconds() * p.RateLimit
+		if p.tokens > burst {
+			p.tokens = burst
+		}
+	}
+	p.lastFill = t
+	if p.tokens < 1 {
+		wait := time.Duration((1 - p.tokens) / p.RateLimit * float64(time.Second))
+		clock.Sleep(wait)
+		p.tokens = 1
+		p.lastFill = t.Add(wait)
+	}
+	p.tokens--
+}
+
+// paced records the end of a transaction. Caller must hold the mutex of
+// the transporter.
+func (p *Pacing) paced(clock Clock) {
+	p.lastEnd = clock.Now()
+}
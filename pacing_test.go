@@ -0,0 +1,53 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacingMinDelay(t *testing.T) {
+	line := newSimLine(19200, rtuSlave(0))
+	handler := newSimRTUClientHandler(line)
+	handler.MinDelayBetweenRequests = 50 * time.Millisecond
+	client := NewClient(handler)
+
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	end := line.clock.Now()
+	if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if silence := line.writes[1].Sub(end); silence != 50*time.Millisecond {
+		t.Fatalf("unexpected silence %v", silence)
+	}
+}
+
+func TestPacingRateLimit(t *testing.T) {
+	line := newSimLine(19200, rtuSlave(0))
+	handler := newSimRTUClientHandler(line)
+	handler.RateLimit = 10
+	handler.RateBurst = 2
+	client := NewClient(handler)
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := line.writes[1].Sub(line.writes[0]); d >= 100*time.Millisecond {
+		t.Fatalf("burst request delayed by %v", d)
+	}
+	if d := line.writes[2].Sub(line.writes[0]); d != 100*time.Millisecond {
+		t.Fatalf("unexpected delay %v", d)
+	}
+	if d := line.writes[3].Sub(line.writes[2]); d != 100*time.Millisecond {
+		t.Fatalf("unexpected delay %v", d)
+	}
+}
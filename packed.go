@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DecodeBCD decodes registers holding a binary-coded decimal, two digits
+// per byte with the most significant first, e.g. 0x12 0x34 is 1234.
+func DecodeBCD(data []byte) (value uint64, err error) {
+	if len(data) > 10 {
+		err = fmt.Errorf("modbus: BCD of '%v' bytes overflows uint64", len(data))
+		return
+	}
+	for _, b := range data {
+		high, low := b>>4, b&0xF
+		if high > 9 || low > 9 {
+			err = fmt.Errorf("modbus: invalid BCD byte '%#x'", b)
+			return
+		}
+		value = value*100 + uint64(high)*10 + uint64(low)
+	}
+	return
+}
+
+// EncodeBCD encodes value as a binary-coded decimal in the quantity of
+// registers.
+func EncodeBCD(value uint64, quantity uint16) (data []byte, err error) {
+	data = make([]byte, 2*int(quantity))
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i] = byte(value%10) | byte(value/10%10)<<4
+		value /= 100
+	}
+	if value != 0 {
+		data = nil
+		err = fmt.Errorf("modbus: value does not fit in '%v' BCD registers", quantity)
+	}
+	return
+}
+
+// StringFormat is the layout of strings packed in registers, two bytes of
+// ASCII or UTF-8 per register.
+type StringFormat struct {
+	// ByteSwap stores the first byte of each register in its low byte,
+	// as some drives do.
+	ByteSwap bool
+	// Padding fills registers after the string, 0 by default or ' '.
+	Padding byte
+}
+
+// DecodeString decodes a string packed in registers, up to the first null
+// byte and without trailing padding.
+func (f StringFormat) DecodeString(data []byte) string {
+	b := make([]byte, len(data))
+	copy(b, data)
+	if f.ByteSwap {
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	if f.Padding != 0 {
+		b = bytes.TrimRight(b, string(f.Padding))
+	}
+	return string(b)
+}
+
+// EncodeString packs s in the quantity of registers, padding the registers
+// left.
+func (f StringFormat) EncodeString(s string, quantity uint16) (data []byte, err error) {
+	if len(s) > 2*int(quantity) {
+		err = fmt.Errorf("modbus: string of '%v' bytes does not fit in '%v' registers", len(s), quantity)
+		return
+	}
+	if !utf8.ValidString(s) {
+		err = fmt.Errorf("modbus: string %q is not valid UTF-8", s)
+		return
+	}
+	data = make([]byte, 2*int(quantity))
+	n := copy(data, s)
+	for i := n; i < len(data); i++ {
+		data[i] = f.Padding
+	}
+	if f.ByteSwap {
+		for i := 0; i < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+	return
+}
+
+// ReadString reads a string packed in the quantity of holding registers
+// starting at address:
+//  model, err := modbus.ReadString(client, 0x100, 8, modbus.StringFormat{Padding: ' '})
+func ReadString(client RegisterReader, address, quantity uint16, format StringFormat) (s string, err error) {
+	results, err := client.ReadHoldingRegisters(address, quantity)
+	if err != nil {
+		return
+	}
+	s = format.DecodeString(results)
+	return
+}
+
+// WriteString writes a string packed in the quantity of holding registers
+// starting at address.
+func WriteString(client RegisterWriter, address, quantity uint16, format StringFormat, s string) (err error) {
+	data, err := format.EncodeString(s, quantity)
+	if err != nil {
+		return
+	}
+	_, err = client.WriteMultipleRegisters(address, quantity, data)
+	return
+}
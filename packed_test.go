@@ -0,0 +1,74 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBCD(t *testing.T) {
+	value, err := DecodeBCD([]byte{0x00, 0x12, 0x34, 0x56})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 123456 {
+		t.Fatalf("unexpected value %v", value)
+	}
+	data, err := EncodeBCD(123456, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x00, 0x12, 0x34, 0x56}, data) {
+		t.Fatalf("unexpected data %x", data)
+	}
+	if _, err = DecodeBCD([]byte{0x1A}); err == nil || err.Error() != "modbus: invalid BCD byte '0x1a'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = EncodeBCD(12345, 1); err == nil {
+		t.Fatal("expected error for value too large")
+	}
+}
+
+func TestStringFormat(t *testing.T) {
+	tests := []struct {
+		format StringFormat
+		s      string
+		data   []byte
+	}{
+		{StringFormat{}, "ABC", []byte{'A', 'B', 'C', 0, 0, 0}},
+		{StringFormat{Padding: ' '}, "ABC", []byte{'A', 'B', 'C', ' ', ' ', ' '}},
+		{StringFormat{ByteSwap: true}, "ABC", []byte{'B', 'A', 0, 'C', 0, 0}},
+		{StringFormat{ByteSwap: true, Padding: ' '}, "é", []byte{0xA9, 0xC3, ' ', ' ', ' ', ' '}},
+	}
+	for _, test := range tests {
+		data, err := test.format.EncodeString(test.s, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(test.data, data) {
+			t.Errorf("%+v: expected %q, actual %q", test.format, test.data, data)
+		}
+		if s := test.format.DecodeString(data); s != test.s {
+			t.Errorf("%+v: expected %q, actual %q", test.format, test.s, s)
+		}
+	}
+	if _, err := (StringFormat{}).EncodeString("ABCDEFG", 3); err == nil {
+		t.Fatal("expected error for string too long")
+	}
+
+	client := &memoryClient{}
+	format := StringFormat{Padding: ' '}
+	if err := WriteString(client, 10, 4, format, "X100"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadString(client, 10, 4, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "X100" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Paging describes devices exposing more than 65536 registers through a
+// window of addresses, whose content is selected by a page register.
+type Paging struct {
+	// Register is the address of the holding register selecting the page.
+	Register uint16 `json:"register" yaml:"register"`
+	// WindowAddress and WindowSize are the range of addresses mapped to
+	// the selected page.
+	WindowAddress uint16 `json:"window_address" yaml:"window_address"`
+	WindowSize    uint16 `json:"window_size" yaml:"window_size"`
+}
+
+// InWindow returns true if the range is inside the window.
+func (p *Paging) InWindow(address, quantity uint16) bool {
+	return address >= p.WindowAddress &&
+		int(address)+int(quantity) <= int(p.WindowAddress)+int(p.WindowSize)
+}
+
+// overlaps returns true if the range includes addresses of the window.
+func (p *Paging) overlaps(address, quantity uint16) bool {
+	return int(address) < int(p.WindowAddress)+int(p.WindowSize) &&
+		int(address)+int(quantity) > int(p.WindowAddress)
+}
+
+// PagedClient wraps a Client and selects the page before accessing the
+// window of a paged device:
+//  client := modbus.NewPagedClient(client, modbus.Paging{Register: 0, WindowAddress: 1000, WindowSize: 1000})
+//  err := client.Do(12, func(c modbus.Client) (err error) {
+//  	results, err = c.ReadHoldingRegisters(1000, 10)
+//  	return
+//  })
+// Selecting the page and accessing the window is atomic for users of the
+// PagedClient, so all accesses to the window must go through it. Methods
+// of the embedded Client are not paged.
+type PagedClient struct {
+	Client
+	Paging Paging
+	// AlwaysSelect writes the page register before each access, for
+	// devices whose page may be changed by other masters.
+	AlwaysSelect bool
+
+	mu sync.Mutex
+	// selected is the page selected, -1 if unknown.
+	selected int
+}
+
+// NewPagedClient creates a new PagedClient wrapping the client.
+func NewPagedClient(client Client, paging Paging) *PagedClient {
+	return &PagedClient{Client: client, Paging: paging, selected: -1}
+}
+
+// Do selects the page and calls fn, other users of the PagedClient wait
+// until fn returns.
+func (mb *PagedClient) Do(page uint16, fn func(client Client) error) (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.AlwaysSelect || mb.selected != int(page) {
+		mb.selected = -1
+		if _, err = mb.Client.WriteSingleRegister(mb.Paging.Register, page); err != nil {
+			err = fmt.Errorf("modbus: failed to select page '%v': %v", page, err)
+			return
+		}
+		mb.selected = int(page)
+	}
+	return fn(mb.Client)
+}
+
+// Reset forgets the page selected, so that it is written before the next
+// access.
+func (mb *PagedClient) Reset() {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.selected = -1
+}
@@ -0,0 +1,206 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pagedDevice maps holding registers 100 to 199 to the page selected by
+// writing holding register 0, register values are page*1000 + address.
+type pagedDevice struct {
+	Client
+	page    uint16
+	selects int
+}
+
+func (d *pagedDevice) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	if address != 0 {
+		return nil, &ModbusError{FunctionCode: 0x86, ExceptionCode: ExceptionCodeIllegalDataAddress}
+	}
+	d.page = value
+	d.selects++
+	return dataBlock(address, value), nil
+}
+
+func (d *pagedDevice) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = address + uint16(i)
+		if values[i] >= 100 && values[i] < 200 {
+			values[i] += d.page * 1000
+		}
+	}
+	return dataBlock(values...), nil
+}
+
+var testPaging = Paging{Register: 0, WindowAddress: 100, WindowSize: 100}
+
+func TestPagedClient(t *testing.T) {
+	device := &pagedDevice{}
+	client := NewPagedClient(device, testPaging)
+
+	read := func(page uint16) (results []byte) {
+		err := client.Do(page, func(c Client) (err error) {
+			results, err = c.ReadHoldingRegisters(100, 1)
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if results := read(2); !reflect.DeepEqual(dataBlock(2100), results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	read(2)
+	if device.selects != 1 {
+		t.Fatalf("page selected %v times", device.selects)
+	}
+	if results := read(3); !reflect.DeepEqual(dataBlock(3100), results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	client.Reset()
+	read(3)
+	client.AlwaysSelect = true
+	read(3)
+	if device.selects != 4 {
+		t.Fatalf("page selected %v times", device.selects)
+	}
+}
+
+func TestReadPlanPaging(t *testing.T) {
+	m := &RegisterMap{
+		Paging: &testPaging,
+		Tags: []TagDef{
+			{Name: "serial", Table: TableHoldingRegisters, Address: 10},
+			{Name: "log0", Table: TableHoldingRegisters, Address: 100, Page: 1},
+			{Name: "log1", Table: TableHoldingRegisters, Address: 100, Page: 2},
+			{Name: "log2", Table: TableHoldingRegisters, Address: 101, Page: 2},
+			{Name: "window", Table: TableHoldingRegisters, Address: 150},
+		},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var planner ReadPlanner
+	planner.MaxGap = 10
+	plan, err := planner.Plan(m.PlanTags())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Blocks) != 4 {
+		t.Fatalf("unexpected blocks %+v", plan.Blocks)
+	}
+	device := &pagedDevice{}
+	values, err := plan.Read(NewPagedClient(device, *m.Paging))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]uint16{
+		"serial": {10},
+		"log0":   {1100},
+		"log1":   {2100},
+		"log2":   {2101},
+		"window": {150},
+	}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf("expected %v, actual %v", expected, values)
+	}
+	if device.selects != 3 {
+		t.Fatalf("page selected %v times", device.selects)
+	}
+
+	if _, err = plan.Read(device); err == nil || !strings.Contains(err.Error(), "requires a paged client") {
+		t.Fatalf("paged client error expected, actual %v", err)
+	}
+}
+
+func TestRegisterMapPagingValidate(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "log0", Table: TableHoldingRegisters, Address: 100, Page: 1},
+	}}
+	if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "tags[0].page") {
+		t.Fatalf("page error expected, actual %v", err)
+	}
+	m.Paging = &Paging{Register: 150, WindowAddress: 100, WindowSize: 100}
+	m.Tags = append(m.Tags,
+		TagDef{Name: "log1", Table: TableHoldingRegisters, Address: 100, Page: 2},
+		TagDef{Name: "log2", Table: TableHoldingRegisters, Address: 199, Type: "uint32", Page: 2},
+	)
+	err := m.Validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("validation errors expected, actual %v", err)
+	}
+	expected := []string{
+		"modbus: paging.register: page register '150' is in the paging window",
+		"modbus: tags[2].address: address '199' of page '2' is outside of the paging window",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors:\n%v", err)
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], e.Error())
+		}
+	}
+}
+
+func TestReadPlanPagingWindowBounds(t *testing.T) {
+	m := &RegisterMap{
+		Paging: &testPaging,
+		Tags: []TagDef{
+			{Name: "before", Table: TableHoldingRegisters, Address: 98},
+			{Name: "first", Table: TableHoldingRegisters, Address: 100},
+			{Name: "last", Table: TableHoldingRegisters, Address: 199},
+			{Name: "after", Table: TableHoldingRegisters, Address: 201},
+			{Name: "log", Table: TableHoldingRegisters, Address: 150, Page: 2},
+		},
+	}
+	planner := ReadPlanner{MaxGap: 10, Paging: m.Paging}
+	plan, err := planner.Plan(m.PlanTags())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range plan.Blocks {
+		if !m.Paging.InWindow(block.Address, block.Quantity) && m.Paging.overlaps(block.Address, block.Quantity) {
+			t.Fatalf("block %+v crosses the window", block)
+		}
+	}
+	device := &pagedDevice{}
+	client := NewPagedClient(device, *m.Paging)
+	// Page 2 is selected when the page 0 blocks are read.
+	for i := 0; i < 2; i++ {
+		values, err := plan.Read(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string][]uint16{"before": {98}, "first": {100}, "last": {199}, "after": {201}, "log": {2150}}
+		if !reflect.DeepEqual(expected, values) {
+			t.Fatalf("expected %v, actual %v", expected, values)
+		}
+	}
+
+	// Blocks crossing the window are not read without selecting page 0.
+	planner.Paging = nil
+	if plan, err = planner.Plan(m.PlanTags()[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = plan.Read(client); err == nil || !strings.Contains(err.Error(), "crosses the bounds") {
+		t.Fatalf("window bounds error expected, actual %v", err)
+	}
+
+	m.Tags = append(m.Tags, TagDef{Name: "straddling", Table: TableHoldingRegisters, Address: 99, Type: "uint32"})
+	if err = m.Validate(); err == nil || !strings.Contains(err.Error(), "crosses the bounds") {
+		t.Fatalf("window bounds error expected, actual %v", err)
+	}
+	planner.Paging = m.Paging
+	if _, err = planner.Plan(m.PlanTags()); err == nil {
+		t.Fatal("window bounds error expected")
+	}
+}
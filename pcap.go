@@ -0,0 +1,242 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// CaptureFormat is the link layer of the packets written by PcapWriter.
+type CaptureFormat int
+
+// Capture formats.
+const (
+	// CaptureTCP writes Modbus TCP frames in IPv4/TCP packets between
+	// 127.0.0.1:50200 and 127.0.0.1:502, dissected by Wireshark as is.
+	CaptureTCP CaptureFormat = iota
+	// CaptureSerial writes RTU or ASCII frames with the link type USER0
+	// (147), dissected by Wireshark once "mbrtu" is set as the payload
+	// protocol of User 0 in the DLT_USER preferences.
+	CaptureSerial
+)
+
+const (
+	pcapLinkTypeRaw   = 101
+	pcapLinkTypeUser0 = 147
+	pcapSnapLen       = 65535
+	// Block types
+	pcapSectionHeader     = 0x0A0D0D0A
+	pcapInterface         = 1
+	pcapEnhancedPacket    = 6
+	pcapByteOrderMagic    = 0x1A2B3C4D
+	pcapOptionFlags       = 2
+	pcapFlagInbound       = 1
+	pcapFlagOutbound      = 2
+	pcapClientPort        = 50200
+	pcapServerPort        = 502
+	pcapIPv4HeaderSize    = 20
+	pcapTCPHeaderSize     = 20
+	pcapTCPFlagsPushAck   = 0x18
+	pcapIPProtocolTCP     = 6
+	pcapTCPWindowSize     = 65535
+	pcapIPv4TimeToLive    = 64
+	pcapIPv4DontFragment  = 0x4000
+	pcapIPv4VersionHeader = 0x45
+)
+
+// pcapLoopback is the address of both ends of captured TCP connections.
+var pcapLoopback = [4]byte{127, 0, 0, 1}
+
+// PcapWriter writes frames to a pcapng file, which Wireshark can open to
+// analyze the exchanges with its Modbus dissectors.
+type PcapWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format CaptureFormat
+	// seq are the TCP sequence numbers of the client and the server.
+	seq [2]uint32
+	// id is the IPv4 identification of the next packet.
+	id uint16
+}
+
+// NewPcapWriter allocates a new PcapWriter and writes the headers of the
+// capture to w.
+func NewPcapWriter(w io.Writer, format CaptureFormat) (*PcapWriter, error) {
+	var linkType uint16
+	switch format {
+	case CaptureTCP:
+		linkType = pcapLinkTypeRaw
+	case CaptureSerial:
+		linkType = pcapLinkTypeUser0
+	default:
+		return nil, fmt.Errorf("modbus: invalid capture format '%v'", format)
+	}
+	p := &PcapWriter{w: w, format: format, seq: [2]uint32{1, 1}}
+
+	// Section header: byte order magic, version 1.0, unspecified length
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb, pcapByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	if err := p.writeBlock(pcapSectionHeader, shb); err != nil {
+		return nil, err
+	}
+	// Interface description: link type, reserved, snap length
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb, linkType)
+	binary.LittleEndian.PutUint32(idb[4:], pcapSnapLen)
+	if err := p.writeBlock(pcapInterface, idb); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteFrame writes a frame sent to or received from the device at t.
+func (p *PcapWriter) WriteFrame(t time.Time, sent bool, frame []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	packet := frame
+	if p.format == CaptureTCP {
+		packet = p.tcpPacket(sent, frame)
+	}
+	micros := uint64(t.UnixNano() / int64(time.Microsecond))
+	padded := (len(packet) + 3) &^ 3
+	epb := make([]byte, 20+padded+12)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(micros))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(packet)))
+	copy(epb[20:], packet)
+	// Direction option, followed by the end of options
+	options := epb[20+padded:]
+	binary.LittleEndian.PutUint16(options, pcapOptionFlags)
+	binary.LittleEndian.PutUint16(options[2:], 4)
+	if sent {
+		binary.LittleEndian.PutUint32(options[4:], pcapFlagOutbound)
+	} else {
+		binary.LittleEndian.PutUint32(options[4:], pcapFlagInbound)
+	}
+	return p.writeBlock(pcapEnhancedPacket, epb)
+}
+
+// writeBlock writes a block whose body is padded to 32 bits.
+func (p *PcapWriter) writeBlock(blockType uint32, body []byte) error {
+	length := 12 + len(body)
+	block := make([]byte, length)
+	binary.LittleEndian.PutUint32(block, blockType)
+	binary.LittleEndian.PutUint32(block[4:], uint32(length))
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[length-4:], uint32(length))
+	_, err := p.w.Write(block)
+	return err
+}
+
+// tcpPacket returns an IPv4 packet of the TCP segment carrying frame.
+func (p *PcapWriter) tcpPacket(sent bool, frame []byte) []byte {
+	packet := make([]byte, pcapIPv4HeaderSize+pcapTCPHeaderSize+len(frame))
+	ip := packet[:pcapIPv4HeaderSize]
+	ip[0] = pcapIPv4VersionHeader
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(packet)))
+	binary.BigEndian.PutUint16(ip[4:], p.id)
+	p.id++
+	binary.BigEndian.PutUint16(ip[6:], pcapIPv4DontFragment)
+	ip[8] = pcapIPv4TimeToLive
+	ip[9] = pcapIPProtocolTCP
+	copy(ip[12:], pcapLoopback[:])
+	copy(ip[16:], pcapLoopback[:])
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(0, ip))
+
+	tcp := packet[pcapIPv4HeaderSize:]
+	// The client is the sender of requests
+	from, to := 0, 1
+	srcPort, dstPort := uint16(pcapClientPort), uint16(pcapServerPort)
+	if !sent {
+		from, to = 1, 0
+		srcPort, dstPort = dstPort, srcPort
+	}
+	binary.BigEndian.PutUint16(tcp, srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], p.seq[from])
+	binary.BigEndian.PutUint32(tcp[8:], p.seq[to])
+	tcp[12] = pcapTCPHeaderSize / 4 << 4
+	tcp[13] = pcapTCPFlagsPushAck
+	binary.BigEndian.PutUint16(tcp[14:], pcapTCPWindowSize)
+	copy(tcp[pcapTCPHeaderSize:], frame)
+	p.seq[from] += uint32(len(frame))
+
+	// Checksum of the pseudo header and the segment
+	pseudo := make([]byte, 12)
+	copy(pseudo, ip[12:20])
+	pseudo[9] = pcapIPProtocolTCP
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], internetChecksum(internetSum(0, pseudo), tcp))
+	return packet
+}
+
+// internetSum adds the 16-bit words of data to sum.
+func internetSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// internetChecksum returns the checksum of IP and TCP headers, RFC 1071.
+func internetChecksum(sum uint32, data []byte) uint16 {
+	sum = internetSum(sum, data)
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+// CaptureTransporter wraps a Transporter and writes all request and
+// response ADUs to a pcapng file:
+//  handler := modbus.NewTCPClientHandler("localhost:502")
+//  capture, err := modbus.NewCaptureTransporter(handler, file, modbus.CaptureTCP)
+//  client := modbus.NewClient2(handler, capture)
+// Serial handlers are captured with CaptureSerial.
+type CaptureTransporter struct {
+	Transporter Transporter
+	Writer      *PcapWriter
+	// Clock timestamps the frames, it defaults to the system time if nil.
+	Clock Clock
+}
+
+// NewCaptureTransporter allocates a new CaptureTransporter writing the
+// capture to w.
+func NewCaptureTransporter(transporter Transporter, w io.Writer, format CaptureFormat) (*CaptureTransporter, error) {
+	writer, err := NewPcapWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureTransporter{Transporter: transporter, Writer: writer}, nil
+}
+
+// Send sends the request with the underlying transporter and captures the
+// exchange. Capture errors are returned only if sending succeeded.
+func (mb *CaptureTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	clock := clockOrSystem(mb.Clock)
+	sent := clock.Now()
+	aduResponse, err = mb.Transporter.Send(aduRequest)
+	received := clock.Now()
+
+	werr := mb.Writer.WriteFrame(sent, true, aduRequest)
+	if werr == nil && len(aduResponse) > 0 {
+		werr = mb.Writer.WriteFrame(received, false, aduResponse)
+	}
+	if werr != nil && err == nil {
+		err = werr
+	}
+	return
+}
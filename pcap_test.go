@@ -0,0 +1,112 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type pcapBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+func readPcapBlocks(t *testing.T, data []byte) (blocks []pcapBlock) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block % x", data)
+		}
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block length %v", length)
+		}
+		blocks = append(blocks, pcapBlock{binary.LittleEndian.Uint32(data), data[8 : length-4]})
+		data = data[length:]
+	}
+	return
+}
+
+func TestCaptureTCP(t *testing.T) {
+	request := []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}
+	response := []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0, 42}
+	var buf bytes.Buffer
+	clock := &simClock{now: time.Unix(1000, 0)}
+	capture, err := NewCaptureTransporter(transporterFunc(func([]byte) ([]byte, error) {
+		clock.Sleep(time.Millisecond)
+		return response, nil
+	}), &buf, CaptureTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.Clock = clock
+	if _, err = capture.Send(request); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := readPcapBlocks(t, buf.Bytes())
+	if len(blocks) != 4 || blocks[0].blockType != pcapSectionHeader || blocks[1].blockType != pcapInterface ||
+		blocks[2].blockType != pcapEnhancedPacket || blocks[3].blockType != pcapEnhancedPacket {
+		t.Fatalf("unexpected blocks %+v", blocks)
+	}
+	if magic := binary.LittleEndian.Uint32(blocks[0].body); magic != pcapByteOrderMagic {
+		t.Fatalf("unexpected byte order magic %x", magic)
+	}
+	if linkType := binary.LittleEndian.Uint16(blocks[1].body); linkType != pcapLinkTypeRaw {
+		t.Fatalf("unexpected link type %v", linkType)
+	}
+	for i, frame := range [][]byte{request, response} {
+		epb := blocks[2+i].body
+		micros := uint64(binary.LittleEndian.Uint32(epb[4:]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:]))
+		if expected := uint64(1000000000 + 1000*i); micros != expected {
+			t.Fatalf("timestamp expected %v, actual %v", expected, micros)
+		}
+		length := binary.LittleEndian.Uint32(epb[12:])
+		packet := epb[20 : 20+length]
+		if internetChecksum(0, packet[:pcapIPv4HeaderSize]) != 0 {
+			t.Fatalf("invalid IPv4 checksum % x", packet)
+		}
+		tcp := packet[pcapIPv4HeaderSize:]
+		dstPort := binary.BigEndian.Uint16(tcp[2:])
+		if (i == 0) != (dstPort == pcapServerPort) {
+			t.Fatalf("unexpected destination port %v", dstPort)
+		}
+		if !bytes.Equal(tcp[pcapTCPHeaderSize:], frame) {
+			t.Fatalf("payload expected % x, actual % x", frame, tcp[pcapTCPHeaderSize:])
+		}
+	}
+	// Acknowledges the request
+	ack := binary.BigEndian.Uint32(blocks[3].body[20+pcapIPv4HeaderSize+8:])
+	if ack != uint32(1+len(request)) {
+		t.Fatalf("unexpected ack %v", ack)
+	}
+}
+
+func TestCaptureSerial(t *testing.T) {
+	var buf bytes.Buffer
+	capture, err := NewCaptureTransporter(transporterFunc(func([]byte) ([]byte, error) {
+		return nil, nil
+	}), &buf, CaptureSerial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := []byte{0, 6, 0, 1, 0, 3, 0x98, 0x1a}
+	if _, err = capture.Send(request); err != nil {
+		t.Fatal(err)
+	}
+	blocks := readPcapBlocks(t, buf.Bytes())
+	if len(blocks) != 3 || binary.LittleEndian.Uint16(blocks[1].body) != pcapLinkTypeUser0 {
+		t.Fatalf("unexpected blocks %+v", blocks)
+	}
+	epb := blocks[2].body
+	if !bytes.Equal(epb[20:28], request) {
+		t.Fatalf("unexpected packet % x", epb[20:28])
+	}
+	if flags := binary.LittleEndian.Uint32(epb[32:]); flags != pcapFlagOutbound {
+		t.Fatalf("unexpected flags %v", flags)
+	}
+}
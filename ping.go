@@ -0,0 +1,92 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Pinger checks that a device responds and measures the latency of a round
+// trip, e.g. for liveness checks and dashboards:
+//  pinger := modbus.NewPinger(client)
+//  latency, err := pinger.Ping()
+// Serial slaves are sent a Return Query Data diagnostic, which they echo.
+// Diagnostics are not supported over TCP, where Probe is sent instead.
+type Pinger struct {
+	Client Client
+	// Probe is the request sent to TCP devices, it reads one holding
+	// register at address 0 if nil.
+	Probe func(client Client) error
+	// Data is echoed by serial slaves.
+	Data uint16
+	// Clock measures the latency, it defaults to the system time if nil.
+	Clock Clock
+}
+
+// NewPinger allocates a new Pinger of client.
+func NewPinger(client Client) *Pinger {
+	return &Pinger{Client: client, Data: 0xA55A}
+}
+
+// Ping returns the latency of a round trip to the device. A device which
+// responds with an exception is up, the error is not returned.
+func Ping(client Client) (latency time.Duration, err error) {
+	return NewPinger(client).Ping()
+}
+
+// Ping returns the latency of a round trip to the device, see Ping.
+func (p *Pinger) Ping() (latency time.Duration, err error) {
+	clock := clockOrSystem(p.Clock)
+	start := clock.Now()
+	if c, ok := p.Client.(*client); ok && !isTCPPackager(c.packager) {
+		err = p.echo(c)
+	} else if p.Probe != nil {
+		err = p.Probe(p.Client)
+	} else {
+		_, err = p.Client.ReadHoldingRegisters(0, 1)
+	}
+	latency = clock.Now().Sub(start)
+	var mbError *ModbusError
+	if errors.As(err, &mbError) {
+		err = nil
+	}
+	if err != nil {
+		latency = 0
+	}
+	return
+}
+
+// echo sends a Return Query Data diagnostic.
+// Request:
+//  Function code         : 1 byte (0x08)
+//  Sub-function          : 2 bytes (0x0000)
+//  Data                  : 2 bytes
+// Response:
+//  Function code         : 1 byte (0x08)
+//  Sub-function          : 2 bytes (0x0000)
+//  Data                  : 2 bytes, echoed
+func (p *Pinger) echo(c *client) (err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeDiagnostics,
+		Data:         dataBlock(DiagnosticReturnQueryData, p.Data),
+	}
+	response, err := c.send(&request)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(response.Data, request.Data) {
+		err = fmt.Errorf("modbus: response data '%v' does not match echoed '%v'", response.Data, request.Data)
+	}
+	return
+}
+
+// isTCPPackager returns true if packager encodes Modbus TCP frames.
+func isTCPPackager(packager Packager) bool {
+	_, ok := packager.(interface{ TransactionId() uint16 })
+	return ok
+}
@@ -0,0 +1,60 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	var requests []*ProtocolDataUnit
+	handler := &pduHandler{serve: func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		requests = append(requests, request)
+		return request
+	}}
+	handler.SlaveId = 1
+	if _, err := Ping(NewClient(handler)); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].FunctionCode != FuncCodeDiagnostics || string(requests[0].Data) != "\x00\x00\xa5\x5a" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+
+	// Exceptions prove that the slave is up
+	handler.serve = func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeIllegalFunction}}
+	}
+	if _, err := Ping(NewClient(handler)); err != nil {
+		t.Fatal(err)
+	}
+	// So do gateway exceptions that the gateway is
+	handler.serve = func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		return &ProtocolDataUnit{request.FunctionCode | 0x80, []byte{ExceptionCodeGatewayTargetDeviceFailedToRespond}}
+	}
+	if _, err := Ping(NewClient(handler)); err != nil {
+		t.Fatal(err)
+	}
+	handler.serve = func(request *ProtocolDataUnit) *ProtocolDataUnit {
+		return &ProtocolDataUnit{request.FunctionCode, []byte{0, 0, 0, 0}}
+	}
+	if _, err := Ping(NewClient(handler)); err == nil {
+		t.Fatal("error expected")
+	}
+
+	// TCP devices are sent the probe
+	tcp := NewClient2(&tcpPackager{SlaveId: 1}, transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		return nil, errors.New("probe expected")
+	}))
+	pinger := NewPinger(tcp)
+	probed := 0
+	pinger.Probe = func(client Client) error {
+		probed++
+		return nil
+	}
+	if latency, err := pinger.Ping(); err != nil || probed != 1 || latency <= 0 {
+		t.Fatalf("unexpected latency %v, error %v, probed %v", latency, err, probed)
+	}
+	pinger.Probe = nil
+	if latency, err := pinger.Ping(); err == nil || latency != 0 {
+		t.Fatalf("unexpected latency %v, error %v", latency, err)
+	}
+}
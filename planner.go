@@ -0,0 +1,339 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Table is a Modbus data table.
+type Table byte
+
+// Data tables.
+const (
+	TableCoils Table = iota + 1
+	TableDiscreteInputs
+	TableHoldingRegisters
+	TableInputRegisters
+)
+
+// String returns the name of the table in messages, e.g. "holding
+// registers". Configuration files use the shorter names of MarshalText
+// and ParseTable.
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscreteInputs:
+		return "discrete inputs"
+	case TableHoldingRegisters:
+		return "holding registers"
+	case TableInputRegisters:
+		return "input registers"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// tableNames are the names of tables in configuration files.
+var tableNames = map[Table]string{
+	TableCoils:            "coils",
+	TableDiscreteInputs:   "discrete",
+	TableHoldingRegisters: "holding",
+	TableInputRegisters:   "input",
+}
+
+// ParseTable returns the table of the name used in configuration files:
+// coils, discrete, holding or input.
+func ParseTable(name string) (Table, error) {
+	for table, tableName := range tableNames {
+		if name == tableName {
+			return table, nil
+		}
+	}
+	return 0, fmt.Errorf("modbus: unknown table '%v'", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Table) MarshalText() ([]byte, error) {
+	name, ok := tableNames[t]
+	if !ok {
+		return nil, fmt.Errorf("modbus: unknown table '%v'", byte(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Table) UnmarshalText(text []byte) (err error) {
+	*t, err = ParseTable(string(text))
+	return
+}
+
+// isBits returns true for tables of single bits.
+func (t Table) isBits() bool {
+	return t == TableCoils || t == TableDiscreteInputs
+}
+
+// Tag is a named range of registers or bits to read.
+type Tag struct {
+	Name    string
+	Table   Table
+	Address uint16
+	// Quantity of registers or bits, 1 if zero.
+	Quantity uint16
+	// Unit is metadata of the value, not used to read it.
+	Unit string
+	// Page of a paged device, selected when reading tags in its window
+	// with a PagedClient. Tags of different pages are read separately.
+	Page uint16
+}
+
+func (t *Tag) quantity() uint16 {
+	if t.Quantity == 0 {
+		return 1
+	}
+	return t.Quantity
+}
+
+// ReadPlanner coalesces tags into as few block reads as possible.
+type ReadPlanner struct {
+	// MaxGap is the maximum number of unused registers or bits read to
+	// merge two tags into one block. Devices may respond with an exception
+	// to reads including unmapped addresses, in which case it should be 0.
+	MaxGap uint16
+	// Maximum quantities of a block read, protocol limits when zero.
+	MaxRegisters uint16
+	MaxBits      uint16
+	// Capabilities limit block sizes to the ones accepted by the device and
+	// reject tags in unsupported tables, if set. See CapabilityProber.
+	Capabilities *Capabilities
+	// Paging splits blocks at the bounds of the paging window, if set, so
+	// that each block is entirely inside or outside of the window.
+	Paging *Paging
+}
+
+// ReadBlock is a block read of a plan and the tags it contains.
+type ReadBlock struct {
+	Table    Table
+	Page     uint16
+	Address  uint16
+	Quantity uint16
+	Tags     []Tag
+}
+
+// ReadPlan is a set of block reads created by ReadPlanner. A plan can be
+// read repeatedly.
+type ReadPlan struct {
+	Blocks []ReadBlock
+}
+
+// Plan creates a plan reading all the tags.
+func (p *ReadPlanner) Plan(tags []Tag) (plan *ReadPlan, err error) {
+	type pageKey struct {
+		table Table
+		page  uint16
+	}
+	byPage := make(map[pageKey][]Tag)
+	var keys []pageKey
+	for _, tag := range tags {
+		if tag.Table < TableCoils || tag.Table > TableInputRegisters {
+			err = fmt.Errorf("modbus: tag '%v' has invalid table '%v'", tag.Name, tag.Table)
+			return
+		}
+		if int(tag.Address)+int(tag.quantity()) > 65536 {
+			err = fmt.Errorf("modbus: tag '%v' address '%v' plus quantity '%v' exceeds '%v'", tag.Name, tag.Address, tag.quantity(), 65536)
+			return
+		}
+		key := pageKey{tag.Table, tag.Page}
+		if _, ok := byPage[key]; !ok {
+			keys = append(keys, key)
+		}
+		byPage[key] = append(byPage[key], tag)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].table != keys[j].table {
+			return keys[i].table < keys[j].table
+		}
+		return keys[i].page < keys[j].page
+	})
+	plan = &ReadPlan{}
+	for _, key := range keys {
+		var max uint16
+		if max, err = p.maxQuantity(key.table); err != nil {
+			return
+		}
+		var blocks []ReadBlock
+		if blocks, err = p.coalesce(byPage[key], max); err != nil {
+			return
+		}
+		plan.Blocks = append(plan.Blocks, blocks...)
+	}
+	return
+}
+
+func (p *ReadPlanner) maxQuantity(table Table) (max uint16, err error) {
+	if table.isBits() {
+		max = limit(p.MaxBits, maxReadBits)
+	} else {
+		max = limit(p.MaxRegisters, maxReadRegisters)
+	}
+	if p.Capabilities == nil {
+		return
+	}
+	var functionCode byte
+	var capability uint16
+	switch table {
+	case TableCoils:
+		functionCode, capability = FuncCodeReadCoils, p.Capabilities.MaxReadCoils
+	case TableDiscreteInputs:
+		functionCode, capability = FuncCodeReadDiscreteInputs, p.Capabilities.MaxReadDiscreteInputs
+	case TableHoldingRegisters:
+		functionCode, capability = FuncCodeReadHoldingRegisters, p.Capabilities.MaxReadHoldingRegisters
+	case TableInputRegisters:
+		functionCode, capability = FuncCodeReadInputRegisters, p.Capabilities.MaxReadInputRegisters
+	}
+	if !p.Capabilities.Supports(functionCode) {
+		err = fmt.Errorf("modbus: %v are not supported by the device", table)
+		return
+	}
+	if capability > 0 && capability < max {
+		max = capability
+	}
+	return
+}
+
+// coalesce merges tags sorted by address into blocks, starting a new block
+// when the next tag is too far from the current one or does not fit in it.
+func (p *ReadPlanner) coalesce(tags []Tag, max uint16) (blocks []ReadBlock, err error) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Address < tags[j].Address
+	})
+	var block *ReadBlock
+	var end int
+	for _, tag := range tags {
+		if tag.quantity() > max {
+			err = fmt.Errorf("modbus: tag '%v' quantity '%v' exceeds maximum '%v'", tag.Name, tag.quantity(), max)
+			return
+		}
+		tagEnd := int(tag.Address) + int(tag.quantity())
+		region := p.windowRegion(int(tag.Address))
+		if region != p.windowRegion(tagEnd-1) {
+			err = fmt.Errorf("modbus: tag '%v' at address '%v' crosses the bounds of the paging window", tag.Name, tag.Address)
+			return
+		}
+		if block != nil && int(tag.Address) <= end+int(p.MaxGap) && region == p.windowRegion(int(block.Address)) {
+			newEnd := end
+			if tagEnd > newEnd {
+				newEnd = tagEnd
+			}
+			if newEnd-int(block.Address) <= int(max) {
+				end = newEnd
+				block.Quantity = uint16(end - int(block.Address))
+				block.Tags = append(block.Tags, tag)
+				continue
+			}
+		}
+		blocks = append(blocks, ReadBlock{
+			Table:    tag.Table,
+			Page:     tag.Page,
+			Address:  tag.Address,
+			Quantity: tag.quantity(),
+			Tags:     []Tag{tag},
+		})
+		block = &blocks[len(blocks)-1]
+		end = tagEnd
+	}
+	return
+}
+
+// windowRegion returns -1, 0 or 1 if address is before, inside or after
+// the paging window, 0 without paging.
+func (p *ReadPlanner) windowRegion(address int) int {
+	switch {
+	case p.Paging == nil:
+		return 0
+	case address < int(p.Paging.WindowAddress):
+		return -1
+	case address >= int(p.Paging.WindowAddress)+int(p.Paging.WindowSize):
+		return 1
+	}
+	return 0
+}
+
+// Read executes the block reads of the plan and returns values of each tag
+// by name: register values, or 0 and 1 for coils and discrete inputs.
+// Blocks in the window of a PagedClient are read after selecting their
+// page, blocks of other pages than 0 require a PagedClient.
+func (plan *ReadPlan) Read(client Client) (values map[string][]uint16, err error) {
+	values = make(map[string][]uint16)
+	for i := range plan.Blocks {
+		if err = plan.Blocks[i].read(client, values); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (b *ReadBlock) read(client Client, values map[string][]uint16) (err error) {
+	paged, ok := client.(*PagedClient)
+	if ok && paged.Paging.InWindow(b.Address, b.Quantity) {
+		return paged.Do(b.Page, func(client Client) error {
+			return b.readPage(client, values)
+		})
+	}
+	if ok && paged.Paging.overlaps(b.Address, b.Quantity) {
+		return fmt.Errorf("modbus: block at address '%v' crosses the bounds of the paging window, see ReadPlanner.Paging", b.Address)
+	}
+	if b.Page != 0 {
+		return fmt.Errorf("modbus: block of page '%v' at address '%v' requires a paged client", b.Page, b.Address)
+	}
+	return b.readPage(client, values)
+}
+
+func (b *ReadBlock) readPage(client Client, values map[string][]uint16) (err error) {
+	var results []byte
+	switch b.Table {
+	case TableCoils:
+		results, err = client.ReadCoils(b.Address, b.Quantity)
+	case TableDiscreteInputs:
+		results, err = client.ReadDiscreteInputs(b.Address, b.Quantity)
+	case TableHoldingRegisters:
+		results, err = client.ReadHoldingRegisters(b.Address, b.Quantity)
+	case TableInputRegisters:
+		results, err = client.ReadInputRegisters(b.Address, b.Quantity)
+	}
+	if err != nil {
+		return
+	}
+	var block []uint16
+	if b.Table.isBits() {
+		var bits []bool
+		if bits, err = UnpackBits(results, b.Quantity); err != nil {
+			return
+		}
+		block = make([]uint16, len(bits))
+		for i, bit := range bits {
+			if bit {
+				block[i] = 1
+			}
+		}
+	} else {
+		if len(results) != 2*int(b.Quantity) {
+			err = fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(results), b.Quantity)
+			return
+		}
+		block = make([]uint16, b.Quantity)
+		for i := range block {
+			block[i] = binary.BigEndian.Uint16(results[2*i:])
+		}
+	}
+	for _, tag := range b.Tags {
+		offset := int(tag.Address - b.Address)
+		values[tag.Name] = block[offset : offset+int(tag.quantity())]
+	}
+	return
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadPlanner(t *testing.T) {
+	tags := []Tag{
+		{Name: "c", Table: TableHoldingRegisters, Address: 105, Quantity: 2},
+		{Name: "a", Table: TableHoldingRegisters, Address: 100},
+		{Name: "b", Table: TableHoldingRegisters, Address: 101, Quantity: 2},
+		{Name: "d", Table: TableHoldingRegisters, Address: 200},
+		{Name: "e", Table: TableCoils, Address: 10},
+		{Name: "f", Table: TableCoils, Address: 15, Quantity: 3},
+	}
+	planner := &ReadPlanner{MaxGap: 5}
+	plan, err := planner.Plan(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][3]int
+	for _, b := range plan.Blocks {
+		blocks = append(blocks, [3]int{int(b.Table), int(b.Address), int(b.Quantity)})
+	}
+	expected := [][3]int{
+		{int(TableCoils), 10, 8},
+		{int(TableHoldingRegisters), 100, 7},
+		{int(TableHoldingRegisters), 200, 1},
+	}
+	if !reflect.DeepEqual(expected, blocks) {
+		t.Fatalf("expected blocks %v, actual %v", expected, blocks)
+	}
+
+	memory := &memoryClient{}
+	for i := range memory.holding {
+		memory.holding[i] = uint16(i)
+	}
+	memory.coils[16] = true
+	values, err := plan.Read(memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memory.requests != 3 {
+		t.Fatalf("unexpected requests %v", memory.requests)
+	}
+	expectedValues := map[string][]uint16{
+		"a": {100}, "b": {101, 102}, "c": {105, 106}, "d": {200},
+		"e": {0}, "f": {0, 1, 0},
+	}
+	if !reflect.DeepEqual(expectedValues, values) {
+		t.Fatalf("expected values %v, actual %v", expectedValues, values)
+	}
+}
+
+func TestReadPlannerLimits(t *testing.T) {
+	tags := []Tag{
+		{Name: "a", Table: TableHoldingRegisters, Address: 0, Quantity: 40},
+		{Name: "b", Table: TableHoldingRegisters, Address: 40, Quantity: 40},
+	}
+	planner := &ReadPlanner{
+		Capabilities: &Capabilities{
+			FunctionCodes:           map[byte]bool{FuncCodeReadHoldingRegisters: true},
+			MaxReadHoldingRegisters: 50,
+		},
+	}
+	plan, err := planner.Plan(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Blocks) != 2 {
+		t.Fatalf("unexpected blocks %+v", plan.Blocks)
+	}
+	tags[1].Quantity = 60
+	if _, err = planner.Plan(tags); err == nil {
+		t.Fatalf("expected quantity error")
+	}
+	tags[1].Table = TableInputRegisters
+	if _, err = planner.Plan(tags[1:]); err == nil {
+		t.Fatalf("expected unsupported error")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// PollGroup is a set of tags read together periodically.
+type PollGroup struct {
+	Name     string
+	Interval time.Duration
+	Tags     []Tag
+	// Handler is called with the values of each poll, see ReadPlan.Read.
+	Handler func(values map[string][]uint16, err error)
+
+	plan *ReadPlan
+	// mu is held while the group is read.
+	mu     sync.Mutex
+	paused bool
+}
+
+// Units returns the units of the tags by name, for the values given to
+// Handler.
+func (g *PollGroup) Units() map[string]string {
+	units := make(map[string]string, len(g.Tags))
+	for _, tag := range g.Tags {
+		units[tag.Name] = tag.Unit
+	}
+	return units
+}
+
+// Poller reads poll groups periodically, each group in its own goroutine:
+//  poller := modbus.NewPoller(client)
+//  err := poller.Add(&modbus.PollGroup{Interval: time.Second, Tags: tags, Handler: handler})
+//  poller.Start()
+//  defer poller.Stop()
+// Groups sharing the same interval are started with evenly distributed
+// phases, so that they do not all poll at the same tick. Jitter adds a
+// random offset to the phases, so that pollers started together, e.g. of
+// several gateways after a power failure, do not poll in step.
+type Poller struct {
+	Client Client
+	// Planner creates the block reads of poll groups.
+	Planner ReadPlanner
+	// NoPhaseShift starts all groups at the same time.
+	NoPhaseShift bool
+	// Jitter is the maximum random offset added to the phase of each
+	// group. Zero disables it.
+	Jitter time.Duration
+	// DutyCycle limits the time the device is busy being polled, polls of
+	// groups are delayed as needed.
+	DutyCycle *DutyCycle
+	// Clock schedules the polls, it defaults to the system time if nil.
+	Clock Clock
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	groups  []*PollGroup
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// NewPoller allocates a new Poller reading from client.
+func NewPoller(client Client) *Poller {
+	return &Poller{Client: client}
+}
+
+// Add plans the reads of the group and adds it to the poller. Groups must
+// be added before Start.
+func (p *Poller) Add(group *PollGroup) (err error) {
+	if group.Interval <= 0 {
+		return fmt.Errorf("modbus: poll group '%v' interval '%v' must be positive", group.Name, group.Interval)
+	}
+	if group.plan, err = p.Planner.Plan(group.Tags); err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.groups = append(p.groups, group)
+	return
+}
+
+// Start starts polling. It does nothing if the poller is already started.
+func (p *Poller) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	phases := make([]time.Duration, len(p.groups))
+	if !p.NoPhaseShift {
+		phases = pollPhases(p.groups)
+	}
+	if p.Jitter > 0 {
+		p.addJitter(phases)
+	}
+	for i, group := range p.groups {
+		p.stopped.Add(1)
+		go p.poll(group, phases[i], p.stop)
+	}
+}
+
+// Seed makes the jitter of the phases reproducible.
+func (p *Poller) Seed(seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rand = rand.New(rand.NewSource(seed))
+}
+
+// addJitter adds a random offset of up to Jitter to the phases.
+func (p *Poller) addJitter(phases []time.Duration) {
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(clockOrSystem(p.Clock).Now().UnixNano()))
+	}
+	for i := range phases {
+		phases[i] += time.Duration(p.rand.Int63n(int64(p.Jitter) + 1))
+	}
+}
+
+// Stop stops polling and waits for polls in progress to complete.
+func (p *Poller) Stop() {
+	p.mu.Lock()
+	stop := p.stop
+	p.stop = nil
+	p.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		p.stopped.Wait()
+	}
+}
+
+// Shutdown stops polling and waits for polls in progress to complete, up
+// to the deadline of ctx.
+func (p *Poller) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	stop := p.stop
+	p.stop = nil
+	p.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	return waitContext(ctx, &p.stopped)
+}
+
+// Pause stops polling the group named name, or all groups if name is
+// empty, e.g. during maintenance of the device. It waits for the poll in
+// progress to complete, so that the device is not accessed by the poller
+// until Resume is called.
+func (p *Poller) Pause(name string) error {
+	return p.setPaused(name, true)
+}
+
+// Resume resumes polling the group named name, or all groups if name is
+// empty, at their next interval.
+func (p *Poller) Resume(name string) error {
+	return p.setPaused(name, false)
+}
+
+// Paused returns true if the group named name is paused.
+func (p *Poller) Paused(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, group := range p.groups {
+		if group.Name == name {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			return group.paused
+		}
+	}
+	return false
+}
+
+func (p *Poller) setPaused(name string, paused bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	found := false
+	for _, group := range p.groups {
+		if name == "" || group.Name == name {
+			group.mu.Lock()
+			group.paused = paused
+			group.mu.Unlock()
+			found = true
+		}
+	}
+	if !found && name != "" {
+		return fmt.Errorf("modbus: poll group '%v' not found", name)
+	}
+	return nil
+}
+
+func (p *Poller) poll(group *PollGroup, phase time.Duration, stop chan struct{}) {
+	defer p.stopped.Done()
+
+	clock := clockOrSystem(p.Clock)
+	if phase > 0 {
+		select {
+		case <-stop:
+			return
+		case <-clock.After(phase):
+		}
+	}
+	next := clock.Now()
+	for {
+		group.mu.Lock()
+		paused := group.paused
+		var values map[string][]uint16
+		var err error
+		if !paused {
+			values, err = p.read(group)
+		}
+		group.mu.Unlock()
+		if !paused && group.Handler != nil {
+			group.Handler(values, err)
+		}
+		// Missed polls are dropped, as by time.Ticker
+		next = next.Add(group.Interval)
+		wait := next.Sub(clock.Now())
+		if wait < 0 {
+			next = next.Add(-wait / group.Interval * group.Interval)
+			wait = 0
+		}
+		select {
+		case <-stop:
+			return
+		case <-clock.After(wait):
+		}
+	}
+}
+
+func (p *Poller) read(group *PollGroup) (values map[string][]uint16, err error) {
+	if p.DutyCycle == nil {
+		return group.plan.Read(p.Client)
+	}
+	err = p.DutyCycle.Do(func() (err error) {
+		values, err = group.plan.Read(p.Client)
+		return
+	})
+	return
+}
+
+// pollPhases returns the start delay of each group: groups sharing the same
+// interval are spread evenly over the interval.
+func pollPhases(groups []*PollGroup) []time.Duration {
+	counts := make(map[time.Duration]int)
+	for _, group := range groups {
+		counts[group.Interval]++
+	}
+	indexes := make(map[time.Duration]int)
+	phases := make([]time.Duration, len(groups))
+	for i, group := range groups {
+		n := counts[group.Interval]
+		phases[i] = group.Interval * time.Duration(indexes[group.Interval]) / time.Duration(n)
+		indexes[group.Interval]++
+	}
+	return phases
+}
@@ -0,0 +1,185 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollPhases(t *testing.T) {
+	groups := []*PollGroup{
+		{Interval: time.Second},
+		{Interval: 100 * time.Millisecond},
+		{Interval: time.Second},
+		{Interval: time.Second},
+		{Interval: time.Second},
+	}
+	expected := []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond, 750 * time.Millisecond}
+	if phases := pollPhases(groups); !reflect.DeepEqual(expected, phases) {
+		t.Fatalf("expected phases %v, actual %v", expected, phases)
+	}
+}
+
+func TestPollerJitter(t *testing.T) {
+	phases := func() []time.Duration {
+		poller := NewPoller(nil)
+		poller.Jitter = 100 * time.Millisecond
+		poller.Seed(1)
+		phases := []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond}
+		poller.addJitter(phases)
+		return phases
+	}
+	first := phases()
+	for i, phase := range first {
+		base := time.Duration(i) * 250 * time.Millisecond
+		if phase < base || phase > base+100*time.Millisecond {
+			t.Fatalf("phase %v out of jitter range: %v", i, first)
+		}
+	}
+	if second := phases(); !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected reproducible phases %v, actual %v", first, second)
+	}
+}
+
+// lockedClient serializes access to a client which is not safe for
+// concurrent use.
+type lockedClient struct {
+	mu sync.Mutex
+	*memoryClient
+}
+
+func (c *lockedClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.memoryClient.ReadHoldingRegisters(address, quantity)
+}
+
+func TestPoller(t *testing.T) {
+	memory := &memoryClient{}
+	memory.holding[1] = 10
+	poller := NewPoller(&lockedClient{memoryClient: memory})
+
+	polls := make(chan time.Time, 100)
+	values := make(chan map[string][]uint16, 100)
+	for i := 0; i < 2; i++ {
+		err := poller.Add(&PollGroup{
+			Interval: 40 * time.Millisecond,
+			Tags:     []Tag{{Name: "a", Table: TableHoldingRegisters, Address: 1}},
+			Handler: func(v map[string][]uint16, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				polls <- time.Now()
+				values <- v
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	poller.Start()
+	first, second := <-polls, <-polls
+	poller.Stop()
+
+	if v := <-values; !reflect.DeepEqual(map[string][]uint16{"a": {10}}, v) {
+		t.Fatalf("unexpected values %v", v)
+	}
+	if first.Sub(start) > 15*time.Millisecond || second.Sub(start) < 15*time.Millisecond {
+		t.Fatalf("groups are not phase shifted: %v, %v", first.Sub(start), second.Sub(start))
+	}
+	if err := poller.Add(&PollGroup{}); err == nil {
+		t.Fatalf("expected interval error")
+	}
+}
+
+func TestPollerPause(t *testing.T) {
+	memory := &memoryClient{}
+	poller := NewPoller(&lockedClient{memoryClient: memory})
+	polls := make(chan string, 100)
+	for _, name := range []string{"status", "log"} {
+		name := name
+		err := poller.Add(&PollGroup{
+			Name:     name,
+			Interval: 10 * time.Millisecond,
+			Tags:     []Tag{{Name: "a", Table: TableHoldingRegisters, Address: 1}},
+			Handler: func(v map[string][]uint16, err error) {
+				polls <- name
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := poller.Pause("log"); err != nil {
+		t.Fatal(err)
+	}
+	if !poller.Paused("log") || poller.Paused("status") {
+		t.Fatalf("unexpected paused groups")
+	}
+	if err := poller.Pause("unknown"); err == nil {
+		t.Fatalf("expected unknown group error")
+	}
+	poller.Start()
+	defer poller.Stop()
+	for i := 0; i < 3; i++ {
+		if name := <-polls; name != "status" {
+			t.Fatalf("paused group %v polled", name)
+		}
+	}
+
+	poller.Pause("")
+	// Drain polls completed before pausing.
+	time.Sleep(5 * time.Millisecond)
+	for len(polls) > 0 {
+		<-polls
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(polls) != 0 {
+		t.Fatalf("unexpected polls while paused")
+	}
+	poller.Resume("log")
+	if name := <-polls; name != "log" {
+		t.Fatalf("unexpected poll of %v", name)
+	}
+}
+
+func TestPollerClock(t *testing.T) {
+	clock := &simClock{now: time.Unix(0, 0)}
+	poller := NewPoller(&memoryClient{})
+	poller.Clock = clock
+	var polls []time.Duration
+	done := make(chan struct{})
+	err := poller.Add(&PollGroup{
+		Interval: time.Second,
+		Tags:     []Tag{{Name: "a", Table: TableHoldingRegisters, Address: 1}},
+		Handler: func(v map[string][]uint16, err error) {
+			if len(polls) == 5 {
+				return
+			}
+			polls = append(polls, clock.Now().Sub(time.Unix(0, 0)))
+			if len(polls) == 2 {
+				// The poll lasts beyond the next one
+				clock.Sleep(2500 * time.Millisecond)
+			}
+			if len(polls) == 5 {
+				close(done)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller.Start()
+	<-done
+	poller.Stop()
+	expected := []time.Duration{0, time.Second, 3500 * time.Millisecond, 4 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(expected, polls) {
+		t.Fatalf("expected polls at %v, actual %v", expected, polls)
+	}
+}
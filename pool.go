@@ -0,0 +1,174 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ClientPool manages Modbus TCP connections to many devices, returning
+// clients by address and unit identifier:
+//  pool := modbus.NewClientPool()
+//  pool.MaxConnections = 100
+//  defer pool.Close()
+//  for _, meter := range meters {
+//  	client, err := pool.Client(meter.Address, meter.UnitId)
+//  	...
+//  	results, err := client.ReadHoldingRegisters(0, 10)
+//  }
+// Clients of the same address share a connection, see TCPConnection,
+// which is dialed on the first request and closed after IdleTimeout.
+type ClientPool struct {
+	// Timeout and IdleTimeout of connections.
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+	// MaxConnections limits the number of addresses in the pool, 0 for no
+	// limit. Connections of the least recently used address are closed
+	// to make room for a new one, their clients reconnect on their next
+	// request without counting in the limit.
+	MaxConnections int
+	// HealthCheck, if not nil, is called with the client of the address
+	// and unit identifier requested when its connection has not been
+	// checked for HealthCheckInterval. The connection is evicted if it
+	// fails and the error is returned by Client.
+	HealthCheck         func(client Client) error
+	HealthCheckInterval time.Duration
+	// Configure, if not nil, is called with new connections, e.g. to set
+	// Lifecycle callbacks.
+	Configure func(conn *TCPConnection)
+	// Transmission logger of connections.
+	Logger *log.Logger
+	// Clock of the pool and its connections, it defaults to the system
+	// time if nil.
+	Clock Clock
+
+	mu    sync.Mutex
+	conns map[string]*pooledConnection
+}
+
+// pooledConnection is a connection of the pool and its clients.
+type pooledConnection struct {
+	conn     *TCPConnection
+	clients  map[byte]Client
+	lastUsed time.Time
+	checked  time.Time
+}
+
+// NewClientPool allocates a new ClientPool with default timeouts.
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		Timeout:     tcpTimeout,
+		IdleTimeout: tcpIdleTimeout,
+		conns:       make(map[string]*pooledConnection),
+	}
+}
+
+// Client returns the client of the unit identifier at address, creating
+// its connection if needed.
+func (p *ClientPool) Client(address string, slaveId byte) (client Client, err error) {
+	var evicted *pooledConnection
+	p.mu.Lock()
+	c, ok := p.conns[address]
+	if !ok {
+		if p.MaxConnections > 0 && len(p.conns) >= p.MaxConnections {
+			evicted = p.leastRecentlyUsed()
+		}
+		c = &pooledConnection{conn: NewTCPConnection(address), clients: make(map[byte]Client)}
+		c.conn.Timeout = p.Timeout
+		c.conn.IdleTimeout = p.IdleTimeout
+		c.conn.Logger = p.Logger
+		c.conn.Clock = p.Clock
+		if p.Configure != nil {
+			p.Configure(c.conn)
+		}
+		c.checked = clockOrSystem(p.Clock).Now()
+		p.conns[address] = c
+	}
+	now := clockOrSystem(p.Clock).Now()
+	c.lastUsed = now
+	client, ok = c.clients[slaveId]
+	if !ok {
+		client = NewClient(c.conn.Handler(slaveId))
+		c.clients[slaveId] = client
+	}
+	check := p.HealthCheck != nil && now.Sub(c.checked) >= p.HealthCheckInterval
+	if check {
+		c.checked = now
+	}
+	p.mu.Unlock()
+
+	if evicted != nil {
+		// Closing waits for the request in progress, if any
+		evicted.conn.Close()
+	}
+	if check {
+		if err = p.HealthCheck(client); err != nil {
+			p.evict(address, c)
+			client = nil
+			err = fmt.Errorf("modbus: health check of '%v' failed: %v", address, err)
+		}
+	}
+	return
+}
+
+// Evict closes the connection to address and removes its clients from the
+// pool.
+func (p *ClientPool) Evict(address string) error {
+	p.mu.Lock()
+	c := p.conns[address]
+	p.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	return p.evict(address, c)
+}
+
+// Len returns the number of addresses in the pool.
+func (p *ClientPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Close closes all the connections and empties the pool.
+func (p *ClientPool) Close() (err error) {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = make(map[string]*pooledConnection)
+	p.mu.Unlock()
+
+	for _, c := range conns {
+		if e := c.conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// evict removes c if it is still the connection to address and closes it.
+func (p *ClientPool) evict(address string, c *pooledConnection) error {
+	p.mu.Lock()
+	if p.conns[address] == c {
+		delete(p.conns, address)
+	}
+	p.mu.Unlock()
+	return c.conn.Close()
+}
+
+// leastRecentlyUsed removes the least recently used connection from the
+// pool and returns it to be closed. Caller must hold the mutex.
+func (p *ClientPool) leastRecentlyUsed() (c *pooledConnection) {
+	var oldest string
+	for address, conn := range p.conns {
+		if c == nil || conn.lastUsed.Before(c.lastUsed) {
+			oldest, c = address, conn
+		}
+	}
+	delete(p.conns, oldest)
+	return
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		go serveCounter(ln)
+		addresses = append(addresses, ln.Addr().String())
+	}
+	clock := newManualClock()
+	pool := NewClientPool()
+	pool.Timeout = time.Second
+	pool.MaxConnections = 1
+	pool.Clock = clock
+	defer pool.Close()
+
+	first, err := pool.Client(addresses[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := pool.Client(addresses[0], 1); again != first {
+		t.Fatal("client of the same address and unit is not reused")
+	}
+	second, _ := pool.Client(addresses[0], 2)
+	if second == first {
+		t.Fatal("client of another unit is reused")
+	}
+	if _, err = first.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	results, err := second.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both clients share the connection
+	if results[1] != 2 {
+		t.Fatalf("unexpected results %v", results)
+	}
+	conn := first.(*client).transporter.(*TCPConnectionHandler).conn
+	if !conn.IsConnected() {
+		t.Fatal("connection is not connected")
+	}
+
+	clock.Advance(time.Second)
+	if _, err = pool.Client(addresses[1], 1); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Len() != 1 || conn.IsConnected() {
+		t.Fatal("least recently used connection is not evicted")
+	}
+}
+
+func TestClientPoolHealthCheck(t *testing.T) {
+	pool := NewClientPool()
+	clock := newManualClock()
+	pool.Clock = clock
+	pool.HealthCheckInterval = time.Minute
+	checks := 0
+	pool.HealthCheck = func(client Client) error {
+		checks++
+		return errors.New("unreachable")
+	}
+	defer pool.Close()
+
+	if _, err := pool.Client("127.0.0.1:0", 1); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	_, err := pool.Client("127.0.0.1:0", 1)
+	if err == nil || err.Error() != "modbus: health check of '127.0.0.1:0' failed: unreachable" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if checks != 1 || pool.Len() != 0 {
+		t.Fatalf("unexpected checks %v, length %v", checks, pool.Len())
+	}
+}
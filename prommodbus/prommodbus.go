@@ -0,0 +1,268 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+/*
+Package prommodbus provides a Prometheus collector of modbus client
+statistics.
+
+Requests pass through the middleware of the collector, which records their
+count, latency, errors by type and exceptions by code per slave id and
+function:
+
+	collector := prommodbus.NewCollector(prommodbus.Opts{
+		Namespace:   "gateway",
+		ConstLabels: prometheus.Labels{"port": "/dev/ttyUSB0"},
+	})
+	collector.AddCRCCounter("/dev/ttyUSB0", handler)
+	prometheus.MustRegister(collector)
+	client := modbus.NewMiddlewareClient(handler, collector.Middleware(handler.SlaveId))
+	http.Handle("/metrics", promhttp.Handler())
+*/
+package prommodbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label names of the metrics.
+const (
+	SlaveIdLabel       = "slave_id"
+	FunctionLabel      = "function"
+	ErrorTypeLabel     = "type"
+	ExceptionCodeLabel = "code"
+	PortLabel          = "port"
+)
+
+// Error types of the errors_total metric.
+const (
+	ErrorTimeout         = "timeout"
+	ErrorPartialResponse = "partial_response"
+	ErrorChecksum        = "checksum"
+	ErrorConnection      = "connection"
+	ErrorInvalidResponse = "invalid_response"
+	ErrorOther           = "other"
+)
+
+// Opts configures the metrics of a Collector.
+type Opts struct {
+	// Namespace and Subsystem prefix the metric names, which are
+	// modbus_requests_total etc. if they are empty.
+	Namespace string
+	Subsystem string
+	// ConstLabels are added to all the metrics, e.g. to tell the ports of
+	// a gateway apart.
+	ConstLabels prometheus.Labels
+	// Buckets of the request duration in seconds, prometheus.DefBuckets if
+	// nil.
+	Buckets []float64
+	// Clock times the requests of the middleware, it defaults to the
+	// system time if nil.
+	Clock modbus.Clock
+}
+
+// Collector implements prometheus.Collector.
+type Collector struct {
+	requests   *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	exceptions *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	crcErrors  *prometheus.Desc
+
+	mu          sync.Mutex
+	crcCounters map[string]CRCCounter
+	clock       modbus.Clock
+}
+
+// CRCCounter is implemented by the serial client handlers, see
+// modbus.RTUClientHandler.CRCErrors.
+type CRCCounter interface {
+	CRCErrors() uint64
+}
+
+// NewCollector allocates a new Collector.
+func NewCollector(opts Opts) *Collector {
+	namespace := opts.Namespace
+	subsystem := opts.Subsystem
+	if namespace == "" && subsystem == "" {
+		namespace = "modbus"
+	}
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: opts.ConstLabels,
+		}, labels)
+	}
+	return &Collector{
+		requests: counter("requests_total", "Number of requests sent.",
+			SlaveIdLabel, FunctionLabel),
+		errors: counter("errors_total", "Number of requests failed, by error type.",
+			SlaveIdLabel, FunctionLabel, ErrorTypeLabel),
+		exceptions: counter("exceptions_total", "Number of exception responses, by exception code.",
+			SlaveIdLabel, FunctionLabel, ExceptionCodeLabel),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "request_duration_seconds",
+			Help:        "Duration of requests, including failed ones.",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     buckets,
+		}, []string{SlaveIdLabel, FunctionLabel}),
+		crcErrors: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "crc_errors_total"),
+			"Number of responses received with a CRC or LRC mismatch.", []string{PortLabel}, opts.ConstLabels),
+		crcCounters: make(map[string]CRCCounter),
+		clock:       opts.Clock,
+	}
+}
+
+// AddCRCCounter exports the checksum errors of the handler of port.
+func (c *Collector) AddCRCCounter(port string, counter CRCCounter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crcCounters[port] = counter
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.errors.Describe(ch)
+	c.exceptions.Describe(ch)
+	c.duration.Describe(ch)
+	ch <- c.crcErrors
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.errors.Collect(ch)
+	c.exceptions.Collect(ch)
+	c.duration.Collect(ch)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for port, counter := range c.crcCounters {
+		ch <- prometheus.MustNewConstMetric(c.crcErrors, prometheus.CounterValue, float64(counter.CRCErrors()), port)
+	}
+}
+
+// Middleware returns a modbus.Middleware recording the requests sent to
+// slaveId.
+func (c *Collector) Middleware(slaveId byte) modbus.Middleware {
+	return func(request *modbus.Request, next modbus.Sender) (response *modbus.Response, err error) {
+		start := c.now()
+		response, err = next(request)
+		if err == nil && response != nil && response.ExceptionCode != 0 {
+			c.Observe(slaveId, request.FunctionCode, c.now().Sub(start),
+				&modbus.ModbusError{FunctionCode: request.FunctionCode | 0x80, ExceptionCode: response.ExceptionCode})
+			return
+		}
+		c.Observe(slaveId, request.FunctionCode, c.now().Sub(start), err)
+		return
+	}
+}
+
+func (c *Collector) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// Observe records a request to slaveId which took duration and failed with
+// err, if not nil. It is used by the middleware and by servers or clients
+// not built on modbus.NewMiddlewareClient.
+func (c *Collector) Observe(slaveId, functionCode byte, duration time.Duration, err error) {
+	slave := strconv.Itoa(int(slaveId))
+	function := functionName(functionCode)
+	c.requests.WithLabelValues(slave, function).Inc()
+	c.duration.WithLabelValues(slave, function).Observe(duration.Seconds())
+	if err == nil {
+		return
+	}
+	var mbError *modbus.ModbusError
+	if errors.As(err, &mbError) {
+		c.exceptions.WithLabelValues(slave, function, strconv.Itoa(int(mbError.ExceptionCode))).Inc()
+		return
+	}
+	c.errors.WithLabelValues(slave, function, ErrorType(err)).Inc()
+}
+
+// ErrorType returns the type of err, ErrorTimeout etc., as recorded in the
+// errors_total metric.
+func ErrorType(err error) string {
+	var checksumError *modbus.ChecksumError
+	if errors.As(err, &checksumError) {
+		return ErrorChecksum
+	}
+	// Partial responses are timeouts as well, reported separately
+	var partialResponse *modbus.PartialResponseError
+	if errors.As(err, &partialResponse) {
+		return ErrorPartialResponse
+	}
+	if errors.Is(err, serial.ErrTimeout) {
+		return ErrorTimeout
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return ErrorTimeout
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrorConnection
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) {
+		return ErrorConnection
+	}
+	if strings.HasPrefix(err.Error(), "modbus: response") {
+		return ErrorInvalidResponse
+	}
+	return ErrorOther
+}
+
+// functionName returns the name of the function code, or its number if it
+// is not a public function.
+func functionName(functionCode byte) string {
+	switch functionCode {
+	case modbus.FuncCodeReadCoils:
+		return "read_coils"
+	case modbus.FuncCodeReadDiscreteInputs:
+		return "read_discrete_inputs"
+	case modbus.FuncCodeReadHoldingRegisters:
+		return "read_holding_registers"
+	case modbus.FuncCodeReadInputRegisters:
+		return "read_input_registers"
+	case modbus.FuncCodeWriteSingleCoil:
+		return "write_single_coil"
+	case modbus.FuncCodeWriteSingleRegister:
+		return "write_single_register"
+	case modbus.FuncCodeWriteMultipleCoils:
+		return "write_multiple_coils"
+	case modbus.FuncCodeWriteMultipleRegisters:
+		return "write_multiple_registers"
+	case modbus.FuncCodeMaskWriteRegister:
+		return "mask_write_register"
+	case modbus.FuncCodeReadWriteMultipleRegisters:
+		return "read_write_multiple_registers"
+	case modbus.FuncCodeReadFIFOQueue:
+		return "read_fifo_queue"
+	case modbus.FuncCodeDiagnostics:
+		return "diagnostics"
+	}
+	return strconv.Itoa(int(functionCode))
+}
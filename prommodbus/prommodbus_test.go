@@ -0,0 +1,106 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package prommodbus
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type crcCounter uint64
+
+func (c crcCounter) CRCErrors() uint64 {
+	return uint64(c)
+}
+
+// stepClock is a clock advancing by step every time it is read.
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func (c *stepClock) Sleep(d time.Duration)                  { c.now = c.now.Add(d) }
+func (c *stepClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (c *stepClock) AfterFunc(d time.Duration, f func()) modbus.Timer {
+	return time.AfterFunc(d, f)
+}
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector(Opts{
+		Namespace:   "gateway",
+		ConstLabels: prometheus.Labels{"bus": "a"},
+		Buckets:     []float64{0.1, 1},
+		Clock:       &stepClock{now: time.Unix(0, 0), step: 250 * time.Millisecond},
+	})
+	collector.AddCRCCounter("/dev/ttyUSB0", crcCounter(3))
+	middleware := collector.Middleware(7)
+	request := &modbus.Request{FunctionCode: modbus.FuncCodeReadHoldingRegisters}
+	responses := []struct {
+		response *modbus.Response
+		err      error
+	}{
+		{&modbus.Response{}, nil},
+		{&modbus.Response{ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}, nil},
+		{nil, serial.ErrTimeout},
+		{nil, &modbus.ChecksumError{Checksum: "crc", Received: 1, Expected: 2}},
+		{nil, &modbus.PartialResponseError{Received: 3, Expected: 9, Timeout: time.Second, Err: serial.ErrTimeout}},
+		{nil, fmt.Errorf("modbus: response data size '1' does not match count '2'")},
+	}
+	for _, r := range responses {
+		middleware(request, func(*modbus.Request) (*modbus.Response, error) {
+			return r.response, r.err
+		})
+	}
+
+	expected := `
+# HELP gateway_crc_errors_total Number of responses received with a CRC or LRC mismatch.
+# TYPE gateway_crc_errors_total counter
+gateway_crc_errors_total{bus="a",port="/dev/ttyUSB0"} 3
+# HELP gateway_errors_total Number of requests failed, by error type.
+# TYPE gateway_errors_total counter
+gateway_errors_total{bus="a",function="read_holding_registers",slave_id="7",type="checksum"} 1
+gateway_errors_total{bus="a",function="read_holding_registers",slave_id="7",type="invalid_response"} 1
+gateway_errors_total{bus="a",function="read_holding_registers",slave_id="7",type="partial_response"} 1
+gateway_errors_total{bus="a",function="read_holding_registers",slave_id="7",type="timeout"} 1
+# HELP gateway_exceptions_total Number of exception responses, by exception code.
+# TYPE gateway_exceptions_total counter
+gateway_exceptions_total{bus="a",code="2",function="read_holding_registers",slave_id="7"} 1
+# HELP gateway_request_duration_seconds Duration of requests, including failed ones.
+# TYPE gateway_request_duration_seconds histogram
+gateway_request_duration_seconds_bucket{bus="a",function="read_holding_registers",slave_id="7",le="0.1"} 0
+gateway_request_duration_seconds_bucket{bus="a",function="read_holding_registers",slave_id="7",le="1"} 6
+gateway_request_duration_seconds_bucket{bus="a",function="read_holding_registers",slave_id="7",le="+Inf"} 6
+gateway_request_duration_seconds_sum{bus="a",function="read_holding_registers",slave_id="7"} 1.5
+gateway_request_duration_seconds_count{bus="a",function="read_holding_registers",slave_id="7"} 6
+# HELP gateway_requests_total Number of requests sent.
+# TYPE gateway_requests_total counter
+gateway_requests_total{bus="a",function="read_holding_registers",slave_id="7"} 6
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFunctionName(t *testing.T) {
+	if name := functionName(modbus.FuncCodeWriteMultipleCoils); name != "write_multiple_coils" {
+		t.Fatalf("unexpected name %v", name)
+	}
+	if name := functionName(100); name != "100" {
+		t.Fatalf("unexpected name %v", name)
+	}
+}
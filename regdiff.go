@@ -0,0 +1,188 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// RegisterDiff compares two snapshots of a block of registers, e.g. read
+// before and after changing a setting of an undocumented device:
+//  diff, err := modbus.DiffRegisters(0, before, after)
+//  for _, change := range diff.Changes {
+//  	for _, value := range diff.Interpret(change.Address) {
+//  		fmt.Printf("%v %v/%v: %v -> %v\n", value.Address, value.Type, value.Order, value.Old, value.New)
+//  	}
+//  }
+// Tags decodes the tags of a register map instead, e.g. to check the
+// values written while commissioning a device.
+type RegisterDiff struct {
+	// Address is the address of the first register of the snapshots.
+	Address uint16
+	Old     []uint16
+	New     []uint16
+	// Changes are the registers which differ, by address.
+	Changes []RegisterChange
+}
+
+// RegisterChange is a register whose value differs between snapshots.
+type RegisterChange struct {
+	Address uint16
+	Old     uint16
+	New     uint16
+}
+
+// Interpretation is a value decoded from registers of both snapshots.
+type Interpretation struct {
+	// Address is the address of the first register of the value.
+	Address uint16
+	Type    string
+	Order   string
+	Old     float64
+	New     float64
+}
+
+// TagDiff is a tag whose value differs between snapshots.
+type TagDiff struct {
+	Name string
+	Old  TagValue
+	New  TagValue
+}
+
+// interpretedTypes are the types and word orders of Interpret.
+var interpretedTypes = []struct {
+	typ    string
+	orders []string
+}{
+	{"uint16", []string{"abcd", "badc"}},
+	{"int16", []string{"abcd", "badc"}},
+	{"uint32", []string{"abcd", "cdab", "badc", "dcba"}},
+	{"int32", []string{"abcd", "cdab", "badc", "dcba"}},
+	{"float32", []string{"abcd", "cdab", "badc", "dcba"}},
+	{"uint64", []string{"abcd", "cdab", "badc", "dcba"}},
+	{"int64", []string{"abcd", "cdab", "badc", "dcba"}},
+	{"float64", []string{"abcd", "cdab", "badc", "dcba"}},
+}
+
+// DiffRegisters compares the old and new registers of a block starting at
+// address, which must have the same length.
+func DiffRegisters(address uint16, old, new []uint16) (diff *RegisterDiff, err error) {
+	if len(old) != len(new) {
+		err = fmt.Errorf("modbus: snapshot sizes '%v' and '%v' do not match", len(old), len(new))
+		return
+	}
+	if int(address)+len(old) > 65536 {
+		err = fmt.Errorf("modbus: snapshot of '%v' registers at address '%v' exceeds the address space", len(old), address)
+		return
+	}
+	diff = &RegisterDiff{Address: address, Old: old, New: new}
+	for i := range old {
+		if old[i] != new[i] {
+			diff.Changes = append(diff.Changes, RegisterChange{Address: address + uint16(i), Old: old[i], New: new[i]})
+		}
+	}
+	return
+}
+
+// Interpret decodes the values of each type and word order including the
+// register at address, which differ between the snapshots, to guess the
+// type of undocumented values. Values of several registers start at the
+// address or at the preceding ones.
+func (d *RegisterDiff) Interpret(address uint16) (values []Interpretation) {
+	offset := int(address) - int(d.Address)
+	if offset < 0 || offset >= len(d.Old) {
+		return
+	}
+	for _, t := range interpretedTypes {
+		size := registerTypeSizes[t.typ]
+		for start := offset - size + 1; start <= offset; start++ {
+			if start < 0 || start+size > len(d.Old) {
+				continue
+			}
+			for _, order := range t.orders {
+				old, err := decodeRegisterValue(d.Old[start:start+size], t.typ, order)
+				if err != nil {
+					continue
+				}
+				new, err := decodeRegisterValue(d.New[start:start+size], t.typ, order)
+				if err != nil || sameValue(old, new) {
+					continue
+				}
+				values = append(values, Interpretation{
+					Address: d.Address + uint16(start),
+					Type:    t.typ,
+					Order:   order,
+					Old:     old,
+					New:     new,
+				})
+			}
+		}
+	}
+	return
+}
+
+// Tags decodes the tags of m in table which include changed registers and
+// whose decoded value differs, in the order of the register map. Tags not
+// entirely in the snapshots are ignored.
+func (d *RegisterDiff) Tags(m *RegisterMap, table Table) (tags []TagDiff, err error) {
+	if table.isBits() {
+		err = fmt.Errorf("modbus: table '%v' is not a register table", table)
+		return
+	}
+	for i := range m.Tags {
+		def := &m.Tags[i]
+		start := int(def.Address) - int(d.Address)
+		end := start + int(def.Quantity())
+		if def.Table != table || start < 0 || end > len(d.Old) || !d.changed(start, end) {
+			continue
+		}
+		var diff TagDiff
+		diff.Name = def.Name
+		if diff.Old, err = def.Decode(d.Old[start:end]); err != nil {
+			return
+		}
+		if diff.New, err = def.Decode(d.New[start:end]); err != nil {
+			return
+		}
+		if !sameValue(diff.Old.Value, diff.New.Value) || diff.Old.Quality != diff.New.Quality {
+			tags = append(tags, diff)
+		}
+	}
+	return
+}
+
+// changed returns true if a register of the snapshots at the offsets
+// [start, end) changed.
+func (d *RegisterDiff) changed(start, end int) bool {
+	for i := start; i < end; i++ {
+		if d.Old[i] != d.New[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue returns true if a and b are equal or both NaN.
+func sameValue(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+// decodeRegisterValue decodes the registers of the type in the word order
+// as a float64.
+func decodeRegisterValue(registers []uint16, typ, order string) (value float64, err error) {
+	data := make([]byte, 2*len(registers))
+	for i, r := range registers {
+		binary.BigEndian.PutUint16(data[2*i:], r)
+	}
+	decoded, err := DecodeValue(data, typ, order)
+	if err != nil {
+		return
+	}
+	value = reflect.ValueOf(decoded).Convert(reflect.TypeOf(float64(0))).Float()
+	return
+}
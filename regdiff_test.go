@@ -0,0 +1,61 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRegisters(t *testing.T) {
+	// float32 cdab at 101 changed from 12 to 12.5, register 104 from 5 to 7
+	old := []uint16{1, 0, 0x4140, 0, 5, 0}
+	new := []uint16{1, 0, 0x4148, 0, 7, 0}
+	diff, err := DiffRegisters(100, old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RegisterChange{{102, 0x4140, 0x4148}, {104, 5, 7}}
+	if !reflect.DeepEqual(expected, diff.Changes) {
+		t.Fatalf("expected changes %v, actual %v", expected, diff.Changes)
+	}
+	found := false
+	for _, value := range diff.Interpret(102) {
+		if value.Address > 102 || int(value.Address)+registerTypeSizes[value.Type] <= 102 {
+			t.Errorf("unexpected address %v", value.Address)
+		}
+		if value.Address == 101 && value.Type == "float32" && value.Order == "cdab" {
+			found = value.Old == 12 && value.New == 12.5
+		}
+	}
+	if !found {
+		t.Fatalf("float32 cdab not interpreted: %+v", diff.Interpret(102))
+	}
+	if values := diff.Interpret(99); values != nil {
+		t.Fatalf("unexpected values %v", values)
+	}
+
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "setpoint", Table: TableHoldingRegisters, Address: 101, Type: "float32", Order: "cdab"},
+		{Name: "mode", Table: TableHoldingRegisters, Address: 103},
+		{Name: "speed", Table: TableHoldingRegisters, Address: 104, Scale: 0.5},
+		{Name: "input", Table: TableInputRegisters, Address: 104},
+		{Name: "outside", Table: TableHoldingRegisters, Address: 105, Type: "uint32"},
+	}}
+	tags, err := diff.Tags(m, TableHoldingRegisters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTags := []TagDiff{
+		{"setpoint", TagValue{Value: 12}, TagValue{Value: 12.5}},
+		{"speed", TagValue{Value: 2.5}, TagValue{Value: 3.5}},
+	}
+	if !reflect.DeepEqual(expectedTags, tags) {
+		t.Fatalf("expected tags %v, actual %v", expectedTags, tags)
+	}
+	if _, err = DiffRegisters(0, old, new[1:]); err == nil {
+		t.Fatal("size error expected")
+	}
+}
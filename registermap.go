@@ -0,0 +1,462 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/goburrow/modbus/encoding"
+)
+
+// RegisterMapSchema is the JSON Schema of register map files, for editors
+// and tools validating them outside of Go.
+//
+//go:embed registermap.schema.json
+var RegisterMapSchema []byte
+
+// RegisterMap describes the values of a device:
+//  {
+//    "name": "flow meter",
+//    "tags": [
+//      {"name": "flow_rate", "table": "input", "address": 0, "type": "float32", "order": "cdab", "unit": "m3/h"},
+//      {"name": "pump", "table": "coils", "address": 3}
+//    ]
+//  }
+type RegisterMap struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Paging is set for devices with paged memory, see PagedClient.
+	Paging *Paging  `json:"paging,omitempty" yaml:"paging,omitempty"`
+	Tags   []TagDef `json:"tags" yaml:"tags"`
+}
+
+// TagDef is a named value of a register map.
+type TagDef struct {
+	Name    string `json:"name" yaml:"name"`
+	Table   Table  `json:"table" yaml:"table"`
+	Address uint16 `json:"address" yaml:"address"`
+	// Page of tags in the paging window.
+	Page uint16 `json:"page,omitempty" yaml:"page,omitempty"`
+	// Type is the register type of the "modbus" struct tag, uint16 by
+	// default, or bool for coils and discrete inputs.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Order is the word order of the "modbus" struct tag, abcd by default.
+	Order string  `json:"order,omitempty" yaml:"order,omitempty"`
+	// Transforms are applied to the decoded value before Scale, in the
+	// syntax of struct tags, e.g. "bit=3" or "bcd=", see RegisterTransform.
+	Transforms string  `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	Scale      float64 `json:"scale,omitempty" yaml:"scale,omitempty"`
+	// Unit is the unit of the value, after scaling.
+	Unit string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	// TargetUnit is the unit the value is converted to when decoded, see
+	// ConvertUnit. Values are not converted if it is empty.
+	TargetUnit string `json:"target_unit,omitempty" yaml:"target_unit,omitempty"`
+	// Min and Max are the plausible range of decoded values, values out
+	// of range usually come from a wrong word order or address.
+	Min *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	// EEPROM marks holding registers persisted in EEPROM by the device,
+	// whose writes are counted and limited by EEPROMClient.
+	EEPROM bool `json:"eeprom,omitempty" yaml:"eeprom,omitempty"`
+	// MinWriteInterval is the minimum time in seconds between two writes
+	// of an EEPROM tag, the one of EEPROMClient if zero.
+	MinWriteInterval float64 `json:"min_write_interval,omitempty" yaml:"min_write_interval,omitempty"`
+}
+
+// Quality is the quality of a decoded value.
+type Quality int
+
+// Qualities of decoded values.
+const (
+	QualityGood Quality = iota
+	// QualityBad values are outside of the plausible range of their tag
+	// and must not be used.
+	QualityBad
+)
+
+// String returns the name of the quality.
+func (q Quality) String() string {
+	if q == QualityGood {
+		return "good"
+	}
+	return "bad"
+}
+
+// TagValue is a decoded value of a tag, its unit and quality.
+type TagValue struct {
+	Value   float64
+	Unit    string
+	Quality Quality
+}
+
+// Quantity returns the number of registers or bits of the tag, 0 if its
+// type is invalid.
+func (t *TagDef) Quantity() uint16 {
+	if t.Table.isBits() {
+		if t.Type == "" || t.Type == "bool" {
+			return 1
+		}
+		return 0
+	}
+	if t.Type == "" {
+		return 1
+	}
+	return uint16(registerTypeSizes[t.Type])
+}
+
+// PlanTags returns the tags of the register map, to be read with ReadPlanner.
+// Their unit is the unit of decoded values.
+func (m *RegisterMap) PlanTags() []Tag {
+	tags := make([]Tag, len(m.Tags))
+	for i := range m.Tags {
+		tags[i] = m.Tags[i].planTag()
+	}
+	return tags
+}
+
+// planTag returns the tag to be read with ReadPlanner.
+func (t *TagDef) planTag() Tag {
+	return Tag{
+		Name:     t.Name,
+		Table:    t.Table,
+		Address:  t.Address,
+		Quantity: t.Quantity(),
+		Unit:     t.unit(),
+		Page:     t.Page,
+	}
+}
+
+// Decode decodes the values of a read plan or poll group, by tag name, as
+// engineering values in their unit. Values of tags not in the register map
+// are ignored.
+func (m *RegisterMap) Decode(values map[string][]uint16) (decoded map[string]TagValue, err error) {
+	decoded = make(map[string]TagValue, len(values))
+	for i := range m.Tags {
+		def := &m.Tags[i]
+		registers, ok := values[def.Name]
+		if !ok {
+			continue
+		}
+		if decoded[def.Name], err = def.Decode(registers); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Decode decodes the registers or bits of the tag, applying its scale and
+// unit conversion.
+func (t *TagDef) Decode(registers []uint16) (value TagValue, err error) {
+	if len(registers) != int(t.Quantity()) || len(registers) == 0 {
+		err = fmt.Errorf("modbus: tag '%v' has '%v' values, expected '%v'", t.Name, len(registers), t.Quantity())
+		return
+	}
+	value.Unit = t.unit()
+	if t.Table.isBits() {
+		value.Value = float64(registers[0] & 1)
+		return
+	}
+	typ := t.Type
+	if typ == "" {
+		typ = "uint16"
+	}
+	if value.Value, err = decodeRegisterValue(registers, typ, t.Order); err != nil {
+		return
+	}
+	if t.Transforms != "" {
+		var transforms []Transform
+		if transforms, err = parseTransforms(t.Transforms); err != nil {
+			err = fmt.Errorf("modbus: tag '%v': %v", t.Name, err)
+			return
+		}
+		for _, transform := range transforms {
+			value.Value = transform.Read(value.Value)
+		}
+	}
+	if t.Scale != 0 {
+		value.Value *= t.Scale
+	}
+	if t.TargetUnit != "" && t.TargetUnit != t.Unit {
+		var transform Transform
+		if transform, err = ConvertUnit(t.Unit, t.TargetUnit); err != nil {
+			err = fmt.Errorf("modbus: tag '%v': %v", t.Name, err)
+			return
+		}
+		value.Value = transform.Read(value.Value)
+	}
+	if math.IsNaN(value.Value) || (t.Min != nil && value.Value < *t.Min) || (t.Max != nil && value.Value > *t.Max) {
+		value.Quality = QualityBad
+	}
+	return
+}
+
+// Read reads the values of the plan, created from PlanTags, and decodes
+// them. If retry is true, blocks with values of bad quality are read once
+// more, in case of a transient error of the device.
+func (m *RegisterMap) Read(client Client, plan *ReadPlan, retry bool) (decoded map[string]TagValue, err error) {
+	values, err := plan.Read(client)
+	if err != nil {
+		return
+	}
+	if decoded, err = m.Decode(values); err != nil || !retry {
+		return
+	}
+	for i := range plan.Blocks {
+		block := &plan.Blocks[i]
+		bad := false
+		for _, tag := range block.Tags {
+			bad = bad || decoded[tag.Name].Quality == QualityBad
+		}
+		if !bad {
+			continue
+		}
+		if err = block.read(client, values); err != nil {
+			return
+		}
+		for _, tag := range block.Tags {
+			if def := m.tag(tag.Name); def != nil {
+				if decoded[tag.Name], err = def.Decode(values[tag.Name]); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// tag returns the definition of the tag named name, nil if not found.
+func (m *RegisterMap) tag(name string) *TagDef {
+	for i := range m.Tags {
+		if m.Tags[i].Name == name {
+			return &m.Tags[i]
+		}
+	}
+	return nil
+}
+
+// unit returns the unit of decoded values.
+func (t *TagDef) unit() string {
+	if t.TargetUnit != "" {
+		return t.TargetUnit
+	}
+	return t.Unit
+}
+
+// ValidationError is an error in a register map, Path locates the invalid
+// value, e.g. tags[2].type.
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+// Error implements error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("modbus: %s: %s", e.Path, e.Msg)
+}
+
+// ValidationErrors are all the errors found in a register map.
+type ValidationErrors []*ValidationError
+
+// Error implements error interface.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks names, tables, types and orders of the tags, and that
+// tags do not overlap. It returns ValidationErrors listing all the errors.
+func (m *RegisterMap) Validate() error {
+	var errs ValidationErrors
+	add := func(i int, field, format string, v ...interface{}) {
+		errs = append(errs, &ValidationError{
+			Path: fmt.Sprintf("tags[%d]%s", i, field),
+			Msg:  fmt.Sprintf(format, v...),
+		})
+	}
+	if p := m.Paging; p != nil {
+		if p.WindowSize == 0 || int(p.WindowAddress)+int(p.WindowSize) > 65536 {
+			errs = append(errs, &ValidationError{"paging.window_size", fmt.Sprintf("invalid window size '%v' at address '%v'", p.WindowSize, p.WindowAddress)})
+		} else if p.InWindow(p.Register, 1) {
+			errs = append(errs, &ValidationError{"paging.register", fmt.Sprintf("page register '%v' is in the paging window", p.Register)})
+		}
+	}
+	names := make(map[string]int)
+	var ranges []int
+	for i := range m.Tags {
+		tag := &m.Tags[i]
+		if tag.Name == "" {
+			add(i, ".name", "name is empty")
+		} else if j, ok := names[tag.Name]; ok {
+			add(i, ".name", "duplicate name '%v' of tags[%d]", tag.Name, j)
+		} else {
+			names[tag.Name] = i
+		}
+		if _, ok := tableNames[tag.Table]; !ok {
+			add(i, ".table", "invalid table '%v'", byte(tag.Table))
+			continue
+		}
+		if tag.Quantity() == 0 {
+			add(i, ".type", "invalid type '%v' for table '%v'", tag.Type, tableNames[tag.Table])
+			continue
+		}
+		if tag.Table.isBits() {
+			if tag.Order != "" {
+				add(i, ".order", "order is not applicable to table '%v'", tableNames[tag.Table])
+			}
+			if tag.Scale != 0 {
+				add(i, ".scale", "scale is not applicable to table '%v'", tableNames[tag.Table])
+			}
+			if tag.Transforms != "" {
+				add(i, ".transforms", "transforms are not applicable to table '%v'", tableNames[tag.Table])
+			}
+		} else {
+			if _, err := encoding.ParseOrder(tag.Order); err != nil {
+				add(i, ".order", "invalid order '%v'", tag.Order)
+			}
+			if _, err := parseTransforms(tag.Transforms); err != nil {
+				add(i, ".transforms", "%v", err)
+			}
+		}
+		if tag.TargetUnit != "" && tag.TargetUnit != tag.Unit {
+			if tag.Table.isBits() {
+				add(i, ".target_unit", "unit conversion is not applicable to table '%v'", tableNames[tag.Table])
+			} else if tag.Unit == "" {
+				add(i, ".target_unit", "target unit '%v' requires unit", tag.TargetUnit)
+			} else if _, err := ConvertUnit(tag.Unit, tag.TargetUnit); err != nil {
+				add(i, ".target_unit", "%v", err)
+			}
+		}
+		if tag.Min != nil && tag.Max != nil && *tag.Min > *tag.Max {
+			add(i, ".min", "minimum '%v' is greater than maximum '%v'", *tag.Min, *tag.Max)
+		}
+		if tag.EEPROM && tag.Table != TableHoldingRegisters {
+			add(i, ".eeprom", "eeprom is not applicable to table '%v'", tableNames[tag.Table])
+		}
+		if tag.MinWriteInterval < 0 {
+			add(i, ".min_write_interval", "negative minimum write interval '%v'", tag.MinWriteInterval)
+		} else if tag.MinWriteInterval > 0 && !tag.EEPROM {
+			add(i, ".min_write_interval", "minimum write interval requires eeprom")
+		}
+		if int(tag.Address)+int(tag.Quantity()) > 65536 {
+			add(i, ".address", "address '%v' plus quantity '%v' exceeds '%v'", tag.Address, tag.Quantity(), 65536)
+			continue
+		}
+		if tag.Page != 0 {
+			if m.Paging == nil {
+				add(i, ".page", "page '%v' requires paging", tag.Page)
+				continue
+			}
+			if !m.Paging.InWindow(tag.Address, tag.Quantity()) {
+				add(i, ".address", "address '%v' of page '%v' is outside of the paging window", tag.Address, tag.Page)
+				continue
+			}
+		} else if m.Paging != nil && m.Paging.overlaps(tag.Address, tag.Quantity()) && !m.Paging.InWindow(tag.Address, tag.Quantity()) {
+			add(i, ".address", "address '%v' crosses the bounds of the paging window", tag.Address)
+			continue
+		}
+		ranges = append(ranges, i)
+	}
+	// Overlapping tags, sorted by table and address
+	sort.SliceStable(ranges, func(a, b int) bool {
+		x, y := &m.Tags[ranges[a]], &m.Tags[ranges[b]]
+		if x.Table != y.Table {
+			return x.Table < y.Table
+		}
+		if x.Page != y.Page {
+			return x.Page < y.Page
+		}
+		return x.Address < y.Address
+	})
+	// last is the tag ending last of the table and page so far, tags are
+	// compared to it rather than to the previous one, which may be
+	// shorter.
+	for k, last := 1, 0; k < len(ranges); k++ {
+		p := ranges[last]
+		prev, tag := &m.Tags[p], &m.Tags[ranges[k]]
+		end := int(prev.Address) + int(prev.Quantity())
+		if prev.Table != tag.Table || prev.Page != tag.Page || int(tag.Address)+int(tag.Quantity()) > end {
+			last = k
+		}
+		if prev.Table != tag.Table || prev.Page != tag.Page || end <= int(tag.Address) {
+			continue
+		}
+		if prev.Address == tag.Address {
+			add(ranges[k], ".address", "duplicate address '%v' of tags[%d]", tag.Address, p)
+		} else {
+			add(ranges[k], ".address", "address '%v' overlaps tags[%d] at '%v' to '%v'",
+				tag.Address, p, prev.Address, end-1)
+		}
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(a, b int) bool {
+			return tagIndex(errs[a].Path) < tagIndex(errs[b].Path)
+		})
+		return errs
+	}
+	return nil
+}
+
+func tagIndex(path string) (i int) {
+	fmt.Sscanf(path, "tags[%d]", &i)
+	return
+}
+
+// LoadRegisterMap reads a JSON register map and validates it. Syntax
+// errors and unknown fields are reported with their line and column.
+func LoadRegisterMap(r io.Reader) (m *RegisterMap, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	m = &RegisterMap{}
+	if err = decoder.Decode(m); err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxError):
+			line, column := position(data, syntaxError.Offset)
+			err = fmt.Errorf("modbus: register map line %d, column %d: %v", line, column, err)
+		case errors.As(err, &typeError):
+			line, column := position(data, typeError.Offset)
+			err = fmt.Errorf("modbus: register map line %d, column %d: %v has invalid value of type %v", line, column, typeError.Field, typeError.Value)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			// The error has no offset, locate the first use of the field
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			if i := bytes.Index(data, []byte(field)); i >= 0 {
+				line, column := position(data, int64(i))
+				err = fmt.Errorf("modbus: register map line %d, column %d: %v", line, column, err)
+			}
+		}
+		m = nil
+		return
+	}
+	if err = m.Validate(); err != nil {
+		m = nil
+	}
+	return
+}
+
+// position returns the line and column, starting from 1, of the offset.
+func position(data []byte, offset int64) (line, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = 1 + bytes.Count(before, []byte{'\n'})
+	column = 1 + len(before) - (bytes.LastIndexByte(before, '\n') + 1)
+	return
+}
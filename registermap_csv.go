@@ -0,0 +1,218 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// csvColumns are the columns of register map CSV files, named as the
+// fields of JSON files.
+var csvColumns = []string{"name", "table", "address", "page", "type", "order", "transforms", "scale", "unit", "target_unit", "min", "max", "eeprom", "min_write_interval"}
+
+// WriteRegisterMap writes the register map as indented JSON, which can be
+// read by LoadRegisterMap.
+func WriteRegisterMap(w io.Writer, m *RegisterMap) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// LoadRegisterMapCSV reads a register map from CSV, one tag per row after
+// a header naming the columns, in any order:
+//  name,table,address,type,order,scale,unit
+//  flow_rate,input,0,float32,cdab,,m3/h
+//  pump,coils,3,,,,
+// Columns are the fields of JSON files, only name, table and address are
+// required. The register map has no name nor paging.
+func LoadRegisterMapCSV(r io.Reader) (m *RegisterMap, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		err = fmt.Errorf("modbus: register map header: %v", err)
+		return
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if !isCSVColumn(name) {
+			err = fmt.Errorf("modbus: register map line 1, column %d: unknown column '%v'", i+1, name)
+			return
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"name", "table", "address"} {
+		if _, ok := columns[name]; !ok {
+			err = fmt.Errorf("modbus: register map column '%v' is missing", name)
+			return
+		}
+	}
+	m = &RegisterMap{}
+	for {
+		var record []string
+		if record, err = reader.Read(); err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			m = nil
+			err = fmt.Errorf("modbus: register map: %v", err)
+			return
+		}
+		var tag TagDef
+		for i, name := range header {
+			if err = parseCSVField(&tag, name, record[i]); err != nil {
+				line, _ := reader.FieldPos(i)
+				m = nil
+				err = fmt.Errorf("modbus: register map line %d, column %d: %v", line, i+1, err)
+				return
+			}
+		}
+		m.Tags = append(m.Tags, tag)
+	}
+	if err = m.Validate(); err != nil {
+		m = nil
+	}
+	return
+}
+
+// WriteRegisterMapCSV writes the tags of the register map as CSV, which
+// can be read by LoadRegisterMapCSV. Register maps with paging can not be
+// written as CSV.
+func WriteRegisterMapCSV(w io.Writer, m *RegisterMap) (err error) {
+	if m.Paging != nil {
+		return fmt.Errorf("modbus: register map '%v' with paging can not be written as CSV", m.Name)
+	}
+	writer := csv.NewWriter(w)
+	if err = writer.Write(csvColumns); err != nil {
+		return
+	}
+	for i := range m.Tags {
+		tag := &m.Tags[i]
+		if err = writer.Write([]string{
+			tag.Name,
+			tableNames[tag.Table],
+			strconv.FormatUint(uint64(tag.Address), 10),
+			formatCSVUint(tag.Page),
+			tag.Type,
+			tag.Order,
+			tag.Transforms,
+			formatCSVScale(tag.Scale),
+			tag.Unit,
+			tag.TargetUnit,
+			formatCSVFloat(tag.Min),
+			formatCSVFloat(tag.Max),
+			formatCSVBool(tag.EEPROM),
+			formatCSVScale(tag.MinWriteInterval),
+		}); err != nil {
+			return
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func isCSVColumn(name string) bool {
+	for _, column := range csvColumns {
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCSVField sets the field of the tag, empty values are ignored.
+func parseCSVField(tag *TagDef, name, value string) (err error) {
+	if value == "" {
+		return
+	}
+	switch name {
+	case "name":
+		tag.Name = value
+	case "table":
+		tag.Table, err = ParseTable(value)
+	case "address":
+		tag.Address, err = parseCSVUint(name, value)
+	case "page":
+		tag.Page, err = parseCSVUint(name, value)
+	case "type":
+		tag.Type = value
+	case "order":
+		tag.Order = value
+	case "transforms":
+		tag.Transforms = value
+	case "scale":
+		tag.Scale, err = parseCSVFloat(name, value)
+	case "unit":
+		tag.Unit = value
+	case "target_unit":
+		tag.TargetUnit = value
+	case "min", "max":
+		var f float64
+		if f, err = parseCSVFloat(name, value); err != nil {
+			break
+		}
+		if name == "min" {
+			tag.Min = &f
+		} else {
+			tag.Max = &f
+		}
+	case "eeprom":
+		if tag.EEPROM, err = strconv.ParseBool(value); err != nil {
+			err = fmt.Errorf("invalid %v '%v'", name, value)
+		}
+	case "min_write_interval":
+		tag.MinWriteInterval, err = parseCSVFloat(name, value)
+	}
+	return
+}
+
+func parseCSVUint(name, value string) (uint16, error) {
+	v, err := strconv.ParseUint(value, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v '%v'", name, value)
+	}
+	return uint16(v), nil
+}
+
+func parseCSVFloat(name, value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v '%v'", name, value)
+	}
+	return v, nil
+}
+
+func formatCSVUint(v uint16) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+func formatCSVBool(v bool) string {
+	if !v {
+		return ""
+	}
+	return strconv.FormatBool(v)
+}
+
+func formatCSVScale(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return formatCSVFloat(&v)
+}
+
+func formatCSVFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'g', -1, 64)
+}
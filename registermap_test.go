@@ -0,0 +1,342 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterMapValidate(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "flow", Table: TableInputRegisters, Address: 0, Type: "float32", Order: "cdab"},
+		{Name: "total", Table: TableInputRegisters, Address: 2, Type: "uint32"},
+		{Name: "pump", Table: TableCoils, Address: 0},
+		{Name: "valve", Table: TableCoils, Address: 1, Type: "bool"},
+	}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Tags = append(m.Tags,
+		TagDef{Name: "flow", Table: TableHoldingRegisters, Address: 0},
+		TagDef{Name: "temperature", Table: TableInputRegisters, Address: 1},
+		TagDef{Name: "level", Table: TableHoldingRegisters, Address: 1, Type: "float16"},
+		TagDef{Name: "alarm", Table: TableCoils, Address: 1, Order: "cdab"},
+		TagDef{Name: "counter", Table: TableHoldingRegisters, Address: 65535, Type: "uint32"},
+		TagDef{Name: "setpoint", Table: TableInputRegisters, Address: 10, EEPROM: true},
+		TagDef{Name: "mode", Table: TableHoldingRegisters, Address: 10, MinWriteInterval: 60},
+	)
+	err := m.Validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("validation errors expected, actual %v", err)
+	}
+	expected := []string{
+		"modbus: tags[4].name: duplicate name 'flow' of tags[0]",
+		"modbus: tags[5].address: address '1' overlaps tags[0] at '0' to '1'",
+		"modbus: tags[6].type: invalid type 'float16' for table 'holding'",
+		"modbus: tags[7].order: order is not applicable to table 'coils'",
+		"modbus: tags[7].address: duplicate address '1' of tags[3]",
+		"modbus: tags[8].address: address '65535' plus quantity '2' exceeds '65536'",
+		"modbus: tags[9].eeprom: eeprom is not applicable to table 'input'",
+		"modbus: tags[10].min_write_interval: minimum write interval requires eeprom",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors:\n%v", err)
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], e.Error())
+		}
+	}
+}
+
+func TestRegisterMapValidateOverlaps(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "a", Table: TableHoldingRegisters, Address: 0, Type: "uint64"},
+		{Name: "b", Table: TableHoldingRegisters, Address: 1},
+		{Name: "c", Table: TableHoldingRegisters, Address: 2},
+		{Name: "d", Table: TableHoldingRegisters, Address: 4},
+	}}
+	errs, ok := m.Validate().(ValidationErrors)
+	if !ok {
+		t.Fatalf("validation errors expected")
+	}
+	expected := []string{
+		"modbus: tags[1].address: address '1' overlaps tags[0] at '0' to '3'",
+		"modbus: tags[2].address: address '2' overlaps tags[0] at '0' to '3'",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors:\n%v", errs)
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Errorf("expected %q, actual %q", expected[i], e.Error())
+		}
+	}
+}
+
+func TestRegisterMapDecode(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "energy", Table: TableInputRegisters, Address: 0, Type: "uint32", Order: "cdab", Unit: "kWh", TargetUnit: "MWh"},
+		{Name: "temperature", Table: TableHoldingRegisters, Address: 0, Type: "int16", Scale: 0.1, Unit: "degF", TargetUnit: "degC"},
+		{Name: "pump", Table: TableCoils, Address: 0},
+	}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tags := m.PlanTags()
+	if tags[0].Unit != "MWh" || tags[0].Quantity != 2 || tags[1].Unit != "degC" {
+		t.Fatalf("unexpected tags %+v", tags)
+	}
+	decoded, err := m.Decode(map[string][]uint16{
+		"energy":      {0x86A0, 0x0001},
+		"temperature": {2120},
+		"pump":        {1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded["energy"]; v.Value != 100 || v.Unit != "MWh" {
+		t.Fatalf("unexpected energy %+v", v)
+	}
+	if v := decoded["temperature"]; v.Value < 99.999 || v.Value > 100.001 || v.Unit != "degC" {
+		t.Fatalf("unexpected temperature %+v", v)
+	}
+	if v := decoded["pump"]; v.Value != 1 {
+		t.Fatalf("unexpected pump %+v", v)
+	}
+
+	m.Tags[0].TargetUnit = "bar"
+	m.Tags[1].Unit = ""
+	err = m.Validate()
+	if err == nil || err.Error() != "modbus: tags[0].target_unit: cannot convert energy to pressure\n"+
+		"modbus: tags[1].target_unit: target unit 'degC' requires unit" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRegisterMapTransforms(t *testing.T) {
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "alarm", Table: TableInputRegisters, Address: 0, Transforms: "bit=15"},
+		{Name: "total", Table: TableInputRegisters, Address: 1, Type: "uint32", Transforms: "bcd", Scale: 0.1, Unit: "m3"},
+		{Name: "mode", Table: TableHoldingRegisters, Address: 0, Transforms: "enum=1:10;2:20"},
+	}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := m.Decode(map[string][]uint16{
+		"alarm": {0x8001},
+		"total": {0x0012, 0x3456},
+		"mode":  {3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded["alarm"]; v.Value != 1 {
+		t.Fatalf("unexpected alarm %+v", v)
+	}
+	if v := decoded["total"]; math.Abs(v.Value-12345.6) > 1e-9 || v.Quality != QualityGood {
+		t.Fatalf("unexpected total %+v", v)
+	}
+	if v := decoded["mode"]; !math.IsNaN(v.Value) || v.Quality != QualityBad {
+		t.Fatalf("unexpected mode %+v", v)
+	}
+
+	memory := &memoryClient{}
+	device, err := NewDevice(singleWriteClient{memory}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = device.WriteTag("mode", 20); err != nil {
+		t.Fatal(err)
+	}
+	if memory.holding[0] != 2 {
+		t.Fatalf("unexpected register %v", memory.holding[0])
+	}
+	if err = device.WriteTag("mode", 30); err == nil {
+		t.Fatal("unmapped value written")
+	}
+
+	m.Tags[0].Transforms = "bit=16:1"
+	m.Tags[2] = TagDef{Name: "pump", Table: TableCoils, Address: 0, Transforms: "bcd"}
+	err = m.Validate()
+	if err == nil || err.Error() != "modbus: tags[0].transforms: invalid bit '16:1': first bit is greater than last bit\n"+
+		"modbus: tags[2].transforms: transforms are not applicable to table 'coils'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// glitchClient responds to the first reads of holding registers with
+// garbage.
+type glitchClient struct {
+	*memoryClient
+	glitches int
+}
+
+func (c *glitchClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	if c.glitches > 0 {
+		c.glitches--
+		return bytes.Repeat([]byte{0xFF}, 2*int(quantity)), nil
+	}
+	return c.memoryClient.ReadHoldingRegisters(address, quantity)
+}
+
+func TestRegisterMapPlausibility(t *testing.T) {
+	min, max := 0.0, 100.0
+	m := &RegisterMap{Tags: []TagDef{
+		{Name: "level", Table: TableHoldingRegisters, Address: 0, Type: "int16", Min: &min, Max: &max},
+		{Name: "count", Table: TableHoldingRegisters, Address: 10},
+	}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := (&ReadPlanner{}).Plan(m.PlanTags())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := &memoryClient{}
+	memory.holding[0] = 42
+	client := &glitchClient{memoryClient: memory, glitches: 1}
+
+	decoded, err := m.Read(client, plan, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded["level"]; v.Quality != QualityBad || v.Value != -1 {
+		t.Fatalf("unexpected level %+v", v)
+	}
+	if v := decoded["count"]; v.Quality != QualityGood {
+		t.Fatalf("unexpected count %+v", v)
+	}
+
+	client.glitches = 1
+	memory.requests = 0
+	if decoded, err = m.Read(client, plan, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded["level"]; v.Quality != QualityGood || v.Value != 42 {
+		t.Fatalf("unexpected level %+v", v)
+	}
+	// Only the block of level is read again.
+	if memory.requests != 2 {
+		t.Fatalf("unexpected requests %v", memory.requests)
+	}
+
+	m.Tags[0].Min = &max
+	m.Tags[0].Max = &min
+	if err = m.Validate(); err == nil || err.Error() != "modbus: tags[0].min: minimum '100' is greater than maximum '0'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestLoadRegisterMap(t *testing.T) {
+	m, err := LoadRegisterMap(strings.NewReader(`{
+  "name": "meter",
+  "tags": [
+    {"name": "flow", "table": "input", "address": 0, "type": "float32", "unit": "m3/h"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "meter" || len(m.Tags) != 1 || m.Tags[0].Table != TableInputRegisters || m.Tags[0].Quantity() != 2 {
+		t.Fatalf("unexpected register map %+v", m)
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"{\n  \"tags\": [\n    {\"name\": \"a\",}\n  ]\n}", "modbus: register map line 3, column 19: "},
+		{"{\n  \"tags\": [\n    {\"name\": \"a\", \"table\": \"holdings\"}\n  ]\n}", "modbus: unknown table 'holdings'"},
+		{"{\n  \"tags\": [\n    {\"name\": \"a\", \"address\": -1}\n  ]\n}", "modbus: register map line 3, column 32: "},
+		{"{\n  \"tags\": [\n    {\"name\": \"a\", \"adress\": 1}\n  ]\n}", "modbus: register map line 3, column 19: json: unknown field \"adress\""},
+		{`{"tags": [{"name": "a", "table": "holding", "address": 1, "type": "int"}]}`, "modbus: tags[0].type: invalid type 'int' for table 'holding'"},
+	}
+	for _, test := range tests {
+		_, err = LoadRegisterMap(strings.NewReader(test.data))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("expected error %q, actual %v", test.err, err)
+		}
+	}
+}
+
+func TestRegisterMapSchema(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(RegisterMapSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	// Types of the schema are the ones supported by Validate
+	tag := schema["$defs"].(map[string]interface{})["tag"].(map[string]interface{})
+	types := tag["properties"].(map[string]interface{})["type"].(map[string]interface{})["enum"].([]interface{})
+	if len(types) != len(registerTypeSizes)+1 {
+		t.Fatalf("unexpected types %v", types)
+	}
+	for _, typ := range types {
+		if _, ok := registerTypeSizes[typ.(string)]; !ok && typ != "bool" {
+			t.Errorf("unsupported type %v", typ)
+		}
+	}
+}
+
+func TestRegisterMapCSV(t *testing.T) {
+	m, err := LoadRegisterMapCSV(strings.NewReader(`name,table,address,type,order,scale,unit,min
+flow_rate,input,0,float32,cdab,,m3/h,0
+temperature,holding,0x10,int16,,0.1,degC,
+pump,coils,3,,,,,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Tags) != 3 || m.Tags[0].Order != "cdab" || *m.Tags[0].Min != 0 ||
+		m.Tags[1].Address != 16 || m.Tags[1].Scale != 0.1 || m.Tags[1].Min != nil || m.Tags[2].Table != TableCoils {
+		t.Fatalf("unexpected register map %+v", m)
+	}
+	var buf bytes.Buffer
+	if err = WriteRegisterMapCSV(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRegisterMapCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, loaded) {
+		t.Fatalf("expected %+v, actual %+v", m, loaded)
+	}
+	buf.Reset()
+	if err = WriteRegisterMap(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadRegisterMap(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, loaded) {
+		t.Fatalf("expected %+v, actual %+v", m, loaded)
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"name,table,adress\n", "modbus: register map line 1, column 3: unknown column 'adress'"},
+		{"name,table\n", "modbus: register map column 'address' is missing"},
+		{"name,table,address\na,holding,1\nb,holding,-1\n", "modbus: register map line 3, column 3: invalid address '-1'"},
+		{"name,table,address\na,holdings,1\n", "modbus: register map line 2, column 2: modbus: unknown table 'holdings'"},
+		{"name,table,address\na,holding\n", "modbus: register map: record on line 2: wrong number of fields"},
+		{"name,table,address\na,holding,1\na,holding,2\n", "modbus: tags[1].name: duplicate name 'a' of tags[0]"},
+	}
+	for _, test := range tests {
+		_, err = LoadRegisterMapCSV(strings.NewReader(test.data))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("expected error %q, actual %v", test.err, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Each recorded exchange is one line of text: the request ADU in hex
+// followed by either the response ADU in hex or '!' and the error message.
+//  0001000000060103000a0001 000100000005010302002a
+//  0002000000060103000a0001 !i/o timeout
+
+// RecordingTransporter wraps a Transporter and records all request and
+// response ADUs, so that the traffic can be replayed later with
+// ReplayTransporter:
+//  handler := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  recorder := modbus.NewRecordingTransporter(handler, file)
+//  client := modbus.NewClient2(handler, recorder)
+type RecordingTransporter struct {
+	Transporter Transporter
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewRecordingTransporter allocates a new RecordingTransporter writing
+// records to w.
+func NewRecordingTransporter(transporter Transporter, w io.Writer) *RecordingTransporter {
+	return &RecordingTransporter{Transporter: transporter, w: w}
+}
+
+// Send sends the request with the underlying transporter and records the
+// exchange. Recording errors are returned only if sending succeeded.
+func (mb *RecordingTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	aduResponse, err = mb.Transporter.Send(aduRequest)
+
+	var line bytes.Buffer
+	line.WriteString(hex.EncodeToString(aduRequest))
+	line.WriteByte(' ')
+	if err != nil {
+		line.WriteByte('!')
+		// Keep one record per line
+		line.WriteString(strings.Replace(err.Error(), "\n", " ", -1))
+	} else {
+		line.WriteString(hex.EncodeToString(aduResponse))
+	}
+	line.WriteByte('\n')
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if _, werr := mb.w.Write(line.Bytes()); werr != nil && err == nil {
+		err = werr
+	}
+	return
+}
+
+type replayRecord struct {
+	request  []byte
+	response []byte
+	err      error
+}
+
+// ReplayTransporter returns responses recorded by RecordingTransporter in
+// the same order, without any device. Requests must match the recorded
+// ones.
+type ReplayTransporter struct {
+	mu      sync.Mutex
+	records []replayRecord
+	next    int
+}
+
+// NewReplayTransporter reads all records from r.
+func NewReplayTransporter(r io.Reader) (*ReplayTransporter, error) {
+	mb := &ReplayTransporter{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("modbus: invalid record at line '%v'", lineNumber)
+		}
+		var record replayRecord
+		var err error
+		if record.request, err = hex.DecodeString(fields[0]); err != nil {
+			return nil, fmt.Errorf("modbus: invalid request at line '%v': %v", lineNumber, err)
+		}
+		if strings.HasPrefix(fields[1], "!") {
+			record.err = errors.New(fields[1][1:])
+		} else if record.response, err = hex.DecodeString(fields[1]); err != nil {
+			return nil, fmt.Errorf("modbus: invalid response at line '%v': %v", lineNumber, err)
+		}
+		mb.records = append(mb.records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mb, nil
+}
+
+// Send returns the next recorded response or error.
+func (mb *ReplayTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.next >= len(mb.records) {
+		err = fmt.Errorf("modbus: no more recorded responses after '%v' records", len(mb.records))
+		return
+	}
+	record := mb.records[mb.next]
+	if !bytes.Equal(record.request, aduRequest) {
+		err = fmt.Errorf("modbus: request '% x' does not match recorded request '% x'", aduRequest, record.request)
+		return
+	}
+	mb.next++
+	if record.err != nil {
+		err = record.err
+		return
+	}
+	aduResponse = append([]byte(nil), record.response...)
+	return
+}
+
+// Remaining returns the number of records which have not been replayed.
+func (mb *ReplayTransporter) Remaining() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	return len(mb.records) - mb.next
+}
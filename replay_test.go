@@ -0,0 +1,66 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type transporterFunc func(aduRequest []byte) ([]byte, error)
+
+func (f transporterFunc) Send(aduRequest []byte) ([]byte, error) {
+	return f(aduRequest)
+}
+
+func TestRecordReplay(t *testing.T) {
+	responses := [][]byte{
+		{0, 1, 0, 0, 0, 5, 0, 3, 2, 0, 42},
+		nil,
+	}
+	var calls int
+	device := transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		response := responses[calls]
+		calls++
+		if response == nil {
+			return nil, errors.New("i/o timeout")
+		}
+		return response, nil
+	})
+	var buf bytes.Buffer
+	handler := &tcpPackager{}
+	client := NewClient2(handler, NewRecordingTransporter(device, &buf))
+	results, err := client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReadHoldingRegisters(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+
+	replay, err := NewReplayTransporter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewClient2(&tcpPackager{}, replay)
+	replayed, err := client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(results, replayed) {
+		t.Fatalf("replayed results expected %v, actual %v", results, replayed)
+	}
+	if _, err = client.ReadHoldingRegisters(0, 1); err == nil || err.Error() != "i/o timeout" {
+		t.Fatalf("unexpected replayed error: %v", err)
+	}
+	if replay.Remaining() != 0 {
+		t.Fatalf("remaining records: %v", replay.Remaining())
+	}
+	// Request does not match
+	if _, err = client.ReadCoils(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// NoResponseError is returned when no byte of the response is received
+// before the timeout, usually a device which is off, disconnected or
+// configured with another slave id:
+//  var noResponse *modbus.NoResponseError
+//  if errors.As(err, &noResponse) {
+//  	// Check the device and its wiring
+//  }
+// It unwraps to the timeout error of the transport, serial.ErrTimeout or
+// a net.Error.
+type NoResponseError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+// Error returns the error message.
+func (e *NoResponseError) Error() string {
+	return fmt.Sprintf("modbus: no response received within '%v'", e.Timeout)
+}
+
+// Unwrap returns the timeout error of the transport.
+func (e *NoResponseError) Unwrap() error {
+	return e.Err
+}
+
+// PartialResponseError is returned when the response stops before its end,
+// usually a wrong baud rate or framing, or a device too slow for the
+// inter-character timeout. It unwraps to the timeout error of the
+// transport.
+type PartialResponseError struct {
+	// Received is the number of bytes received.
+	Received int
+	// Expected is the number of bytes of the response, 0 if unknown.
+	Expected int
+	// Timeout is the time waited for the next byte.
+	Timeout time.Duration
+	Err     error
+}
+
+// Error returns the error message.
+func (e *PartialResponseError) Error() string {
+	return fmt.Sprintf("modbus: no character received for '%v' after '%v' bytes of the response", e.Timeout, e.Received)
+}
+
+// Unwrap returns the timeout error of the transport.
+func (e *PartialResponseError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError is returned when the CRC of a RTU response or the LRC of
+// an ASCII response does not match its content, usually noise on the line.
+type ChecksumError struct {
+	// Checksum is "crc" or "lrc".
+	Checksum string
+	Received uint16
+	Expected uint16
+}
+
+// Error returns the error message.
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("modbus: response %v '%v' does not match expected '%v'", e.Checksum, e.Received, e.Expected)
+}
+
+// responseTimeoutError returns err as a NoResponseError or
+// PartialResponseError if it is a timeout of a net.Conn after received
+// bytes of the response.
+func responseTimeoutError(err error, received int, timeout time.Duration) error {
+	var netError net.Error
+	if !errors.As(err, &netError) || !netError.Timeout() {
+		return err
+	}
+	if received == 0 {
+		return &NoResponseError{Timeout: timeout, Err: err}
+	}
+	return &PartialResponseError{Received: received, Timeout: timeout, Err: err}
+}
+
+// isTimeout returns true if err is a timeout of a net.Conn.
+func isTimeout(err error) bool {
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
+
+// expectResponse sets the expected length of the response to err if it is
+// a PartialResponseError.
+func expectResponse(err error, expected int) {
+	var partial *PartialResponseError
+	if errors.As(err, &partial) && partial.Expected == 0 {
+		partial.Expected = expected
+	}
+}
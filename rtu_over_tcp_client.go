@@ -5,8 +5,8 @@
 package modbus
 
 import (
+	"context"
 	"io"
-	"time"
 )
 
 // RTUOverTCPClientHandler implements Packager and Transporter interface.
@@ -15,6 +15,11 @@ type RTUOverTCPClientHandler struct {
 	rtuTCPTransporter
 }
 
+// buffered implements bufferTransporter.
+func (mb *RTUOverTCPClientHandler) buffered() Transporter {
+	return mb
+}
+
 // NewRTUOverTCPClientHandler allocates and initializes a RTUOverTCPClientHandler.
 func NewRTUOverTCPClientHandler(address string) *RTUOverTCPClientHandler {
 	handler := &RTUOverTCPClientHandler{}
@@ -36,45 +41,51 @@ type rtuTCPTransporter struct {
 }
 
 func (mb *rtuTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.tcpTransporter.mu.Lock()
-	defer mb.tcpTransporter.mu.Unlock()
+	return mb.sendBuffer(context.Background(), new(aduBuffer), aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (mb *rtuTCPTransporter) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	if !heldByBatch(ctx, &mb.tcpTransporter.mu) {
+		mb.tcpTransporter.mu.Lock()
+		defer mb.tcpTransporter.mu.Unlock()
+	}
+	defer mb.tcpTransporter.notifyError(&err)
+	defer mb.tcpTransporter.closeFailed(&err)
 
 	// Establish a new connection if not connected
 	if err = mb.tcpTransporter.connect(); err != nil {
 		return
 	}
+	mb.tcpTransporter.pace(clockOrSystem(mb.tcpTransporter.Clock))
+	defer mb.tcpTransporter.paced(clockOrSystem(mb.tcpTransporter.Clock))
 	// Set timer to close when idle
-	mb.tcpTransporter.lastActivity = time.Now()
+	mb.tcpTransporter.lastActivity = mb.tcpTransporter.now()
 	mb.tcpTransporter.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
-	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
-		return
-	}
-
 	// Send the request
-	mb.tcpTransporter.logf("modbus: sending % x\n", aduRequest)
-	if _, err = mb.conn.Write(aduRequest); err != nil {
+	mb.tcpTransporter.logFrame(frameRTU, true, aduRequest)
+	if err = mb.tcpTransporter.send(aduRequest, aduRequest[0]); err != nil {
 		return
 	}
+	defer mb.tcpTransporter.observeResponse(aduRequest[0], mb.tcpTransporter.now(), &err)
 	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
+	functionFail := aduRequest[1] | 0x80
 	bytesToRead := calculateResponseLength(aduRequest)
 
 	var n int
 	var n1 int
-	var data [rtuMaxSize]byte
+	data := buf[:rtuMaxSize]
 	//We first read the minimum length and then read either the full package
 	//or the error package, depending on the error status (byte 2 of the response)
-	n, err = io.ReadAtLeast(mb.conn, data[:], rtuMinSize)
+	n, err = io.ReadAtLeast(mb.conn, data, rtuMinSize)
 	if err != nil {
+		err = mb.tcpTransporter.responseError(err, n, aduRequest[0])
+		expectResponse(err, bytesToRead)
 		return
 	}
 	//if the function is correct
 	if data[1] == function {
+		bytesToRead = receivedResponseLength(aduRequest, data, bytesToRead)
 		//we read the rest of the bytes
 		if n < bytesToRead {
 			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
@@ -86,6 +97,7 @@ func (mb *rtuTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err er
 		}
 	} else if data[1] == functionFail {
 		//for error we need to read 5 bytes
+		bytesToRead = rtuExceptionSize
 		if n < rtuExceptionSize {
 			n1, err = io.ReadFull(mb.conn, data[n:rtuExceptionSize])
 		}
@@ -93,9 +105,11 @@ func (mb *rtuTCPTransporter) Send(aduRequest []byte) (aduResponse []byte, err er
 	}
 
 	if err != nil {
+		err = mb.tcpTransporter.responseError(err, n, aduRequest[0])
+		expectResponse(err, bytesToRead)
 		return
 	}
 	aduResponse = data[:n]
-	mb.logf("modbus: received % x\n", aduResponse)
+	mb.logFrame(frameRTU, false, aduResponse)
 	return
 }
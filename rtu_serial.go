@@ -0,0 +1,197 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// RTUClientHandler implements Packager and Transporter interface.
+type RTUClientHandler struct {
+	rtuPackager
+	rtuSerialTransporter
+}
+
+// buffered implements bufferTransporter.
+func (mb *RTUClientHandler) buffered() Transporter {
+	return mb
+}
+
+// NewRTUClientHandler allocates and initializes a RTUClientHandler.
+func NewRTUClientHandler(address string) *RTUClientHandler {
+	handler := &RTUClientHandler{}
+	handler.Address = address
+	handler.Timeout = serialTimeout
+	handler.IdleTimeout = serialIdleTimeout
+	handler.BroadcastDelay = serialBroadcastDelay
+	return handler
+}
+
+// RTUClient creates RTU client with default handler and given connect string.
+func RTUClient(address string) Client {
+	handler := NewRTUClientHandler(address)
+	return NewClient(handler)
+}
+
+// rtuSerialTransporter implements Transporter interface.
+type rtuSerialTransporter struct {
+	serialPort
+	// StrictFrameDelay guarantees at least 3.5 character times of silence
+	// between the end of a response and the next request, as some slaves
+	// do not recognize frames sent back-to-back.
+	StrictFrameDelay bool
+}
+
+func (mb *rtuSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendRTU(context.Background(), new(aduBuffer), aduRequest, mb.StrictFrameDelay)
+}
+
+// SendContext is Send returning early with the error of ctx when it is
+// done, see ContextTransporter.
+func (mb *rtuSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendRTU(ctx, new(aduBuffer), aduRequest, mb.StrictFrameDelay)
+}
+
+// sendBuffer implements bufferTransporter.
+func (mb *rtuSerialTransporter) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.serialPort.sendRTU(ctx, buf, aduRequest, mb.StrictFrameDelay)
+}
+
+// sendRTU sends a RTU frame and reads the response into buf.
+func (mb *serialPort) sendRTU(ctx context.Context, buf *aduBuffer, aduRequest []byte, strictFrameDelay bool) (aduResponse []byte, err error) {
+	broadcast := aduRequest[0] == 0
+	if broadcast {
+		if err = checkBroadcast(aduRequest[1]); err != nil {
+			return
+		}
+	}
+	if !heldByBatch(ctx, &mb.mu) {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+	}
+	defer mb.notifyError(&err)
+
+	if err = mb.checkRTUFormat(); err != nil {
+		return
+	}
+	// Make sure port is connected
+	if err = mb.connect(); err != nil {
+		return
+	}
+	mb.waitTurnaround()
+	mb.pace(clockOrSystem(mb.Clock))
+	defer mb.paced(clockOrSystem(mb.Clock))
+	// Start the timer to close when idle
+	mb.lastActivity = mb.now()
+	mb.startCloseTimer()
+	if !broadcast {
+		defer mb.startTurnaround(aduRequest[1])
+	}
+
+	for attempt := 0; ; attempt++ {
+		aduResponse, err = mb.exchangeRTU(ctx, buf, aduRequest, broadcast, strictFrameDelay)
+		if err != nil || len(aduResponse) == 0 || rtuChecksumValid(aduResponse) || !mb.retryChecksum(attempt) {
+			return
+		}
+	}
+}
+
+// exchangeRTU sends the request and reads the response. Caller must hold
+// the mutex.
+func (mb *serialPort) exchangeRTU(ctx context.Context, buf *aduBuffer, aduRequest []byte, broadcast, strictFrameDelay bool) (aduResponse []byte, err error) {
+	if strictFrameDelay && !mb.lastReceive.IsZero() {
+		if wait := mb.lastReceive.Add(mb.frameDelay()).Sub(mb.now()); wait > 0 {
+			mb.sleep(wait)
+		}
+	}
+	// Discard data pending from previous exchanges
+	if err = mb.flush(); err != nil {
+		return
+	}
+	defer mb.exchanged(&err)
+	// Send the request
+	mb.logFrame(frameRTU, true, aduRequest)
+	if err = mb.write(aduRequest); err != nil {
+		return
+	}
+	defer func() {
+		mb.lastReceive = mb.now()
+	}()
+	if broadcast {
+		// Slaves do not respond, wait for the request to be processed.
+		mb.sleep(mb.calculateDelay(len(aduRequest)) + mb.BroadcastDelay)
+		return
+	}
+	defer mb.observeResponse(aduRequest[0], mb.now(), &err)
+	function := aduRequest[1]
+	functionFail := aduRequest[1] | 0x80
+	bytesToRead := calculateResponseLength(aduRequest)
+	mb.sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
+
+	var n int
+	var n1 int
+	data := buf[:rtuMaxSize]
+	port := &portReader{mb: mb, ctx: ctx, timeout: mb.slaveTimeout(aduRequest[0], mb.responseTimeout())}
+	//We first read the minimum length and then read either the full package
+	//or the error package, depending on the error status (byte 2 of the response)
+	n, err = io.ReadAtLeast(port, data, rtuMinSize)
+	if err != nil {
+		expectResponse(err, bytesToRead)
+		return
+	}
+	//if the function is correct
+	if data[1] == function {
+		bytesToRead = receivedResponseLength(aduRequest, data, bytesToRead)
+		//we read the rest of the bytes
+		if n < bytesToRead {
+			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
+				if bytesToRead > n {
+					n1, err = io.ReadFull(port, data[n:bytesToRead])
+					n += n1
+				}
+			}
+		}
+	} else if data[1] == functionFail {
+		//for error we need to read 5 bytes
+		bytesToRead = rtuExceptionSize
+		if n < rtuExceptionSize {
+			n1, err = io.ReadFull(port, data[n:rtuExceptionSize])
+		}
+		n += n1
+	}
+
+	if err != nil {
+		expectResponse(err, bytesToRead)
+		return
+	}
+	aduResponse = data[:n]
+	mb.logFrame(frameRTU, false, aduResponse)
+	return
+}
+
+// calculateDelay roughly calculates time needed for the next frame.
+// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
+func (mb *serialPort) calculateDelay(chars int) time.Duration {
+	var characterDelay, frameDelay int // us
+
+	if mb.BaudRate <= 0 || mb.BaudRate > 19200 {
+		characterDelay = 750
+		frameDelay = 1750
+	} else {
+		characterDelay = 15000000 / mb.BaudRate
+		frameDelay = 35000000 / mb.BaudRate
+	}
+	return time.Duration(characterDelay*chars+frameDelay) * time.Microsecond
+}
+
+// frameDelay returns the t3.5 inter-frame silence for the baud rate.
+func (mb *serialPort) frameDelay() time.Duration {
+	return rtuFrameDelay(mb.BaudRate)
+}
+
@@ -7,7 +7,6 @@ package modbus
 import (
 	"encoding/binary"
 	"fmt"
-	"io"
 	"time"
 )
 
@@ -18,30 +17,31 @@ const (
 	rtuExceptionSize = 5
 )
 
-// RTUClientHandler implements Packager and Transporter interface.
-type RTUClientHandler struct {
-	rtuPackager
-	rtuSerialTransporter
+// NewRTUPackager returns a packager of RTU frames of slaveId, to encode and
+// decode frames without transporter, e.g. in protocol analyzers or custom
+// transports:
+//  packager := modbus.NewRTUPackager(1)
+//  adu, err := packager.Encode(&modbus.ProtocolDataUnit{FunctionCode: 3, Data: data})
+//  pdu, err := packager.Decode(aduResponse)
+// Decode checks the CRC of requests and responses, Verify that a response
+// matches the request.
+func NewRTUPackager(slaveId byte) Packager {
+	return &rtuPackager{SlaveId: slaveId}
 }
 
-// NewRTUClientHandler allocates and initializes a RTUClientHandler.
-func NewRTUClientHandler(address string) *RTUClientHandler {
-	handler := &RTUClientHandler{}
-	handler.Address = address
-	handler.Timeout = serialTimeout
-	handler.IdleTimeout = serialIdleTimeout
-	return handler
+// rtuPackager implements Packager interface.
+type rtuPackager struct {
+	SlaveId byte
 }
 
-// RTUClient creates RTU client with default handler and given connect string.
-func RTUClient(address string) Client {
-	handler := NewRTUClientHandler(address)
-	return NewClient(handler)
+// withSlaveId implements slavePackager.
+func (mb *rtuPackager) withSlaveId(slaveId byte) Packager {
+	return &rtuPackager{SlaveId: slaveId}
 }
 
-// rtuPackager implements Packager interface.
-type rtuPackager struct {
-	SlaveId byte
+// isBroadcast implements broadcastPackager.
+func (mb *rtuPackager) isBroadcast(aduRequest []byte) bool {
+	return len(aduRequest) > 0 && aduRequest[0] == 0
 }
 
 // Encode encodes PDU in a RTU frame:
@@ -50,12 +50,16 @@ type rtuPackager struct {
 //  Data            : 0 up to 252 bytes
 //  CRC             : 2 byte
 func (mb *rtuPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	return mb.encodeTo(make([]byte, 0, len(pdu.Data)+4), pdu)
+}
+
+// encodeTo implements bufferEncoder.
+func (mb *rtuPackager) encodeTo(adu []byte, pdu *ProtocolDataUnit) ([]byte, error) {
 	length := len(pdu.Data) + 4
 	if length > rtuMaxSize {
-		err = fmt.Errorf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize)
-		return
+		return nil, fmt.Errorf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize)
 	}
-	adu = make([]byte, length)
+	adu = adu[:length]
 
 	adu[0] = mb.SlaveId
 	adu[1] = pdu.FunctionCode
@@ -68,7 +72,7 @@ func (mb *rtuPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 
 	adu[length-1] = byte(checksum >> 8)
 	adu[length-2] = byte(checksum)
-	return
+	return adu, nil
 }
 
 // Verify verifies response length and slave id.
@@ -84,18 +88,27 @@ func (mb *rtuPackager) Verify(aduRequest []byte, aduResponse []byte) (err error)
 		err = fmt.Errorf("modbus: response slave id '%v' does not match request '%v'", aduResponse[0], aduRequest[0])
 		return
 	}
+	// Function code must match, or be the exception of the request
+	if aduResponse[1] != aduRequest[1] && aduResponse[1] != aduRequest[1]|0x80 {
+		err = fmt.Errorf("modbus: response function code '%v' does not match request '%v'", aduResponse[1], aduRequest[1])
+		return
+	}
 	return
 }
 
 // Decode extracts PDU from RTU frame and verify CRC.
 func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	length := len(adu)
+	if length < rtuMinSize || length > rtuMaxSize {
+		err = fmt.Errorf("modbus: frame length '%v' must be between '%v' and '%v'", length, rtuMinSize, rtuMaxSize)
+		return
+	}
 	// Calculate checksum
 	var crc crc
 	crc.reset().pushBytes(adu[0 : length-2])
 	checksum := uint16(adu[length-1])<<8 | uint16(adu[length-2])
 	if checksum != crc.value() {
-		err = fmt.Errorf("modbus: response crc '%v' does not match expected '%v'", checksum, crc.value())
+		err = &ChecksumError{Checksum: "crc", Received: checksum, Expected: crc.value()}
 		return
 	}
 	// Function code & data
@@ -105,79 +118,22 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	return
 }
 
-// rtuSerialTransporter implements Transporter interface.
-type rtuSerialTransporter struct {
-	serialPort
-}
-
-func (mb *rtuSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	// Make sure port is connected
-	if err = mb.serialPort.connect(); err != nil {
-		return
-	}
-	// Start the timer to close when idle
-	mb.serialPort.lastActivity = time.Now()
-	mb.serialPort.startCloseTimer()
-
-	// Send the request
-	mb.serialPort.logf("modbus: sending % x\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
-		return
-	}
-	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
-	bytesToRead := calculateResponseLength(aduRequest)
-	time.Sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
-
-	var n int
-	var n1 int
-	var data [rtuMaxSize]byte
-	//We first read the minimum length and then read either the full package
-	//or the error package, depending on the error status (byte 2 of the response)
-	n, err = io.ReadAtLeast(mb.port, data[:], rtuMinSize)
-	if err != nil {
-		return
-	}
-	//if the function is correct
-	if data[1] == function {
-		//we read the rest of the bytes
-		if n < bytesToRead {
-			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
-				if bytesToRead > n {
-					n1, err = io.ReadFull(mb.port, data[n:bytesToRead])
-					n += n1
-				}
-			}
-		}
-	} else if data[1] == functionFail {
-		//for error we need to read 5 bytes
-		if n < rtuExceptionSize {
-			n1, err = io.ReadFull(mb.port, data[n:rtuExceptionSize])
-		}
-		n += n1
-	}
-
-	if err != nil {
-		return
+// rtuChecksumValid returns false if the CRC of the frame does not match.
+func rtuChecksumValid(adu []byte) bool {
+	length := len(adu)
+	if length < rtuMinSize {
+		return true
 	}
-	aduResponse = data[:n]
-	mb.serialPort.logf("modbus: received % x\n", aduResponse)
-	return
+	var crc crc
+	crc.reset().pushBytes(adu[0 : length-2])
+	return uint16(adu[length-1])<<8|uint16(adu[length-2]) == crc.value()
 }
 
-// calculateDelay roughly calculates time needed for the next frame.
-// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
-func (mb *rtuSerialTransporter) calculateDelay(chars int) time.Duration {
-	var characterDelay, frameDelay int // us
-
-	if mb.BaudRate <= 0 || mb.BaudRate > 19200 {
-		characterDelay = 750
-		frameDelay = 1750
-	} else {
-		characterDelay = 15000000 / mb.BaudRate
-		frameDelay = 35000000 / mb.BaudRate
+func rtuFrameDelay(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
 	}
-	return time.Duration(characterDelay*chars+frameDelay) * time.Microsecond
+	return time.Duration(35000000/baudRate) * time.Microsecond
 }
 
 func calculateResponseLength(adu []byte) int {
@@ -202,9 +158,28 @@ func calculateResponseLength(adu []byte) int {
 		length += 4
 	case FuncCodeMaskWriteRegister:
 		length += 6
+	case FuncCodeDiagnostics:
+		// Echo of the request
+		length = len(adu)
 	case FuncCodeReadFIFOQueue:
 		// undetermined
 	default:
 	}
 	return length
 }
+
+// receivedResponseLength returns the length of the response once its byte
+// count is received, when it is not given by the request: reports of slave
+// id, and reads of 32-bit Enron Modbus registers whose byte count is 4 per
+// register, see EnronClient.
+func receivedResponseLength(aduRequest, aduResponse []byte, length int) int {
+	switch aduResponse[1] {
+	case FuncCodeReportSlaveId:
+		return rtuMinSize + 1 + int(aduResponse[2])
+	case FuncCodeReadHoldingRegisters:
+		if count := int(aduResponse[2]); count == 4*int(binary.BigEndian.Uint16(aduRequest[4:])) {
+			return rtuMinSize + 1 + count
+		}
+	}
+	return length
+}
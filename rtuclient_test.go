@@ -56,6 +56,7 @@ var responseLengthTests = []struct {
 	{[]byte{0x11, 6, 0, 1, 0, 3, 0x9A, 0x9B}, 8},
 	{[]byte{0x11, 0xF, 0, 0x13, 0, 0xA, 2, 0xCD, 1, 0xBF, 0xB}, 8},
 	{[]byte{0x11, 0x10, 0, 1, 0, 2, 4, 0, 0xA, 1, 2, 0xC6, 0xF0}, 8},
+	{[]byte{1, 8, 0, 0, 0xA5, 0x5A, 0x1F, 0x84}, 8},
 }
 
 func TestCalculateResponseLength(t *testing.T) {
@@ -96,3 +97,60 @@ func BenchmarkRTUDecoder(b *testing.B) {
 		}
 	}
 }
+
+func TestNewPackagers(t *testing.T) {
+	request := &ProtocolDataUnit{FunctionCode: 3, Data: []byte{0, 4, 0, 3}}
+	for _, packager := range []Packager{NewRTUPackager(17), NewASCIIPackager(17), NewTCPPackager(17)} {
+		adu, err := packager.Encode(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = packager.Verify(adu, adu); err != nil {
+			t.Fatalf("%T: %v", packager, err)
+		}
+		pdu, err := packager.Decode(adu)
+		if err != nil {
+			t.Fatalf("%T: %v", packager, err)
+		}
+		if pdu.FunctionCode != request.FunctionCode || !bytes.Equal(pdu.Data, request.Data) {
+			t.Fatalf("%T: unexpected pdu %v", packager, pdu)
+		}
+	}
+}
+
+func FuzzPackagerDecode(f *testing.F) {
+	request := &ProtocolDataUnit{FunctionCode: 3, Data: []byte{0, 4, 0, 3}}
+	packagers := []Packager{NewRTUPackager(17), NewASCIIPackager(17), NewTCPPackager(17)}
+	for _, packager := range packagers {
+		adu, err := packager.Encode(request)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(adu)
+	}
+	f.Add([]byte{1})
+	f.Add([]byte(":\r\n"))
+	f.Fuzz(func(t *testing.T, adu []byte) {
+		for _, packager := range packagers {
+			request, _ := packager.Encode(request)
+			// Must not panic
+			packager.Verify(request, adu)
+			packager.Decode(adu)
+		}
+	})
+}
+
+func TestEmptyResponse(t *testing.T) {
+	silent := transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		return nil, nil
+	})
+	results, err := NewClient2(NewRTUPackager(0), silent).WriteSingleRegister(1, 2)
+	if err != nil || !bytes.Equal(results, []byte{0, 2}) {
+		t.Fatalf("unexpected broadcast results %x, error %v", results, err)
+	}
+	for _, packager := range []Packager{NewRTUPackager(1), NewASCIIPackager(1), NewTCPPackager(1)} {
+		if _, err = NewClient2(packager, silent).WriteSingleRegister(1, 2); err == nil {
+			t.Fatalf("%T: error expected", packager)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScanResult is a slave which responded to a scan probe.
+type ScanResult struct {
+	SlaveId byte
+	Latency time.Duration
+	// Err is the exception returned by the slave, if any.
+	Err *ModbusError
+	// Data is the response to a report slave id probe.
+	Data []byte
+}
+
+// Scanner discovers slaves on a serial bus, or unit ids behind a TCP
+// gateway, by probing each id of a range:
+//  handler := modbus.NewRTUClientHandler("/dev/ttyUSB0")
+//  handler.Timeout = 100 * time.Millisecond
+//  scanner := modbus.NewScanner(handler)
+//  results, err := scanner.Scan(1, 247)
+// A slave is found if it responds, even with an exception other than a
+// gateway exception. Probes are sent with WithSlaveId, the slave id of
+// the handler is not changed and other clients may use it during the scan.
+// A short timeout on the handler keeps the scan of absent ids fast.
+type Scanner struct {
+	Handler ClientHandler
+	// FunctionCode of the probe: FuncCodeReadHoldingRegisters (default)
+	// reads one register at Address, FuncCodeReportSlaveId asks the slave
+	// for its description and is only supported on serial lines.
+	FunctionCode byte
+	Address      uint16
+	// Found is called with each slave found, if set.
+	Found func(result *ScanResult)
+	// Clock measures the latencies, it defaults to the system time if nil.
+	Clock Clock
+}
+
+// NewScanner allocates a new Scanner probing with a read of one holding
+// register at address 0.
+func NewScanner(handler ClientHandler) *Scanner {
+	return &Scanner{Handler: handler, FunctionCode: FuncCodeReadHoldingRegisters}
+}
+
+// Scan probes ids from first to last, inclusive, and returns the slaves
+// which responded.
+func (s *Scanner) Scan(first, last byte) (results []*ScanResult, err error) {
+	if _, ok := s.Handler.(slavePackager); !ok {
+		err = fmt.Errorf("modbus: handler '%T' does not support scanning", s.Handler)
+		return
+	}
+	var request *ProtocolDataUnit
+	switch s.FunctionCode {
+	case 0, FuncCodeReadHoldingRegisters:
+		request = &ProtocolDataUnit{
+			FunctionCode: FuncCodeReadHoldingRegisters,
+			Data:         dataBlock(s.Address, 1),
+		}
+	case FuncCodeReportSlaveId:
+		request = &ProtocolDataUnit{FunctionCode: FuncCodeReportSlaveId}
+	default:
+		err = fmt.Errorf("modbus: function '%v' is not supported by the scanner", s.FunctionCode)
+		return
+	}
+	if first == 0 {
+		// Broadcasts are not answered
+		first = 1
+	}
+	base := NewClient(s.Handler)
+	clock := clockOrSystem(s.Clock)
+	for id := int(first); id <= int(last); id++ {
+		c := WithSlaveId(base, byte(id)).(*client)
+		start := clock.Now()
+		response, probeErr := c.roundTrip(request)
+		if probeErr != nil {
+			continue
+		}
+		result := &ScanResult{SlaveId: byte(id), Latency: clock.Now().Sub(start)}
+		switch response.FunctionCode {
+		case request.FunctionCode:
+			if request.FunctionCode == FuncCodeReportSlaveId && len(response.Data) > 0 {
+				result.Data = response.Data[1:]
+			}
+		case request.FunctionCode | 0x80:
+			mbError, ok := responseError(response).(*ModbusError)
+			if !ok {
+				// Gateway exceptions report ids not found behind it
+				continue
+			}
+			result.Err = mbError
+		default:
+			continue
+		}
+		results = append(results, result)
+		if s.Found != nil {
+			s.Found(result)
+		}
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// busHandler simulates slaves of a RTU bus. Slaves respond with their
+// exception code if not zero.
+type busHandler struct {
+	rtuPackager
+	slaves map[byte]byte
+}
+
+func (mb *busHandler) Send(aduRequest []byte) ([]byte, error) {
+	exceptionCode, ok := mb.slaves[aduRequest[0]]
+	if !ok {
+		return nil, fmt.Errorf("serial: timeout")
+	}
+	slave := &rtuPackager{SlaveId: aduRequest[0]}
+	if exceptionCode != 0 {
+		return slave.Encode(&ProtocolDataUnit{aduRequest[1] | 0x80, []byte{exceptionCode}})
+	}
+	if aduRequest[1] == FuncCodeReportSlaveId {
+		return slave.Encode(&ProtocolDataUnit{aduRequest[1], []byte{2, aduRequest[0], 0xFF}})
+	}
+	return slave.Encode(&ProtocolDataUnit{aduRequest[1], []byte{2, 0, 0}})
+}
+
+func TestScanner(t *testing.T) {
+	handler := &busHandler{slaves: map[byte]byte{3: 0, 7: ExceptionCodeIllegalDataAddress, 200: 0}}
+	handler.SlaveId = 1
+	scanner := NewScanner(handler)
+	var found []byte
+	scanner.Found = func(result *ScanResult) {
+		found = append(found, result.SlaveId)
+		// Other clients of the handler still address their slave
+		if handler.SlaveId != 1 {
+			t.Errorf("slave id of the handler changed to %v", handler.SlaveId)
+		}
+	}
+	results, err := scanner.Scan(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{3, 7}, found) || len(results) != 2 {
+		t.Fatalf("unexpected slaves %v", found)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[1].Err.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected results %+v %+v", results[0], results[1])
+	}
+	if handler.SlaveId != 1 {
+		t.Fatalf("slave id of the handler changed to %v", handler.SlaveId)
+	}
+
+	scanner.FunctionCode = FuncCodeReportSlaveId
+	if results, err = scanner.Scan(190, 255); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !bytes.Equal([]byte{200, 0xFF}, results[0].Data) {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	if _, err = NewScanner(transporterHandler{}).Scan(1, 2); err == nil {
+		t.Fatalf("unsupported handler error expected")
+	}
+}
+
+type transporterHandler struct {
+	Packager
+	Transporter
+}
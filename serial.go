@@ -2,12 +2,20 @@
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 
+//go:build !modbus_noserial
+
 package modbus
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/goburrow/serial"
@@ -17,6 +25,11 @@ const (
 	// Default timeout
 	serialTimeout     = 5 * time.Second
 	serialIdleTimeout = 60 * time.Second
+	// Turnaround delay after broadcast requests
+	serialBroadcastDelay = 100 * time.Millisecond
+	// Read timeout of the port, reads are repeated until ReadTimeout so that
+	// they can be interrupted
+	serialReadSlice = 50 * time.Millisecond
 )
 
 // serialPort has configuration and I/O controller.
@@ -26,61 +39,469 @@ type serialPort struct {
 
 	Logger      *log.Logger
 	IdleTimeout time.Duration
+	// Format of the frames logged
+	WireLog
+	// BroadcastDelay is waited after sending a broadcast request (slave
+	// id 0), to which slaves do not respond, so that they can process it.
+	BroadcastDelay time.Duration
+	// Turnaround is waited after the response to a request of
+	// TurnaroundFunctions, writes if nil, before sending the next request,
+	// for slaves which process writes after responding, e.g. storing them
+	// in EEPROM. Unlike BroadcastDelay, the request does not wait for it.
+	Turnaround          time.Duration
+	TurnaroundFunctions []byte
+	// Callbacks of the port state
+	Lifecycle
+	// Spacing of requests
+	Pacing
+	// Timeouts of request and response
+	Timeouts
+	// FlushOutput discards data written but not transmitted yet before
+	// sending a request, if the port supports it, see flush.
+	FlushOutput bool
+	// ResponseTimeout bounds waiting for the first character of the
+	// response, ReadTimeout or Timeout if zero. InterCharTimeout bounds the
+	// silence between characters once the response started, so that
+	// truncated frames fail early without shortening the response time of
+	// slow slaves. It is ResponseTimeout if zero.
+	ResponseTimeout  time.Duration
+	InterCharTimeout time.Duration
+	// CRCRetries is the number of times a request is sent again, after
+	// discarding the pending data, when the CRC or LRC of the response does
+	// not match, so that noise of the line does not fail requests. Those
+	// responses are counted by CRCErrors whether or not they are retried.
+	CRCRetries int
+	// USBSerialNumber, if not empty, selects the USB adapter of the serial
+	// number instead of Address, whose path may change when the adapter is
+	// plugged again, see ListSerialPorts.
+	USBSerialNumber string
+	// Clock defaults to the system time if nil.
+	Clock Clock
 
 	mu sync.Mutex
 	// port is platform-dependent data structure for serial port.
-	port         io.ReadWriteCloser
+	port io.ReadWriteCloser
+	// address is the address of the port, resolved from USBSerialNumber.
+	address      string
 	lastActivity time.Time
-	closeTimer   *time.Timer
+	closeTimer   Timer
+	// lastReceive is the end of the last read from the port.
+	lastReceive time.Time
+	// turnaroundEnd is the end of the turnaround of the last request.
+	turnaroundEnd time.Time
+	// failed is true if the last exchange failed.
+	failed bool
+	// open defaults to openPort if nil.
+	open func(config *serial.Config) (io.ReadWriteCloser, error)
+	// listPorts defaults to ListSerialPorts if nil.
+	listPorts func() ([]SerialPortInfo, error)
+	// crcErrors is the number of responses with a checksum mismatch.
+	crcErrors atomic.Uint64
+	// shutdown is set by Shutdown, requests then fail with ErrClosed.
+	shutdown atomic.Bool
+}
+
+// portFlusher is implemented by ports which can discard the data of their
+// buffers, like tcflush.
+type portFlusher interface {
+	Flush(input, output bool) error
 }
 
+// Connect opens the serial port. It does nothing if the port is already open.
 func (mb *serialPort) Connect() (err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
+	defer mb.notifyError(&err)
 
 	return mb.connect()
 }
 
 // connect connects to the serial port if it is not connected. Caller must hold the mutex.
 func (mb *serialPort) connect() error {
+	if mb.shutdown.Load() {
+		return ErrClosed
+	}
 	if mb.port == nil {
-		port, err := serial.Open(&mb.Config)
+		open := mb.open
+		if open == nil {
+			open = openPort
+		}
+		config := mb.Config
+		if err := checkSerialFormat(&config); err != nil {
+			return err
+		}
+		if mb.USBSerialNumber != "" {
+			address, err := mb.findUSBPort()
+			if err != nil {
+				return err
+			}
+			config.Address = address
+		}
+		config.Timeout = mb.responseTimeout()
+		if config.Timeout <= 0 || config.Timeout > serialReadSlice {
+			config.Timeout = serialReadSlice
+		}
+		if mb.InterCharTimeout > 0 && mb.InterCharTimeout < config.Timeout {
+			config.Timeout = mb.InterCharTimeout
+		}
+		if mb.Adaptive != nil && mb.Adaptive.Min > 0 && mb.Adaptive.Min < config.Timeout {
+			config.Timeout = mb.Adaptive.Min
+		}
+		port, err := open(&config)
 		if err != nil {
 			return err
 		}
 		mb.port = port
+		mb.address = config.Address
+		mb.connected()
+	}
+	return nil
+}
+
+// findUSBPort returns the address of the USB adapter of USBSerialNumber.
+func (mb *serialPort) findUSBPort() (address string, err error) {
+	list := mb.listPorts
+	if list == nil {
+		list = ListSerialPorts
+	}
+	ports, err := list()
+	if err != nil {
+		return
+	}
+	for _, port := range ports {
+		if port.USB && port.SerialNumber == mb.USBSerialNumber {
+			return port.Name, nil
+		}
+	}
+	err = fmt.Errorf("modbus: no USB serial port with serial number '%v'", mb.USBSerialNumber)
+	return
+}
+
+// checkSerialFormat validates the character format of config, one of
+// the formats of the Modbus serial line specification:
+//  8E1, 8O1, 8N2 (and the common 8N1) for RTU and ASCII
+//  7E1, 7O1, 7N2 for ASCII only
+// Zero DataBits and StopBits are 8 and 1, empty Parity is even.
+func checkSerialFormat(config *serial.Config) error {
+	switch config.DataBits {
+	case 0, 7, 8:
+	default:
+		return fmt.Errorf("modbus: data bits '%v' must be 7 or 8", config.DataBits)
+	}
+	switch config.StopBits {
+	case 0, 1, 2:
+	default:
+		return fmt.Errorf("modbus: stop bits '%v' must be 1 or 2", config.StopBits)
+	}
+	switch config.Parity {
+	case "", "N", "E", "O":
+	default:
+		return fmt.Errorf("modbus: parity '%v' must be N, E or O", config.Parity)
+	}
+	// Characters of 7 data bits must have 10 bits
+	if config.DataBits == 7 && config.Parity == "N" && config.StopBits != 2 {
+		return fmt.Errorf("modbus: 7 data bits without parity require '2' stop bits, not '%v'", config.StopBits)
+	}
+	return nil
+}
+
+// checkRTUFormat returns an error if the port is not configured with 8
+// data bits, which RTU framing requires.
+func (mb *serialPort) checkRTUFormat() error {
+	if mb.DataBits != 0 && mb.DataBits != 8 {
+		return fmt.Errorf("modbus: RTU requires 8 data bits, not '%v', use ASCII for 7 data bits", mb.DataBits)
 	}
 	return nil
 }
 
+// Close closes the serial port. It is safe to call Close more than once,
+// a closed port is reopened on the next Connect or Send.
 func (mb *serialPort) Close() (err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	if mb.closeTimer != nil {
+		mb.closeTimer.Stop()
+	}
 	return mb.close()
 }
 
+// Shutdown waits for the request in progress, if any, up to the deadline
+// of ctx and closes the serial port, rather than closing it under a
+// pending read. Requests sent after Shutdown fail with ErrClosed. If ctx is
+// done first, the error of ctx is returned and the port is closed once the
+// request completes.
+func (mb *serialPort) Shutdown(ctx context.Context) error {
+	mb.shutdown.Store(true)
+	return drain(ctx, &mb.mu, func() error {
+		if mb.closeTimer != nil {
+			mb.closeTimer.Stop()
+		}
+		return mb.close()
+	})
+}
+
+// IsConnected returns true if the serial port is open.
+func (mb *serialPort) IsConnected() bool {
+	return mb.State() == StateConnected
+}
+
+// State returns current state of the serial port.
+func (mb *serialPort) State() ConnectionState {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.port == nil {
+		return StateDisconnected
+	}
+	return StateConnected
+}
+
 // close closes the serial port if it is connected. Caller must hold the mutex.
 func (mb *serialPort) close() (err error) {
 	if mb.port != nil {
 		err = mb.port.Close()
 		mb.port = nil
+		mb.failed = false
+		mb.disconnected()
 	}
 	return
 }
 
+// write writes the whole frame to the port in one burst. Serial drivers
+// opened in non-blocking mode may accept only a part of the frame; the
+// remaining bytes are then written as soon as the driver accepts them and
+// the fragmentation is logged, since the inter-character gap may make
+// strict slaves drop the frame. Caller must hold the mutex.
+func (mb *serialPort) write(frame []byte) (err error) {
+	var deadline time.Time
+	if timeout := mb.writeTimeout(mb.Timeout); timeout > 0 {
+		deadline = mb.now().Add(timeout)
+	}
+	written := 0
+	fragments := 0
+	for written < len(frame) {
+		var n int
+		n, err = mb.port.Write(frame[written:])
+		if n > 0 {
+			written += n
+			fragments++
+		}
+		if err != nil && err != syscall.EAGAIN {
+			return
+		}
+		if written >= len(frame) {
+			break
+		}
+		if !deadline.IsZero() && mb.now().After(deadline) {
+			return fmt.Errorf("modbus: timed out writing frame, '%v' of '%v' bytes written", written, len(frame))
+		}
+		if n == 0 {
+			// Output buffer is full, wait for the driver to drain it.
+			mb.sleep(time.Millisecond)
+		}
+	}
+	err = nil
+	if fragments > 1 {
+		mb.logf("modbus: warning: frame of '%v' bytes written in '%v' fragments\n", len(frame), fragments)
+	}
+	return
+}
+
+// flush discards data received before the request, e.g. the late response
+// to a request which timed out, so that it is not taken as the response.
+// The ports opened by openPort flush their buffers with tcflush or
+// PurgeComm; other ports are reopened after an exchange failed instead.
+// Caller must hold the mutex.
+func (mb *serialPort) flush() (err error) {
+	if flusher, ok := mb.port.(portFlusher); ok {
+		return flusher.Flush(true, mb.FlushOutput)
+	}
+	if !mb.failed {
+		return
+	}
+	mb.logf("modbus: reopening port to discard pending data\n")
+	mb.failed = false
+	if err = mb.close(); err != nil {
+		return
+	}
+	return mb.connect()
+}
+
+// batchMutex implements batchLocker.
+func (mb *serialPort) batchMutex() *sync.Mutex {
+	return &mb.mu
+}
+
+// CRCErrors returns the number of responses received with a CRC or LRC
+// which does not match, including those retried, see CRCRetries.
+func (mb *serialPort) CRCErrors() uint64 {
+	return mb.crcErrors.Load()
+}
+
+// retryChecksum returns true if the response of attempt, which does not
+// match its checksum, must be discarded and the request sent again. Caller
+// must hold the mutex.
+func (mb *serialPort) retryChecksum(attempt int) bool {
+	mb.crcErrors.Add(1)
+	// The rest of the corrupted frame may still be received
+	mb.failed = true
+	if attempt >= mb.CRCRetries {
+		return false
+	}
+	mb.logf("modbus: response checksum does not match, sending request again\n")
+	return true
+}
+
+// exchanged records whether the exchange failed. Caller must hold the mutex.
+func (mb *serialPort) exchanged(err *error) {
+	mb.failed = *err != nil
+	if mb.failed && portRemoved(*err) {
+		// The adapter was unplugged, the port is reopened by the next
+		// request once it is back.
+		mb.logf("modbus: closing removed port: %v\n", *err)
+		mb.close()
+	}
+}
+
+// responseTimeout returns the timeout of the first character of responses.
+func (mb *serialPort) responseTimeout() time.Duration {
+	if mb.ResponseTimeout > 0 {
+		return mb.ResponseTimeout
+	}
+	return mb.readTimeout(mb.Timeout)
+}
+
+// observeResponse records the response time of slaveId since sent, or
+// the timeout if the response timed out, see Adaptive. Caller must hold
+// the mutex.
+func (mb *serialPort) observeResponse(slaveId byte, sent time.Time, err *error) {
+	if mb.Adaptive == nil {
+		return
+	}
+	switch {
+	case *err == nil:
+		mb.Adaptive.Observe(slaveId, mb.now().Sub(sent))
+	case errors.Is(*err, serial.ErrTimeout):
+		mb.Adaptive.Observe(slaveId, mb.slaveTimeout(slaveId, mb.responseTimeout()))
+	}
+}
+
+// portReader reads from the port, waiting for data up to ResponseTimeout,
+// or InterCharTimeout once data is received, or until ctx is done. Caller
+// must hold the mutex.
+type portReader struct {
+	mb  *serialPort
+	ctx context.Context
+	// timeout of the first character, responseTimeout if zero.
+	timeout time.Duration
+	// received is the number of bytes read.
+	received int
+}
+
+func (r *portReader) Read(b []byte) (n int, err error) {
+	var deadline time.Time
+	timeout := r.timeout
+	if timeout <= 0 {
+		timeout = r.mb.responseTimeout()
+	}
+	if r.received > 0 && r.mb.InterCharTimeout > 0 {
+		timeout = r.mb.InterCharTimeout
+	}
+	if timeout > 0 {
+		deadline = r.mb.now().Add(timeout)
+	}
+	for {
+		n, err = r.mb.port.Read(b)
+		r.received += n
+		if err != serial.ErrTimeout {
+			return
+		}
+		if err = r.ctx.Err(); err != nil {
+			return
+		}
+		now := r.mb.now()
+		if d, ok := r.ctx.Deadline(); ok && !now.Before(d) {
+			return 0, context.DeadlineExceeded
+		}
+		if !deadline.IsZero() && !now.Before(deadline) {
+			if r.received > 0 {
+				return 0, &PartialResponseError{Received: r.received, Timeout: timeout, Err: serial.ErrTimeout}
+			}
+			return 0, &NoResponseError{Timeout: timeout, Err: serial.ErrTimeout}
+		}
+	}
+}
+
+// waitTurnaround waits for the end of the turnaround of the previous
+// request, see Turnaround. Caller must hold the mutex.
+func (mb *serialPort) waitTurnaround() {
+	if mb.turnaroundEnd.IsZero() {
+		return
+	}
+	if wait := mb.turnaroundEnd.Sub(mb.now()); wait > 0 {
+		mb.sleep(wait)
+	}
+	mb.turnaroundEnd = time.Time{}
+}
+
+// startTurnaround starts the turnaround after a request of the function.
+// Caller must hold the mutex.
+func (mb *serialPort) startTurnaround(functionCode byte) {
+	if mb.Turnaround <= 0 {
+		return
+	}
+	functions := mb.TurnaroundFunctions
+	if functions == nil {
+		if checkBroadcast(functionCode) != nil {
+			return
+		}
+	} else if bytes.IndexByte(functions, functionCode) < 0 {
+		return
+	}
+	mb.turnaroundEnd = mb.now().Add(mb.Turnaround)
+}
+
+// checkBroadcast returns an error if the function can not be broadcast,
+// only writes can.
+func checkBroadcast(functionCode byte) error {
+	switch functionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister:
+		return nil
+	}
+	return fmt.Errorf("modbus: function '%v' can not be broadcast, only writes can be sent to slave id '%v'", functionCode, 0)
+}
+
+func (mb *serialPort) now() time.Time {
+	return clockOrSystem(mb.Clock).Now()
+}
+
+func (mb *serialPort) sleep(d time.Duration) {
+	clockOrSystem(mb.Clock).Sleep(d)
+}
+
 func (mb *serialPort) logf(format string, v ...interface{}) {
 	if mb.Logger != nil {
 		mb.Logger.Printf(format, v...)
 	}
 }
 
+// logFrame logs a frame sent or received, see WireLog. It does not
+// allocate without Logger.
+func (mb *serialPort) logFrame(format frameFormat, sent bool, frame []byte) {
+	if mb.Logger != nil {
+		mb.Logger.Print(mb.formatFrame(format, sent, mb.now(), frame))
+	}
+}
+
 func (mb *serialPort) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
 	}
 	if mb.closeTimer == nil {
-		mb.closeTimer = time.AfterFunc(mb.IdleTimeout, mb.closeIdle)
+		mb.closeTimer = clockOrSystem(mb.Clock).AfterFunc(mb.IdleTimeout, mb.closeIdle)
 	} else {
 		mb.closeTimer.Reset(mb.IdleTimeout)
 	}
@@ -94,7 +515,7 @@ func (mb *serialPort) closeIdle() {
 	if mb.IdleTimeout <= 0 {
 		return
 	}
-	idle := time.Now().Sub(mb.lastActivity)
+	idle := mb.now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
 		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
 		mb.close()
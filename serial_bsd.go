@@ -0,0 +1,30 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build (darwin || freebsd || netbsd || openbsd) && !modbus_noserial
+
+package modbus
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Queues of TIOCFLUSH, see sys/fcntl.h.
+const (
+	flushRead  = 0x1
+	flushWrite = 0x2
+)
+
+// tcflush discards the data received but not read, and written but not
+// transmitted, of the terminal fd. See man tcflush(3).
+func tcflush(fd int, input, output bool) error {
+	queue := 0
+	if input {
+		queue |= flushRead
+	}
+	if output {
+		queue |= flushWrite
+	}
+	return unix.IoctlSetPointerInt(fd, unix.TIOCFLUSH, queue)
+}
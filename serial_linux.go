@@ -0,0 +1,23 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// tcflush discards the data received but not read, and written but not
+// transmitted, of the terminal fd. See man tcflush(3).
+func tcflush(fd int, input, output bool) error {
+	queue := unix.TCIOFLUSH
+	if !output {
+		queue = unix.TCIFLUSH
+	} else if !input {
+		queue = unix.TCOFLUSH
+	}
+	return unix.IoctlSetInt(fd, unix.TCFLSH, queue)
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo terminal, returning its master and the path of
+// its slave.
+func openPty(t *testing.T) (master *os.File, path string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { master.Close() })
+	fd := int(master.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// TestSerialFlushStale checks that the bytes received before a request,
+// e.g. the late response to a request which timed out, are discarded by
+// tcflush rather than taken as the response.
+func TestSerialFlushStale(t *testing.T) {
+	master, path := openPty(t)
+	handler := NewRTUClientHandler(path)
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	port, ok := handler.port.(*posixPort)
+	if !ok {
+		t.Fatalf("unexpected port %T", handler.port)
+	}
+
+	response := func(value byte) []byte {
+		pdu := ProtocolDataUnit{FuncCodeReadHoldingRegisters, []byte{2, 0, value}}
+		adu, _ := (&rtuPackager{SlaveId: 1}).Encode(&pdu)
+		return adu
+	}
+	stale := response(1)
+	if _, err := master.Write(stale); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		n, err := unix.IoctlGetInt(port.fd, unix.TIOCINQ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == len(stale) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("'%v' stale bytes received", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(master, request); err == nil {
+			master.Write(response(2))
+		}
+	}()
+	results, err := NewClient(handler).ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 2}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+func TestPortFd(t *testing.T) {
+	if fd, ok := portFd(serial.New()); !ok || fd != -1 {
+		t.Fatalf("unexpected fd %v, %v", fd, ok)
+	}
+}
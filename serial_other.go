@@ -0,0 +1,63 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !windows && !modbus_noserial
+
+package modbus
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"syscall"
+
+	"github.com/goburrow/serial"
+)
+
+// posixPort is a port of github.com/goburrow/serial which discards its
+// buffers with tcflush instead of being reopened, see portFlusher.
+type posixPort struct {
+	serial.Port
+	fd int
+}
+
+// openPort opens the serial port of config.
+func openPort(config *serial.Config) (io.ReadWriteCloser, error) {
+	port, err := serial.Open(config)
+	if err != nil {
+		return nil, err
+	}
+	if fd, ok := portFd(port); ok {
+		return &posixPort{Port: port, fd: fd}, nil
+	}
+	return port, nil
+}
+
+// portFd returns the file descriptor of a port of github.com/goburrow/serial,
+// which does not export it, or false if the port has none.
+func portFd(port serial.Port) (fd int, ok bool) {
+	v := reflect.ValueOf(port)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	field := v.Elem().FieldByName("fd")
+	if field.Kind() != reflect.Int {
+		return
+	}
+	return int(field.Int()), true
+}
+
+// Flush implements portFlusher.
+func (p *posixPort) Flush(input, output bool) error {
+	if !input && !output {
+		return nil
+	}
+	return tcflush(p.fd, input, output)
+}
+
+// portRemoved returns true if err is returned by the port of a USB adapter
+// which was unplugged.
+func portRemoved(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.ENODEV)
+}
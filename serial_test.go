@@ -1,10 +1,16 @@
+//go:build !modbus_noserial
+
 package modbus
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/goburrow/serial"
 )
 
 type nopCloser struct {
@@ -34,3 +40,212 @@ func TestSerialCloseIdle(t *testing.T) {
 		t.Fatalf("serial port is not closed when inactivity: %+v", port)
 	}
 }
+
+// fragmentingPort accepts at most max bytes per write.
+type fragmentingPort struct {
+	nopCloser
+	max    int
+	writes int
+}
+
+func (p *fragmentingPort) Write(b []byte) (int, error) {
+	p.writes++
+	if len(b) > p.max {
+		b = b[:p.max]
+	}
+	return p.ReadWriter.Write(b)
+}
+
+func TestSerialWriteFragmented(t *testing.T) {
+	buf := &bytes.Buffer{}
+	port := &fragmentingPort{max: 3}
+	port.ReadWriter = buf
+	s := serialPort{port: port}
+
+	frame := []byte{1, 3, 0, 0, 0, 2, 0xC4, 0x0B}
+	if err := s.write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, buf.Bytes()) {
+		t.Fatalf("frame expected %x, actual %x", frame, buf.Bytes())
+	}
+	if port.writes != 3 {
+		t.Fatalf("writes expected %v, actual %v", 3, port.writes)
+	}
+}
+
+func TestSerialLifecycle(t *testing.T) {
+	line := newSimLine(9600, func(request []byte) []byte { return nil })
+	handler := newSimRTUClientHandler(line)
+	var errs []error
+	disconnects := 0
+	handler.OnError = func(err error) { errs = append(errs, err) }
+	handler.OnDisconnect = func() { disconnects++ }
+	client := NewClient(handler)
+
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	handler.Close()
+	if len(errs) != 1 || !errors.Is(errs[0], serial.ErrTimeout) || disconnects != 1 || !line.closed {
+		t.Fatalf("unexpected errors %v, disconnects %v", errs, disconnects)
+	}
+}
+
+func TestSerialFormat(t *testing.T) {
+	var opened []serial.Config
+	open := func(config *serial.Config) (io.ReadWriteCloser, error) {
+		opened = append(opened, *config)
+		return &nopCloser{ReadWriter: &bytes.Buffer{}}, nil
+	}
+	valid := []serial.Config{
+		{}, {DataBits: 8, Parity: "N", StopBits: 1}, {DataBits: 8, Parity: "E", StopBits: 1},
+		{DataBits: 7, Parity: "E", StopBits: 1}, {DataBits: 7, Parity: "O", StopBits: 1},
+		{DataBits: 7, Parity: "N", StopBits: 2},
+	}
+	for _, config := range valid {
+		port := serialPort{Config: config, open: open}
+		if err := port.Connect(); err != nil {
+			t.Errorf("%+v: %v", config, err)
+		}
+	}
+	if len(opened) != len(valid) || opened[3].DataBits != 7 || opened[3].Parity != "E" {
+		t.Fatalf("unexpected configurations opened: %+v", opened)
+	}
+	invalid := []serial.Config{
+		{DataBits: 6}, {DataBits: 9}, {StopBits: 3}, {Parity: "M"},
+		{DataBits: 7, Parity: "N", StopBits: 1},
+	}
+	for _, config := range invalid {
+		port := serialPort{Config: config, open: open}
+		if err := port.Connect(); err == nil {
+			t.Errorf("%+v: error expected", config)
+		}
+	}
+	if len(opened) != len(valid) {
+		t.Fatalf("invalid configuration opened: %+v", opened[len(valid):])
+	}
+
+	handler := NewRTUClientHandler("/dev/null")
+	handler.SlaveId = 1
+	handler.DataBits = 7
+	handler.open = open
+	_, err := NewClient(handler).ReadHoldingRegisters(0, 1)
+	if err == nil || err.Error() != "modbus: RTU requires 8 data bits, not '7', use ASCII for 7 data bits" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// removedPort fails like the port of an unplugged USB adapter.
+type removedPort struct {
+	nopCloser
+}
+
+func (p *removedPort) Read(b []byte) (int, error) {
+	return 0, syscall.EIO
+}
+
+func TestSerialPortRemoved(t *testing.T) {
+	var addresses []string
+	var ports []SerialPortInfo
+	handler := NewRTUClientHandler("/dev/ttyUSB0")
+	handler.SlaveId = 1
+	handler.USBSerialNumber = "A10K4KQ2"
+	handler.listPorts = func() ([]SerialPortInfo, error) {
+		return ports, nil
+	}
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		addresses = append(addresses, config.Address)
+		return &removedPort{nopCloser{ReadWriter: &bytes.Buffer{}}}, nil
+	}
+	disconnects := 0
+	handler.OnDisconnect = func() { disconnects++ }
+	client := NewClient(handler)
+
+	if _, err := client.ReadHoldingRegisters(0, 1); err == nil || err.Error() != "modbus: no USB serial port with serial number 'A10K4KQ2'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ports = []SerialPortInfo{{Name: "/dev/ttyS0"}, {Name: "/dev/ttyUSB1", USB: true, SerialNumber: "A10K4KQ2"}}
+	if _, err := client.ReadHoldingRegisters(0, 1); err != syscall.EIO {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if handler.IsConnected() || disconnects != 1 {
+		t.Fatalf("removed port is not closed, disconnects %v", disconnects)
+	}
+	ports[1].Name = "/dev/ttyUSB2"
+	client.ReadHoldingRegisters(0, 1)
+	if len(addresses) != 2 || addresses[0] != "/dev/ttyUSB1" || addresses[1] != "/dev/ttyUSB2" {
+		t.Fatalf("unexpected ports opened %v", addresses)
+	}
+}
+
+// truncatedPort sends the first bytes of a response and then stays silent.
+type truncatedPort struct {
+	nopCloser
+	response []byte
+}
+
+func (p *truncatedPort) Read(b []byte) (n int, err error) {
+	if len(p.response) == 0 {
+		return 0, serial.ErrTimeout
+	}
+	n = copy(b, p.response)
+	p.response = p.response[n:]
+	return
+}
+
+func TestSerialInterCharTimeout(t *testing.T) {
+	handler := NewRTUClientHandler("/dev/ttyUSB0")
+	handler.SlaveId = 1
+	handler.ResponseTimeout = 5 * time.Second
+	handler.InterCharTimeout = 20 * time.Millisecond
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		if config.Timeout != handler.InterCharTimeout {
+			t.Errorf("port timeout %v, expected %v", config.Timeout, handler.InterCharTimeout)
+		}
+		return &truncatedPort{nopCloser{ReadWriter: &bytes.Buffer{}}, []byte{1, 3, 2}}, nil
+	}
+	client := NewClient(handler)
+	defer handler.Close()
+
+	start := time.Now()
+	_, err := client.ReadHoldingRegisters(0, 1)
+	if err == nil || err.Error() != "modbus: no character received for '20ms' after '3' bytes of the response" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= handler.ResponseTimeout {
+		t.Fatalf("inter-character timeout took %v", elapsed)
+	}
+}
+
+func TestSerialResponseErrors(t *testing.T) {
+	var response []byte
+	handler := NewRTUClientHandler("/dev/ttyUSB0")
+	handler.SlaveId = 1
+	handler.ResponseTimeout = 20 * time.Millisecond
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &truncatedPort{nopCloser{ReadWriter: &bytes.Buffer{}}, response}, nil
+	}
+	client := NewClient(handler)
+
+	_, err := client.ReadHoldingRegisters(0, 1)
+	var noResponse *NoResponseError
+	if !errors.As(err, &noResponse) || noResponse.Timeout != handler.ResponseTimeout || !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("no response expected, actual %v", err)
+	}
+	handler.Close()
+	response = []byte{1, 3, 2}
+	_, err = client.ReadHoldingRegisters(0, 1)
+	var partial *PartialResponseError
+	if !errors.As(err, &partial) || partial.Received != 3 || partial.Expected != 7 || !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("partial response expected, actual %v", err)
+	}
+	handler.Close()
+	response = []byte{1, 3, 2, 0, 1, 0, 0}
+	_, err = client.ReadHoldingRegisters(0, 1)
+	var checksum *ChecksumError
+	if !errors.As(err, &checksum) || checksum.Checksum != "crc" || checksum.Received != 0 || checksum.Expected != 0x8479 {
+		t.Fatalf("checksum error expected, actual %v", err)
+	}
+	handler.Close()
+}
@@ -0,0 +1,355 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build windows && !modbus_noserial
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/goburrow/serial"
+)
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetCommState       = kernel32.NewProc("GetCommState")
+	procSetCommState       = kernel32.NewProc("SetCommState")
+	procGetCommTimeouts    = kernel32.NewProc("GetCommTimeouts")
+	procSetCommTimeouts    = kernel32.NewProc("SetCommTimeouts")
+	procSetupComm          = kernel32.NewProc("SetupComm")
+	procPurgeComm          = kernel32.NewProc("PurgeComm")
+	procClearCommError     = kernel32.NewProc("ClearCommError")
+	procEscapeCommFunction = kernel32.NewProc("EscapeCommFunction")
+)
+
+const (
+	// DCB flags
+	dcbBinary          = 0x0001
+	dcbParity          = 0x0002
+	dcbDtrEnable       = 0x0010
+	dcbRtsEnable       = 0x1000
+	dcbRtsToggle       = 0x3000
+	dcbDtrControl      = 0x0030
+	dcbRtsControl      = 0x3000
+	dcbFlowControl     = 0x034C // fOutxCtsFlow, fOutxDsrFlow, fDsrSensitivity, fOutX, fInX
+	dcbAbortOnError    = 0x4000
+	dcbNoParity        = 0
+	dcbOddParity       = 1
+	dcbEvenParity      = 2
+	dcbOneStopBit      = 0
+	dcbTwoStopBits     = 2
+	purgeTxClear       = 0x0004
+	purgeRxClear       = 0x0008
+	escapeSetRTS       = 3
+	escapeClrRTS       = 4
+	escapeSetDTR       = 5
+	escapeClrDTR       = 6
+	ceRxOver           = 0x0001
+	ceOverrun          = 0x0002
+	ceRxParity         = 0x0004
+	ceFrame            = 0x0008
+	ceBreak            = 0x0010
+	commBufferSize     = 4096
+	commMaxDWORD       = 0xFFFFFFFF
+	windowsBaudRate    = 19200
+	windowsDevPrefix   = `\\.\`
+	errorBadCommand    = 22
+	errorGenFailure    = 31
+	errorDeviceRemoved = 1617
+)
+
+// commState is the DCB structure of the Windows API.
+type commState struct {
+	DCBlength  uint32
+	BaudRate   uint32
+	Flags      uint32
+	wReserved  uint16
+	XonLim     uint16
+	XoffLim    uint16
+	ByteSize   byte
+	Parity     byte
+	StopBits   byte
+	XonChar    byte
+	XoffChar   byte
+	ErrorChar  byte
+	EofChar    byte
+	EvtChar    byte
+	wReserved1 uint16
+}
+
+// commTimeouts is the COMMTIMEOUTS structure of the Windows API.
+type commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+// windowsPort is a serial port of Windows. Unlike the port of
+// github.com/goburrow/serial, it keeps the driver settings it does not
+// configure, accepts COM10 and above, drives the DTR and RTS lines and
+// discards its buffers without reopening, see portFlusher.
+type windowsPort struct {
+	handle      syscall.Handle
+	oldState    commState
+	oldTimeouts commTimeouts
+	// lineErrors counts the errors cleared, see LineErrors.
+	lineErrors LineErrors
+}
+
+// openPort opens the serial port of config.
+func openPort(config *serial.Config) (io.ReadWriteCloser, error) {
+	address := config.Address
+	if !strings.HasPrefix(address, windowsDevPrefix) {
+		// Required by COM10 and above
+		address = windowsDevPrefix + address
+	}
+	path, err := syscall.UTF16PtrFromString(address)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: opening '%v' failed: %v", config.Address, err)
+	}
+	p := &windowsPort{handle: handle}
+	if err = p.configure(config); err != nil {
+		syscall.CloseHandle(handle)
+		return nil, fmt.Errorf("modbus: configuring '%v' failed: %v", config.Address, err)
+	}
+	return p, nil
+}
+
+// configure sets the character format, the line control and the timeouts
+// of the port, saving the previous settings to be restored by Close.
+func (p *windowsPort) configure(config *serial.Config) (err error) {
+	if err = commCall(procSetupComm, uintptr(p.handle), commBufferSize, commBufferSize); err != nil {
+		return
+	}
+	p.oldState.DCBlength = uint32(unsafe.Sizeof(p.oldState))
+	if err = commCall(procGetCommState, uintptr(p.handle), uintptr(unsafe.Pointer(&p.oldState))); err != nil {
+		return
+	}
+	state, err := newCommState(p.oldState, config)
+	if err != nil {
+		return
+	}
+	if err = commCall(procGetCommTimeouts, uintptr(p.handle), uintptr(unsafe.Pointer(&p.oldTimeouts))); err != nil {
+		return
+	}
+	if err = commCall(procSetCommState, uintptr(p.handle), uintptr(unsafe.Pointer(&state))); err != nil {
+		return
+	}
+	timeouts := newCommTimeouts(config)
+	if err = commCall(procSetCommTimeouts, uintptr(p.handle), uintptr(unsafe.Pointer(&timeouts))); err != nil {
+		commCall(procSetCommState, uintptr(p.handle), uintptr(unsafe.Pointer(&p.oldState)))
+		return
+	}
+	return p.Flush(true, true)
+}
+
+// newCommState returns the DCB of config, keeping the other settings of
+// the driver. Any baud rate is passed to the driver, which rejects those
+// the adapter does not support. DTR is asserted, as some adapters are
+// powered by it, and RTS is asserted or, with RS485 enabled, asserted
+// while sending.
+func newCommState(state commState, config *serial.Config) (commState, error) {
+	state.BaudRate = uint32(config.BaudRate)
+	if config.BaudRate <= 0 {
+		state.BaudRate = windowsBaudRate
+	}
+	state.ByteSize = byte(config.DataBits)
+	if config.DataBits == 0 {
+		state.ByteSize = 8
+	}
+	switch config.StopBits {
+	case 0, 1:
+		state.StopBits = dcbOneStopBit
+	case 2:
+		state.StopBits = dcbTwoStopBits
+	default:
+		return state, fmt.Errorf("modbus: stop bits '%v' must be 1 or 2", config.StopBits)
+	}
+	state.Flags &^= dcbParity | dcbFlowControl | dcbDtrControl | dcbRtsControl | dcbAbortOnError
+	state.Flags |= dcbBinary | dcbDtrEnable
+	switch config.Parity {
+	case "", "E":
+		state.Parity = dcbEvenParity
+		state.Flags |= dcbParity
+	case "O":
+		state.Parity = dcbOddParity
+		state.Flags |= dcbParity
+	case "N":
+		state.Parity = dcbNoParity
+	default:
+		return state, fmt.Errorf("modbus: parity '%v' must be N, E or O", config.Parity)
+	}
+	rs485 := config.RS485
+	switch {
+	case !rs485.Enabled:
+		state.Flags |= dcbRtsEnable
+	case !rs485.RtsHighDuringSend || rs485.RtsHighAfterSend:
+		return state, fmt.Errorf("modbus: RS485 on Windows requires RTS high during send and low after send")
+	case rs485.DelayRtsBeforeSend != 0 || rs485.DelayRtsAfterSend != 0:
+		return state, fmt.Errorf("modbus: RS485 RTS delays are not supported on Windows")
+	default:
+		state.Flags |= dcbRtsToggle
+	}
+	return state, nil
+}
+
+// newCommTimeouts returns the timeouts of config: reads return as soon as
+// data is received or after the timeout, 1ms at least, and writes time out
+// after the transmission time of the data in addition to the timeout.
+func newCommTimeouts(config *serial.Config) (timeouts commTimeouts) {
+	timeout := uint32((config.Timeout + time.Millisecond - 1) / time.Millisecond)
+	if timeout == 0 {
+		timeout = 1
+	}
+	baudRate := config.BaudRate
+	if baudRate <= 0 {
+		baudRate = windowsBaudRate
+	}
+	timeouts.ReadIntervalTimeout = commMaxDWORD
+	timeouts.ReadTotalTimeoutMultiplier = commMaxDWORD
+	timeouts.ReadTotalTimeoutConstant = timeout
+	// 11 bits per character, rounded up to 1ms
+	timeouts.WriteTotalTimeoutMultiplier = uint32((11000 + baudRate - 1) / baudRate)
+	timeouts.WriteTotalTimeoutConstant = timeout
+	return
+}
+
+// Read reads from the port, it returns serial.ErrTimeout if nothing is
+// received within the timeout.
+func (p *windowsPort) Read(b []byte) (n int, err error) {
+	var done uint32
+	if err = syscall.ReadFile(p.handle, b, &done, nil); err != nil {
+		p.clearError()
+		return
+	}
+	if done == 0 {
+		err = serial.ErrTimeout
+		return
+	}
+	n = int(done)
+	return
+}
+
+// Write writes to the port.
+func (p *windowsPort) Write(b []byte) (n int, err error) {
+	var done uint32
+	if err = syscall.WriteFile(p.handle, b, &done, nil); err != nil {
+		p.clearError()
+	}
+	n = int(done)
+	return
+}
+
+// Flush implements portFlusher.
+func (p *windowsPort) Flush(input, output bool) error {
+	var flags uintptr
+	if input {
+		flags |= purgeRxClear
+	}
+	if output {
+		flags |= purgeTxClear
+	}
+	if flags == 0 {
+		return nil
+	}
+	return commCall(procPurgeComm, uintptr(p.handle), flags)
+}
+
+// SetDTR sets or clears the DTR line.
+func (p *windowsPort) SetDTR(high bool) error {
+	if high {
+		return commCall(procEscapeCommFunction, uintptr(p.handle), escapeSetDTR)
+	}
+	return commCall(procEscapeCommFunction, uintptr(p.handle), escapeClrDTR)
+}
+
+// SetRTS sets or clears the RTS line.
+func (p *windowsPort) SetRTS(high bool) error {
+	if high {
+		return commCall(procEscapeCommFunction, uintptr(p.handle), escapeSetRTS)
+	}
+	return commCall(procEscapeCommFunction, uintptr(p.handle), escapeClrRTS)
+}
+
+// Close restores the settings of the port and closes it.
+func (p *windowsPort) Close() (err error) {
+	if p.handle == syscall.InvalidHandle {
+		return
+	}
+	commCall(procSetCommTimeouts, uintptr(p.handle), uintptr(unsafe.Pointer(&p.oldTimeouts)))
+	commCall(procSetCommState, uintptr(p.handle), uintptr(unsafe.Pointer(&p.oldState)))
+	err = syscall.CloseHandle(p.handle)
+	p.handle = syscall.InvalidHandle
+	return
+}
+
+// LineErrors implements lineErrorCounter. The driver reports whether
+// errors occurred since they were last cleared, not how many, each is
+// counted once per read or write failing.
+func (p *windowsPort) LineErrors() (LineErrors, error) {
+	if err := p.clearError(); err != nil {
+		return LineErrors{}, err
+	}
+	return p.lineErrors, nil
+}
+
+// clearError clears the error state of the driver, which would block the
+// following reads and writes, and counts the errors.
+func (p *windowsPort) clearError() error {
+	var errors uint32
+	if err := commCall(procClearCommError, uintptr(p.handle), uintptr(unsafe.Pointer(&errors)), 0); err != nil {
+		return err
+	}
+	p.lineErrors.add(errors)
+	return nil
+}
+
+// add counts the CE_ flags of ClearCommError.
+func (e *LineErrors) add(flags uint32) {
+	if flags&ceFrame != 0 {
+		e.Framing++
+	}
+	if flags&ceRxParity != 0 {
+		e.Parity++
+	}
+	if flags&ceOverrun != 0 {
+		e.Overrun++
+	}
+	if flags&ceRxOver != 0 {
+		e.BufferOverrun++
+	}
+	if flags&ceBreak != 0 {
+		e.Break++
+	}
+}
+
+// portRemoved returns true if err is returned by the port of a USB adapter
+// which was unplugged.
+func portRemoved(err error) bool {
+	return errors.Is(err, syscall.ERROR_ACCESS_DENIED) || errors.Is(err, syscall.Errno(errorBadCommand)) ||
+		errors.Is(err, syscall.Errno(errorGenFailure)) || errors.Is(err, syscall.Errno(errorDeviceRemoved))
+}
+
+// commCall calls a communication function returning a BOOL.
+func commCall(proc *syscall.LazyProc, args ...uintptr) error {
+	r, _, err := proc.Call(args...)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build windows && !modbus_noserial
+
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+func TestWindowsCommState(t *testing.T) {
+	old := commState{Flags: dcbFlowControl | dcbAbortOnError, XonLim: 2048}
+	state, err := newCommState(old, &serial.Config{BaudRate: 250000, DataBits: 7, Parity: "O", StopBits: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.BaudRate != 250000 || state.ByteSize != 7 || state.Parity != dcbOddParity || state.StopBits != dcbOneStopBit ||
+		state.XonLim != 2048 || state.Flags != dcbBinary|dcbParity|dcbDtrEnable|dcbRtsEnable {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	config := &serial.Config{Parity: "N", StopBits: 2}
+	config.RS485.Enabled = true
+	config.RS485.RtsHighDuringSend = true
+	if state, err = newCommState(old, config); err != nil {
+		t.Fatal(err)
+	}
+	if state.BaudRate != windowsBaudRate || state.ByteSize != 8 || state.Flags&dcbRtsControl != dcbRtsToggle {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	config.RS485.RtsHighDuringSend = false
+	if _, err = newCommState(old, config); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestWindowsCommTimeouts(t *testing.T) {
+	timeouts := newCommTimeouts(&serial.Config{BaudRate: 9600, Timeout: 50 * time.Millisecond})
+	if timeouts.ReadIntervalTimeout != commMaxDWORD || timeouts.ReadTotalTimeoutMultiplier != commMaxDWORD ||
+		timeouts.ReadTotalTimeoutConstant != 50 || timeouts.WriteTotalTimeoutMultiplier != 2 {
+		t.Fatalf("unexpected timeouts %+v", timeouts)
+	}
+	timeouts = newCommTimeouts(&serial.Config{BaudRate: 921600, Timeout: 100 * time.Microsecond})
+	if timeouts.ReadTotalTimeoutConstant != 1 || timeouts.WriteTotalTimeoutMultiplier != 1 {
+		t.Fatalf("unexpected timeouts %+v", timeouts)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SerialPortInfo describes a serial port of the system.
+type SerialPortInfo struct {
+	// Name is the address of the port, e.g. /dev/ttyUSB0 or COM3.
+	Name string
+	// Description is the product or the name given by the driver, if
+	// known.
+	Description string
+	// USB is true for USB adapters, described by the following fields
+	// when the platform provides them.
+	USB          bool
+	VendorId     uint16
+	ProductId    uint16
+	SerialNumber string
+	Manufacturer string
+}
+
+// knownAdapters are the chips of common USB-RS485 adapters by vendor and
+// product identifier.
+var knownAdapters = map[[2]uint16]string{
+	{0x0403, 0x6001}: "FTDI FT232R",
+	{0x0403, 0x6010}: "FTDI FT2232",
+	{0x0403, 0x6011}: "FTDI FT4232",
+	{0x0403, 0x6014}: "FTDI FT232H",
+	{0x0403, 0x6015}: "FTDI FT-X",
+	{0x10c4, 0xea60}: "Silicon Labs CP210x",
+	{0x1a86, 0x7523}: "WCH CH340",
+	{0x1a86, 0x55d3}: "WCH CH343",
+	{0x067b, 0x2303}: "Prolific PL2303",
+}
+
+// KnownAdapter returns the chip of the port if it is a common USB-RS485
+// adapter, empty otherwise.
+func (p *SerialPortInfo) KnownAdapter() string {
+	if !p.USB {
+		return ""
+	}
+	return knownAdapters[[2]uint16{p.VendorId, p.ProductId}]
+}
+
+// ListSerialPorts returns the serial ports of the system sorted by name,
+// e.g. to present a port picker or to select a known adapter:
+//  ports, err := modbus.ListSerialPorts()
+//  for _, p := range ports {
+//  	if p.KnownAdapter() != "" {
+//  		handler := modbus.NewRTUClientHandler(p.Name)
+//  		...
+//  	}
+//  }
+// USB metadata is read from sysfs on Linux and from the registry on
+// Windows. On macOS, ports are the call-out devices /dev/cu.* and only
+// the serial number is known, from the name given by the driver.
+func ListSerialPorts() (ports []SerialPortInfo, err error) {
+	if ports, err = listSerialPorts(); err != nil {
+		return
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].Name < ports[j].Name
+	})
+	return
+}
+
+// parseUSBIds parses the vendor and product identifiers of hardware ids
+// like VID_0403&PID_6001 or VID_0403+PID_6001+A10K4KQ2.
+func parseUSBIds(s string) (vendorId, productId uint16, ok bool) {
+	s = strings.ToUpper(s)
+	vid := strings.Index(s, "VID_")
+	pid := strings.Index(s, "PID_")
+	if vid < 0 || pid < 0 || len(s) < vid+8 || len(s) < pid+8 {
+		return
+	}
+	v, err := strconv.ParseUint(s[vid+4:vid+8], 16, 16)
+	if err != nil {
+		return
+	}
+	p, err := strconv.ParseUint(s[pid+4:pid+8], 16, 16)
+	if err != nil {
+		return
+	}
+	return uint16(v), uint16(p), true
+}
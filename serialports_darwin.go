@@ -0,0 +1,34 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// listSerialPorts lists the call-out devices, which do not wait for the
+// carrier when opened. Reading the USB metadata requires IOKit, only the
+// serial number in the name given by the FTDI driver is known.
+func listSerialPorts() (ports []SerialPortInfo, err error) {
+	names, err := filepath.Glob("/dev/cu.*")
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		port := SerialPortInfo{Name: name}
+		base := strings.TrimPrefix(filepath.Base(name), "cu.")
+		if strings.HasPrefix(base, "usbserial-") {
+			port.USB = true
+			port.SerialNumber = strings.TrimPrefix(base, "usbserial-")
+		} else if strings.HasPrefix(base, "usbmodem") {
+			port.USB = true
+		}
+		ports = append(ports, port)
+	}
+	return
+}
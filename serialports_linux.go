@@ -0,0 +1,78 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func listSerialPorts() ([]SerialPortInfo, error) {
+	return listSysfsSerialPorts("/sys", "/dev")
+}
+
+// listSysfsSerialPorts lists the ttys of sysfs backed by a device. Ports
+// of the platform bus are skipped, they are the legacy serial8250 ports
+// which exist whether or not the hardware does.
+func listSysfsSerialPorts(sysfs, dev string) (ports []SerialPortInfo, err error) {
+	entries, err := os.ReadDir(filepath.Join(sysfs, "class", "tty"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, entry := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(sysfs, "class", "tty", entry.Name(), "device"))
+		if err != nil {
+			// Virtual terminal
+			continue
+		}
+		subsystem, _ := filepath.EvalSymlinks(filepath.Join(device, "subsystem"))
+		if filepath.Base(subsystem) == "platform" {
+			continue
+		}
+		port := SerialPortInfo{Name: filepath.Join(dev, entry.Name())}
+		if usb := sysfsUSBDevice(device); usb != "" {
+			port.USB = true
+			port.VendorId = sysfsHex(usb, "idVendor")
+			port.ProductId = sysfsHex(usb, "idProduct")
+			port.SerialNumber = sysfsString(usb, "serial")
+			port.Manufacturer = sysfsString(usb, "manufacturer")
+			port.Description = sysfsString(usb, "product")
+		}
+		ports = append(ports, port)
+	}
+	return
+}
+
+// sysfsUSBDevice returns the USB device of the interface of device, the
+// first parent with a vendor identifier.
+func sysfsUSBDevice(device string) string {
+	for i := 0; i < 3; i++ {
+		if _, err := os.Stat(filepath.Join(device, "idVendor")); err == nil {
+			return device
+		}
+		device = filepath.Dir(device)
+	}
+	return ""
+}
+
+func sysfsString(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func sysfsHex(dir, name string) uint16 {
+	v, _ := strconv.ParseUint(sysfsString(dir, name), 16, 16)
+	return uint16(v)
+}
@@ -0,0 +1,71 @@
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListSysfsSerialPorts(t *testing.T) {
+	sysfs := t.TempDir()
+	mkdir := func(path string) string {
+		path = filepath.Join(sysfs, path)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	symlink := func(target, link string) {
+		if err := os.Symlink(filepath.Join(sysfs, target), filepath.Join(sysfs, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, data string) {
+		if err := os.WriteFile(filepath.Join(sysfs, path), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir("bus/usb-serial")
+	mkdir("bus/platform")
+	mkdir("bus/pnp")
+	// FTDI adapter
+	mkdir("devices/usb1/1-1/1-1:1.0/ttyUSB0")
+	write("devices/usb1/1-1/idVendor", "0403\n")
+	write("devices/usb1/1-1/idProduct", "6001\n")
+	write("devices/usb1/1-1/serial", "A10K4KQ2\n")
+	write("devices/usb1/1-1/manufacturer", "FTDI\n")
+	write("devices/usb1/1-1/product", "FT232R USB UART\n")
+	symlink("bus/usb-serial", "devices/usb1/1-1/1-1:1.0/ttyUSB0/subsystem")
+	// Legacy port without hardware
+	mkdir("devices/platform/serial8250")
+	symlink("bus/platform", "devices/platform/serial8250/subsystem")
+	// Onboard port
+	mkdir("devices/pnp0/00:04")
+	symlink("bus/pnp", "devices/pnp0/00:04/subsystem")
+
+	for _, tty := range []string{"tty0", "ttyS0", "ttyS4", "ttyUSB0"} {
+		mkdir("class/tty/" + tty)
+	}
+	symlink("devices/pnp0/00:04", "class/tty/ttyS0/device")
+	symlink("devices/platform/serial8250", "class/tty/ttyS4/device")
+	symlink("devices/usb1/1-1/1-1:1.0/ttyUSB0", "class/tty/ttyUSB0/device")
+
+	ports, err := listSysfsSerialPorts(sysfs, "/dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SerialPortInfo{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", Description: "FT232R USB UART", USB: true, VendorId: 0x0403, ProductId: 0x6001,
+			SerialNumber: "A10K4KQ2", Manufacturer: "FTDI"},
+	}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Fatalf("unexpected ports %+v", ports)
+	}
+	if adapter := ports[1].KnownAdapter(); adapter != "FTDI FT232R" {
+		t.Fatalf("unexpected adapter %q", adapter)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !linux && !darwin && !windows && !modbus_noserial
+
+package modbus
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func listSerialPorts() ([]SerialPortInfo, error) {
+	return nil, fmt.Errorf("modbus: listing serial ports is not supported on '%v'", runtime.GOOS)
+}
@@ -0,0 +1,37 @@
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"testing"
+)
+
+func TestParseUSBIds(t *testing.T) {
+	tests := []struct {
+		id        string
+		vendorId  uint16
+		productId uint16
+		ok        bool
+	}{
+		{"VID_0403&PID_6001", 0x0403, 0x6001, true},
+		{"VID_10C4+PID_EA60+0001A", 0x10c4, 0xea60, true},
+		{"vid_1a86&pid_7523&rev_0264", 0x1a86, 0x7523, true},
+		{"VID_0403", 0, 0, false},
+		{"VID_04G3&PID_6001", 0, 0, false},
+		{"ROOT_HUB30", 0, 0, false},
+	}
+	for _, test := range tests {
+		vendorId, productId, ok := parseUSBIds(test.id)
+		if vendorId != test.vendorId || productId != test.productId || ok != test.ok {
+			t.Errorf("%v: unexpected %#x %#x %v", test.id, vendorId, productId, ok)
+		}
+	}
+	port := SerialPortInfo{USB: true, VendorId: 0x1a86, ProductId: 0x7523}
+	if adapter := port.KnownAdapter(); adapter != "WCH CH340" {
+		t.Fatalf("unexpected adapter %q", adapter)
+	}
+	port.USB = false
+	if adapter := port.KnownAdapter(); adapter != "" {
+		t.Fatalf("unexpected adapter %q", adapter)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const errorNoMoreItems = 259
+
+var (
+	advapi32         = syscall.NewLazyDLL("advapi32.dll")
+	procRegEnumValue = advapi32.NewProc("RegEnumValueW")
+)
+
+// listSerialPorts lists the ports registered by the drivers, described by
+// the device instances of the USB and FTDI buses naming them.
+func listSerialPorts() (ports []SerialPortInfo, err error) {
+	names, err := serialPortNames()
+	if err != nil {
+		return
+	}
+	usb := make(map[string]SerialPortInfo)
+	for _, bus := range []string{"USB", "FTDIBUS"} {
+		listRegistryUSBPorts(`SYSTEM\CurrentControlSet\Enum\`+bus, usb)
+	}
+	for _, name := range names {
+		port := usb[strings.ToUpper(name)]
+		port.Name = name
+		ports = append(ports, port)
+	}
+	return
+}
+
+// serialPortNames returns the names of the serial ports registered by the
+// drivers, e.g. COM3.
+func serialPortNames() (names []string, err error) {
+	key, err := regOpen(syscall.HKEY_LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`)
+	if err != nil {
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			// No serial port
+			err = nil
+		}
+		return
+	}
+	defer syscall.RegCloseKey(key)
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		var data [256]uint16
+		nameLen := uint32(len(name))
+		dataLen := uint32(2 * len(data))
+		var valueType uint32
+		r, _, _ := procRegEnumValue.Call(uintptr(key), uintptr(i),
+			uintptr(unsafe.Pointer(&name[0])), uintptr(unsafe.Pointer(&nameLen)), 0,
+			uintptr(unsafe.Pointer(&valueType)), uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&dataLen)))
+		if syscall.Errno(r) == errorNoMoreItems {
+			return
+		}
+		if r != 0 {
+			err = syscall.Errno(r)
+			return
+		}
+		if valueType == syscall.REG_SZ {
+			names = append(names, syscall.UTF16ToString(data[:dataLen/2]))
+		}
+	}
+}
+
+// listRegistryUSBPorts adds the ports of the device instances of bus to
+// ports by name. Instances are named VID_0403&PID_6001\<serial number> on
+// the USB bus and VID_0403+PID_6001+<serial number>\0000 on the FTDI bus.
+func listRegistryUSBPorts(bus string, ports map[string]SerialPortInfo) {
+	root, err := regOpen(syscall.HKEY_LOCAL_MACHINE, bus)
+	if err != nil {
+		return
+	}
+	defer syscall.RegCloseKey(root)
+	for _, hardwareId := range regSubKeys(root) {
+		vendorId, productId, ok := parseUSBIds(hardwareId)
+		if !ok {
+			continue
+		}
+		device, err := regOpen(root, hardwareId)
+		if err != nil {
+			continue
+		}
+		for _, instance := range regSubKeys(device) {
+			key, err := regOpen(device, instance)
+			if err != nil {
+				continue
+			}
+			name := ""
+			if params, err := regOpen(key, "Device Parameters"); err == nil {
+				name = regString(params, "PortName")
+				syscall.RegCloseKey(params)
+			}
+			if name != "" {
+				port := SerialPortInfo{
+					USB:          true,
+					VendorId:     vendorId,
+					ProductId:    productId,
+					Description:  regString(key, "FriendlyName"),
+					Manufacturer: regString(key, "Mfg"),
+				}
+				// Values may be references to the driver strings
+				if i := strings.LastIndexByte(port.Manufacturer, ';'); i >= 0 {
+					port.Manufacturer = port.Manufacturer[i+1:]
+				}
+				if parts := strings.Split(hardwareId, "+"); len(parts) == 3 {
+					// FTDI appends the channel, A for single channel chips
+					port.SerialNumber = strings.TrimSuffix(parts[2], "A")
+				} else if !strings.Contains(instance, "&") {
+					// Generated by Windows for devices without serial number
+					port.SerialNumber = instance
+				}
+				ports[strings.ToUpper(name)] = port
+			}
+			syscall.RegCloseKey(key)
+		}
+		syscall.RegCloseKey(device)
+	}
+}
+
+func regOpen(parent syscall.Handle, path string) (key syscall.Handle, err error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return
+	}
+	err = syscall.RegOpenKeyEx(parent, p, 0, syscall.KEY_READ, &key)
+	return
+}
+
+func regSubKeys(key syscall.Handle) (names []string) {
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		nameLen := uint32(len(name))
+		if err := syscall.RegEnumKeyEx(key, i, &name[0], &nameLen, nil, nil, nil, nil); err != nil {
+			return
+		}
+		names = append(names, syscall.UTF16ToString(name[:nameLen]))
+	}
+}
+
+func regString(key syscall.Handle, name string) string {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return ""
+	}
+	var data [512]uint16
+	var valueType uint32
+	dataLen := uint32(2 * len(data))
+	if err = syscall.RegQueryValueEx(key, p, nil, &valueType, (*byte)(unsafe.Pointer(&data[0])), &dataLen); err != nil || valueType != syscall.REG_SZ {
+		return ""
+	}
+	return syscall.UTF16ToString(data[:dataLen/2])
+}
@@ -0,0 +1,555 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+type simByte struct {
+	value byte
+	at    time.Time
+}
+
+// simLine is a serial line in simulated time. Each character takes
+// bitsPerChar/baudRate to transmit. Requests written by the master are
+// passed to slave, whose response is received turnaround after the end
+// of the request, one character at a time.
+type simLine struct {
+	clock       *simClock
+	baudRate    int
+	bitsPerChar int
+	// timeout of a read without data, as the serial driver does.
+	timeout    time.Duration
+	turnaround time.Duration
+	slave      func(request []byte) []byte
+
+	rx     []simByte
+	closed bool
+	// writes contains start times of the frames written.
+	writes []time.Time
+}
+
+func newSimLine(baudRate int, slave func(request []byte) []byte) *simLine {
+	return &simLine{
+		clock:       &simClock{now: time.Unix(0, 0)},
+		baudRate:    baudRate,
+		bitsPerChar: 11,
+		timeout:     time.Second,
+		turnaround:  time.Millisecond,
+		slave:       slave,
+	}
+}
+
+func (l *simLine) charTime() time.Duration {
+	return time.Duration(l.bitsPerChar) * time.Second / time.Duration(l.baudRate)
+}
+
+// deliver queues bytes to be received one character time apart, the
+// first one completing at start plus one character time. Characters
+// overlapping characters already queued collide and are received garbled.
+func (l *simLine) deliver(start time.Time, data []byte) {
+	for i, b := range data {
+		at := start.Add(time.Duration(i+1) * l.charTime())
+		j := sort.Search(len(l.rx), func(j int) bool {
+			return !l.rx[j].at.Before(at)
+		})
+		if j > 0 && at.Sub(l.rx[j-1].at) < l.charTime() {
+			l.rx[j-1].value ^= b
+			continue
+		}
+		if j < len(l.rx) && l.rx[j].at.Sub(at) < l.charTime() {
+			l.rx[j].value ^= b
+			continue
+		}
+		l.rx = append(l.rx, simByte{})
+		copy(l.rx[j+1:], l.rx[j:])
+		l.rx[j] = simByte{b, at}
+	}
+}
+
+// inject simulates a second master sending request at start, and the
+// response of the slave if it is addressed to it.
+func (l *simLine) inject(start time.Time, request []byte) {
+	l.deliver(start, request)
+	end := start.Add(time.Duration(len(request)) * l.charTime())
+	if response := l.slave(append([]byte(nil), request...)); response != nil {
+		l.deliver(end.Add(l.turnaround), response)
+	}
+}
+
+func (l *simLine) Write(b []byte) (int, error) {
+	l.writes = append(l.writes, l.clock.now)
+	end := l.clock.now.Add(time.Duration(len(b)) * l.charTime())
+	for _, c := range l.rx {
+		if c.at.After(l.clock.now) && c.at.Before(end.Add(l.charTime())) {
+			// The request collides with another transmission, the slave
+			// receives garbage and does not respond.
+			return len(b), nil
+		}
+	}
+	if response := l.slave(append([]byte(nil), b...)); response != nil {
+		l.deliver(end.Add(l.turnaround), response)
+	}
+	return len(b), nil
+}
+
+// Read blocks until at least one byte has arrived or the timeout elapses,
+// then returns all bytes received so far.
+func (l *simLine) Read(b []byte) (n int, err error) {
+	if len(l.rx) == 0 || l.rx[0].at.Sub(l.clock.now) > l.timeout {
+		l.clock.Sleep(l.timeout)
+		return 0, serial.ErrTimeout
+	}
+	if l.rx[0].at.After(l.clock.now) {
+		l.clock.now = l.rx[0].at
+	}
+	for n < len(b) && len(l.rx) > 0 && !l.rx[0].at.After(l.clock.now) {
+		b[n] = l.rx[0].value
+		l.rx = l.rx[1:]
+		n++
+	}
+	return
+}
+
+func (l *simLine) Close() error {
+	l.closed = true
+	return nil
+}
+
+// discard drops the characters received so far, as closing the port or
+// flushing its input does.
+func (l *simLine) discard() {
+	for len(l.rx) > 0 && !l.rx[0].at.After(l.clock.now) {
+		l.rx = l.rx[1:]
+	}
+}
+
+// flushingLine is a simLine whose port supports flushing.
+type flushingLine struct {
+	*simLine
+	flushes int
+}
+
+func (l *flushingLine) Flush(input, output bool) error {
+	l.flushes++
+	if input {
+		l.discard()
+	}
+	return nil
+}
+
+// rtuSlave responds to read holding registers requests with zeros, or
+// with exceptionCode if it is not zero.
+func rtuSlave(exceptionCode byte) func(request []byte) []byte {
+	return func(request []byte) []byte {
+		var packager rtuPackager
+		packager.SlaveId = request[0]
+		pdu := ProtocolDataUnit{FunctionCode: request[1]}
+		if exceptionCode != 0 {
+			pdu.FunctionCode |= 0x80
+			pdu.Data = []byte{exceptionCode}
+		} else {
+			count := int(request[5])
+			pdu.Data = make([]byte, 1+2*count)
+			pdu.Data[0] = byte(2 * count)
+		}
+		response, _ := packager.Encode(&pdu)
+		return response
+	}
+}
+
+func newSimRTUClientHandler(line *simLine) *RTUClientHandler {
+	handler := NewRTUClientHandler("sim")
+	handler.BaudRate = line.baudRate
+	handler.SlaveId = 1
+	handler.port = line
+	handler.Clock = line.clock
+	handler.Timeout = line.timeout
+	handler.open = func(*serial.Config) (io.ReadWriteCloser, error) {
+		line.closed = false
+		line.discard()
+		return line, nil
+	}
+	return handler
+}
+
+func TestRTUSimulatedTiming(t *testing.T) {
+	for _, baudRate := range []int{1200, 9600, 19200, 115200} {
+		line := newSimLine(baudRate, rtuSlave(0))
+		handler := newSimRTUClientHandler(line)
+		client := NewClient(handler)
+
+		start := line.clock.Now()
+		results, err := client.ReadHoldingRegisters(0, 10)
+		if err != nil {
+			t.Fatalf("baud rate %v: %v", baudRate, err)
+		}
+		if len(results) != 20 {
+			t.Fatalf("baud rate %v: unexpected results length %v", baudRate, len(results))
+		}
+		elapsed := line.clock.Now().Sub(start)
+		// 8 bytes request, 25 bytes response
+		wire := 33*line.charTime() + line.turnaround
+		if elapsed < wire {
+			t.Fatalf("baud rate %v: elapsed %v is shorter than wire time %v", baudRate, elapsed, wire)
+		}
+		delay := handler.calculateDelay(33)
+		if elapsed > wire+delay {
+			t.Fatalf("baud rate %v: elapsed %v exceeds wire time %v plus delay %v", baudRate, elapsed, wire, delay)
+		}
+	}
+}
+
+func TestRTUSimulatedException(t *testing.T) {
+	line := newSimLine(9600, rtuSlave(ExceptionCodeIllegalDataAddress))
+	client := NewClient(newSimRTUClientHandler(line))
+
+	_, err := client.ReadHoldingRegisters(0, 10)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("exception expected, actual %v", err)
+	}
+
+	// The exception frame is not all received when the read starts.
+	line.turnaround = 100 * time.Millisecond
+	start := line.clock.Now()
+	_, err = client.ReadHoldingRegisters(0, 10)
+	if mbError, ok := err.(*ModbusError); !ok || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("exception expected, actual %v", err)
+	}
+	if elapsed := line.clock.Now().Sub(start); elapsed >= line.timeout {
+		t.Fatalf("elapsed %v exceeds timeout", elapsed)
+	}
+}
+
+func TestRTUSimulatedTimeout(t *testing.T) {
+	line := newSimLine(9600, func(request []byte) []byte { return nil })
+	handler := newSimRTUClientHandler(line)
+	client := NewClient(handler)
+
+	start := line.clock.Now()
+	if _, err := client.ReadHoldingRegisters(0, 10); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	elapsed := line.clock.Now().Sub(start)
+	expected := handler.calculateDelay(8+25) + line.timeout
+	if elapsed != expected {
+		t.Fatalf("elapsed expected %v, actual %v", expected, elapsed)
+	}
+}
+
+func TestRTUSimulatedStrictFrameDelay(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		line := newSimLine(9600, rtuSlave(0))
+		handler := newSimRTUClientHandler(line)
+		handler.StrictFrameDelay = strict
+		client := NewClient(handler)
+
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		end := line.clock.Now()
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		silence := line.writes[1].Sub(end)
+		// t3.5 at 9600 bauds
+		if strict && silence < 3645*time.Microsecond {
+			t.Fatalf("expected silence of at least t3.5, actual %v", silence)
+		}
+		if !strict && silence != 0 {
+			t.Fatalf("unexpected silence %v", silence)
+		}
+	}
+}
+
+func TestRTUSimulatedTurnaround(t *testing.T) {
+	for _, functions := range [][]byte{nil, {FuncCodeReadHoldingRegisters}} {
+		line := newSimLine(9600, rtuSlave(0))
+		handler := newSimRTUClientHandler(line)
+		handler.Turnaround = 50 * time.Millisecond
+		handler.TurnaroundFunctions = functions
+		client := NewClient(handler)
+
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		end := line.clock.Now()
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		silence := line.writes[1].Sub(end)
+		// Reads are not followed by a turnaround unless configured
+		if functions == nil && silence != 0 || functions != nil && silence != handler.Turnaround {
+			t.Fatalf("functions %v: unexpected silence %v", functions, silence)
+		}
+	}
+}
+
+func TestRTUSimulatedAdaptiveTimeout(t *testing.T) {
+	respond := true
+	line := newSimLine(9600, func(request []byte) []byte {
+		if !respond {
+			return nil
+		}
+		return rtuSlave(0)(request)
+	})
+	// Reads time out in slices, as the port is opened
+	line.timeout = 5 * time.Millisecond
+	handler := newSimRTUClientHandler(line)
+	handler.Timeout = time.Second
+	handler.Adaptive = &AdaptiveTimeout{Min: 10 * time.Millisecond}
+	client := NewClient(handler)
+
+	for i := 0; i < adaptiveMinSamples; i++ {
+		if _, err := client.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timeout := handler.Adaptive.Timeout(1)
+	if timeout <= 0 || timeout >= 100*time.Millisecond {
+		t.Fatalf("unexpected adapted timeout %v", timeout)
+	}
+	respond = false
+	start := line.clock.Now()
+	if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	if elapsed := line.clock.Now().Sub(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("elapsed %v is not bounded by the adapted timeout %v", elapsed, timeout)
+	}
+	if handler.Adaptive.Timeout(1) <= timeout {
+		t.Fatalf("timeout is not increased after timing out")
+	}
+}
+
+func TestRTUSimulatedBroadcast(t *testing.T) {
+	requests := 0
+	line := newSimLine(19200, func(request []byte) []byte {
+		requests++
+		return nil
+	})
+	handler := newSimRTUClientHandler(line)
+	handler.SlaveId = 0
+	client := NewClient(handler)
+
+	start := line.clock.Now()
+	results, err := client.WriteMultipleRegisters(1, 2, []byte{0, 1, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 2}, results) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if elapsed := line.clock.Now().Sub(start); elapsed < serialBroadcastDelay || elapsed > serialBroadcastDelay+20*time.Millisecond {
+		t.Fatalf("unexpected broadcast delay %v", elapsed)
+	}
+	if _, err = client.ReadHoldingRegisters(1, 2); err == nil {
+		t.Fatalf("expected broadcast read error")
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
+
+// TestRTUSimulatedSecondMaster checks that traffic of another master on
+// the bus makes requests fail rather than return foreign data, and that
+// the client resynchronizes once the bus is quiet.
+func TestRTUSimulatedSecondMaster(t *testing.T) {
+	ms := time.Millisecond
+	foreign, _ := (&rtuPackager{SlaveId: 2}).Encode(&ProtocolDataUnit{FuncCodeReadHoldingRegisters, dataBlock(0, 4)})
+	tests := []struct {
+		name string
+		// delay of the foreign request after the start of the client request
+		delay time.Duration
+	}{
+		{"idle", -100 * ms},
+		{"request", 0},
+		{"response", 10 * ms},
+	}
+	for _, test := range tests {
+		line := newSimLine(9600, rtuSlave(0))
+		client := NewClient(newSimRTUClientHandler(line))
+		if test.delay < 0 {
+			line.inject(line.clock.Now(), foreign)
+			line.clock.Sleep(-test.delay)
+		} else {
+			line.inject(line.clock.Now().Add(test.delay), foreign)
+		}
+		recovered := false
+		for i := 0; i < 3 && !recovered; i++ {
+			results, err := client.ReadHoldingRegisters(0, 1)
+			if err == nil {
+				if !bytes.Equal([]byte{0, 0}, results) {
+					t.Fatalf("%v: unexpected results %v", test.name, results)
+				}
+				recovered = true
+			} else if results != nil {
+				t.Fatalf("%v: unexpected results %v with error %v", test.name, results, err)
+			}
+			line.clock.Sleep(100 * ms)
+		}
+		if !recovered {
+			t.Fatalf("%v: client did not resynchronize", test.name)
+		}
+	}
+}
+
+// TestRTUSimulatedFlush checks that a late response to a request which
+// timed out is not taken as the response to the next request.
+func TestRTUSimulatedFlush(t *testing.T) {
+	for _, flusher := range []bool{false, true} {
+		requests := 0
+		line := newSimLine(9600, func(request []byte) []byte {
+			requests++
+			pdu := ProtocolDataUnit{request[1], []byte{2, 0, byte(requests)}}
+			response, _ := (&rtuPackager{SlaveId: request[0]}).Encode(&pdu)
+			return response
+		})
+		line.turnaround = 1500 * time.Millisecond
+		handler := newSimRTUClientHandler(line)
+		var flushing *flushingLine
+		if flusher {
+			flushing = &flushingLine{simLine: line}
+			handler.port = flushing
+		}
+		client := NewClient(handler)
+
+		if _, err := client.ReadHoldingRegisters(0, 1); !errors.Is(err, serial.ErrTimeout) {
+			t.Fatalf("timeout expected, actual %v", err)
+		}
+		// The late response has arrived.
+		line.clock.Sleep(time.Second)
+		line.turnaround = time.Millisecond
+		results, err := client.ReadHoldingRegisters(0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte{0, 2}, results) {
+			t.Fatalf("flusher %v: unexpected results %v", flusher, results)
+		}
+		if flusher && flushing.flushes != 2 {
+			t.Fatalf("unexpected flushes %v", flushing.flushes)
+		}
+	}
+}
+
+// TestRTUSimulatedContext checks that a read waiting for a response is
+// interrupted when the context is canceled rather than at the timeout.
+func TestRTUSimulatedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	line := newSimLine(9600, func(request []byte) []byte {
+		cancel()
+		return nil
+	})
+	// The port reads for a slice of the timeout
+	line.timeout = serialReadSlice
+	handler := newSimRTUClientHandler(line)
+	handler.Timeout = 5 * time.Second
+
+	start := line.clock.Now()
+	_, err := WithContext(NewClient(handler), ctx).ReadHoldingRegisters(0, 10)
+	if err != context.Canceled {
+		t.Fatalf("canceled error expected, actual %v", err)
+	}
+	elapsed := line.clock.Now().Sub(start)
+	if expected := handler.calculateDelay(8+25) + serialReadSlice; elapsed != expected {
+		t.Fatalf("elapsed expected %v, actual %v", expected, elapsed)
+	}
+
+	// Without context, the read lasts until the timeout.
+	line.slave = func(request []byte) []byte { return nil }
+	start = line.clock.Now()
+	if _, err = NewClient(handler).ReadHoldingRegisters(0, 10); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	if elapsed = line.clock.Now().Sub(start); elapsed < handler.Timeout {
+		t.Fatalf("elapsed %v is shorter than timeout", elapsed)
+	}
+}
+
+func TestRTUSimulatedReadTimeout(t *testing.T) {
+	line := newSimLine(9600, func(request []byte) []byte { return nil })
+	line.timeout = serialReadSlice
+	handler := newSimRTUClientHandler(line)
+	handler.Timeout = 5 * time.Second
+	handler.ReadTimeout = 200 * time.Millisecond
+
+	start := line.clock.Now()
+	if _, err := NewClient(handler).ReadHoldingRegisters(0, 10); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	elapsed := line.clock.Now().Sub(start)
+	if elapsed < handler.ReadTimeout || elapsed >= time.Second {
+		t.Fatalf("elapsed %v does not match read timeout", elapsed)
+	}
+}
+
+func TestRTUSimulatedEnron(t *testing.T) {
+	device := &enronDevice{registers: map[uint16]uint32{7000: math.Float32bits(2.5), 7001: math.Float32bits(-1)}}
+	line := newSimLine(9600, func(request []byte) []byte {
+		packager := rtuPackager{SlaveId: request[0]}
+		pdu, _ := packager.Decode(request)
+		response, _ := packager.Encode(device.serve(pdu))
+		return response
+	})
+	client := NewEnronClient(NewClient(newSimRTUClientHandler(line)))
+
+	values, err := client.ReadFloat32s(7000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]float32{2.5, -1}, values) {
+		t.Fatalf("unexpected values %v", values)
+	}
+}
+
+func TestRTUCRCRetry(t *testing.T) {
+	corrupted := 0
+	slave := rtuSlave(0)
+	line := newSimLine(9600, func(request []byte) []byte {
+		response := slave(request)
+		if corrupted > 0 {
+			corrupted--
+			response[3] ^= 0x10
+		}
+		return response
+	})
+	handler := newSimRTUClientHandler(line)
+	handler.CRCRetries = 2
+	client := NewClient(handler)
+
+	corrupted = 1
+	if _, err := client.ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(line.writes) != 2 || handler.CRCErrors() != 1 {
+		t.Fatalf("unexpected writes %v, crc errors %v", len(line.writes), handler.CRCErrors())
+	}
+	corrupted = 5
+	if _, err := client.ReadHoldingRegisters(0, 2); err == nil {
+		t.Fatal("crc error expected")
+	}
+	if len(line.writes) != 5 || handler.CRCErrors() != 4 {
+		t.Fatalf("unexpected writes %v, crc errors %v", len(line.writes), handler.CRCErrors())
+	}
+	corrupted = 0
+	if _, err := client.ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatal(err)
+	}
+}
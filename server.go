@@ -0,0 +1,583 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// Handler serves the data of a Modbus server, see Server. Requests are
+// validated by the server, addresses and quantities are in range. Errors
+// wrapping a *ModbusError, including gateway errors such as
+// ErrGatewayPathUnavailable, are sent as their exception, others as server
+// device failure.
+type Handler interface {
+	OnReadCoils(unitId byte, address, quantity uint16) (values []bool, err error)
+	OnReadDiscreteInputs(unitId byte, address, quantity uint16) (values []bool, err error)
+	OnReadHoldingRegisters(unitId byte, address, quantity uint16) (values []uint16, err error)
+	OnReadInputRegisters(unitId byte, address, quantity uint16) (values []uint16, err error)
+	OnWriteCoils(unitId byte, address uint16, values []bool) error
+	OnWriteHoldingRegisters(unitId byte, address uint16, values []uint16) error
+}
+
+// Server serves Modbus TCP clients with a Handler, e.g. to emulate a
+// device backed by live application data:
+//  store := modbus.NewMemoryStore()
+//  store.SetHoldingRegisters(0, 230, 50)
+//  server := modbus.NewServer(store)
+//  err := server.ListenAndServe(":502")
+// Mask write register and read/write multiple registers requests are
+// served with a read and a write of the handler.
+//
+// A server may serve several unit ids with distinct handlers, e.g. to
+// emulate a gateway fronting many devices:
+//  server := modbus.NewServer(nil)
+//  server.Handle(1, meter)
+//  server.Handle(2, inverter)
+// Requests to units without handler are answered with the exception
+// gateway target device failed to respond.
+type Server struct {
+	// Handler serves the units without their own handler, if not nil.
+	Handler Handler
+	// Logger logs handler failures if set.
+	Logger *log.Logger
+	// Strict answers requests followed by extra bytes with the exception
+	// Illegal Data Value instead of ignoring the extra bytes, see
+	// ValidateRequest.
+	Strict bool
+	// StrictFraming closes TCP connections receiving keep-alive frames
+	// without PDU or frames with a non-zero protocol id, which are
+	// otherwise skipped.
+	StrictFraming bool
+	// Limits of the clients
+	ConnLimits
+	// PDUHandler, if set, serves all the requests instead of the handlers
+	// and hooks, e.g. to simulate a device answering any function code.
+	// If it fails, the request is not answered and its TCP connection is
+	// closed.
+	PDUHandler func(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error)
+	// BeforeRequest, if set, is called before serving each request, e.g.
+	// to authorize writes. The request is not served if it returns an
+	// error, which is answered like the errors of the handler:
+	//  server.BeforeRequest = func(r *modbus.ServerRequest) error {
+	//  	if r.IsWrite() && !trusted(r.Client) {
+	//  		return &modbus.ModbusError{ExceptionCode: modbus.ExceptionCodeIllegalFunction}
+	//  	}
+	//  	return nil
+	//  }
+	// AfterRequest, if set, is called after serving each request, or after
+	// BeforeRequest denied it, with the error answered, e.g. to keep an
+	// audit trail. Hooks are called concurrently for requests of distinct
+	// connections. The old values of writes are read from the handler only
+	// if a hook is set.
+	BeforeRequest func(r *ServerRequest) error
+	AfterRequest  func(r *ServerRequest, err error)
+
+	mu       sync.Mutex
+	units    map[byte]Handler
+	listener net.Listener
+	conns    connTracker
+	ports    map[io.Closer]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer allocates a new Server of handler.
+func NewServer(handler Handler) *Server {
+	return &Server{
+		Handler: handler,
+		units:   make(map[byte]Handler),
+		ports:   make(map[io.Closer]struct{}),
+	}
+}
+
+// Handle serves the requests to unitId with handler, or with Handler if
+// handler is nil.
+func (s *Server) Handle(unitId byte, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handler == nil {
+		delete(s.units, unitId)
+	} else {
+		s.units[unitId] = handler
+	}
+}
+
+// handler returns the handler of unitId, nil if the unit is unknown.
+func (s *Server) handler(unitId byte) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.units[unitId]; ok {
+		return h
+	}
+	return s.Handler
+}
+
+// ListenAndServe listens on the TCP address and serves clients until
+// Close is called.
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each of them in its
+// own goroutine until Close is called. It always returns a non-nil error.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.serveListener(listener, s.serveConn)
+}
+
+// serveListener accepts connections on the listener and serves each of
+// them with serve in its own goroutine, see Serve.
+func (s *Server) serveListener(listener net.Listener, serve func(conn net.Conn)) error {
+	if err := s.parseLimits(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.conns.isDraining() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		host, err := s.admit(conn)
+		if err != nil {
+			s.logf("modbus: server rejected connection: %v", err)
+			conn.Close()
+			continue
+		}
+		if !s.conns.add(conn) {
+			s.release(host)
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.trackConn(conn, host, serve)
+	}
+}
+
+// Close stops listening, closes client connections and serial ports and
+// waits for requests in progress to complete.
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.conns.closeAll()
+	for port := range s.ports {
+		port.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return
+}
+
+// Shutdown stops listening and waits for the requests in progress to
+// complete, up to the deadline of ctx, closing connections and serial
+// ports once idle. If ctx is done first, Shutdown closes them without
+// waiting for the requests in progress and returns the error of ctx.
+// Serve methods return ErrServerClosed after Shutdown.
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	s.conns.drain()
+	s.mu.Lock()
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Unlock()
+	if e := waitContext(ctx, &s.wg); e != nil {
+		s.mu.Lock()
+		s.conns.closeAll()
+		for port := range s.ports {
+			port.Close()
+		}
+		s.mu.Unlock()
+		return e
+	}
+	return
+}
+
+// ServePDU serves the request to unitId with the handler and returns the
+// response, which is an exception response if the request can not be
+// served. It allows serving requests received by other transports.
+func (s *Server) ServePDU(unitId byte, request *ProtocolDataUnit) *ProtocolDataUnit {
+	return s.servePDU("", unitId, request)
+}
+
+// servePDU serves the request of client, see ServePDU.
+func (s *Server) servePDU(client string, unitId byte, request *ProtocolDataUnit) *ProtocolDataUnit {
+	h := s.handler(unitId)
+	if h == nil {
+		return gatewayException(request, ExceptionCodeGatewayTargetDeviceFailedToRespond)
+	}
+	if s.Strict {
+		if err := ValidateRequest(request, true); err != nil {
+			return gatewayException(request, ExceptionCodeIllegalDataValue)
+		}
+	}
+	var data []byte
+	var err error
+	if s.BeforeRequest != nil || s.AfterRequest != nil {
+		data, err = s.serveHooked(client, h, unitId, request)
+	} else {
+		data, err = s.serve(h, unitId, request)
+	}
+	if err != nil {
+		exceptionCode := byte(ExceptionCodeServerDeviceFailure)
+		var mbError *ModbusError
+		if errors.As(err, &mbError) {
+			exceptionCode = mbError.ExceptionCode
+		} else {
+			s.logf("modbus: server failed to serve function '%v' of unit id '%v': %v", request.FunctionCode, unitId, err)
+		}
+		return gatewayException(request, exceptionCode)
+	}
+	return &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: data}
+}
+
+// trackConn serves conn with serve and closes it.
+func (s *Server) trackConn(conn net.Conn, host string, serve func(conn net.Conn)) {
+	defer s.wg.Done()
+	defer s.release(host)
+	defer func() {
+		s.conns.remove(conn)
+		conn.Close()
+	}()
+	serve(conn)
+}
+
+// serveConn serves the Modbus TCP requests of conn.
+func (s *Server) serveConn(conn net.Conn) {
+	serveTCP(conn, &s.ConnLimits, &s.conns, s.StrictFraming, s.logf, s.tcpServe(conn.RemoteAddr().String()))
+}
+
+// tcpServe returns the function serving the Modbus TCP requests of client
+// with PDUHandler if set, or the handlers.
+func (s *Server) tcpServe(client string) func(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+	return func(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		if s.PDUHandler != nil {
+			return s.PDUHandler(unitId, request)
+		}
+		return s.servePDU(client, unitId, request), nil
+	}
+}
+
+func (s *Server) serve(h Handler, unitId byte, request *ProtocolDataUnit) (data []byte, err error) {
+	switch request.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		address, quantity, err := serverRange(request.Data, 4, 2000)
+		if err != nil {
+			return nil, err
+		}
+		var values []bool
+		if request.FunctionCode == FuncCodeReadCoils {
+			values, err = h.OnReadCoils(unitId, address, quantity)
+		} else {
+			values, err = h.OnReadDiscreteInputs(unitId, address, quantity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		bits := packBits(values, int(quantity))
+		return append([]byte{byte(len(bits))}, bits...), nil
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		address, quantity, err := serverRange(request.Data, 4, 125)
+		if err != nil {
+			return nil, err
+		}
+		var values []uint16
+		if request.FunctionCode == FuncCodeReadHoldingRegisters {
+			values, err = h.OnReadHoldingRegisters(unitId, address, quantity)
+		} else {
+			values, err = h.OnReadInputRegisters(unitId, address, quantity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return registerBlock(values, int(quantity)), nil
+	case FuncCodeWriteSingleCoil:
+		if len(request.Data) != 4 {
+			return nil, serverException(ExceptionCodeIllegalDataValue)
+		}
+		var value bool
+		switch binary.BigEndian.Uint16(request.Data[2:]) {
+		case 0xFF00:
+			value = true
+		case 0x0000:
+		default:
+			return nil, serverException(ExceptionCodeIllegalDataValue)
+		}
+		if err = h.OnWriteCoils(unitId, binary.BigEndian.Uint16(request.Data), []bool{value}); err != nil {
+			return
+		}
+		return request.Data, nil
+	case FuncCodeWriteSingleRegister:
+		if len(request.Data) != 4 {
+			return nil, serverException(ExceptionCodeIllegalDataValue)
+		}
+		value := binary.BigEndian.Uint16(request.Data[2:])
+		if err = h.OnWriteHoldingRegisters(unitId, binary.BigEndian.Uint16(request.Data), []uint16{value}); err != nil {
+			return
+		}
+		return request.Data, nil
+	case FuncCodeWriteMultipleCoils:
+		address, quantity, err := serverRange(request.Data, 5, 1968)
+		if err != nil {
+			return nil, err
+		}
+		count := (int(quantity) + 7) / 8
+		if int(request.Data[4]) != count || len(request.Data) != 5+count {
+			return nil, serverException(ExceptionCodeIllegalDataValue)
+		}
+		values := make([]bool, quantity)
+		for i := range values {
+			values[i] = request.Data[5+i/8]&(1<<uint(i%8)) != 0
+		}
+		if err = h.OnWriteCoils(unitId, address, values); err != nil {
+			return nil, err
+		}
+		return request.Data[:4], nil
+	case FuncCodeWriteMultipleRegisters:
+		address, quantity, err := serverRange(request.Data, 5, 123)
+		if err != nil {
+			return nil, err
+		}
+		values, err := serverRegisters(request.Data[4:], quantity)
+		if err != nil {
+			return nil, err
+		}
+		if err = h.OnWriteHoldingRegisters(unitId, address, values); err != nil {
+			return nil, err
+		}
+		return request.Data[:4], nil
+	case FuncCodeMaskWriteRegister:
+		if len(request.Data) != 6 {
+			return nil, serverException(ExceptionCodeIllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(request.Data)
+		andMask := binary.BigEndian.Uint16(request.Data[2:])
+		orMask := binary.BigEndian.Uint16(request.Data[4:])
+		values, err := h.OnReadHoldingRegisters(unitId, address, 1)
+		if err != nil {
+			return nil, err
+		}
+		var value uint16
+		if len(values) > 0 {
+			value = values[0]
+		}
+		value = (value & andMask) | (orMask &^ andMask)
+		if err = h.OnWriteHoldingRegisters(unitId, address, []uint16{value}); err != nil {
+			return nil, err
+		}
+		return request.Data, nil
+	case FuncCodeReadWriteMultipleRegisters:
+		readAddress, readQuantity, err := serverRange(request.Data, 9, 125)
+		if err != nil {
+			return nil, err
+		}
+		writeAddress, writeQuantity, err := serverRange(request.Data[4:], 5, 121)
+		if err != nil {
+			return nil, err
+		}
+		values, err := serverRegisters(request.Data[8:], writeQuantity)
+		if err != nil {
+			return nil, err
+		}
+		// Write is performed before read
+		if err = h.OnWriteHoldingRegisters(unitId, writeAddress, values); err != nil {
+			return nil, err
+		}
+		if values, err = h.OnReadHoldingRegisters(unitId, readAddress, readQuantity); err != nil {
+			return nil, err
+		}
+		return registerBlock(values, int(readQuantity)), nil
+	default:
+		return nil, serverException(ExceptionCodeIllegalFunction)
+	}
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// serverException returns the error of the exception, see Handler.
+func serverException(exceptionCode byte) error {
+	return &ModbusError{ExceptionCode: exceptionCode}
+}
+
+// ValidateRequest checks the length of the data of request, e.g. received
+// from untrusted input, against the fields of its function and their byte
+// count. It returns the exception Illegal Data Value if the data is too
+// short or, if strict, followed by extra bytes. Requests of functions
+// other than the reads and writes of coils and registers are not checked.
+func ValidateRequest(request *ProtocolDataUnit, strict bool) error {
+	length, ok := requestDataLength(request)
+	if !ok {
+		return nil
+	}
+	if len(request.Data) < length || strict && len(request.Data) > length {
+		return serverException(ExceptionCodeIllegalDataValue)
+	}
+	return nil
+}
+
+// requestDataLength returns the length of the data of request given its
+// byte count, or the length up to the byte count if data is shorter.
+func requestDataLength(request *ProtocolDataUnit) (length int, ok bool) {
+	data := request.Data
+	switch request.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		return 4, true
+	case FuncCodeMaskWriteRegister:
+		return 6, true
+	case FuncCodeReadFIFOQueue:
+		return 2, true
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		if len(data) < 5 {
+			return 5, true
+		}
+		return 5 + int(data[4]), true
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 9 {
+			return 9, true
+		}
+		return 9 + int(data[8]), true
+	}
+	return 0, false
+}
+
+// serverRange returns the address and quantity of request data of at
+// least size bytes, checking the quantity against max and the range
+// against the address space.
+func serverRange(data []byte, size int, max uint16) (address, quantity uint16, err error) {
+	if len(data) < size {
+		err = serverException(ExceptionCodeIllegalDataValue)
+		return
+	}
+	address = binary.BigEndian.Uint16(data)
+	quantity = binary.BigEndian.Uint16(data[2:])
+	if quantity < 1 || quantity > max {
+		err = serverException(ExceptionCodeIllegalDataValue)
+		return
+	}
+	if int(address)+int(quantity) > 65536 {
+		err = serverException(ExceptionCodeIllegalDataAddress)
+	}
+	return
+}
+
+// serverRegisters decodes the byte count and the quantity of registers
+// following it.
+func serverRegisters(data []byte, quantity uint16) (values []uint16, err error) {
+	if int(data[0]) != 2*int(quantity) || len(data) != 1+2*int(quantity) {
+		err = serverException(ExceptionCodeIllegalDataValue)
+		return
+	}
+	values = make([]uint16, quantity)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[1+2*i:])
+	}
+	return
+}
+
+// packBits packs quantity values, 8 per byte starting with the least
+// significant bit.
+func packBits(values []bool, quantity int) []byte {
+	bits := make([]byte, (quantity+7)/8)
+	for i := 0; i < quantity && i < len(values); i++ {
+		if values[i] {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bits
+}
+
+// registerBlock encodes the byte count and quantity values.
+func registerBlock(values []uint16, quantity int) []byte {
+	data := make([]byte, 1+2*quantity)
+	data[0] = byte(2 * quantity)
+	for i := 0; i < quantity && i < len(values); i++ {
+		binary.BigEndian.PutUint16(data[1+2*i:], values[i])
+	}
+	return data
+}
+
+// serveTCP reads the Modbus TCP requests of conn and writes the responses
+// returned by serve, until conn is closed, a frame is invalid, serve fails,
+// the timeouts of limits expire or conns drains. A nil response is not
+// sent. Keep-alive frames without PDU and frames of other protocols than
+// Modbus are skipped, unless strict.
+func serveTCP(conn net.Conn, limits *ConnLimits, conns *connTracker, strict bool, logf func(format string, v ...interface{}), serve func(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error)) {
+	var data [tcpMaxLength]byte
+	for {
+		if !conns.setIdle(conn, true) {
+			return
+		}
+		limits.awaitRequest(conn)
+		if _, err := io.ReadFull(conn, data[:1]); err != nil {
+			return
+		}
+		conns.setIdle(conn, false)
+		limits.receiveFrame(conn)
+		// Keep-alive frames may end before the unit id
+		if _, err := io.ReadFull(conn, data[1:tcpHeaderSize-1]); err != nil {
+			logf("modbus: closing connection, request header not received: %v", err)
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if length > tcpMaxLength-tcpHeaderSize+1 {
+			logf("modbus: closing connection, invalid length in request header '%v'", length)
+			return
+		}
+		if _, err := io.ReadFull(conn, data[tcpHeaderSize-1:tcpHeaderSize-1+length]); err != nil {
+			logf("modbus: closing connection, request not received: %v", err)
+			return
+		}
+		if protocolId := binary.BigEndian.Uint16(data[2:]); length < 2 || protocolId != tcpProtocolIdentifier {
+			if strict {
+				logf("modbus: closing connection, frame of protocol id '%v' and length '%v' received", protocolId, length)
+				return
+			}
+			continue
+		}
+		request := &ProtocolDataUnit{
+			FunctionCode: data[tcpHeaderSize],
+			Data:         data[tcpHeaderSize+1 : tcpHeaderSize+length-1],
+		}
+		response, err := serve(data[6], request)
+		if err != nil {
+			logf("modbus: closing connection, request not served: %v", err)
+			return
+		}
+		if response == nil {
+			continue
+		}
+		adu := make([]byte, tcpHeaderSize+1+len(response.Data))
+		// Transaction, protocol and unit id are echoed
+		copy(adu, data[:4])
+		binary.BigEndian.PutUint16(adu[4:], uint16(2+len(response.Data)))
+		adu[6] = data[6]
+		adu[tcpHeaderSize] = response.FunctionCode
+		copy(adu[tcpHeaderSize+1:], response.Data)
+		limits.sendFrame(conn)
+		if _, err := conn.Write(adu); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/goburrow/serial"
+)
+
+// framingNames are the names of the framings logged by ServeDetect.
+var framingNames = map[frameFormat]string{
+	frameRTU:   "RTU",
+	frameASCII: "ASCII",
+	frameTCP:   "TCP",
+}
+
+// ListenAndServeDetect opens the serial port of config and serves RTU or
+// ASCII requests until Close is called, see ServeDetect.
+func (s *Server) ListenAndServeDetect(config serial.Config) error {
+	if err := checkSerialFormat(&config); err != nil {
+		return err
+	}
+	if config.Timeout <= 0 || config.Timeout > serialReadSlice {
+		config.Timeout = serialReadSlice
+	}
+	port, err := openPort(&config)
+	if err != nil {
+		return err
+	}
+	return s.ServeDetect(port)
+}
+
+// ServeDetectTCP accepts connections on the listener and serves each of
+// them in the framing detected, Modbus TCP, RTU or ASCII over TCP, e.g. for
+// serial servers of unknown equipment, see ServeDetect.
+func (s *Server) ServeDetectTCP(listener net.Listener) error {
+	return s.serveListener(listener, func(conn net.Conn) {
+		s.ServeDetect(conn)
+	})
+}
+
+// ServeDetect serves the requests received on the port in the framing of
+// its first frame, until reading fails or Close is called:
+//  ASCII  a colon, hexadecimal characters with a valid LRC and CRLF
+//  RTU    a frame with a valid CRC
+//  TCP    a MBAP header with protocol id 0 and a plausible length, on
+//         TCP connections only
+// Data preceding the first frame is discarded, on serial ports when the
+// line is silent. The framing is then kept, see ServeRTU and ServeASCII.
+func (s *Server) ServeDetect(port io.ReadWriteCloser) error {
+	s.mu.Lock()
+	s.ports[port] = struct{}{}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	defer func() {
+		s.mu.Lock()
+		delete(s.ports, port)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	conn, mbap := port.(net.Conn)
+	var buf [asciiMaxSize]byte
+	length := 0
+	for {
+		n, err := port.Read(buf[length:])
+		if err == serial.ErrTimeout {
+			// Characters of ASCII frames may be up to one second apart
+			if length > 0 && buf[0] != asciiStart[0] {
+				s.logf("modbus: server discarding undetected frame % x\n", buf[:length])
+				length = 0
+			}
+			if length == 0 && s.drained(port) {
+				return ErrServerClosed
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		length += n
+		format, ok := detectFraming(buf[:length], mbap)
+		if !ok {
+			if length == len(buf) {
+				// Not the start of a frame
+				length = copy(buf[:], buf[1:length])
+			}
+			continue
+		}
+		s.logf("modbus: server detected %v framing\n", framingNames[format])
+		prefix := append([]byte(nil), buf[:length]...)
+		switch format {
+		case frameASCII:
+			return s.ServeASCII(&prefixPort{port, prefix})
+		case frameTCP:
+			serveTCP(&prefixConn{conn, prefix}, &s.ConnLimits, &s.conns, s.StrictFraming, s.logf, s.tcpServe(conn.RemoteAddr().String()))
+			return io.EOF
+		default:
+			return s.ServeRTU(&prefixPort{port, prefix})
+		}
+	}
+}
+
+// detectFraming returns the framing of the first frame of data, or false
+// if it is not complete. MBAP headers are only detected if mbap is true.
+func detectFraming(data []byte, mbap bool) (format frameFormat, ok bool) {
+	if data[0] == asciiStart[0] {
+		if end := bytes.IndexByte(data, '\n'); end >= 0 {
+			adu, err := decodeASCIIFrame(data[:end+1])
+			if err == nil && len(adu) >= 3 && LRC(adu[:len(adu)-1]) == adu[len(adu)-1] {
+				return frameASCII, true
+			}
+		}
+	}
+	// The CRC of RTU frames is checked first, as requests of address 0 are
+	// plausible MBAP headers
+	if rtuFrameLength(data) > 0 {
+		return frameRTU, true
+	}
+	if mbap && len(data) >= tcpHeaderSize && data[2] == 0 && data[3] == 0 {
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if length >= 2 && length <= tcpMaxLength-tcpHeaderSize+1 && len(data) >= tcpHeaderSize-1+length {
+			return frameTCP, true
+		}
+	}
+	return
+}
+
+// prefixPort is a port whose reads return prefix first.
+type prefixPort struct {
+	io.ReadWriteCloser
+	prefix []byte
+}
+
+func (p *prefixPort) Read(b []byte) (n int, err error) {
+	if len(p.prefix) > 0 {
+		n = copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		return
+	}
+	return p.ReadWriteCloser.Read(b)
+}
+
+// prefixConn is a connection whose reads return prefix first.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (n int, err error) {
+	if len(c.prefix) > 0 {
+		n = copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return
+	}
+	return c.Conn.Read(b)
+}
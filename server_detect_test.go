@@ -0,0 +1,95 @@
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDetectFraming(t *testing.T) {
+	rtu, _ := NewRTUPackager(1).Encode(&ProtocolDataUnit{FuncCodeReadHoldingRegisters, dataBlock(0, 10)})
+	ascii, _ := NewASCIIPackager(1).Encode(&ProtocolDataUnit{FuncCodeReadHoldingRegisters, dataBlock(0, 10)})
+	tcp := []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 10}
+	tests := []struct {
+		data   []byte
+		mbap   bool
+		format frameFormat
+		ok     bool
+	}{
+		{rtu, true, frameRTU, true},
+		{rtu[:6], true, 0, false},
+		{ascii, false, frameASCII, true},
+		{ascii[:10], false, 0, false},
+		{tcp, true, frameTCP, true},
+		{append(tcp, tcp[:3]...), true, frameTCP, true},
+		{tcp[:9], true, 0, false},
+		{tcp, false, 0, false},
+	}
+	for i, test := range tests {
+		if format, ok := detectFraming(test.data, test.mbap); format != test.format || ok != test.ok {
+			t.Errorf("%v: unexpected framing %v, %v", i, format, ok)
+		}
+	}
+}
+
+func TestServerDetectTCP(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 7, 8)
+	server := NewServer(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeDetectTCP(listener)
+	defer server.Close()
+	address := listener.Addr().String()
+
+	tcp := NewTCPClientHandler(address)
+	rtu := NewRTUOverTCPClientHandler(address)
+	ascii := NewASCIIOverTCPClientHandler(address)
+	handlers := map[string]ClientHandler{"TCP": tcp, "RTU": rtu, "ASCII": ascii}
+	tcp.SlaveId, rtu.SlaveId, ascii.SlaveId = 1, 1, 1
+	tcp.Timeout, rtu.Timeout, ascii.Timeout = time.Second, time.Second, time.Second
+	for name, handler := range handlers {
+		client := NewClient(handler)
+		// The framing is kept after the first request
+		for i := 0; i < 2; i++ {
+			results, err := client.ReadHoldingRegisters(0, 2)
+			if err != nil || !reflect.DeepEqual(results, []byte{0, 7, 0, 8}) {
+				t.Fatalf("%v: unexpected results %v, error %v", name, results, err)
+			}
+		}
+	}
+	tcp.Close()
+	rtu.Close()
+	ascii.Close()
+}
+
+func TestServerDetectSerial(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 5)
+	server := NewServer(store)
+	serverPort, clientPort := net.Pipe()
+	go server.ServeDetect(&pipePort{serverPort})
+	defer server.Close()
+
+	// Noise on the line is discarded
+	clientPort.Write([]byte{0xFF, 0x00})
+	time.Sleep(50 * time.Millisecond)
+	ascii, _ := NewASCIIPackager(1).Encode(&ProtocolDataUnit{FuncCodeReadHoldingRegisters, dataBlock(0, 1)})
+	if _, err := clientPort.Write(ascii); err != nil {
+		t.Fatal(err)
+	}
+	clientPort.SetReadDeadline(time.Now().Add(time.Second))
+	response := make([]byte, 64)
+	n, err := clientPort.Read(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response[:n]) != ":0103020005F5\r\n" {
+		t.Fatalf("unexpected response %q", response[:n])
+	}
+}
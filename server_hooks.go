@@ -0,0 +1,156 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ServerRequest is a request received by a Server, passed to its hooks,
+// see BeforeRequest.
+type ServerRequest struct {
+	// Client is the address of the TCP client, empty for requests received
+	// on serial ports or passed to ServePDU.
+	Client       string
+	UnitId       byte
+	FunctionCode byte
+	// Table, Address and Quantity are the range accessed, the range written
+	// by read/write multiple registers. They are zero if the request is
+	// invalid.
+	Table    Table
+	Address  uint16
+	Quantity uint16
+	// Values are the values written, coils are 0 or 1. OldValues are the
+	// values they replace, read from the handler before serving the
+	// request, nil if they can not be read.
+	Values    []uint16
+	OldValues []uint16
+
+	andMask, orMask uint16
+}
+
+// String returns the function, the unit and the range of the request.
+func (r *ServerRequest) String() string {
+	return fmt.Sprintf("function '%v' of unit id '%v' on %v %v-%v", r.FunctionCode, r.UnitId, r.Table, r.Address, int(r.Address)+int(r.Quantity)-1)
+}
+
+// IsWrite returns true if the request writes values.
+func (r *ServerRequest) IsWrite() bool {
+	switch r.FunctionCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister, FuncCodeReadWriteMultipleRegisters:
+		return true
+	}
+	return false
+}
+
+// newServerRequest decodes the range and the values written of request,
+// ignoring the errors reported by serve.
+func newServerRequest(client string, unitId byte, request *ProtocolDataUnit) *ServerRequest {
+	r := &ServerRequest{Client: client, UnitId: unitId, FunctionCode: request.FunctionCode}
+	data := request.Data
+	var err error
+	switch request.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		r.Table = TableCoils
+		if request.FunctionCode == FuncCodeReadDiscreteInputs {
+			r.Table = TableDiscreteInputs
+		}
+		r.Address, r.Quantity, err = serverRange(data, 4, 2000)
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		r.Table = TableHoldingRegisters
+		if request.FunctionCode == FuncCodeReadInputRegisters {
+			r.Table = TableInputRegisters
+		}
+		r.Address, r.Quantity, err = serverRange(data, 4, 125)
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		if len(data) != 4 {
+			return r
+		}
+		r.Table = TableHoldingRegisters
+		r.Address, r.Quantity = binary.BigEndian.Uint16(data), 1
+		value := binary.BigEndian.Uint16(data[2:])
+		if request.FunctionCode == FuncCodeWriteSingleCoil {
+			r.Table = TableCoils
+			if value == 0xFF00 {
+				value = 1
+			}
+		}
+		r.Values = []uint16{value}
+	case FuncCodeWriteMultipleCoils:
+		r.Table = TableCoils
+		if r.Address, r.Quantity, err = serverRange(data, 5, 1968); err == nil && len(data) == 5+(int(r.Quantity)+7)/8 {
+			r.Values = make([]uint16, r.Quantity)
+			for i := range r.Values {
+				if data[5+i/8]&(1<<uint(i%8)) != 0 {
+					r.Values[i] = 1
+				}
+			}
+		}
+	case FuncCodeWriteMultipleRegisters:
+		r.Table = TableHoldingRegisters
+		if r.Address, r.Quantity, err = serverRange(data, 5, 123); err == nil {
+			r.Values, err = serverRegisters(data[4:], r.Quantity)
+		}
+	case FuncCodeMaskWriteRegister:
+		if len(data) != 6 {
+			return r
+		}
+		r.Table = TableHoldingRegisters
+		r.Address, r.Quantity = binary.BigEndian.Uint16(data), 1
+		r.andMask, r.orMask = binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:])
+	case FuncCodeReadWriteMultipleRegisters:
+		r.Table = TableHoldingRegisters
+		if len(data) >= 9 {
+			if r.Address, r.Quantity, err = serverRange(data[4:], 5, 121); err == nil {
+				r.Values, err = serverRegisters(data[8:], r.Quantity)
+			}
+		}
+	}
+	if err != nil {
+		return &ServerRequest{Client: client, UnitId: unitId, FunctionCode: request.FunctionCode}
+	}
+	return r
+}
+
+// readOldValues reads the values replaced by the write of r from h.
+func (r *ServerRequest) readOldValues(h Handler) {
+	if !r.IsWrite() || r.Table == 0 {
+		return
+	}
+	if r.Table == TableCoils {
+		values, err := h.OnReadCoils(r.UnitId, r.Address, r.Quantity)
+		if err != nil || len(values) < int(r.Quantity) {
+			return
+		}
+		r.OldValues = bitValues(values[:r.Quantity])
+		return
+	}
+	values, err := h.OnReadHoldingRegisters(r.UnitId, r.Address, r.Quantity)
+	if err != nil || len(values) < int(r.Quantity) {
+		return
+	}
+	r.OldValues = append([]uint16(nil), values[:r.Quantity]...)
+	if r.FunctionCode == FuncCodeMaskWriteRegister {
+		r.Values = []uint16{(r.OldValues[0] & r.andMask) | (r.orMask &^ r.andMask)}
+	}
+}
+
+// serveHooked serves the request with the hooks of the server.
+func (s *Server) serveHooked(client string, h Handler, unitId byte, request *ProtocolDataUnit) (data []byte, err error) {
+	r := newServerRequest(client, unitId, request)
+	r.readOldValues(h)
+	if s.BeforeRequest != nil {
+		err = s.BeforeRequest(r)
+	}
+	if err == nil {
+		data, err = s.serve(h, unitId, request)
+	}
+	if s.AfterRequest != nil {
+		s.AfterRequest(r, err)
+	}
+	return
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServerHooks(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(10, 1, 2, 0x00F0)
+	server := NewServer(store)
+	var mu sync.Mutex
+	var audit []ServerRequest
+	var errs []error
+	server.BeforeRequest = func(r *ServerRequest) error {
+		if r.IsWrite() && r.Address == 100 {
+			return &ModbusError{ExceptionCode: ExceptionCodeIllegalDataAddress}
+		}
+		return nil
+	}
+	server.AfterRequest = func(r *ServerRequest, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		audit = append(audit, *r)
+		errs = append(errs, err)
+	}
+	client := startServer(t, server, 3)
+
+	if _, err := client.ReadHoldingRegisters(10, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteMultipleRegisters(10, 2, []byte{0, 5, 0, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.MaskWriteRegister(12, 0x000F, 0x0001); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteMultipleCoils(2, 3, []byte{0x05}); err != nil {
+		t.Fatal(err)
+	}
+	var mbError *ModbusError
+	if _, err := client.WriteSingleRegister(100, 7); !errors.As(err, &mbError) || mbError.ExceptionCode != ExceptionCodeIllegalDataAddress {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if values, _ := store.OnReadHoldingRegisters(3, 100, 1); values[0] != 0 {
+		t.Fatalf("denied write served, value %v", values[0])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(audit) != 5 {
+		t.Fatalf("unexpected requests %v", audit)
+	}
+	for i := range audit {
+		if !strings.HasPrefix(audit[i].Client, "127.0.0.1:") || audit[i].UnitId != 3 {
+			t.Fatalf("unexpected request %+v", audit[i])
+		}
+		audit[i].Client = ""
+	}
+	expected := []ServerRequest{
+		{UnitId: 3, FunctionCode: FuncCodeReadHoldingRegisters, Table: TableHoldingRegisters, Address: 10, Quantity: 2},
+		{UnitId: 3, FunctionCode: FuncCodeWriteMultipleRegisters, Table: TableHoldingRegisters, Address: 10, Quantity: 2,
+			Values: []uint16{5, 6}, OldValues: []uint16{1, 2}},
+		{UnitId: 3, FunctionCode: FuncCodeMaskWriteRegister, Table: TableHoldingRegisters, Address: 12, Quantity: 1,
+			Values: []uint16{0x00F0&0x000F | 0x0001&^0x000F}, OldValues: []uint16{0x00F0}, andMask: 0x000F, orMask: 0x0001},
+		{UnitId: 3, FunctionCode: FuncCodeWriteMultipleCoils, Table: TableCoils, Address: 2, Quantity: 3,
+			Values: []uint16{1, 0, 1}, OldValues: []uint16{0, 0, 0}},
+		{UnitId: 3, FunctionCode: FuncCodeWriteSingleRegister, Table: TableHoldingRegisters, Address: 100, Quantity: 1,
+			Values: []uint16{7}, OldValues: []uint16{0}},
+	}
+	if !reflect.DeepEqual(audit, expected) {
+		t.Fatalf("unexpected requests\n%+v\nexpected\n%+v", audit, expected)
+	}
+	if errs[0] != nil || errs[3] != nil || !errors.As(errs[4], &mbError) {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if s := audit[1].String(); s != "function '16' of unit id '3' on holding registers 10-11" {
+		t.Fatalf("unexpected string %v", s)
+	}
+}
+
+func TestServerRequestInvalid(t *testing.T) {
+	r := newServerRequest("", 1, &ProtocolDataUnit{FunctionCode: FuncCodeWriteMultipleRegisters, Data: []byte{0, 1, 0, 2, 4, 0}})
+	if r.Table != 0 || r.Address != 0 || r.Values != nil {
+		t.Fatalf("unexpected request %+v", r)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/goburrow/serial"
+)
+
+// ListenAndServeRTU opens the serial port of config and serves RTU
+// requests until Close is called, see ServeRTU.
+func (s *Server) ListenAndServeRTU(config serial.Config) error {
+	if err := checkSerialFormat(&config); err != nil {
+		return err
+	}
+	if config.Timeout <= 0 || config.Timeout > serialReadSlice {
+		config.Timeout = serialReadSlice
+	}
+	port, err := openPort(&config)
+	if err != nil {
+		return err
+	}
+	return s.ServeRTU(port)
+}
+
+// ServeRTU serves the RTU requests received on the port until reading
+// fails or Close is called, which closes the port. After Shutdown, the
+// port is closed once the line is silent. Requests to units without
+// handler are not answered since other slaves may share the bus, and
+// broadcasts are served by all handlers without response.
+func (s *Server) ServeRTU(port io.ReadWriteCloser) error {
+	s.mu.Lock()
+	s.ports[port] = struct{}{}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	defer func() {
+		s.mu.Lock()
+		delete(s.ports, port)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	var buf [rtuMaxSize]byte
+	length := 0
+	for {
+		n, err := port.Read(buf[length:])
+		if err == serial.ErrTimeout {
+			// Partial frames are ended by the silence
+			length = 0
+			if s.drained(port) {
+				return ErrServerClosed
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		length += n
+		for length > 0 {
+			n := rtuFrameLength(buf[:length])
+			if n == 0 {
+				break
+			}
+			if err = s.serveRTUFrame(port, buf[:n]); err != nil {
+				return err
+			}
+			length = copy(buf[:], buf[n:length])
+		}
+		if length == len(buf) {
+			s.logf("modbus: server discarding invalid frame % x\n", buf[:length])
+			length = 0
+		}
+	}
+}
+
+// drained closes port and returns true if the server is shut down, it is
+// called when the line is silent so that no request is in progress.
+func (s *Server) drained(port io.Closer) bool {
+	if !s.conns.isDraining() {
+		return false
+	}
+	port.Close()
+	return true
+}
+
+// serveRTUFrame serves the request of adu and writes the response.
+func (s *Server) serveRTUFrame(w io.Writer, adu []byte) error {
+	request := &ProtocolDataUnit{FunctionCode: adu[1], Data: adu[2 : len(adu)-2]}
+	return s.serveSerialRequest(w, adu[0], request, NewRTUPackager(adu[0]))
+}
+
+// ListenAndServeASCII opens the serial port of config and serves ASCII
+// requests until Close is called, see ServeASCII. Unlike RTU, ASCII
+// accepts 7 data bits.
+func (s *Server) ListenAndServeASCII(config serial.Config) error {
+	if err := checkSerialFormat(&config); err != nil {
+		return err
+	}
+	if config.Timeout <= 0 || config.Timeout > serialReadSlice {
+		config.Timeout = serialReadSlice
+	}
+	port, err := openPort(&config)
+	if err != nil {
+		return err
+	}
+	return s.ServeASCII(port)
+}
+
+// ServeASCII serves the ASCII requests received on the port until reading
+// fails or Close is called, like ServeRTU. Frames start with a colon,
+// which also restarts a frame in progress, and end with CRLF. Frames with
+// a wrong LRC are discarded.
+func (s *Server) ServeASCII(port io.ReadWriteCloser) error {
+	s.mu.Lock()
+	s.ports[port] = struct{}{}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	defer func() {
+		s.mu.Lock()
+		delete(s.ports, port)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	var buf [asciiMaxSize]byte
+	length := 0
+	for {
+		n, err := port.Read(buf[length:])
+		if err == serial.ErrTimeout {
+			// Characters of a frame may be up to one second apart
+			if length == 0 && s.drained(port) {
+				return ErrServerClosed
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		length += n
+		for length > 0 {
+			// Characters before the colon are noise
+			start := bytes.IndexByte(buf[:length], asciiStart[0])
+			if start < 0 {
+				length = 0
+				break
+			}
+			length = copy(buf[:], buf[start:length])
+			end := bytes.IndexByte(buf[:length], '\n')
+			if end < 0 {
+				break
+			}
+			frame := buf[:end+1]
+			if restart := bytes.LastIndexByte(frame, asciiStart[0]); restart > 0 {
+				length = copy(buf[:], buf[restart:length])
+				continue
+			}
+			if err = s.serveASCIIFrame(port, frame); err != nil {
+				return err
+			}
+			length = copy(buf[:], buf[end+1:length])
+		}
+		if length == len(buf) {
+			s.logf("modbus: server discarding invalid frame %q\n", buf[:length])
+			length = 0
+		}
+	}
+}
+
+// serveASCIIFrame serves the request of frame and writes the response.
+func (s *Server) serveASCIIFrame(w io.Writer, frame []byte) error {
+	adu, err := decodeASCIIFrame(frame)
+	if err != nil || len(adu) < 3 || LRC(adu[:len(adu)-1]) != adu[len(adu)-1] {
+		s.logf("modbus: server discarding invalid frame %q\n", frame)
+		return nil
+	}
+	request := &ProtocolDataUnit{FunctionCode: adu[1], Data: adu[2 : len(adu)-1]}
+	return s.serveSerialRequest(w, adu[0], request, NewASCIIPackager(adu[0]))
+}
+
+// serveSerialRequest serves the request to unitId and writes the response
+// encoded by packager. Broadcasts are served by all handlers and requests
+// to units without handler are ignored, unless PDUHandler serves them.
+func (s *Server) serveSerialRequest(w io.Writer, unitId byte, request *ProtocolDataUnit, packager Packager) error {
+	if s.PDUHandler != nil {
+		response, err := s.PDUHandler(unitId, request)
+		if err != nil || response == nil || unitId == 0 {
+			return nil
+		}
+		return writeSerialResponse(w, response, packager)
+	}
+	if unitId == 0 {
+		s.mu.Lock()
+		handlers := make([]Handler, 0, len(s.units)+1)
+		for _, h := range s.units {
+			handlers = append(handlers, h)
+		}
+		if s.Handler != nil {
+			handlers = append(handlers, s.Handler)
+		}
+		s.mu.Unlock()
+		for _, h := range handlers {
+			s.serve(h, unitId, request)
+		}
+		return nil
+	}
+	if s.handler(unitId) == nil {
+		return nil
+	}
+	return writeSerialResponse(w, s.ServePDU(unitId, request), packager)
+}
+
+// writeSerialResponse writes the response encoded by packager.
+func writeSerialResponse(w io.Writer, response *ProtocolDataUnit, packager Packager) error {
+	aduResponse, err := packager.Encode(response)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(aduResponse)
+	return err
+}
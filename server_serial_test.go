@@ -0,0 +1,119 @@
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// pipePort is a serial port over a pipe, reads time out like serial ports.
+type pipePort struct {
+	net.Conn
+}
+
+func (p *pipePort) Read(b []byte) (int, error) {
+	p.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	n, err := p.Conn.Read(b)
+	if os.IsTimeout(err) {
+		err = serial.ErrTimeout
+	}
+	return n, err
+}
+
+// Flush implements portFlusher, the pipe is not reopened after timeouts.
+func (p *pipePort) Flush(input, output bool) error {
+	return nil
+}
+
+func TestServerRTU(t *testing.T) {
+	meter, inverter := NewMemoryStore(), NewMemoryStore()
+	meter.SetHoldingRegisters(0, 1)
+	inverter.SetHoldingRegisters(0, 2)
+	server := NewServer(nil)
+	server.Handle(1, meter)
+	server.Handle(2, inverter)
+
+	serverPort, clientPort := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- server.ServeRTU(&pipePort{serverPort}) }()
+	handler := NewRTUClientHandler("pipe")
+	handler.Timeout = 200 * time.Millisecond
+	handler.BroadcastDelay = 10 * time.Millisecond
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &pipePort{clientPort}, nil
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+
+	for unitId, expected := range map[byte][]byte{1: {0, 1}, 2: {0, 2}} {
+		results, err := WithSlaveId(client, unitId).ReadHoldingRegisters(0, 1)
+		if err != nil || !reflect.DeepEqual(results, expected) {
+			t.Fatalf("unit %v: unexpected results %v, error %v", unitId, results, err)
+		}
+	}
+	// Other slaves of the bus answer unknown units
+	if _, err := WithSlaveId(client, 3).ReadHoldingRegisters(0, 1); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := WithSlaveId(client, 0).WriteSingleRegister(5, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WithSlaveId(client, 1).ReadHoldingRegisters(5, 1); err != nil {
+		t.Fatal(err)
+	}
+	if meter.HoldingRegisters(5, 1)[0] != 7 || inverter.HoldingRegisters(5, 1)[0] != 7 {
+		t.Fatalf("broadcast is not served by all units")
+	}
+	server.Close()
+	if err := <-done; err == nil {
+		t.Fatal("serve error expected after close")
+	}
+}
+
+func TestServerASCII(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 1)
+	server := NewServer(nil)
+	server.Handle(1, store)
+
+	serverPort, clientPort := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- server.ServeASCII(&pipePort{serverPort}) }()
+	handler := NewASCIIClientHandler("pipe")
+	handler.Timeout = 200 * time.Millisecond
+	handler.open = func(config *serial.Config) (io.ReadWriteCloser, error) {
+		return &pipePort{clientPort}, nil
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+
+	// Noise, an interrupted frame and a frame with a wrong LRC are ignored
+	if _, err := clientPort.Write([]byte("\x00:0103\r\n:010600050007EF\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	results, err := WithSlaveId(client, 1).ReadHoldingRegisters(0, 1)
+	if err != nil || !reflect.DeepEqual(results, []byte{0, 1}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if _, err = WithSlaveId(client, 1).WriteSingleRegister(5, 7); err != nil {
+		t.Fatal(err)
+	}
+	if store.HoldingRegisters(5, 1)[0] != 7 {
+		t.Fatalf("unexpected value %v", store.HoldingRegisters(5, 1))
+	}
+	if _, err = WithSlaveId(client, 2).ReadHoldingRegisters(0, 1); !errors.Is(err, serial.ErrTimeout) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server.Close()
+	if err := <-done; err == nil {
+		t.Fatal("serve error expected after close")
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startServer serves handler on a loopback address and returns a client of
+// unitId.
+func startServer(t *testing.T, server *Server, unitId byte) Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	handler := NewTCPClientHandler(listener.Addr().String())
+	handler.SlaveId = unitId
+	handler.Timeout = time.Second
+	t.Cleanup(func() {
+		handler.Close()
+		server.Close()
+	})
+	return NewClient(handler)
+}
+
+// sendPDU sends the request with c without validating it.
+func sendPDU(c Client, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+	return c.(*client).send(request)
+}
+
+// failingHandler fails all requests with err.
+type failingHandler struct {
+	*MemoryStore
+	err error
+}
+
+func (h *failingHandler) OnReadHoldingRegisters(unitId byte, address, quantity uint16) ([]uint16, error) {
+	return nil, h.err
+}
+
+func TestServer(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(10, 1, 2, 3)
+	store.SetInputRegisters(5, 7)
+	store.SetDiscreteInputs(1, true, false, true)
+	type change struct {
+		unitId            byte
+		table             Table
+		address, quantity uint16
+	}
+	var changes []change
+	store.OnChange = func(unitId byte, table Table, address, quantity uint16) {
+		changes = append(changes, change{unitId, table, address, quantity})
+	}
+	client := startServer(t, NewServer(store), 3)
+
+	results, err := client.ReadHoldingRegisters(10, 3)
+	if err != nil || !reflect.DeepEqual(results, []byte{0, 1, 0, 2, 0, 3}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if results, err = client.ReadInputRegisters(5, 1); err != nil || !reflect.DeepEqual(results, []byte{0, 7}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if results, err = client.ReadDiscreteInputs(0, 4); err != nil || !reflect.DeepEqual(results, []byte{0x0A}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if _, err = client.WriteMultipleCoils(2, 10, []byte{0xFF, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteSingleCoil(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = client.ReadCoils(0, 16); err != nil || !reflect.DeepEqual(results, []byte{0xF8, 0x07}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if _, err = client.WriteSingleRegister(11, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.MaskWriteRegister(11, 0xFF00, 0x0056); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = client.ReadWriteMultipleRegisters(10, 2, 12, 1, []byte{0, 9}); err != nil || !reflect.DeepEqual(results, []byte{0, 1, 0x12, 0x56}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+	if values := store.HoldingRegisters(10, 3); !reflect.DeepEqual(values, []uint16{1, 0x1256, 9}) {
+		t.Fatalf("unexpected registers %v", values)
+	}
+	expected := []change{
+		{3, TableCoils, 2, 10}, {3, TableCoils, 2, 1}, {3, TableHoldingRegisters, 11, 1},
+		{3, TableHoldingRegisters, 11, 1}, {3, TableHoldingRegisters, 12, 1},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+
+	tests := []struct {
+		request       func() error
+		exceptionCode byte
+	}{
+		// Sent as is, the client rejects the range
+		{func() (err error) {
+			_, err = sendPDU(client, &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: dataBlock(65535, 2)})
+			return
+		}, ExceptionCodeIllegalDataAddress},
+		{func() (err error) { _, err = client.ReadFIFOQueue(0); return }, ExceptionCodeIllegalFunction},
+	}
+	for i, test := range tests {
+		err = test.request()
+		if e, ok := err.(*ModbusError); !ok || e.ExceptionCode != test.exceptionCode {
+			t.Errorf("%v: expected exception %v, actual %v", i, test.exceptionCode, err)
+		}
+	}
+}
+
+func TestServerHandlerErrors(t *testing.T) {
+	handler := &failingHandler{MemoryStore: NewMemoryStore(), err: &ModbusError{ExceptionCode: ExceptionCodeServerDeviceBusy}}
+	client := startServer(t, NewServer(handler), 1)
+	_, err := client.ReadHoldingRegisters(0, 1)
+	if e, ok := err.(*ModbusError); !ok || e.ExceptionCode != ExceptionCodeServerDeviceBusy {
+		t.Fatalf("unexpected error %v", err)
+	}
+	handler.err = errors.New("database is down")
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if e, ok := err.(*ModbusError); !ok || e.ExceptionCode != ExceptionCodeServerDeviceFailure {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestServerPipelinedRequests(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 1, 2)
+	server := NewServer(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	// Two requests in one write, the second one split
+	if _, err = conn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1, 0, 2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err = conn.Write([]byte{0, 0, 6, 1, 3, 0, 1, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	responses := make([]byte, 22)
+	if _, err = io.ReadFull(conn, responses); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0, 1, 0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 2}
+	if !reflect.DeepEqual(responses, expected) {
+		t.Fatalf("unexpected responses % x", responses)
+	}
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 0x1234)
+	dial := func(server *Server) net.Conn {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve(listener)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		t.Cleanup(func() {
+			conn.Close()
+			server.Close()
+		})
+		return conn
+	}
+	conn := dial(NewServer(store))
+
+	// Keep-alive frames and frames of other protocols are skipped
+	conn.Write([]byte{0, 0, 0, 0, 0, 0})
+	conn.Write([]byte{0, 0, 0, 0, 0, 1, 1})
+	conn.Write([]byte{0, 1, 0, 5, 0, 6, 1, 3, 0, 0, 0, 1})
+	conn.Write([]byte{0, 2, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	var response [11]byte
+	if _, err := io.ReadFull(conn, response[:]); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response[:]) {
+		t.Fatalf("unexpected response % x", response)
+	}
+
+	server := NewServer(store)
+	server.StrictFraming = true
+	conn = dial(server)
+	conn.Write([]byte{0, 1, 0, 5, 0, 6, 1, 3, 0, 0, 0, 1})
+	if _, err := io.ReadFull(conn, response[:]); err != io.EOF {
+		t.Fatalf("closed connection expected, actual %v", err)
+	}
+}
+
+func TestServerPDUHandler(t *testing.T) {
+	server := NewServer(nil)
+	injected := errors.New("injected")
+	server.PDUHandler = func(unitId byte, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+		if request.FunctionCode == FuncCodeReadCoils {
+			return nil, injected
+		}
+		return &ProtocolDataUnit{FunctionCode: request.FunctionCode, Data: []byte{unitId}}, nil
+	}
+	client := startServer(t, server, 7)
+	response, err := sendPDU(client, &ProtocolDataUnit{FunctionCode: 0x41, Data: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.FunctionCode != 0x41 || !reflect.DeepEqual(response.Data, []byte{7}) {
+		t.Fatalf("unexpected response %v", response)
+	}
+	// The connection is closed without response
+	if _, err = client.ReadCoils(0, 1); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestServerStrict(t *testing.T) {
+	server := NewServer(NewMemoryStore())
+	request := &ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1, 0xFF}}
+	if response := server.ServePDU(1, request); response.FunctionCode != request.FunctionCode {
+		t.Fatalf("unexpected response %v", response)
+	}
+	server.Strict = true
+	response := server.ServePDU(1, request)
+	if response.FunctionCode != request.FunctionCode|0x80 || response.Data[0] != ExceptionCodeIllegalDataValue {
+		t.Fatalf("unexpected response %v", response)
+	}
+	tests := []struct {
+		request *ProtocolDataUnit
+		valid   bool
+	}{
+		{&ProtocolDataUnit{FunctionCode: FuncCodeWriteMultipleRegisters, Data: []byte{0, 0, 0, 1, 2, 0, 7}}, true},
+		{&ProtocolDataUnit{FunctionCode: FuncCodeWriteMultipleRegisters, Data: []byte{0, 0, 0, 1, 2, 0}}, false},
+		{&ProtocolDataUnit{FunctionCode: FuncCodeWriteMultipleRegisters, Data: []byte{0, 0, 0, 1, 2, 0, 7, 0}}, false},
+		{&ProtocolDataUnit{FunctionCode: FuncCodeReadWriteMultipleRegisters, Data: []byte{0, 0, 0, 1, 0, 0}}, false},
+		{&ProtocolDataUnit{FunctionCode: FuncCodeReadCoils}, false},
+		{&ProtocolDataUnit{FunctionCode: 0x41, Data: []byte{1, 2, 3}}, true},
+	}
+	for i, test := range tests {
+		if err := ValidateRequest(test.request, true); (err == nil) != test.valid {
+			t.Errorf("%v: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestServerUnits(t *testing.T) {
+	meter, inverter := NewMemoryStore(), NewMemoryStore()
+	meter.SetHoldingRegisters(0, 1)
+	inverter.SetHoldingRegisters(0, 2)
+	server := NewServer(nil)
+	server.Handle(1, meter)
+	server.Handle(2, inverter)
+	client := startServer(t, server, 1)
+
+	for unitId, expected := range map[byte][]byte{1: {0, 1}, 2: {0, 2}} {
+		results, err := WithSlaveId(client, unitId).ReadHoldingRegisters(0, 1)
+		if err != nil || !reflect.DeepEqual(results, expected) {
+			t.Fatalf("unit %v: unexpected results %v, error %v", unitId, results, err)
+		}
+	}
+	_, err := WithSlaveId(client, 3).ReadHoldingRegisters(0, 1)
+	if !errors.Is(err, ErrGatewayTargetDeviceFailedToRespond) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// Handler serves the other units
+	server.Handler = NewMemoryStore()
+	if _, err = WithSlaveId(client, 3).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	server.Handle(2, nil)
+	results, err := WithSlaveId(client, 2).ReadHoldingRegisters(0, 1)
+	if err != nil || !reflect.DeepEqual(results, []byte{0, 0}) {
+		t.Fatalf("unexpected results %v, error %v", results, err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+// SerialPort is a serial port shared by several clients, each one with
+// RTU or ASCII framing, e.g. on a bus with devices of both protocols:
+//  port := modbus.NewSerialPort("/dev/ttyUSB0")
+//  port.BaudRate = 9600
+//  defer port.Close()
+//  meter := modbus.NewClient(port.RTUHandler(1))
+//  display := modbus.NewClient(port.ASCIIHandler(2))
+// Transactions of all clients are serialized on the port, which stays
+// open when switching between protocols.
+type SerialPort struct {
+	serialPort
+	// StrictFrameDelay guarantees at least 3.5 character times of silence
+	// before RTU requests, see RTUClientHandler.
+	StrictFrameDelay bool
+}
+
+// NewSerialPort allocates a new SerialPort.
+func NewSerialPort(address string) *SerialPort {
+	p := &SerialPort{}
+	p.Address = address
+	p.Timeout = serialTimeout
+	p.IdleTimeout = serialIdleTimeout
+	p.BroadcastDelay = serialBroadcastDelay
+	return p
+}
+
+// RTUHandler returns a new client handler sending RTU requests to slaveId
+// through the port. Port must be closed with the Close method of
+// SerialPort, not of the handler.
+func (p *SerialPort) RTUHandler(slaveId byte) *SerialPortRTUHandler {
+	h := &SerialPortRTUHandler{port: p}
+	h.SlaveId = slaveId
+	return h
+}
+
+// ASCIIHandler returns a new client handler sending ASCII requests to
+// slaveId through the port.
+func (p *SerialPort) ASCIIHandler(slaveId byte) *SerialPortASCIIHandler {
+	h := &SerialPortASCIIHandler{port: p}
+	h.SlaveId = slaveId
+	return h
+}
+
+// SerialPortRTUHandler implements Packager and Transporter interface
+// using a shared SerialPort.
+type SerialPortRTUHandler struct {
+	rtuPackager
+
+	port *SerialPort
+}
+
+// buffered implements bufferTransporter.
+func (h *SerialPortRTUHandler) buffered() Transporter {
+	return h
+}
+
+// batchMutex implements batchLocker.
+func (h *SerialPortRTUHandler) batchMutex() *sync.Mutex {
+	return &h.port.mu
+}
+
+// Send sends data through the shared port.
+func (h *SerialPortRTUHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendRTU(context.Background(), new(aduBuffer), aduRequest, h.port.StrictFrameDelay)
+}
+
+// SendContext sends data through the shared port, see ContextTransporter.
+func (h *SerialPortRTUHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendRTU(ctx, new(aduBuffer), aduRequest, h.port.StrictFrameDelay)
+}
+
+// sendBuffer implements bufferTransporter.
+func (h *SerialPortRTUHandler) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendRTU(ctx, buf, aduRequest, h.port.StrictFrameDelay)
+}
+
+// SerialPortASCIIHandler implements Packager and Transporter interface
+// using a shared SerialPort.
+type SerialPortASCIIHandler struct {
+	asciiPackager
+
+	port *SerialPort
+}
+
+// buffered implements bufferTransporter.
+func (h *SerialPortASCIIHandler) buffered() Transporter {
+	return h
+}
+
+// batchMutex implements batchLocker.
+func (h *SerialPortASCIIHandler) batchMutex() *sync.Mutex {
+	return &h.port.mu
+}
+
+// Send sends data through the shared port.
+func (h *SerialPortASCIIHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendASCII(context.Background(), new(aduBuffer), aduRequest)
+}
+
+// SendContext sends data through the shared port, see ContextTransporter.
+func (h *SerialPortASCIIHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendASCII(ctx, new(aduBuffer), aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (h *SerialPortASCIIHandler) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	return h.port.sendASCII(ctx, buf, aduRequest)
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/goburrow/serial"
+)
+
+// mixedSlave responds to RTU and ASCII read holding registers requests
+// with the slave id as the register values.
+func mixedSlave(request []byte) []byte {
+	if request[0] != ':' {
+		response := rtuSlave(0)(request)
+		// Set the register value and recompute the CRC
+		pdu, _ := (&rtuPackager{}).Decode(response)
+		pdu.Data[len(pdu.Data)-1] = request[0]
+		response, _ = (&rtuPackager{SlaveId: request[0]}).Encode(pdu)
+		return response
+	}
+	var packager asciiPackager
+	pdu, err := packager.Decode(request)
+	if err != nil {
+		return nil
+	}
+	packager.SlaveId, _ = readHex(request[1:])
+	count := int(pdu.Data[3])
+	data := make([]byte, 1+2*count)
+	data[0] = byte(2 * count)
+	data[len(data)-1] = packager.SlaveId
+	response, _ := packager.Encode(&ProtocolDataUnit{pdu.FunctionCode, data})
+	return response
+}
+
+func TestSerialPortShared(t *testing.T) {
+	line := newSimLine(9600, mixedSlave)
+	opens := 0
+	port := NewSerialPort("sim")
+	port.BaudRate = line.baudRate
+	port.Clock = line.clock
+	port.open = func(*serial.Config) (io.ReadWriteCloser, error) {
+		opens++
+		return line, nil
+	}
+	defer port.Close()
+
+	rtu := NewClient(port.RTUHandler(1))
+	ascii := NewClient(port.ASCIIHandler(2))
+	for i := 0; i < 2; i++ {
+		results, err := rtu.ReadHoldingRegisters(0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte{0, 1}, results) {
+			t.Fatalf("unexpected RTU results %v", results)
+		}
+		if results, err = ascii.ReadHoldingRegisters(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte{0, 2}, results) {
+			t.Fatalf("unexpected ASCII results %v", results)
+		}
+	}
+	if opens != 1 || line.closed {
+		t.Fatalf("unexpected opens %v", opens)
+	}
+}
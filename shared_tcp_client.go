@@ -0,0 +1,72 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+// TCPConnection is a Modbus TCP connection shared by several clients,
+// typically addressing different unit identifiers behind a gateway which
+// limits the number of concurrent connections:
+//  conn := modbus.NewTCPConnection("gateway:502")
+//  defer conn.Close()
+//  meter := modbus.NewClient(conn.Handler(1))
+//  inverter := modbus.NewClient(conn.Handler(2))
+// Transactions of all clients are serialized on the connection and use
+// the same transaction identifier sequence.
+type TCPConnection struct {
+	tcpTransporter
+
+	transactionId uint32
+}
+
+// NewTCPConnection allocates a new TCPConnection.
+func NewTCPConnection(address string) *TCPConnection {
+	c := &TCPConnection{}
+	c.Address = address
+	c.Timeout = tcpTimeout
+	c.IdleTimeout = tcpIdleTimeout
+	return c
+}
+
+// Handler returns a new client handler sending requests to slaveId
+// through the connection. Connection must be closed with the Close method
+// of TCPConnection, not of the handler.
+func (c *TCPConnection) Handler(slaveId byte) *TCPConnectionHandler {
+	h := &TCPConnectionHandler{conn: c}
+	h.SlaveId = slaveId
+	h.sharedTransactionId = &c.transactionId
+	return h
+}
+
+// TCPConnectionHandler implements Packager and Transporter interface
+// using a shared TCPConnection.
+type TCPConnectionHandler struct {
+	tcpPackager
+
+	conn *TCPConnection
+}
+
+// buffered implements bufferTransporter.
+func (h *TCPConnectionHandler) buffered() Transporter {
+	return h
+}
+
+// batchMutex implements batchLocker.
+func (h *TCPConnectionHandler) batchMutex() *sync.Mutex {
+	return &h.conn.mu
+}
+
+// Send sends data through the shared connection.
+func (h *TCPConnectionHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return h.conn.Send(aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (h *TCPConnectionHandler) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	return h.conn.sendBuffer(ctx, buf, aduRequest)
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var mu sync.Mutex
+	connections := 0
+	transactions := make(map[uint16]byte)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			connections++
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				// Echo requests, which are valid responses to WriteSingleRegister.
+				frame := make([]byte, 12)
+				for {
+					if _, err := io.ReadFull(conn, frame); err != nil {
+						return
+					}
+					mu.Lock()
+					transactions[binary.BigEndian.Uint16(frame)] = frame[6]
+					mu.Unlock()
+					conn.Write(frame)
+				}
+			}()
+		}
+	}()
+
+	conn := NewTCPConnection(ln.Addr().String())
+	conn.Timeout = time.Second
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for slaveId := byte(1); slaveId <= 3; slaveId++ {
+		client := NewClient(conn.Handler(slaveId))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if _, err := client.WriteSingleRegister(uint16(i), uint16(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if connections != 1 {
+		t.Fatalf("unexpected connections %v", connections)
+	}
+	if len(transactions) != 30 {
+		t.Fatalf("unexpected transactions %v", len(transactions))
+	}
+	units := make(map[byte]int)
+	for _, unit := range transactions {
+		units[unit]++
+	}
+	if units[1] != 10 || units[2] != 10 || units[3] != 10 {
+		t.Fatalf("unexpected units %v", units)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by the Serve methods of a server after
+// Shutdown.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// drain waits for the transaction holding mu to complete, up to the
+// deadline of ctx, and calls closeLocked with mu locked. If ctx is done
+// first, its error is returned and closeLocked is called once the
+// transaction completes.
+func drain(ctx context.Context, mu *sync.Mutex, closeLocked func() error) error {
+	locked := make(chan struct{})
+	go func() {
+		mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		defer mu.Unlock()
+		return closeLocked()
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			defer mu.Unlock()
+			closeLocked()
+		}()
+		return ctx.Err()
+	}
+}
+
+// waitContext waits for wg up to the deadline of ctx.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connTracker tracks the connections of a server and whether they are
+// idle, waiting for a request, so that a shutdown closes the idle ones and
+// lets the others complete their request. The zero value is ready to use.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	draining bool
+}
+
+// add adds the idle conn, it returns false if the server is draining.
+func (t *connTracker) add(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]bool)
+	}
+	t.conns[conn] = true
+	return true
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+// setIdle marks conn idle or busy serving a request. It returns false if
+// conn becomes idle while the server is draining, and must be closed.
+func (t *connTracker) setIdle(conn net.Conn, idle bool) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if idle && t.draining {
+		return false
+	}
+	if _, ok := t.conns[conn]; ok {
+		t.conns[conn] = idle
+	}
+	return true
+}
+
+// isDraining returns true once drain is called.
+func (t *connTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// drain closes the idle connections, the others are closed once they
+// complete their request.
+func (t *connTracker) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+	for conn, idle := range t.conns {
+		if idle {
+			conn.Close()
+		}
+	}
+}
+
+// closeAll closes all connections.
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
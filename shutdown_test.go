@@ -0,0 +1,122 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingHandler blocks reads of holding registers until released.
+type blockingHandler struct {
+	*MemoryStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) OnReadHoldingRegisters(unitId byte, address, quantity uint16) ([]uint16, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return h.MemoryStore.OnReadHoldingRegisters(unitId, address, quantity)
+}
+
+func TestServerShutdown(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetHoldingRegisters(0, 7)
+	h := &blockingHandler{store, make(chan struct{}), make(chan struct{})}
+	server := NewServer(h)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	busy := NewTCPClientHandler(listener.Addr().String())
+	busy.Timeout = 5 * time.Second
+	defer busy.Close()
+	idle := NewTCPClientHandler(listener.Addr().String())
+	idle.Timeout = 5 * time.Second
+	defer idle.Close()
+	if _, err = NewClient(idle).ReadInputRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan error, 1)
+	go func() {
+		_, err := NewClient(busy).ReadHoldingRegisters(0, 1)
+		results <- err
+	}()
+	<-h.started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	// The idle connection is closed while the request is in progress
+	if _, err = NewClient(idle).ReadInputRegisters(0, 1); err == nil {
+		t.Fatal("idle connection is not closed")
+	}
+	close(h.release)
+	if err = <-results; err != nil {
+		t.Fatalf("request in progress failed: %v", err)
+	}
+	if err = <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("unexpected serve error %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	h := &blockingHandler{NewMemoryStore(), make(chan struct{}), make(chan struct{})}
+	defer close(h.release)
+	server := NewServer(h)
+	client := startServer(t, server, 1)
+	go client.ReadHoldingRegisters(0, 1)
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTCPShutdown(t *testing.T) {
+	h := &blockingHandler{NewMemoryStore(), make(chan struct{}), make(chan struct{})}
+	server := NewServer(h)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+	handler := NewTCPClientHandler(listener.Addr().String())
+	handler.Timeout = 5 * time.Second
+	client := NewClient(handler)
+
+	results := make(chan error, 1)
+	go func() {
+		_, err := client.ReadHoldingRegisters(0, 1)
+		results <- err
+	}()
+	<-h.started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = handler.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	close(h.release)
+	if err = <-results; err != nil {
+		t.Fatalf("request in progress failed: %v", err)
+	}
+	if _, err = client.ReadHoldingRegisters(0, 1); err != ErrClosed {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = handler.Shutdown(context.Background()); err != nil || handler.IsConnected() {
+		t.Fatalf("connection is not closed: %v", err)
+	}
+}
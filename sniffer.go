@@ -0,0 +1,268 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+const (
+	// Default sniffer timeouts
+	snifferReadTimeout     = 50 * time.Millisecond
+	snifferResponseTimeout = 1 * time.Second
+)
+
+// Transaction is a request and its response observed on a serial line.
+type Transaction struct {
+	// Time the request was received.
+	Time    time.Time
+	SlaveId byte
+	Request *ProtocolDataUnit
+	// Response is nil for broadcasts and unanswered requests.
+	Response *ProtocolDataUnit
+	// Latency is the time between the starts of the request and the response.
+	Latency time.Duration
+	// Err is set for unanswered requests, and for invalid frames, in which
+	// case Request is nil.
+	Err error
+}
+
+// RTUSniffer monitors the RTU traffic of a serial line without
+// transmitting, e.g. to debug the communication between third-party
+// masters and slaves:
+//
+//	sniffer := modbus.NewRTUSniffer("/dev/ttyUSB0")
+//	sniffer.BaudRate = 19200
+//	sniffer.Handler = func(t *modbus.Transaction) { log.Printf("%+v", t) }
+//	err := sniffer.Listen()
+//
+// Frames are delimited by silences of FrameGap. Frames received back to
+// back are separated using their CRC.
+type RTUSniffer struct {
+	// Serial port configuration, Timeout is the read timeout.
+	serial.Config
+
+	// FrameGap is the silence ending a frame, t3.5 of the baud rate if zero.
+	// USB adapters delivering data in bursts may require a larger gap.
+	FrameGap time.Duration
+	// ResponseTimeout is the time after which a request is reported as
+	// unanswered.
+	ResponseTimeout time.Duration
+	// Handler is called with each transaction, in the sniffer goroutine.
+	Handler func(transaction *Transaction)
+	Logger  *log.Logger
+	// Clock defaults to the system time if nil.
+	Clock Clock
+
+	mu   sync.Mutex
+	port io.ReadWriteCloser
+
+	frame       []byte
+	frameStart  time.Time
+	lastReceive time.Time
+	pending     *Transaction
+}
+
+// NewRTUSniffer allocates a new RTUSniffer of the serial port.
+func NewRTUSniffer(address string) *RTUSniffer {
+	s := &RTUSniffer{}
+	s.Address = address
+	s.Timeout = snifferReadTimeout
+	s.ResponseTimeout = snifferResponseTimeout
+	return s
+}
+
+// Listen opens the serial port and monitors it until Close is called.
+func (s *RTUSniffer) Listen() error {
+	s.mu.Lock()
+	if s.port != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("modbus: sniffer is already listening")
+	}
+	port, err := serial.Open(&s.Config)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.port = port
+	s.mu.Unlock()
+
+	err = s.Sniff(port)
+	s.mu.Lock()
+	closed := s.port == nil
+	s.port = nil
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+	port.Close()
+	return err
+}
+
+// Close stops listening.
+func (s *RTUSniffer) Close() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.port != nil {
+		err = s.port.Close()
+		s.port = nil
+	}
+	return
+}
+
+// Sniff monitors the traffic read from r until it returns an error other
+// than serial.ErrTimeout, which is returned.
+func (s *RTUSniffer) Sniff(r io.Reader) (err error) {
+	var data [rtuMaxSize]byte
+	for {
+		var n int
+		n, err = r.Read(data[:])
+		now := s.now()
+		if n > 0 {
+			s.receive(now, data[:n])
+		}
+		if err == serial.ErrTimeout {
+			s.idle(now)
+			continue
+		}
+		if err != nil {
+			s.endFrame()
+			s.flush()
+			return
+		}
+	}
+}
+
+// receive appends data to the current frame, ending the previous frame
+// first if the line was silent for FrameGap.
+func (s *RTUSniffer) receive(at time.Time, data []byte) {
+	if len(s.frame) > 0 && at.Sub(s.lastReceive) > s.frameGap() {
+		s.endFrame()
+	}
+	if len(s.frame) == 0 {
+		s.frameStart = at
+	}
+	s.frame = append(s.frame, data...)
+	s.lastReceive = at
+	if len(s.frame) > 2*rtuMaxSize {
+		s.endFrame()
+	}
+}
+
+// idle ends the current frame if the line has been silent for FrameGap and
+// reports the pending request if it timed out.
+func (s *RTUSniffer) idle(at time.Time) {
+	if len(s.frame) > 0 && at.Sub(s.lastReceive) > s.frameGap() {
+		s.endFrame()
+	}
+	if s.pending != nil && s.ResponseTimeout > 0 && at.Sub(s.pending.Time) > s.ResponseTimeout {
+		s.flush()
+	}
+}
+
+// endFrame handles the frames of the received data, which contains more
+// than one frame if they were not separated by a silence.
+func (s *RTUSniffer) endFrame() {
+	data := s.frame
+	s.frame = nil
+	for len(data) > 0 {
+		n := rtuFrameLength(data)
+		if n == 0 {
+			s.logf("modbus: sniffer received invalid frame % x\n", data)
+			s.emit(&Transaction{
+				Time: s.frameStart,
+				Err:  fmt.Errorf("modbus: invalid frame of '%v' bytes", len(data)),
+			})
+			return
+		}
+		s.handleFrame(data[:n])
+		data = data[n:]
+	}
+}
+
+// rtuFrameLength returns the length of the shortest frame with a valid CRC
+// at the start of data, or 0.
+func rtuFrameLength(data []byte) int {
+	var crc crc
+	for n := rtuMinSize; n <= len(data) && n <= rtuMaxSize; n++ {
+		crc.reset().pushBytes(data[:n-2])
+		if uint16(data[n-1])<<8|uint16(data[n-2]) == crc.value() {
+			return n
+		}
+	}
+	return 0
+}
+
+// handleFrame pairs the frame with the pending request if it is its
+// response, otherwise the frame is a new request.
+func (s *RTUSniffer) handleFrame(adu []byte) {
+	pdu := &ProtocolDataUnit{
+		FunctionCode: adu[1],
+		Data:         append([]byte(nil), adu[2:len(adu)-2]...),
+	}
+	if t := s.pending; t != nil && adu[0] == t.SlaveId &&
+		(pdu.FunctionCode == t.Request.FunctionCode || pdu.FunctionCode == t.Request.FunctionCode|0x80) {
+		t.Response = pdu
+		t.Latency = s.frameStart.Sub(t.Time)
+		s.pending = nil
+		s.emit(t)
+		return
+	}
+	s.flush()
+	t := &Transaction{
+		Time:    s.frameStart,
+		SlaveId: adu[0],
+		Request: pdu,
+	}
+	if t.SlaveId == 0 {
+		// Broadcasts are not answered.
+		s.emit(t)
+		return
+	}
+	s.pending = t
+}
+
+// flush reports the pending request as unanswered.
+func (s *RTUSniffer) flush() {
+	if s.pending == nil {
+		return
+	}
+	t := s.pending
+	s.pending = nil
+	t.Err = fmt.Errorf("modbus: slave id '%v' did not respond to function '%v'", t.SlaveId, t.Request.FunctionCode)
+	s.emit(t)
+}
+
+func (s *RTUSniffer) emit(t *Transaction) {
+	if s.Handler != nil {
+		s.Handler(t)
+	}
+}
+
+func (s *RTUSniffer) frameGap() time.Duration {
+	if s.FrameGap > 0 {
+		return s.FrameGap
+	}
+	return rtuFrameDelay(s.BaudRate)
+}
+
+func (s *RTUSniffer) now() time.Time {
+	return clockOrSystem(s.Clock).Now()
+}
+
+func (s *RTUSniffer) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
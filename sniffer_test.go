@@ -0,0 +1,101 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build !modbus_noserial
+
+package modbus
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// chunkReader returns each chunk after its delay, and serial.ErrTimeout
+// for nil chunks.
+type chunkReader struct {
+	clock  *simClock
+	chunks []timedChunk
+}
+
+type timedChunk struct {
+	delay time.Duration
+	data  []byte
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	r.clock.Sleep(chunk.delay)
+	if chunk.data == nil {
+		return 0, serial.ErrTimeout
+	}
+	return copy(b, chunk.data), nil
+}
+
+func rtuFrame(slaveId byte, pdu ...byte) []byte {
+	adu, _ := (&rtuPackager{SlaveId: slaveId}).Encode(&ProtocolDataUnit{pdu[0], pdu[1:]})
+	return adu
+}
+
+func TestRTUSniffer(t *testing.T) {
+	ms := time.Millisecond
+	readRequest := rtuFrame(1, 0x03, 0x00, 0x0A, 0x00, 0x01)
+	readResponse := rtuFrame(1, 0x03, 0x02, 0x12, 0x34)
+	exception := rtuFrame(2, 0x83, 0x02)
+	clock := &simClock{now: time.Unix(0, 0)}
+	r := &chunkReader{clock: clock, chunks: []timedChunk{
+		// Request in two chunks, response after 10ms
+		{0, readRequest[:3]},
+		{ms / 2, readRequest[3:]},
+		{10 * ms, readResponse},
+		// Broadcast
+		{10 * ms, rtuFrame(0, 0x06, 0x00, 0x01, 0x00, 0x02)},
+		// Request and exception received back to back
+		{10 * ms, append(rtuFrame(2, 0x03, 0x00, 0x00, 0x00, 0x01), exception...)},
+		// Corrupted frame
+		{10 * ms, []byte{0x01, 0x02, 0x03, 0x04, 0x05}},
+		// Unanswered request
+		{10 * ms, readRequest},
+		{600 * ms, nil},
+		{600 * ms, nil},
+	}}
+	var transactions []*Transaction
+	sniffer := NewRTUSniffer("")
+	sniffer.BaudRate = 19200
+	sniffer.Clock = clock
+	sniffer.Handler = func(t *Transaction) {
+		transactions = append(transactions, t)
+	}
+	if err := sniffer.Sniff(r); err != io.EOF {
+		t.Fatalf("expected EOF, actual %v", err)
+	}
+	if len(transactions) != 5 {
+		t.Fatalf("unexpected transactions %+v", transactions)
+	}
+	first := transactions[0]
+	if first.SlaveId != 1 || first.Err != nil || first.Latency != 10*ms+ms/2 ||
+		!reflect.DeepEqual(first.Request, &ProtocolDataUnit{0x03, []byte{0x00, 0x0A, 0x00, 0x01}}) ||
+		!reflect.DeepEqual(first.Response, &ProtocolDataUnit{0x03, []byte{0x02, 0x12, 0x34}}) {
+		t.Fatalf("unexpected transaction %+v", first)
+	}
+	if broadcast := transactions[1]; broadcast.SlaveId != 0 || broadcast.Response != nil || broadcast.Err != nil {
+		t.Fatalf("unexpected broadcast %+v", broadcast)
+	}
+	if third := transactions[2]; third.SlaveId != 2 || third.Response == nil || third.Response.FunctionCode != 0x83 {
+		t.Fatalf("unexpected transaction %+v", third)
+	}
+	if invalid := transactions[3]; invalid.Request != nil || invalid.Err == nil {
+		t.Fatalf("unexpected invalid frame %+v", invalid)
+	}
+	if unanswered := transactions[4]; unanswered.Response != nil || unanswered.Err == nil {
+		t.Fatalf("unexpected unanswered request %+v", unanswered)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+)
+
+// Maximum quantities of a single request.
+const (
+	maxReadBits       = 2000
+	maxReadRegisters  = 125
+	maxWriteBits      = 1968
+	maxWriteRegisters = 123
+	// maxReadWriteRegisters bounds the write of ReadWriteMultipleRegisters
+	maxReadWriteRegisters = 121
+)
+
+// SplittingClient splits reads and writes exceeding the protocol limits
+// into multiple requests and stitches the results together, so that
+// callers need not care about quantity limits:
+//  client := modbus.NewSplittingClient(modbus.NewClient(handler))
+//  results, err := client.ReadHoldingRegisters(0, 1000)
+// Requests are sent in ascending address order and the first error stops
+// the operation, in which case a write may have been partially applied.
+// ReadWriteMultipleRegisters is never split.
+type SplittingClient struct {
+	Client
+	// Disabled sends requests unchanged, the underlying client then
+	// returns quantity errors.
+	Disabled bool
+	// Maximum quantities per request, protocol limits when zero. They can
+	// be lowered for devices which accept less than the protocol allows.
+	// Bit quantities are rounded down to a multiple of 8.
+	MaxReadBits       uint16
+	MaxReadRegisters  uint16
+	MaxWriteBits      uint16
+	MaxWriteRegisters uint16
+}
+
+// NewSplittingClient allocates a new SplittingClient wrapping client.
+func NewSplittingClient(client Client) *SplittingClient {
+	return &SplittingClient{Client: client}
+}
+
+// ReadCoils reads quantity coils in as many requests as needed.
+func (mb *SplittingClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	if mb.Disabled {
+		return mb.Client.ReadCoils(address, quantity)
+	}
+	return readBitsSplit(mb.Client.ReadCoils, address, quantity, bitLimit(mb.MaxReadBits, maxReadBits))
+}
+
+// ReadDiscreteInputs reads quantity discrete inputs in as many requests as
+// needed.
+func (mb *SplittingClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	if mb.Disabled {
+		return mb.Client.ReadDiscreteInputs(address, quantity)
+	}
+	return readBitsSplit(mb.Client.ReadDiscreteInputs, address, quantity, bitLimit(mb.MaxReadBits, maxReadBits))
+}
+
+// ReadHoldingRegisters reads quantity holding registers in as many
+// requests as needed.
+func (mb *SplittingClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	if mb.Disabled {
+		return mb.Client.ReadHoldingRegisters(address, quantity)
+	}
+	return readRegistersSplit(mb.Client.ReadHoldingRegisters, address, quantity, limit(mb.MaxReadRegisters, maxReadRegisters))
+}
+
+// ReadInputRegisters reads quantity input registers in as many requests
+// as needed.
+func (mb *SplittingClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	if mb.Disabled {
+		return mb.Client.ReadInputRegisters(address, quantity)
+	}
+	return readRegistersSplit(mb.Client.ReadInputRegisters, address, quantity, limit(mb.MaxReadRegisters, maxReadRegisters))
+}
+
+// WriteMultipleCoils writes quantity coils in as many requests as needed
+// and returns the total quantity written.
+func (mb *SplittingClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	max := bitLimit(mb.MaxWriteBits, maxWriteBits)
+	if mb.Disabled || quantity <= max {
+		return mb.Client.WriteMultipleCoils(address, quantity, value)
+	}
+	if err = checkSplitRange(address, quantity); err != nil {
+		return
+	}
+	if len(value) != (int(quantity)+7)/8 {
+		err = fmt.Errorf("modbus: value size '%v' does not match quantity '%v'", len(value), quantity)
+		return
+	}
+	for done := 0; done < int(quantity); done += int(max) {
+		n := min(int(max), int(quantity)-done)
+		if _, err = mb.Client.WriteMultipleCoils(address+uint16(done), uint16(n), value[done/8:(done+n+7)/8]); err != nil {
+			return
+		}
+	}
+	results = dataBlock(quantity)
+	return
+}
+
+// WriteMultipleRegisters writes quantity registers in as many requests as
+// needed and returns the total quantity written.
+func (mb *SplittingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	max := limit(mb.MaxWriteRegisters, maxWriteRegisters)
+	if mb.Disabled || quantity <= max {
+		return mb.Client.WriteMultipleRegisters(address, quantity, value)
+	}
+	if err = checkSplitRange(address, quantity); err != nil {
+		return
+	}
+	if len(value) != 2*int(quantity) {
+		err = fmt.Errorf("modbus: value size '%v' does not match quantity '%v'", len(value), quantity)
+		return
+	}
+	for done := 0; done < int(quantity); done += int(max) {
+		n := min(int(max), int(quantity)-done)
+		if _, err = mb.Client.WriteMultipleRegisters(address+uint16(done), uint16(n), value[2*done:2*(done+n)]); err != nil {
+			return
+		}
+	}
+	results = dataBlock(quantity)
+	return
+}
+
+func readBitsSplit(read func(address, quantity uint16) ([]byte, error), address, quantity, max uint16) (results []byte, err error) {
+	if quantity <= max {
+		return read(address, quantity)
+	}
+	if err = checkSplitRange(address, quantity); err != nil {
+		return
+	}
+	results = make([]byte, 0, (int(quantity)+7)/8)
+	for done := 0; done < int(quantity); done += int(max) {
+		n := min(int(max), int(quantity)-done)
+		var part []byte
+		if part, err = read(address+uint16(done), uint16(n)); err != nil {
+			return nil, err
+		}
+		if len(part) != (n+7)/8 {
+			err = fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(part), n)
+			return nil, err
+		}
+		// Parts but the last contain a multiple of 8 bits.
+		results = append(results, part...)
+	}
+	return
+}
+
+func readRegistersSplit(read func(address, quantity uint16) ([]byte, error), address, quantity, max uint16) (results []byte, err error) {
+	if quantity <= max {
+		return read(address, quantity)
+	}
+	if err = checkSplitRange(address, quantity); err != nil {
+		return
+	}
+	results = make([]byte, 0, 2*int(quantity))
+	for done := 0; done < int(quantity); done += int(max) {
+		n := min(int(max), int(quantity)-done)
+		var part []byte
+		if part, err = read(address+uint16(done), uint16(n)); err != nil {
+			return nil, err
+		}
+		if len(part) != 2*n {
+			err = fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(part), n)
+			return nil, err
+		}
+		results = append(results, part...)
+	}
+	return
+}
+
+// checkSplitRange verifies the range does not wrap around the address space.
+func checkSplitRange(address, quantity uint16) error {
+	if int(address)+int(quantity) > 65536 {
+		return fmt.Errorf("modbus: address '%v' plus quantity '%v' exceeds '%v'", address, quantity, 65536)
+	}
+	return nil
+}
+
+func limit(value, max uint16) uint16 {
+	if value == 0 || value > max {
+		return max
+	}
+	return value
+}
+
+func bitLimit(value, max uint16) uint16 {
+	value = limit(value, max) &^ 7
+	if value == 0 {
+		return 8
+	}
+	return value
+}
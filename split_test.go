@@ -0,0 +1,116 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// memoryClient serves coils and holding registers from memory and
+// enforces protocol quantity limits.
+type memoryClient struct {
+	Client
+	coils    [65536]bool
+	holding  [65536]uint16
+	requests int
+}
+
+func (c *memoryClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	if quantity < 1 || quantity > maxReadBits {
+		return nil, &ModbusError{FunctionCode: 0x81, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	_, value, _ := PackBits(c.coils[address : int(address)+int(quantity)])
+	return value, nil
+}
+
+func (c *memoryClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	c.requests++
+	if quantity < 1 || quantity > maxWriteBits {
+		return nil, &ModbusError{FunctionCode: 0x8F, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	values, err := UnpackBits(value, quantity)
+	if err != nil {
+		return nil, err
+	}
+	copy(c.coils[address:], values)
+	return dataBlock(quantity), nil
+}
+
+func (c *memoryClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	c.requests++
+	if quantity < 1 || quantity > maxReadRegisters {
+		return nil, &ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	return dataBlock(c.holding[address : int(address)+int(quantity)]...), nil
+}
+
+func (c *memoryClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	c.requests++
+	if quantity < 1 || quantity > maxWriteRegisters {
+		return nil, &ModbusError{FunctionCode: 0x90, ExceptionCode: ExceptionCodeIllegalDataValue}
+	}
+	for i := 0; i < int(quantity); i++ {
+		c.holding[int(address)+i] = binary.BigEndian.Uint16(value[2*i:])
+	}
+	return dataBlock(quantity), nil
+}
+
+func TestSplittingClientRegisters(t *testing.T) {
+	memory := &memoryClient{}
+	client := NewSplittingClient(memory)
+	value := make([]byte, 600)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	results, err := client.WriteMultipleRegisters(100, 300, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x01, 0x2C}, results) || memory.requests != 3 {
+		t.Fatalf("unexpected results %x, requests %v", results, memory.requests)
+	}
+	if memory.holding[399] != 0x5657 {
+		t.Fatalf("unexpected register %x", memory.holding[399])
+	}
+	if results, err = client.ReadHoldingRegisters(100, 300); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, results) {
+		t.Fatalf("unexpected results %x", results)
+	}
+
+	client.Disabled = true
+	if _, err = client.ReadHoldingRegisters(100, 300); err == nil {
+		t.Fatalf("expected quantity error")
+	}
+}
+
+func TestSplittingClientCoils(t *testing.T) {
+	memory := &memoryClient{}
+	client := NewSplittingClient(memory)
+	client.MaxReadBits = 100 // Rounded down to 96
+	quantity, value := uint16(2500), make([]byte, 313)
+	value[312] = 0x09 // coils 2496 and 2499
+	if _, err := client.WriteMultipleCoils(10, quantity, value); err != nil {
+		t.Fatal(err)
+	}
+	if coils := memory.coils[2506:2510]; !coils[0] || coils[1] || !coils[3] {
+		t.Fatalf("unexpected coils %v", coils)
+	}
+	memory.requests = 0
+	results, err := client.ReadCoils(10, quantity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, results) || memory.requests != 27 {
+		t.Fatalf("unexpected results %x, requests %v", results, memory.requests)
+	}
+	if _, err = client.ReadCoils(65000, 2500); err == nil {
+		t.Fatalf("expected range error")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// snapshotGap is the number of zero values splitting blocks of snapshots.
+const snapshotGap = 8
+
+// StoreSnapshot is the state of a MemoryStore, the blocks of its non-zero
+// values, coils and discrete inputs are 0 or 1:
+//  {
+//    "coils": [{"address": 3, "values": [1, 0, 1]}],
+//    "holding_registers": [{"address": 100, "values": [230, 50]}]
+//  }
+type StoreSnapshot struct {
+	Coils            []StoreBlock `json:"coils,omitempty"`
+	DiscreteInputs   []StoreBlock `json:"discrete_inputs,omitempty"`
+	HoldingRegisters []StoreBlock `json:"holding_registers,omitempty"`
+	InputRegisters   []StoreBlock `json:"input_registers,omitempty"`
+}
+
+// StoreBlock is a block of values of a table starting at Address.
+type StoreBlock struct {
+	Address uint16   `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// Snapshot writes the values of the store as JSON, see StoreSnapshot, e.g.
+// to persist the state of an emulated device between runs:
+//  f, err := os.Create("device.json")
+//  ...
+//  err = store.Snapshot(f)
+func (s *MemoryStore) Snapshot(w io.Writer) error {
+	snapshot := s.snapshot()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&snapshot)
+}
+
+// Restore replaces the values of the store with the snapshot read from r,
+// written by Snapshot or as a test fixture. Values not in the snapshot
+// are cleared. Subscriptions are not notified.
+func (s *MemoryStore) Restore(r io.Reader) error {
+	var snapshot StoreSnapshot
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snapshot); err != nil {
+		return fmt.Errorf("modbus: invalid store snapshot: %v", err)
+	}
+	return s.restore(&snapshot)
+}
+
+// snapshot returns the values of the store.
+func (s *MemoryStore) snapshot() (snapshot StoreSnapshot) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot.Coils = bitBlocks(s.coils)
+	snapshot.DiscreteInputs = bitBlocks(s.discreteInputs)
+	snapshot.HoldingRegisters = registerBlocks(s.holdingRegisters)
+	snapshot.InputRegisters = registerBlocks(s.inputRegisters)
+	return
+}
+
+// restore replaces the values of the store with the snapshot, the store
+// is not modified if the snapshot is invalid.
+func (s *MemoryStore) restore(snapshot *StoreSnapshot) (err error) {
+	tables := []struct {
+		table  Table
+		blocks []StoreBlock
+	}{
+		{TableCoils, snapshot.Coils},
+		{TableDiscreteInputs, snapshot.DiscreteInputs},
+		{TableHoldingRegisters, snapshot.HoldingRegisters},
+		{TableInputRegisters, snapshot.InputRegisters},
+	}
+	for _, t := range tables {
+		for _, block := range t.blocks {
+			if int(block.Address)+len(block.Values) > 65536 {
+				return fmt.Errorf("modbus: block of '%v' %v at address '%v' exceeds the address space", len(block.Values), t.table, block.Address)
+			}
+			if t.table.isBits() {
+				for i, v := range block.Values {
+					if v > 1 {
+						return fmt.Errorf("modbus: invalid value '%v' of %v at address '%v'", v, t.table, int(block.Address)+i)
+					}
+				}
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	restoreBits(s.coils, snapshot.Coils)
+	restoreBits(s.discreteInputs, snapshot.DiscreteInputs)
+	restoreRegisters(s.holdingRegisters, snapshot.HoldingRegisters)
+	restoreRegisters(s.inputRegisters, snapshot.InputRegisters)
+	return
+}
+
+// registerBlocks returns the blocks of non-zero values, split by
+// snapshotGap zeros.
+func registerBlocks(values []uint16) (blocks []StoreBlock) {
+	for i := 0; i < len(values); {
+		if values[i] == 0 {
+			i++
+			continue
+		}
+		// Extend the block until snapshotGap zeros or the end
+		end, zeros := i+1, 0
+		for j := i + 1; j < len(values) && zeros < snapshotGap; j++ {
+			if values[j] == 0 {
+				zeros++
+			} else {
+				end, zeros = j+1, 0
+			}
+		}
+		blocks = append(blocks, StoreBlock{Address: uint16(i), Values: append([]uint16(nil), values[i:end]...)})
+		i = end
+	}
+	return
+}
+
+// bitBlocks returns the blocks of bits set, see registerBlocks.
+func bitBlocks(bits []bool) []StoreBlock {
+	return registerBlocks(bitValues(bits))
+}
+
+func restoreRegisters(values []uint16, blocks []StoreBlock) {
+	for i := range values {
+		values[i] = 0
+	}
+	for _, block := range blocks {
+		copy(values[block.Address:], block.Values)
+	}
+}
+
+func restoreBits(bits []bool, blocks []StoreBlock) {
+	for i := range bits {
+		bits[i] = false
+	}
+	for _, block := range blocks {
+		for i, v := range block.Values {
+			bits[int(block.Address)+i] = v != 0
+		}
+	}
+}
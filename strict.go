@@ -0,0 +1,104 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// StrictValidation is a Middleware rejecting malformed responses of buggy
+// devices which the client would otherwise accept:
+//  client := modbus.NewMiddlewareClient(handler, modbus.StrictValidation)
+// It checks that the function code is echoed, that exceptions have one
+// exception code, that byte counts match both the payload and the
+// quantity requested, and that writes echo the address, quantity and
+// values of the request.
+func StrictValidation(request *Request, next Sender) (response *Response, err error) {
+	if response, err = next(request); err != nil {
+		return
+	}
+	if err = validateResponse(request, response.PDU); err != nil {
+		response = nil
+	}
+	return
+}
+
+// validateResponse checks the response PDU of the request.
+func validateResponse(request *Request, pdu *ProtocolDataUnit) error {
+	data := pdu.Data
+	switch pdu.FunctionCode {
+	case request.FunctionCode:
+	case request.FunctionCode | 0x80:
+		if len(data) != 1 {
+			return fmt.Errorf("modbus: exception response data size '%v' does not match expected '%v'", len(data), 1)
+		}
+		return nil
+	default:
+		return fmt.Errorf("modbus: response function code '%v' does not match request '%v'", pdu.FunctionCode, request.FunctionCode)
+	}
+	switch request.FunctionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		return checkByteCount(data, (int(request.Quantity)+7)/8)
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeReadWriteMultipleRegisters:
+		return checkByteCount(data, 2*int(request.Quantity))
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		value := binary.BigEndian.Uint16(request.PDU.Data[2:])
+		return checkEcho(data, 4, "value", request.Address, value)
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		return checkEcho(data, 4, "quantity", request.Address, request.Quantity)
+	case FuncCodeMaskWriteRegister:
+		if err := checkEcho(data, 6, "AND-mask", request.Address, request.Values[0]); err != nil {
+			return err
+		}
+		if value := binary.BigEndian.Uint16(data[4:]); value != request.Values[1] {
+			return fmt.Errorf("modbus: response OR-mask '%v' does not match request '%v'", value, request.Values[1])
+		}
+	case FuncCodeReadFIFOQueue:
+		if len(data) < 4 {
+			return fmt.Errorf("modbus: response data size '%v' is less than expected '%v'", len(data), 4)
+		}
+		if count := int(binary.BigEndian.Uint16(data)); count != len(data)-2 {
+			return fmt.Errorf("modbus: response byte count '%v' does not match data size '%v'", count, len(data)-2)
+		}
+		count := int(binary.BigEndian.Uint16(data[2:]))
+		if count > 31 {
+			return fmt.Errorf("modbus: fifo count '%v' is greater than expected '%v'", count, 31)
+		}
+		if 2*count != len(data)-4 {
+			return fmt.Errorf("modbus: response data size '%v' does not match fifo count '%v'", len(data)-4, count)
+		}
+	}
+	return nil
+}
+
+// checkByteCount checks the byte count of read responses.
+func checkByteCount(data []byte, expected int) error {
+	if len(data) < 1 {
+		return fmt.Errorf("modbus: response data is empty")
+	}
+	if count := int(data[0]); count != len(data)-1 {
+		return fmt.Errorf("modbus: response byte count '%v' does not match data size '%v'", count, len(data)-1)
+	} else if count != expected {
+		return fmt.Errorf("modbus: response byte count '%v' does not match expected '%v' of quantity requested", count, expected)
+	}
+	return nil
+}
+
+// checkEcho checks the size of write responses and the address and the
+// field following it echoed.
+func checkEcho(data []byte, size int, field string, address, value uint16) error {
+	if len(data) != size {
+		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(data), size)
+	}
+	if v := binary.BigEndian.Uint16(data); v != address {
+		return fmt.Errorf("modbus: response address '%v' does not match request '%v'", v, address)
+	}
+	if v := binary.BigEndian.Uint16(data[2:]); v != value {
+		return fmt.Errorf("modbus: response %v '%v' does not match request '%v'", field, v, value)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStrictValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  func(client Client) error
+		response *ProtocolDataUnit
+		err      string
+	}{
+		{
+			name: "exception",
+			request: func(client Client) (err error) {
+				_, err = client.ReadHoldingRegisters(0, 1)
+				return
+			},
+			response: &ProtocolDataUnit{0x83, []byte{2, 0}},
+			err:      "exception response data size '2'",
+		},
+		{
+			name: "quantity",
+			request: func(client Client) (err error) {
+				_, err = client.ReadHoldingRegisters(0, 2)
+				return
+			},
+			response: &ProtocolDataUnit{FuncCodeReadHoldingRegisters, []byte{2, 0, 1}},
+			err:      "byte count '2' does not match expected '4'",
+		},
+		{
+			name: "write",
+			request: func(client Client) (err error) {
+				_, err = client.WriteMultipleRegisters(1, 1, []byte{0, 1})
+				return
+			},
+			response: &ProtocolDataUnit{FuncCodeWriteMultipleRegisters, []byte{0, 2, 0, 1}},
+			err:      "response address '2' does not match request '1'",
+		},
+		{
+			name: "fifo",
+			request: func(client Client) (err error) {
+				_, err = client.ReadFIFOQueue(1)
+				return
+			},
+			response: &ProtocolDataUnit{FuncCodeReadFIFOQueue, []byte{0, 5, 0, 1, 0, 7}},
+			err:      "byte count '5' does not match data size '4'",
+		},
+	}
+	for _, test := range tests {
+		response := test.response
+		handler := &pduHandler{serve: func(*ProtocolDataUnit) *ProtocolDataUnit { return response }}
+		err := test.request(NewMiddlewareClient(handler, StrictValidation))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected error %q, actual %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestRTUVerifyFunctionCode(t *testing.T) {
+	var packager rtuPackager
+	request := []byte{1, 3, 0, 0, 0, 1, 0x84, 0x0A}
+	if err := packager.Verify(request, []byte{1, 0x83, 2, 0xC0, 0xF1}); err != nil {
+		t.Fatal(err)
+	}
+	err := packager.Verify(request, []byte{1, 4, 2, 0, 0, 0, 0})
+	if err == nil || !strings.Contains(err.Error(), "function code '4'") {
+		t.Fatalf("function code error expected, actual %v", err)
+	}
+}
+
+func TestReadFIFOQueueByteCount(t *testing.T) {
+	// The byte count of the specification includes the FIFO count but not
+	// itself: 2 bytes of FIFO count and 2 registers
+	handler := &pduHandler{serve: func(*ProtocolDataUnit) *ProtocolDataUnit {
+		return &ProtocolDataUnit{FuncCodeReadFIFOQueue, []byte{0, 6, 0, 2, 0, 7, 0, 8}}
+	}}
+	for _, client := range []Client{NewClient(handler), NewMiddlewareClient(handler, StrictValidation)} {
+		results, err := client.ReadFIFOQueue(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal([]byte{0, 7, 0, 8}, results) {
+			t.Fatalf("unexpected results %v", results)
+		}
+	}
+}
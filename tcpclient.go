@@ -5,6 +5,7 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -26,12 +27,22 @@ const (
 	tcpIdleTimeout = 60 * time.Second
 )
 
+// TCPUnitIdDirect is the unit identifier of devices addressed directly by
+// their IP address, which ignore it. Gateways forward requests of other
+// unit identifiers to the serial slave of that address.
+const TCPUnitIdDirect byte = 0xFF
+
 // TCPClientHandler implements Packager and Transporter interface.
 type TCPClientHandler struct {
 	tcpPackager
 	tcpTransporter
 }
 
+// buffered implements bufferTransporter.
+func (mb *TCPClientHandler) buffered() Transporter {
+	return mb
+}
+
 // NewTCPClientHandler allocates a new TCPClientHandler.
 func NewTCPClientHandler(address string) *TCPClientHandler {
 	h := &TCPClientHandler{}
@@ -47,14 +58,56 @@ func TCPClient(address string) Client {
 	return NewClient(handler)
 }
 
+// NewTCPPackager returns a packager of Modbus TCP frames of the unit
+// identifier, see NewRTUPackager. Requests are numbered from transaction
+// identifier 1.
+func NewTCPPackager(slaveId byte) Packager {
+	return &tcpPackager{SlaveId: slaveId}
+}
+
 // tcpPackager implements Packager interface.
 type tcpPackager struct {
 	// For synchronization between messages of server & client
 	transactionId uint32
+	// sharedTransactionId is used instead of transactionId if not nil.
+	sharedTransactionId *uint32
 	// Broadcast address is 0
 	SlaveId byte
 }
 
+// SetTransactionId sets the transaction id of the next request, e.g. to
+// a random one after reconnecting to gateways which reject transaction ids
+// seen before:
+//  handler.OnConnect = func() {
+//  	handler.SetTransactionId(uint16(rand.Intn(65536)))
+//  }
+func (mb *tcpPackager) SetTransactionId(transactionId uint16) {
+	atomic.StoreUint32(mb.counter(), uint32(transactionId-1))
+}
+
+// TransactionId returns the transaction id of the last request.
+func (mb *tcpPackager) TransactionId() uint16 {
+	return uint16(atomic.LoadUint32(mb.counter()))
+}
+
+func (mb *tcpPackager) counter() *uint32 {
+	if mb.sharedTransactionId != nil {
+		return mb.sharedTransactionId
+	}
+	return &mb.transactionId
+}
+
+// withSlaveId implements slavePackager, the transaction id sequence is
+// shared.
+func (mb *tcpPackager) withSlaveId(slaveId byte) Packager {
+	return &tcpPackager{sharedTransactionId: mb.counter(), SlaveId: slaveId}
+}
+
+// isBroadcast implements broadcastPackager.
+func (mb *tcpPackager) isBroadcast(aduRequest []byte) bool {
+	return len(aduRequest) > 6 && aduRequest[6] == 0
+}
+
 // Encode adds modbus application protocol header:
 //  Transaction identifier: 2 bytes
 //  Protocol identifier: 2 bytes
@@ -63,10 +116,15 @@ type tcpPackager struct {
 //  Function code: 1 byte
 //  Data: n bytes
 func (mb *tcpPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
-	adu = make([]byte, tcpHeaderSize+1+len(pdu.Data))
+	return mb.encodeTo(make([]byte, 0, tcpHeaderSize+1+len(pdu.Data)), pdu)
+}
+
+// encodeTo implements bufferEncoder.
+func (mb *tcpPackager) encodeTo(adu []byte, pdu *ProtocolDataUnit) ([]byte, error) {
+	adu = adu[:tcpHeaderSize+1+len(pdu.Data)]
 
 	// Transaction identifier
-	transactionId := atomic.AddUint32(&mb.transactionId, 1)
+	transactionId := atomic.AddUint32(mb.counter(), 1)
 	binary.BigEndian.PutUint16(adu, uint16(transactionId))
 	// Protocol identifier
 	binary.BigEndian.PutUint16(adu[2:], tcpProtocolIdentifier)
@@ -79,11 +137,15 @@ func (mb *tcpPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
 	// PDU
 	adu[tcpHeaderSize] = pdu.FunctionCode
 	copy(adu[tcpHeaderSize+1:], pdu.Data)
-	return
+	return adu, nil
 }
 
 // Verify confirms transaction, protocol and unit id.
 func (mb *tcpPackager) Verify(aduRequest []byte, aduResponse []byte) (err error) {
+	if len(aduResponse) < tcpHeaderSize {
+		err = fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'", len(aduResponse), tcpHeaderSize)
+		return
+	}
 	// Transaction id
 	responseVal := binary.BigEndian.Uint16(aduResponse)
 	requestVal := binary.BigEndian.Uint16(aduRequest)
@@ -112,6 +174,10 @@ func (mb *tcpPackager) Verify(aduRequest []byte, aduResponse []byte) (err error)
 //  Length: 2 bytes
 //  Unit identifier: 1 byte
 func (mb *tcpPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
+	if len(adu) <= tcpHeaderSize || len(adu) > tcpMaxLength {
+		err = fmt.Errorf("modbus: frame length '%v' must be between '%v' and '%v'", len(adu), tcpHeaderSize+1, tcpMaxLength)
+		return
+	}
 	// Read length value in the header
 	length := binary.BigEndian.Uint16(adu[4:])
 	pduLength := len(adu) - tcpHeaderSize
@@ -136,106 +202,298 @@ type tcpTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// Format of the frames logged
+	WireLog
+	// Callbacks of the connection state
+	Lifecycle
+	// Spacing of requests
+	Pacing
+	// Timeouts of connection, request and response
+	Timeouts
+	// Redundant endpoints
+	Failover
+	// DialContext, if not nil, connects instead of net.Dialer, e.g. through
+	// a SOCKS5 proxy, an SSH tunnel or from a given interface. The context
+	// expires after DialTimeout.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// StrictFraming fails on keep-alive frames without PDU and frames with
+	// another protocol or transaction id than the request instead of
+	// logging and skipping them. Frames of another transaction are usually
+	// late responses to requests which timed out.
+	StrictFraming bool
+	// Clock defaults to the system time if nil, it does not apply to the
+	// deadlines of the connection.
+	Clock Clock
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Conn
-	closeTimer   *time.Timer
+	closeTimer   Timer
 	lastActivity time.Time
+	// shutdown is set by Shutdown, requests then fail with ErrClosed.
+	shutdown atomic.Bool
 }
 
 // Send sends data to server and ensures response length is greater than header length.
 func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.mu.Lock()
-	defer mb.mu.Unlock()
+	return mb.sendBuffer(context.Background(), new(aduBuffer), aduRequest)
+}
+
+// sendBuffer implements bufferTransporter.
+func (mb *tcpTransporter) sendBuffer(ctx context.Context, buf *aduBuffer, aduRequest []byte) (aduResponse []byte, err error) {
+	if !heldByBatch(ctx, &mb.mu) {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+	}
+	defer mb.notifyError(&err)
+	defer mb.closeFailed(&err)
 
 	// Establish a new connection if not connected
 	if err = mb.connect(); err != nil {
 		return
 	}
+	mb.pace(clockOrSystem(mb.Clock))
+	defer mb.paced(clockOrSystem(mb.Clock))
 	// Set timer to close when idle
-	mb.lastActivity = time.Now()
+	mb.lastActivity = mb.now()
 	mb.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
-	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
+	// Send data
+	mb.logFrame(frameTCP, true, aduRequest)
+	if err = mb.send(aduRequest, aduRequest[6]); err != nil {
 		return
 	}
-	// Send data
-	mb.logf("modbus: sending % x", aduRequest)
-	if _, err = mb.conn.Write(aduRequest); err != nil {
+	defer mb.observeResponse(aduRequest[6], mb.now(), &err)
+	data := buf[:tcpMaxLength]
+	var length int
+	if length, err = mb.readFrame(data, aduRequest); err != nil {
 		return
 	}
-	// Read header first
-	var data [tcpMaxLength]byte
-	if _, err = io.ReadFull(mb.conn, data[:tcpHeaderSize]); err != nil {
+	aduResponse = data[:length]
+	mb.logFrame(frameTCP, false, aduResponse)
+	return
+}
+
+// send writes the request within WriteTimeout and sets the deadline of
+// the response of slaveId to ReadTimeout after it. Caller must hold the
+// mutex.
+func (mb *tcpTransporter) send(aduRequest []byte, slaveId byte) (err error) {
+	if err = mb.conn.SetWriteDeadline(deadline(mb.writeTimeout(mb.Timeout))); err != nil {
 		return
 	}
-	// Read length, ignore transaction & protocol id (4 bytes)
-	length := int(binary.BigEndian.Uint16(data[4:]))
-	if length <= 0 {
-		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
+	if _, err = mb.conn.Write(aduRequest); err != nil {
 		return
 	}
-	if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
-		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
+	return mb.conn.SetReadDeadline(deadline(mb.slaveTimeout(slaveId, mb.readTimeout(mb.Timeout))))
+}
+
+// observeResponse records the response time of slaveId since sent, or
+// the timeout if the response timed out, see Adaptive.
+func (mb *tcpTransporter) observeResponse(slaveId byte, sent time.Time, err *error) {
+	if mb.Adaptive == nil {
 		return
 	}
-	// Skip unit id
-	length += tcpHeaderSize - 1
-	if _, err = io.ReadFull(mb.conn, data[tcpHeaderSize:length]); err != nil {
+	if *err == nil {
+		mb.Adaptive.Observe(slaveId, mb.now().Sub(sent))
 		return
 	}
-	aduResponse = data[:length]
-	mb.logf("modbus: received % x\n", aduResponse)
+	if isTimeout(*err) {
+		mb.Adaptive.Observe(slaveId, mb.slaveTimeout(slaveId, mb.readTimeout(mb.Timeout)))
+	}
+}
+
+// responseError returns the timeout err reading the response of slaveId
+// after received bytes as a NoResponseError or PartialResponseError.
+func (mb *tcpTransporter) responseError(err error, received int, slaveId byte) error {
+	return responseTimeoutError(err, received, mb.slaveTimeout(slaveId, mb.readTimeout(mb.Timeout)))
+}
+
+// deadline returns the time timeout from now, or zero time if timeout is
+// not positive.
+func deadline(timeout time.Duration) (t time.Time) {
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
 	return
 }
 
+// readFrame reads the response frame to aduRequest into data and returns
+// its length. Keep-alive frames and frames of another protocol or
+// transaction than the request are skipped unless StrictFraming is set, so
+// that they do not desynchronize the stream.
+func (mb *tcpTransporter) readFrame(data []byte, aduRequest []byte) (length int, err error) {
+	transactionId := binary.BigEndian.Uint16(aduRequest)
+	protocolId := binary.BigEndian.Uint16(aduRequest[2:])
+	for {
+		// Read header without unit id first, keep-alive frames may end there
+		var n int
+		if n, err = io.ReadFull(mb.conn, data[:tcpHeaderSize-1]); err != nil {
+			err = mb.responseError(err, n, aduRequest[6])
+			return
+		}
+		// Read length
+		length = int(binary.BigEndian.Uint16(data[4:]))
+		if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
+			mb.flush(data[:])
+			err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
+			return
+		}
+		if length <= 1 && mb.StrictFraming {
+			mb.flush(data[:])
+			err = fmt.Errorf("modbus: length in response header '%v' must be greater than '%v'", length, 1)
+			return
+		}
+		length += tcpHeaderSize - 1
+		if n, err = io.ReadFull(mb.conn, data[tcpHeaderSize-1:length]); err != nil {
+			err = mb.responseError(err, tcpHeaderSize-1+n, aduRequest[6])
+			expectResponse(err, length)
+			return
+		}
+		if length <= tcpHeaderSize {
+			mb.logf("modbus: skipped keep-alive frame % x\n", data[:length])
+			continue
+		}
+		if id := binary.BigEndian.Uint16(data[2:]); id != protocolId {
+			if mb.StrictFraming {
+				err = fmt.Errorf("modbus: response protocol id '%v' does not match request '%v'", id, protocolId)
+				return
+			}
+			mb.logf("modbus: skipped frame of protocol '%v' % x\n", id, data[:length])
+			continue
+		}
+		if id := binary.BigEndian.Uint16(data); id != transactionId {
+			if mb.StrictFraming {
+				err = fmt.Errorf("modbus: response transaction id '%v' does not match request '%v'", id, transactionId)
+				return
+			}
+			mb.logf("modbus: skipped frame of transaction '%v' % x\n", id, data[:length])
+			continue
+		}
+		return
+	}
+}
+
 // Connect establishes a new connection to the address in Address.
-// Connect and Close are exported so that multiple requests can be done with one session
-func (mb *tcpTransporter) Connect() error {
+// Connect and Close are exported so that multiple requests can be done with one session.
+// Connect does nothing if the connection is already established.
+func (mb *tcpTransporter) Connect() (err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
+	defer mb.notifyError(&err)
 
 	return mb.connect()
 }
 
 func (mb *tcpTransporter) connect() error {
+	if mb.shutdown.Load() {
+		return ErrClosed
+	}
+	if mb.conn != nil && mb.failback(mb.Address, mb.now()) {
+		mb.probed = mb.now()
+		conn, err := mb.dial(mb.Address)
+		if err != nil {
+			mb.logf("modbus: failback to '%v' failed: %v\n", mb.Address, err)
+			return nil
+		}
+		mb.close()
+		mb.conn = conn
+		mb.setEndpoint(mb.Address)
+		mb.connected()
+	}
 	if mb.conn == nil {
-		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
+		conn, err := mb.dialEndpoints(mb.Address, mb.now(), mb.dial)
 		if err != nil {
 			return err
 		}
 		mb.conn = conn
+		mb.connected()
 	}
 	return nil
 }
 
+func (mb *tcpTransporter) dial(address string) (net.Conn, error) {
+	timeout := mb.dialTimeout(mb.Timeout)
+	if mb.DialContext == nil {
+		dialer := net.Dialer{Timeout: timeout}
+		return dialer.Dial("tcp", address)
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return mb.DialContext(ctx, "tcp", address)
+}
+
+// closeFailed closes the connection after a failed request when failover
+// addresses are set. Caller must hold the mutex.
+func (mb *tcpTransporter) closeFailed(err *error) {
+	if mb.failing(*err) {
+		mb.close()
+	}
+}
+
+// Endpoint returns the address of the endpoint connected last, Address
+// or one of FailoverAddresses.
+func (mb *tcpTransporter) Endpoint() string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.endpoint
+}
+
 func (mb *tcpTransporter) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
 	}
 	if mb.closeTimer == nil {
-		mb.closeTimer = time.AfterFunc(mb.IdleTimeout, mb.closeIdle)
+		mb.closeTimer = clockOrSystem(mb.Clock).AfterFunc(mb.IdleTimeout, mb.closeIdle)
 	} else {
 		mb.closeTimer.Reset(mb.IdleTimeout)
 	}
 }
 
-// Close closes current connection.
+// Close closes current connection. It is safe to call Close more than once,
+// a closed transporter reconnects on the next Connect or Send.
 func (mb *tcpTransporter) Close() error {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	if mb.closeTimer != nil {
+		mb.closeTimer.Stop()
+	}
 	return mb.close()
 }
 
+// Shutdown waits for the request in progress, if any, up to the deadline
+// of ctx and closes the connection. Requests sent after Shutdown fail with
+// ErrClosed. If ctx is done first, the error of ctx is returned and the
+// connection is closed once the request completes.
+func (mb *tcpTransporter) Shutdown(ctx context.Context) error {
+	mb.shutdown.Store(true)
+	return drain(ctx, &mb.mu, func() error {
+		if mb.closeTimer != nil {
+			mb.closeTimer.Stop()
+		}
+		return mb.close()
+	})
+}
+
+// IsConnected returns true if the connection is established.
+func (mb *tcpTransporter) IsConnected() bool {
+	return mb.State() == StateConnected
+}
+
+// State returns current state of the connection.
+func (mb *tcpTransporter) State() ConnectionState {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.conn == nil {
+		return StateDisconnected
+	}
+	return StateConnected
+}
+
 // flush flushes pending data in the connection,
 // returns io.EOF if connection is closed.
 func (mb *tcpTransporter) flush(b []byte) (err error) {
@@ -245,24 +503,46 @@ func (mb *tcpTransporter) flush(b []byte) (err error) {
 	// Timeout setting will be reset when reading
 	if _, err = mb.conn.Read(b); err != nil {
 		// Ignore timeout error
-		if netError, ok := err.(net.Error); ok && netError.Timeout() {
+		if isTimeout(err) {
 			err = nil
 		}
 	}
 	return
 }
 
+func (mb *tcpTransporter) now() time.Time {
+	return clockOrSystem(mb.Clock).Now()
+}
+
+func (mb *tcpTransporter) sleep(d time.Duration) {
+	clockOrSystem(mb.Clock).Sleep(d)
+}
+
 func (mb *tcpTransporter) logf(format string, v ...interface{}) {
 	if mb.Logger != nil {
 		mb.Logger.Printf(format, v...)
 	}
 }
 
-// closeLocked closes current connection. Caller must hold the mutex before calling this method.
+// logFrame logs a frame sent or received, see WireLog. It does not
+// allocate without Logger.
+func (mb *tcpTransporter) logFrame(format frameFormat, sent bool, frame []byte) {
+	if mb.Logger != nil {
+		mb.Logger.Print(mb.formatFrame(format, sent, mb.now(), frame))
+	}
+}
+
+// batchMutex implements batchLocker.
+func (mb *tcpTransporter) batchMutex() *sync.Mutex {
+	return &mb.mu
+}
+
+// close closes current connection. Caller must hold the mutex before calling this method.
 func (mb *tcpTransporter) close() (err error) {
 	if mb.conn != nil {
 		err = mb.conn.Close()
 		mb.conn = nil
+		mb.disconnected()
 	}
 	return
 }
@@ -275,7 +555,7 @@ func (mb *tcpTransporter) closeIdle() {
 	if mb.IdleTimeout <= 0 {
 		return
 	}
-	idle := time.Now().Sub(mb.lastActivity)
+	idle := mb.now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
 		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
 		mb.close()
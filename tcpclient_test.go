@@ -6,6 +6,8 @@ package modbus
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net"
 	"testing"
@@ -88,6 +90,82 @@ func TestTCPTransporter(t *testing.T) {
 	}
 }
 
+func TestTCPTransporterIdleClock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	clock := newManualClock()
+	client := &tcpTransporter{
+		Address:     ln.Addr().String(),
+		Timeout:     1 * time.Second,
+		IdleTimeout: time.Minute,
+		Clock:       clock,
+	}
+	defer client.Close()
+	if _, err = client.Send([]byte{0, 1, 0, 0, 0, 2, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Second)
+	if !client.IsConnected() {
+		t.Fatal("connection is closed before the idle timeout")
+	}
+	clock.Advance(time.Second)
+	if client.IsConnected() {
+		t.Fatal("connection is not closed after the idle timeout")
+	}
+}
+
+func TestTCPTransporterFragmentedFrames(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	req := []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}
+	rsp := []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0, 7}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if _, err = io.ReadFull(conn, make([]byte, len(req))); err != nil {
+			t.Error(err)
+			return
+		}
+		// A late response and the head of the response in one read, then
+		// the rest of the response one byte at a time
+		late := []byte{0, 0, 0, 0, 0, 5, 1, 3, 2, 0, 9}
+		conn.Write(append(late, rsp[:3]...))
+		for i := 3; i < len(rsp); i++ {
+			time.Sleep(time.Millisecond)
+			conn.Write(rsp[i : i+1])
+		}
+	}()
+	client := &tcpTransporter{Address: ln.Addr().String(), Timeout: time.Second}
+	defer client.Close()
+	aduResponse, err := client.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsp, aduResponse) {
+		t.Fatalf("unexpected response: % x", aduResponse)
+	}
+}
+
 func BenchmarkTCPEncoder(b *testing.B) {
 	encoder := tcpPackager{
 		SlaveId: 10,
@@ -116,3 +194,266 @@ func BenchmarkTCPDecoder(b *testing.B) {
 		}
 	}
 }
+
+func TestTCPTransporterState(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client := &tcpTransporter{
+		Address: ln.Addr().String(),
+		Timeout: 1 * time.Second,
+	}
+	if client.IsConnected() || client.State() != StateDisconnected {
+		t.Fatalf("unexpected state: %v", client.State())
+	}
+	for i := 0; i < 2; i++ {
+		if err = client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		if !client.IsConnected() {
+			t.Fatalf("unexpected state: %v", client.State())
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err = client.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if client.State() != StateDisconnected {
+			t.Fatalf("unexpected state: %v", client.State())
+		}
+	}
+}
+
+func TestTCPTransporterKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		var req [12]byte
+		if _, err = io.ReadFull(conn, req[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write([]byte{0, 0, 0, 0, 0, 0})                      // keep-alive without unit id
+		conn.Write([]byte{0, 0, 0, 0, 0, 1, 1})                   // keep-alive with unit id
+		conn.Write([]byte{0, 1, 0, 5, 0, 4, 1, 3, 1, 0})          // another protocol
+		conn.Write([]byte{0, 0, 0, 0, 0, 5, 1, 3, 2, 0x56, 0x78}) // late response
+		conn.Write([]byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}) // response
+	}()
+	handler := NewTCPClientHandler(ln.Addr().String())
+	handler.Timeout = time.Second
+	handler.SlaveId = 1
+	defer handler.Close()
+	results, err := NewClient(handler).ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x12, 0x34}, results) {
+		t.Fatalf("unexpected results: % x", results)
+	}
+}
+
+func TestTCPTransactionId(t *testing.T) {
+	var packager tcpPackager
+	packager.SetTransactionId(0xABCD)
+	adu, err := packager.Encode(&ProtocolDataUnit{FunctionCode: 3, Data: []byte{0, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adu[0] != 0xAB || adu[1] != 0xCD || packager.TransactionId() != 0xABCD {
+		t.Fatalf("unexpected transaction id % x", adu[:2])
+	}
+	packager.SetTransactionId(0)
+	if adu, _ = packager.Encode(&ProtocolDataUnit{FunctionCode: 3}); adu[0] != 0 || adu[1] != 0 {
+		t.Fatalf("unexpected transaction id % x", adu[:2])
+	}
+
+	// Late responses fail with StrictFraming
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		var req [12]byte
+		io.ReadFull(server, req[:])
+		server.Write([]byte{0, 0, 0, 0, 0, 5, 1, 3, 2, 0x56, 0x78})
+		server.Close()
+	}()
+	transporter := &tcpTransporter{conn: client, StrictFraming: true}
+	_, err = transporter.Send([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	if err == nil || err.Error() != "modbus: response transaction id '0' does not match request '1'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTCPTransporterReadTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Never respond
+		io.Copy(io.Discard, conn)
+	}()
+	handler := NewTCPClientHandler(ln.Addr().String())
+	handler.Timeout = 10 * time.Second
+	handler.ReadTimeout = 100 * time.Millisecond
+	defer handler.Close()
+	start := time.Now()
+	_, err = NewClient(handler).ReadHoldingRegisters(0, 1)
+	var noResponse *NoResponseError
+	if !errors.As(err, &noResponse) || noResponse.Timeout != handler.ReadTimeout || !isTimeout(err) {
+		t.Fatalf("timeout expected, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("elapsed %v does not match read timeout", elapsed)
+	}
+}
+
+func TestTCPTransporterFailover(t *testing.T) {
+	// The primary is down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := ln.Addr().String()
+	ln.Close()
+	standby, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Close()
+	go serveCounter(standby)
+
+	handler := NewTCPClientHandler(primary)
+	handler.Timeout = time.Second
+	handler.FailoverAddresses = []string{standby.Addr().String()}
+	handler.FailbackInterval = 10 * time.Millisecond
+	var endpoints []string
+	handler.OnEndpoint = func(address string) {
+		endpoints = append(endpoints, address)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	if _, err = client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if handler.Endpoint() != standby.Addr().String() {
+		t.Fatalf("unexpected endpoint %v", handler.Endpoint())
+	}
+
+	// Fail back once the primary is up
+	if ln, err = net.Listen("tcp", primary); err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go serveCounter(ln)
+	time.Sleep(20 * time.Millisecond)
+	results, err := client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1] != 1 || handler.Endpoint() != primary {
+		t.Fatalf("unexpected results %v from %v", results, handler.Endpoint())
+	}
+	if len(endpoints) != 2 || endpoints[0] != standby.Addr().String() || endpoints[1] != primary {
+		t.Fatalf("unexpected endpoints %v", endpoints)
+	}
+
+}
+
+func TestTCPTransporterDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveCounter(ln)
+
+	handler := NewTCPClientHandler("device:502")
+	handler.Timeout = time.Second
+	var dialed string
+	handler.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("context has no deadline")
+		}
+		// Tunnel to the listener
+		dialed = network + " " + address
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, ln.Addr().String())
+	}
+	defer handler.Close()
+	if _, err = NewClient(handler).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if dialed != "tcp device:502" {
+		t.Fatalf("unexpected dial %v", dialed)
+	}
+}
+
+func TestTCPWithSlaveId(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveCounter(ln)
+
+	handler := NewTCPClientHandler(ln.Addr().String())
+	handler.Timeout = time.Second
+	handler.SlaveId = TCPUnitIdDirect
+	defer handler.Close()
+	var requests [][]byte
+	client := NewClient2(handler, transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		requests = append(requests, aduRequest)
+		return handler.Send(aduRequest)
+	}))
+	if _, err = client.ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = WithSlaveId(client, 3).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if requests[0][6] != 0xFF || requests[1][6] != 3 {
+		t.Fatalf("unexpected unit ids %v, %v", requests[0][6], requests[1][6])
+	}
+	// The transaction id sequence is shared
+	if requests[1][1] != requests[0][1]+1 {
+		t.Fatalf("unexpected transaction ids %x, %x", requests[0][:2], requests[1][:2])
+	}
+
+	// Responses of another unit are rejected
+	client = NewClient2(handler, transporterFunc(func(aduRequest []byte) ([]byte, error) {
+		return []byte{aduRequest[0], aduRequest[1], 0, 0, 0, 5, 4, 3, 2, 0, 1}, nil
+	}))
+	_, err = WithSlaveId(client, 3).ReadHoldingRegisters(0, 1)
+	if err == nil || err.Error() != "modbus: response unit id '4' does not match request '3'" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -2,6 +2,8 @@
 // This software may be modified and distributed under the terms
 // of the BSD license.  See the LICENSE file for details.
 
+//go:build !modbus_noserial
+
 package test
 
 import (
@@ -0,0 +1,59 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"time"
+)
+
+// Timeouts bound the phases of a transaction separately, e.g. to allow
+// slow connection setup but expect fast responses:
+//  handler := modbus.NewTCPClientHandler("localhost:502")
+//  handler.DialTimeout = 30 * time.Second
+//  handler.ReadTimeout = 500 * time.Millisecond
+// Zero values default to Timeout of the transporter.
+type Timeouts struct {
+	// DialTimeout bounds establishing network connections, it does not
+	// apply to serial ports.
+	DialTimeout time.Duration
+	// ReadTimeout bounds waiting for the response after the request has
+	// been sent.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds sending the request.
+	WriteTimeout time.Duration
+	// Adaptive, if set, bounds waiting for the response of each slave by
+	// its response times instead, see AdaptiveTimeout.
+	Adaptive *AdaptiveTimeout
+}
+
+func (t *Timeouts) dialTimeout(timeout time.Duration) time.Duration {
+	if t.DialTimeout > 0 {
+		return t.DialTimeout
+	}
+	return timeout
+}
+
+func (t *Timeouts) readTimeout(timeout time.Duration) time.Duration {
+	if t.ReadTimeout > 0 {
+		return t.ReadTimeout
+	}
+	return timeout
+}
+
+// slaveTimeout returns the response timeout of slaveId, timeout unless
+// Adaptive is set.
+func (t *Timeouts) slaveTimeout(slaveId byte, timeout time.Duration) time.Duration {
+	if t.Adaptive == nil {
+		return timeout
+	}
+	return t.Adaptive.bound(slaveId, timeout)
+}
+
+func (t *Timeouts) writeTimeout(timeout time.Duration) time.Duration {
+	if t.WriteTimeout > 0 {
+		return t.WriteTimeout
+	}
+	return timeout
+}
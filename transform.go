@@ -0,0 +1,287 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transform converts a register value to an engineering value on Read and
+// back on Write.
+type Transform interface {
+	Read(value float64) float64
+	Write(value float64) float64
+}
+
+// TransformFunc creates a Transform from the argument given in a struct tag.
+type TransformFunc func(arg string) (Transform, error)
+
+var (
+	transformsMu sync.RWMutex
+	transforms   = map[string]TransformFunc{
+		"scale":  newScaleTransform,
+		"offset": newOffsetTransform,
+		"clamp":  newClampTransform,
+		"unit":   newUnitTransform,
+		"round":  newRoundTransform,
+		"bit":    newBitTransform,
+		"enum":   newEnumTransform,
+		"bcd":    newBCDTransform,
+	}
+)
+
+// RegisterTransform makes a transform available in struct tags as
+// name=arg. Built-in transforms are:
+//  scale=0.1       value = raw * 0.1
+//  offset=-40      value = raw + (-40)
+//  clamp=0:100     limits value to [0, 100] on read and write
+//  unit=degC:degF  converts between units of the same quantity
+//  round=2         rounds value to 2 decimal places
+//  bit=3           value = bit 3 of raw, bit=4:7 for bits 4 to 7
+//  enum=1:10;2:20  maps raw 1 to 10 and 2 to 20, others to NaN
+//  bcd=            decodes raw as binary-coded decimal, NaN if invalid
+// Names addr, type and order are reserved.
+func RegisterTransform(name string, factory TransformFunc) {
+	switch name {
+	case "addr", "type", "order":
+		panic("modbus: transform name '" + name + "' is reserved")
+	}
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	transforms[name] = factory
+}
+
+func lookupTransform(name string) TransformFunc {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	return transforms[name]
+}
+
+type linearTransform struct {
+	factor float64
+	offset float64
+}
+
+func (t *linearTransform) Read(value float64) float64 {
+	return value*t.factor + t.offset
+}
+
+func (t *linearTransform) Write(value float64) float64 {
+	return (value - t.offset) / t.factor
+}
+
+func newScaleTransform(arg string) (Transform, error) {
+	factor, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil, err
+	}
+	if factor == 0 {
+		return nil, fmt.Errorf("scale must not be zero")
+	}
+	return &linearTransform{factor: factor}, nil
+}
+
+func newOffsetTransform(arg string) (Transform, error) {
+	offset, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &linearTransform{factor: 1, offset: offset}, nil
+}
+
+type clampTransform struct {
+	min, max float64
+}
+
+func (t *clampTransform) Read(value float64) float64 {
+	return math.Max(t.min, math.Min(t.max, value))
+}
+
+func (t *clampTransform) Write(value float64) float64 {
+	return t.Read(value)
+}
+
+func newClampTransform(arg string) (Transform, error) {
+	i := strings.IndexByte(arg, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("expected min:max")
+	}
+	min, err := strconv.ParseFloat(arg[:i], 64)
+	if err != nil {
+		return nil, err
+	}
+	max, err := strconv.ParseFloat(arg[i+1:], 64)
+	if err != nil {
+		return nil, err
+	}
+	if min > max {
+		return nil, fmt.Errorf("min is greater than max")
+	}
+	return &clampTransform{min, max}, nil
+}
+
+type roundTransform struct {
+	factor float64
+}
+
+func (t *roundTransform) Read(value float64) float64 {
+	return math.Round(value*t.factor) / t.factor
+}
+
+func (t *roundTransform) Write(value float64) float64 {
+	return t.Read(value)
+}
+
+func newRoundTransform(arg string) (Transform, error) {
+	places, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &roundTransform{math.Pow(10, float64(places))}, nil
+}
+
+func newUnitTransform(arg string) (Transform, error) {
+	i := strings.IndexByte(arg, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("expected from:to")
+	}
+	return ConvertUnit(arg[:i], arg[i+1:])
+}
+
+// bitTransform extracts bits, writes clear the other bits.
+type bitTransform struct {
+	shift uint
+	mask  uint64
+}
+
+func (t *bitTransform) Read(value float64) float64 {
+	return float64((uint64(value) >> t.shift) & t.mask)
+}
+
+func (t *bitTransform) Write(value float64) float64 {
+	return float64((uint64(value) & t.mask) << t.shift)
+}
+
+func newBitTransform(arg string) (Transform, error) {
+	first, last := arg, arg
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		first, last = arg[:i], arg[i+1:]
+	}
+	from, err := strconv.ParseUint(first, 10, 6)
+	if err != nil {
+		return nil, err
+	}
+	to, err := strconv.ParseUint(last, 10, 6)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("first bit is greater than last bit")
+	}
+	return &bitTransform{uint(from), 1<<(to-from+1) - 1}, nil
+}
+
+// enumTransform maps raw values to values, unknown values are NaN.
+type enumTransform struct {
+	values map[float64]float64
+}
+
+func (t *enumTransform) Read(value float64) float64 {
+	if v, ok := t.values[value]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+func (t *enumTransform) Write(value float64) float64 {
+	for raw, v := range t.values {
+		if v == value {
+			return raw
+		}
+	}
+	return math.NaN()
+}
+
+func newEnumTransform(arg string) (Transform, error) {
+	t := &enumTransform{values: make(map[float64]float64)}
+	for _, pair := range strings.Split(arg, ";") {
+		i := strings.IndexByte(pair, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("expected raw:value")
+		}
+		raw, err := strconv.ParseFloat(pair[:i], 64)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil {
+			return nil, err
+		}
+		t.values[raw] = value
+	}
+	return t, nil
+}
+
+// bcdTransform decodes binary-coded decimals, 4 bits per digit.
+type bcdTransform struct{}
+
+func (bcdTransform) Read(value float64) float64 {
+	raw := uint64(value)
+	decimal, digit := uint64(0), uint64(1)
+	for ; raw > 0; raw >>= 4 {
+		if raw&0xF > 9 {
+			return math.NaN()
+		}
+		decimal += (raw & 0xF) * digit
+		digit *= 10
+	}
+	return float64(decimal)
+}
+
+func (bcdTransform) Write(value float64) float64 {
+	decimal := uint64(math.Round(value))
+	raw, shift := uint64(0), uint(0)
+	for ; decimal > 0; decimal /= 10 {
+		raw |= (decimal % 10) << shift
+		shift += 4
+	}
+	return float64(raw)
+}
+
+func newBCDTransform(arg string) (Transform, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("expected no argument")
+	}
+	return bcdTransform{}, nil
+}
+
+// parseTransforms parses transforms in the syntax of struct tags, e.g.
+// "bcd=,scale=0.1".
+func parseTransforms(spec string) (transforms []Transform, err error) {
+	for _, option := range strings.Split(spec, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, val := option, ""
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			key, val = option[:i], option[i+1:]
+		}
+		factory := lookupTransform(key)
+		if factory == nil {
+			return nil, fmt.Errorf("unknown transform '%v'", key)
+		}
+		var t Transform
+		if t, err = factory(val); err != nil {
+			return nil, fmt.Errorf("invalid %v '%v': %v", key, val, err)
+		}
+		transforms = append(transforms, t)
+	}
+	return
+}
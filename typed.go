@@ -0,0 +1,98 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/goburrow/modbus/encoding"
+)
+
+// registerGoTypes are the Go types of the values decoded by DecodeValue.
+var registerGoTypes = map[string]reflect.Type{
+	"uint16":  reflect.TypeOf(uint16(0)),
+	"int16":   reflect.TypeOf(int16(0)),
+	"uint32":  reflect.TypeOf(uint32(0)),
+	"int32":   reflect.TypeOf(int32(0)),
+	"float32": reflect.TypeOf(float32(0)),
+	"uint64":  reflect.TypeOf(uint64(0)),
+	"int64":   reflect.TypeOf(int64(0)),
+	"float64": reflect.TypeOf(float64(0)),
+}
+
+// newValueField returns the field encoding values of the register type in
+// the word order. Types and orders are the ones of the "modbus" struct tag.
+func newValueField(typ, order string) (f *registerField, err error) {
+	if _, ok := registerTypeSizes[typ]; !ok {
+		err = fmt.Errorf("modbus: unsupported type '%v'", typ)
+		return
+	}
+	f = &registerField{name: "value", typ: typ}
+	f.order, err = encoding.ParseOrder(order)
+	return
+}
+
+// TypeQuantity returns the number of registers of the register type, or 0
+// if the type is not supported.
+func TypeQuantity(typ string) uint16 {
+	return uint16(registerTypeSizes[typ])
+}
+
+// EncodeValue encodes value, a Go integer, float or bool, as registers of
+// the type in the word order, e.g. EncodeValue(49.5, "float32", "cdab").
+// Floats are rounded when encoded as integers.
+func EncodeValue(value interface{}, typ, order string) (data []byte, err error) {
+	f, err := newValueField(typ, order)
+	if err != nil {
+		return
+	}
+	v := reflect.ValueOf(value)
+	if !isNumericKind(v.Kind()) {
+		err = fmt.Errorf("modbus: value '%v' of type '%T' is not a number", value, value)
+		return
+	}
+	data = make([]byte, 2*f.quantity())
+	err = f.encode(v, data)
+	return
+}
+
+// DecodeValue decodes registers of the type in the word order. The value
+// returned has the Go type named by typ, e.g. float32 for "float32".
+func DecodeValue(data []byte, typ, order string) (value interface{}, err error) {
+	f, err := newValueField(typ, order)
+	if err != nil {
+		return
+	}
+	if len(data) != 2*f.quantity() {
+		err = fmt.Errorf("modbus: data size '%v' does not match type '%v' size '%v'", len(data), typ, 2*f.quantity())
+		return
+	}
+	v := reflect.New(registerGoTypes[typ]).Elem()
+	if err = f.decode(v, data); err != nil {
+		return
+	}
+	value = v.Interface()
+	return
+}
+
+// WriteValues encodes values as registers of the type in the word order
+// and writes them with WriteMultipleRegisters starting at address:
+//  results, err := modbus.WriteValues(client, 100, "float32", "cdab", 49.5)
+func WriteValues(client RegisterWriter, address uint16, typ, order string, values ...interface{}) (results []byte, err error) {
+	var data []byte
+	for _, value := range values {
+		var b []byte
+		if b, err = EncodeValue(value, typ, order); err != nil {
+			return
+		}
+		data = append(data, b...)
+	}
+	if len(data) == 0 {
+		err = fmt.Errorf("modbus: no values to write")
+		return
+	}
+	return client.WriteMultipleRegisters(address, uint16(len(data)/2), data)
+}
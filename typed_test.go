@@ -0,0 +1,64 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		typ      string
+		order    string
+		expected []byte
+	}{
+		{49.5, "float32", "abcd", []byte{0x42, 0x46, 0x00, 0x00}},
+		{49.5, "float32", "cdab", []byte{0x00, 0x00, 0x42, 0x46}},
+		{float32(49.5), "float32", "dcba", []byte{0x00, 0x00, 0x46, 0x42}},
+		{-2, "int16", "", []byte{0xFF, 0xFE}},
+		{uint32(0x12345678), "uint32", "badc", []byte{0x34, 0x12, 0x78, 0x56}},
+		{true, "uint16", "", []byte{0x00, 0x01}},
+	}
+	for _, test := range tests {
+		data, err := EncodeValue(test.value, test.typ, test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(test.expected, data) {
+			t.Errorf("%v %v %v: expected %x, actual %x", test.value, test.typ, test.order, test.expected, data)
+		}
+		value, err := DecodeValue(data, test.typ, test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back, _ := EncodeValue(value, test.typ, test.order); !bytes.Equal(data, back) {
+			t.Errorf("%v %v %v: decoded %v", test.value, test.typ, test.order, value)
+		}
+	}
+	if TypeQuantity("float64") != 4 || TypeQuantity("float16") != 0 {
+		t.Fatalf("unexpected type quantities")
+	}
+	if _, err := EncodeValue(70000, "uint16", ""); err == nil {
+		t.Fatalf("expected overflow error")
+	}
+	if _, err := EncodeValue("1", "uint16", ""); err == nil {
+		t.Fatalf("expected type error")
+	}
+	if _, err := EncodeValue(1, "uint16", "acbd"); err == nil {
+		t.Fatalf("expected order error")
+	}
+}
+
+func TestWriteValues(t *testing.T) {
+	memory := &memoryClient{}
+	if _, err := WriteValues(memory, 10, "float32", "cdab", 49.5, 1); err != nil {
+		t.Fatal(err)
+	}
+	if memory.holding[10] != 0 || memory.holding[11] != 0x4246 || memory.holding[13] != 0x3F80 {
+		t.Fatalf("unexpected registers %x", memory.holding[10:14])
+	}
+}
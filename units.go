@@ -0,0 +1,109 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// UnitConverter returns the transform converting values from a unit to
+// another, Read converting from and Write converting back. It allows unit
+// libraries to replace the built-in conversions, see SetUnitConverter.
+type UnitConverter func(from, to string) (Transform, error)
+
+// unit converts a value to the base unit of its quantity:
+// base = value * factor + offset.
+type unit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+var (
+	unitsMu       sync.RWMutex
+	unitConverter UnitConverter
+	units         = map[string]unit{
+		"degC": {"temperature", 1, 0},
+		"degF": {"temperature", 5.0 / 9, -32 * 5.0 / 9},
+		"K":    {"temperature", 1, -273.15},
+
+		"Pa":   {"pressure", 1, 0},
+		"kPa":  {"pressure", 1e3, 0},
+		"bar":  {"pressure", 1e5, 0},
+		"mbar": {"pressure", 1e2, 0},
+		"psi":  {"pressure", 6894.757293168, 0},
+
+		"W":  {"power", 1, 0},
+		"kW": {"power", 1e3, 0},
+		"MW": {"power", 1e6, 0},
+
+		"Wh":  {"energy", 1, 0},
+		"kWh": {"energy", 1e3, 0},
+		"MWh": {"energy", 1e6, 0},
+
+		"mV": {"voltage", 1e-3, 0},
+		"V":  {"voltage", 1, 0},
+		"kV": {"voltage", 1e3, 0},
+
+		"mA": {"current", 1e-3, 0},
+		"A":  {"current", 1, 0},
+	}
+)
+
+// RegisterUnit adds a unit to the built-in conversions. Units of the same
+// quantity can be converted to each other, a value in the unit is
+// value * factor + offset in the base unit of the quantity:
+//  modbus.RegisterUnit("gal/min", "flow", 6.30901964e-5, 0) // m3/s base
+func RegisterUnit(name, quantity string, factor, offset float64) {
+	if factor == 0 {
+		panic("modbus: unit '" + name + "' factor must not be zero")
+	}
+	unitsMu.Lock()
+	defer unitsMu.Unlock()
+	units[name] = unit{quantity, factor, offset}
+}
+
+// SetUnitConverter replaces the built-in conversions used by the unit
+// transform and register maps, nil restores them.
+func SetUnitConverter(converter UnitConverter) {
+	unitsMu.Lock()
+	defer unitsMu.Unlock()
+	unitConverter = converter
+}
+
+// ConvertUnit returns the transform converting values from a unit to
+// another, using the converter set by SetUnitConverter if any.
+func ConvertUnit(from, to string) (Transform, error) {
+	unitsMu.RLock()
+	converter := unitConverter
+	unitsMu.RUnlock()
+	if converter != nil {
+		return converter(from, to)
+	}
+	return convertUnit(from, to)
+}
+
+func convertUnit(from, to string) (Transform, error) {
+	unitsMu.RLock()
+	defer unitsMu.RUnlock()
+
+	fromUnit, ok := units[from]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := units[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.quantity != toUnit.quantity {
+		return nil, fmt.Errorf("cannot convert %v to %v", fromUnit.quantity, toUnit.quantity)
+	}
+	// value = (raw * from.factor + from.offset - to.offset) / to.factor
+	return &linearTransform{
+		factor: fromUnit.factor / toUnit.factor,
+		offset: (fromUnit.offset - toUnit.offset) / toUnit.factor,
+	}, nil
+}
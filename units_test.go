@@ -0,0 +1,70 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		from, to string
+		value    float64
+		expected float64
+	}{
+		{"kWh", "MWh", 1500, 1.5},
+		{"degF", "degC", 212, 100},
+		{"K", "degC", 0, -273.15},
+		{"bar", "psi", 1, 14.503773773},
+	}
+	for _, test := range tests {
+		transform, err := ConvertUnit(test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := transform.Read(test.value); math.Abs(value-test.expected) > 1e-6 {
+			t.Errorf("%v %v to %v: expected %v, actual %v", test.value, test.from, test.to, test.expected, value)
+		}
+		if value := transform.Write(test.expected); math.Abs(value-test.value) > 1e-6 {
+			t.Errorf("%v %v to %v: expected %v, actual %v", test.expected, test.to, test.from, test.value, value)
+		}
+	}
+	if _, err := ConvertUnit("kWh", "bar"); err == nil {
+		t.Fatalf("expected error for incompatible units")
+	}
+
+	RegisterUnit("m3/h", "flow", 1.0/3600, 0)
+	RegisterUnit("l/s", "flow", 1e-3, 0)
+	transform, err := ConvertUnit("m3/h", "l/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := transform.Read(36); math.Abs(value-10) > 1e-9 {
+		t.Fatalf("unexpected flow %v", value)
+	}
+}
+
+func TestSetUnitConverter(t *testing.T) {
+	SetUnitConverter(func(from, to string) (Transform, error) {
+		if from == "cups" && to == "ml" {
+			return &linearTransform{factor: 250}, nil
+		}
+		return nil, fmt.Errorf("unknown conversion %v to %v", from, to)
+	})
+	defer SetUnitConverter(nil)
+
+	transform, err := ConvertUnit("cups", "ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transform.Read(2) != 500 {
+		t.Fatalf("unexpected conversion %v", transform.Read(2))
+	}
+	if _, err = ConvertUnit("kWh", "MWh"); err == nil {
+		t.Fatalf("expected built-in conversions to be replaced")
+	}
+}
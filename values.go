@@ -0,0 +1,91 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build go1.18
+
+package modbus
+
+// The helpers below read and write 32-bit and 64-bit values in holding
+// registers in the word order of the "modbus" struct tag, "" being
+// big-endian, see ReadValue. Writes always send WriteMultipleRegisters
+// with the quantity of the type, never WriteSingleRegister with half of
+// the value:
+//  err := modbus.WriteFloat32(client, 100, "cdab", 49.5)
+//  setpoint, err := modbus.ReadFloat32(client, 100, "cdab")
+
+// consistentReadAttempts is the number of reads of ReadConsistentUint64
+// and ReadConsistentFloat64.
+const consistentReadAttempts = 3
+
+// ReadUint32 reads the uint32 at address.
+func ReadUint32(client RegisterReader, address uint16, order string) (uint32, error) {
+	return ReadValue[uint32](client, address, order)
+}
+
+// WriteUint32 writes the uint32 at address.
+func WriteUint32(client RegisterWriter, address uint16, order string, value uint32) error {
+	return WriteValue(client, address, order, value)
+}
+
+// ReadInt32 reads the int32 at address.
+func ReadInt32(client RegisterReader, address uint16, order string) (int32, error) {
+	return ReadValue[int32](client, address, order)
+}
+
+// WriteInt32 writes the int32 at address.
+func WriteInt32(client RegisterWriter, address uint16, order string, value int32) error {
+	return WriteValue(client, address, order, value)
+}
+
+// ReadFloat32 reads the float32 at address.
+func ReadFloat32(client RegisterReader, address uint16, order string) (float32, error) {
+	return ReadValue[float32](client, address, order)
+}
+
+// WriteFloat32 writes the float32 at address.
+func WriteFloat32(client RegisterWriter, address uint16, order string, value float32) error {
+	return WriteValue(client, address, order, value)
+}
+
+// ReadUint64 reads the uint64 at address.
+func ReadUint64(client RegisterReader, address uint16, order string) (uint64, error) {
+	return ReadValue[uint64](client, address, order)
+}
+
+// ReadConsistentUint64 reads the uint64 counter at address, see
+// ReadConsistentValue.
+func ReadConsistentUint64(client RegisterReader, address uint16, order string) (uint64, error) {
+	return ReadConsistentValue[uint64](client, address, order, consistentReadAttempts)
+}
+
+// WriteUint64 writes the uint64 at address.
+func WriteUint64(client RegisterWriter, address uint16, order string, value uint64) error {
+	return WriteValue(client, address, order, value)
+}
+
+// ReadInt64 reads the int64 at address.
+func ReadInt64(client RegisterReader, address uint16, order string) (int64, error) {
+	return ReadValue[int64](client, address, order)
+}
+
+// WriteInt64 writes the int64 at address.
+func WriteInt64(client RegisterWriter, address uint16, order string, value int64) error {
+	return WriteValue(client, address, order, value)
+}
+
+// ReadFloat64 reads the float64 at address.
+func ReadFloat64(client RegisterReader, address uint16, order string) (float64, error) {
+	return ReadValue[float64](client, address, order)
+}
+
+// ReadConsistentFloat64 reads the float64 counter at address, see
+// ReadConsistentValue.
+func ReadConsistentFloat64(client RegisterReader, address uint16, order string) (float64, error) {
+	return ReadConsistentValue[float64](client, address, order, consistentReadAttempts)
+}
+
+// WriteFloat64 writes the float64 at address.
+func WriteFloat64(client RegisterWriter, address uint16, order string, value float64) error {
+	return WriteValue(client, address, order, value)
+}
@@ -0,0 +1,252 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrWriteNotApplied is matched by errors.Is when a write succeeded but
+// reading back the values showed the device did not apply it.
+var ErrWriteNotApplied = errors.New("modbus: write was not applied")
+
+// WriteNotAppliedError reports the values written and read back, register
+// values, or 0 and 1 for coils.
+type WriteNotAppliedError struct {
+	Table    Table
+	Address  uint16
+	Expected []uint16
+	Actual   []uint16
+}
+
+// Error implements error interface.
+func (e *WriteNotAppliedError) Error() string {
+	return fmt.Sprintf("modbus: write to %v at address '%v' was not applied, expected '%v', read back '%v'",
+		e.Table, e.Address, e.Expected, e.Actual)
+}
+
+// Is returns true for ErrWriteNotApplied.
+func (e *WriteNotAppliedError) Is(target error) bool {
+	return target == ErrWriteNotApplied
+}
+
+// VerifyingClient wraps a Client and reads back every write of coils and
+// holding registers, returning a WriteNotAppliedError if the values read
+// differ from the ones written. Devices often acknowledge writes to
+// read-only or out-of-range registers without applying them.
+type VerifyingClient struct {
+	Client
+
+	// ReadBackDelay is waited before reading back, for devices applying
+	// writes asynchronously.
+	ReadBackDelay time.Duration
+	// Tolerance is the maximum difference of float values written by
+	// WriteValues and read back.
+	Tolerance float64
+	// Clock waits ReadBackDelay, it defaults to the system time if nil.
+	Clock Clock
+}
+
+// NewVerifyingClient creates a new VerifyingClient wrapping the client.
+func NewVerifyingClient(client Client) *VerifyingClient {
+	return &VerifyingClient{Client: client}
+}
+
+// WriteSingleCoil writes the coil and reads it back.
+func (mb *VerifyingClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	if results, err = mb.Client.WriteSingleCoil(address, value); err != nil {
+		return
+	}
+	var expected uint16
+	if value == 0xFF00 {
+		expected = 1
+	}
+	err = mb.verifyCoils(address, []uint16{expected})
+	return
+}
+
+// WriteMultipleCoils writes the coils and reads them back.
+func (mb *VerifyingClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.Client.WriteMultipleCoils(address, quantity, value); err != nil {
+		return
+	}
+	bits, err := UnpackBits(value, quantity)
+	if err != nil {
+		return
+	}
+	expected := make([]uint16, quantity)
+	for i, bit := range bits {
+		if bit {
+			expected[i] = 1
+		}
+	}
+	err = mb.verifyCoils(address, expected)
+	return
+}
+
+// WriteSingleRegister writes the register and reads it back.
+func (mb *VerifyingClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	if results, err = mb.Client.WriteSingleRegister(address, value); err != nil {
+		return
+	}
+	err = mb.verifyRegisters(address, []uint16{value}, nil)
+	return
+}
+
+// WriteMultipleRegisters writes the registers and reads them back.
+func (mb *VerifyingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.Client.WriteMultipleRegisters(address, quantity, value); err != nil {
+		return
+	}
+	err = mb.verifyRegisters(address, registerValues(value), nil)
+	return
+}
+
+// MaskWriteRegister writes the register and checks that the bits set by
+// the masks were applied.
+func (mb *VerifyingClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	if results, err = mb.Client.MaskWriteRegister(address, andMask, orMask); err != nil {
+		return
+	}
+	// Bits cleared in the AND-mask are replaced by the OR-mask
+	err = mb.verifyRegisters(address, []uint16{orMask &^ andMask}, func(expected, actual []uint16) bool {
+		return actual[0]&^andMask == expected[0]
+	})
+	return
+}
+
+// ReadWriteMultipleRegisters writes and reads the registers, then reads
+// back the registers written.
+func (mb *VerifyingClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value); err != nil {
+		return
+	}
+	err = mb.verifyRegisters(writeAddress, registerValues(value), nil)
+	return
+}
+
+// WriteValues writes typed values like the function WriteValues and reads
+// them back, float values are compared with Tolerance.
+func (mb *VerifyingClient) WriteValues(address uint16, typ, order string, values ...interface{}) (results []byte, err error) {
+	if results, err = WriteValues(mb.Client, address, typ, order, values...); err != nil {
+		return
+	}
+	size := int(TypeQuantity(typ))
+	var equal func(expected, actual []uint16) bool
+	if (typ == "float32" || typ == "float64") && mb.Tolerance > 0 {
+		equal = func(expected, actual []uint16) bool {
+			for i := 0; i < len(expected); i += size {
+				x, err1 := decodeFloat(expected[i:i+size], typ, order)
+				y, err2 := decodeFloat(actual[i:i+size], typ, order)
+				if err1 != nil || err2 != nil || math.Abs(x-y) > mb.Tolerance {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	data := make([]byte, 0, 2*size*len(values))
+	for _, value := range values {
+		var b []byte
+		if b, err = EncodeValue(value, typ, order); err != nil {
+			return
+		}
+		data = append(data, b...)
+	}
+	err = mb.verifyRegisters(address, registerValues(data), equal)
+	return
+}
+
+func (mb *VerifyingClient) verifyCoils(address uint16, expected []uint16) (err error) {
+	mb.wait()
+	results, err := mb.Client.ReadCoils(address, uint16(len(expected)))
+	if err != nil {
+		return
+	}
+	bits, err := UnpackBits(results, uint16(len(expected)))
+	if err != nil {
+		return
+	}
+	actual := make([]uint16, len(bits))
+	for i, bit := range bits {
+		if bit {
+			actual[i] = 1
+		}
+	}
+	if !equalValues(expected, actual) {
+		err = &WriteNotAppliedError{TableCoils, address, expected, actual}
+	}
+	return
+}
+
+// verifyRegisters reads back the registers and compares them with equal,
+// or exactly if nil.
+func (mb *VerifyingClient) verifyRegisters(address uint16, expected []uint16, equal func(expected, actual []uint16) bool) (err error) {
+	mb.wait()
+	results, err := mb.Client.ReadHoldingRegisters(address, uint16(len(expected)))
+	if err != nil {
+		return
+	}
+	if len(results) != 2*len(expected) {
+		err = fmt.Errorf("modbus: response data size '%v' does not match quantity '%v'", len(results), len(expected))
+		return
+	}
+	actual := registerValues(results)
+	if equal == nil {
+		equal = equalValues
+	}
+	if !equal(expected, actual) {
+		err = &WriteNotAppliedError{TableHoldingRegisters, address, expected, actual}
+	}
+	return
+}
+
+func (mb *VerifyingClient) wait() {
+	if mb.ReadBackDelay > 0 {
+		clockOrSystem(mb.Clock).Sleep(mb.ReadBackDelay)
+	}
+}
+
+func registerValues(data []byte) []uint16 {
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return values
+}
+
+func equalValues(expected, actual []uint16) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeFloat(registers []uint16, typ, order string) (float64, error) {
+	data := make([]byte, 2*len(registers))
+	for i, r := range registers {
+		binary.BigEndian.PutUint16(data[2*i:], r)
+	}
+	value, err := DecodeValue(data, typ, order)
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("modbus: type '%v' is not a float", typ)
+}
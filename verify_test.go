@@ -0,0 +1,55 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// readOnlyClient acknowledges writes to registers from 1000 without
+// applying them, and truncates the low word of other writes of two
+// registers, like a device storing floats with a lower precision.
+type readOnlyClient struct {
+	memoryClient
+}
+
+func (c *readOnlyClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	if address >= 1000 {
+		return dataBlock(address, quantity), nil
+	}
+	if quantity == 2 {
+		value = []byte{value[0], value[1], 0, 0}
+	}
+	return c.memoryClient.WriteMultipleRegisters(address, quantity, value)
+}
+
+func TestVerifyingClient(t *testing.T) {
+	memory := &readOnlyClient{}
+	client := NewVerifyingClient(memory)
+
+	if _, err := client.WriteMultipleRegisters(10, 3, []byte{0, 1, 0, 2, 0, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteMultipleCoils(10, 3, []byte{0x05}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.WriteMultipleRegisters(1000, 1, []byte{0, 1})
+	if !errors.Is(err, ErrWriteNotApplied) {
+		t.Fatalf("expected write not applied, actual %v", err)
+	}
+	if e := err.(*WriteNotAppliedError); e.Address != 1000 || e.Expected[0] != 1 || e.Actual[0] != 0 {
+		t.Fatalf("unexpected error %+v", e)
+	}
+
+	// 0.1 is stored as 0x3DCC0000, 0.099609375
+	if _, err = client.WriteValues(20, "float32", "abcd", 0.1); !errors.Is(err, ErrWriteNotApplied) {
+		t.Fatalf("expected write not applied, actual %v", err)
+	}
+	client.Tolerance = 1e-3
+	if _, err = client.WriteValues(20, "float32", "abcd", 0.1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Watchdog writes a holding register periodically, independently of the
+// other requests, for remote I/O modules which reset their outputs when
+// their watchdog register is not written in time:
+//  watchdog := modbus.NewWatchdog(client, 0x1120, time.Second)
+//  watchdog.Toggle = true
+//  watchdog.OnFailure = func(err error) { log.Printf("watchdog: %v", err) }
+//  err := watchdog.Start()
+//  defer watchdog.Stop()
+type Watchdog struct {
+	Client   Client
+	Address  uint16
+	Interval time.Duration
+	// Value is written to the register. If Toggle is set, Value and zero
+	// are written alternately, for modules expecting the value to change.
+	Value  uint16
+	Toggle bool
+	// OnFailure is called when a write fails after the previous one
+	// succeeded, and OnRecover when a write succeeds again after a
+	// failure. They are called from the goroutine of the watchdog.
+	OnFailure func(err error)
+	OnRecover func()
+	// Clock schedules the writes, it defaults to the system time if nil.
+	Clock Clock
+
+	mu       sync.Mutex
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	failures int
+	lastErr  error
+}
+
+// NewWatchdog allocates a new Watchdog writing 1 to the register at
+// address every interval.
+func NewWatchdog(client Client, address uint16, interval time.Duration) *Watchdog {
+	return &Watchdog{Client: client, Address: address, Interval: interval, Value: 1}
+}
+
+// Start writes the register and starts writing it every Interval. It does
+// nothing if the watchdog is already started.
+func (w *Watchdog) Start() error {
+	if w.Interval <= 0 {
+		return fmt.Errorf("modbus: watchdog interval '%v' must be positive", w.Interval)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop != nil {
+		return nil
+	}
+	w.stop = make(chan struct{})
+	w.stopped.Add(1)
+	go w.run(w.stop)
+	return nil
+}
+
+// Stop stops writing the register and waits for the write in progress to
+// complete.
+func (w *Watchdog) Stop() {
+	w.mu.Lock()
+	stop := w.stop
+	w.stop = nil
+	w.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		w.stopped.Wait()
+	}
+}
+
+// Failures returns the number of consecutive failed writes and the error
+// of the last one.
+func (w *Watchdog) Failures() (failures int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failures, w.lastErr
+}
+
+func (w *Watchdog) run(stop chan struct{}) {
+	defer w.stopped.Done()
+
+	clock := clockOrSystem(w.Clock)
+	next := clock.Now()
+	high := true
+	for {
+		value := w.Value
+		if w.Toggle && !high {
+			value = 0
+		}
+		high = !high
+		w.write(value)
+		// Missed writes are dropped, as by time.Ticker
+		next = next.Add(w.Interval)
+		wait := next.Sub(clock.Now())
+		if wait < 0 {
+			next = next.Add(-wait / w.Interval * w.Interval)
+			wait = 0
+		}
+		select {
+		case <-stop:
+			return
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// write writes value and calls the callbacks on failure and recovery.
+func (w *Watchdog) write(value uint16) {
+	_, err := w.Client.WriteSingleRegister(w.Address, value)
+
+	w.mu.Lock()
+	failed := w.failures > 0
+	if err != nil {
+		w.failures++
+		w.lastErr = err
+	} else {
+		w.failures = 0
+	}
+	w.mu.Unlock()
+
+	switch {
+	case err != nil && !failed && w.OnFailure != nil:
+		w.OnFailure(err)
+	case err == nil && failed && w.OnRecover != nil:
+		w.OnRecover()
+	}
+}
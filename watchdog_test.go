@@ -0,0 +1,80 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchdogClient records the values written, failing while err is set.
+type watchdogClient struct {
+	Client
+	mu     sync.Mutex
+	values []uint16
+	err    error
+}
+
+func (c *watchdogClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.values = append(c.values, value)
+	return dataBlock(value), nil
+}
+
+func (c *watchdogClient) written() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.values)
+}
+
+func TestWatchdog(t *testing.T) {
+	client := &watchdogClient{}
+	watchdog := NewWatchdog(client, 100, 5*time.Millisecond)
+	watchdog.Value = 0xA5
+	watchdog.Toggle = true
+	failed := make(chan error, 10)
+	recovered := make(chan struct{}, 10)
+	watchdog.OnFailure = func(err error) { failed <- err }
+	watchdog.OnRecover = func() { recovered <- struct{}{} }
+	if err := watchdog.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer watchdog.Stop()
+
+	for client.written() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	errTimeout := errors.New("timeout")
+	client.mu.Lock()
+	client.err = errTimeout
+	if client.values[0] != 0xA5 || client.values[1] != 0 || client.values[2] != 0xA5 {
+		t.Fatalf("unexpected values %v", client.values)
+	}
+	client.mu.Unlock()
+	if err := <-failed; err != errTimeout {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for failures, _ := watchdog.Failures(); failures < 2; failures, _ = watchdog.Failures() {
+		time.Sleep(time.Millisecond)
+	}
+	client.mu.Lock()
+	client.err = nil
+	client.mu.Unlock()
+	<-recovered
+	watchdog.Stop()
+
+	if len(failed) != 0 || len(recovered) != 0 {
+		t.Fatalf("callbacks called more than once")
+	}
+	if failures, err := watchdog.Failures(); failures != 0 || err != errTimeout {
+		t.Fatalf("unexpected failures %v, %v", failures, err)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// frameFormat is the framing of the frames logged.
+type frameFormat int
+
+const (
+	frameRTU frameFormat = iota
+	frameASCII
+	frameTCP
+)
+
+// WireLog configures the frames logged by handlers with a Logger, one line
+// per frame with its time, direction, slave id, function and bytes:
+//  modbus: 10:04:05.000120 sending slave 1 read holding registers (3): 01 03 00 00 00 02 c4 0b
+//  modbus: 10:04:05.010370 received slave 1 read holding registers (3): 01 03 04 00 2a 00 00 fa 39
+// ASCII frames are logged as the bytes they encode.
+type WireLog struct {
+	// LogPDU adds the fields of the PDU to the frames logged, e.g. the
+	// address and quantity of requests or the exception of responses.
+	LogPDU bool
+	// Redact hides the data of the frames logged, such as register values,
+	// so that logs can be shared. Headers, slave id, function and the
+	// length of the data are kept.
+	Redact bool
+}
+
+// formatFrame returns the line logged for frame, sent or received at t.
+func (w *WireLog) formatFrame(format frameFormat, sent bool, t time.Time, frame []byte) string {
+	var b strings.Builder
+	b.WriteString("modbus: ")
+	b.WriteString(t.Format("15:04:05.000000"))
+	if sent {
+		b.WriteString(" sending ")
+	} else {
+		b.WriteString(" received ")
+	}
+	adu := frame
+	if format == frameASCII {
+		var err error
+		if adu, err = decodeASCIIFrame(frame); err != nil {
+			w.writeInvalidFrame(&b, "%q", frame)
+			return b.String()
+		}
+	}
+	// header is the part before the function code, trailer the checksum
+	header, trailer := 1, 2
+	if format == frameTCP {
+		header, trailer = tcpHeaderSize, 0
+	} else if format == frameASCII {
+		trailer = 1
+	}
+	if len(adu) < header+1+trailer {
+		w.writeInvalidFrame(&b, "% x", frame)
+		return b.String()
+	}
+	functionCode := adu[header]
+	fmt.Fprintf(&b, "slave %v %s (%v)", adu[header-1], functionName(functionCode&0x7F), functionCode)
+	pdu := &ProtocolDataUnit{FunctionCode: functionCode, Data: adu[header+1 : len(adu)-trailer]}
+	if w.LogPDU {
+		w.writePDUFields(&b, sent, pdu)
+	}
+	if w.Redact {
+		fmt.Fprintf(&b, ": % x [%v bytes]\n", adu[:header+1], len(adu)-header-1)
+	} else {
+		fmt.Fprintf(&b, ": % x\n", adu)
+	}
+	return b.String()
+}
+
+// writeInvalidFrame writes frame in the verb format, or only its length
+// if redacted.
+func (w *WireLog) writeInvalidFrame(b *strings.Builder, verb string, frame []byte) {
+	if w.Redact {
+		fmt.Fprintf(b, "invalid frame [%v bytes]\n", len(frame))
+		return
+	}
+	fmt.Fprintf(b, "invalid frame "+verb+"\n", frame)
+}
+
+// writePDUFields writes the fields of the request or response pdu.
+func (w *WireLog) writePDUFields(b *strings.Builder, sent bool, pdu *ProtocolDataUnit) {
+	if !sent {
+		if pdu.FunctionCode&0x80 != 0 {
+			if len(pdu.Data) > 0 {
+				fmt.Fprintf(b, " exception %v (%s)", pdu.Data[0], ExceptionName(pdu.Data[0]))
+			}
+			return
+		}
+		switch pdu.FunctionCode {
+		case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+			FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+			FuncCodeReadWriteMultipleRegisters:
+			if len(pdu.Data) > 0 {
+				fmt.Fprintf(b, " byte count %v", pdu.Data[0])
+			}
+			return
+		case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+			if len(pdu.Data) >= 4 {
+				fmt.Fprintf(b, " address %v quantity %v", binary.BigEndian.Uint16(pdu.Data),
+					binary.BigEndian.Uint16(pdu.Data[2:]))
+			}
+			return
+		}
+	}
+	request, err := DecodeRequest(pdu)
+	if err != nil || (request.Quantity == 0 && request.Values == nil) {
+		return
+	}
+	fmt.Fprintf(b, " address %v quantity %v", request.Address, request.Quantity)
+	if request.FunctionCode == FuncCodeReadWriteMultipleRegisters {
+		fmt.Fprintf(b, " write address %v quantity %v", request.WriteAddress, request.WriteQuantity)
+	}
+	if request.Values != nil && !w.Redact {
+		fmt.Fprintf(b, " values %v", request.Values)
+	}
+}
+
+// decodeASCIIFrame returns the bytes encoded by the ASCII frame.
+func decodeASCIIFrame(frame []byte) (adu []byte, err error) {
+	if len(frame) < 3 || frame[0] != asciiStart[0] {
+		return nil, fmt.Errorf("modbus: frame is not started with '%v'", asciiStart)
+	}
+	return hex.DecodeString(strings.TrimRight(string(frame[1:]), "\r\n"))
+}
+
+// functionName returns the name of the function code.
+func functionName(functionCode byte) string {
+	switch functionCode {
+	case FuncCodeReadCoils:
+		return "read coils"
+	case FuncCodeReadDiscreteInputs:
+		return "read discrete inputs"
+	case FuncCodeReadHoldingRegisters:
+		return "read holding registers"
+	case FuncCodeReadInputRegisters:
+		return "read input registers"
+	case FuncCodeWriteSingleCoil:
+		return "write single coil"
+	case FuncCodeWriteSingleRegister:
+		return "write single register"
+	case FuncCodeWriteMultipleCoils:
+		return "write multiple coils"
+	case FuncCodeWriteMultipleRegisters:
+		return "write multiple registers"
+	case FuncCodeMaskWriteRegister:
+		return "mask write register"
+	case FuncCodeReadWriteMultipleRegisters:
+		return "read write multiple registers"
+	case FuncCodeReadFIFOQueue:
+		return "read fifo queue"
+	case FuncCodeDiagnostics:
+		return "diagnostics"
+	case FuncCodeReportSlaveId:
+		return "report slave id"
+	case FuncCodeEncapsulatedInterfaceTransport:
+		return "encapsulated interface transport"
+	}
+	return "function"
+}
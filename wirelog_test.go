@@ -0,0 +1,43 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWireLogFormat(t *testing.T) {
+	at := time.Date(2016, 1, 2, 10, 4, 5, 120000, time.UTC)
+	tests := []struct {
+		log      WireLog
+		format   frameFormat
+		sent     bool
+		frame    []byte
+		expected string
+	}{
+		{WireLog{}, frameRTU, true, []byte{1, 3, 0, 0, 0, 2, 0xc4, 0x0b},
+			"modbus: 10:04:05.000120 sending slave 1 read holding registers (3): 01 03 00 00 00 02 c4 0b\n"},
+		{WireLog{LogPDU: true}, frameRTU, true, []byte{1, 6, 0, 10, 0, 42, 0xe8, 0x1d},
+			"modbus: 10:04:05.000120 sending slave 1 write single register (6) address 10 quantity 1 values [42]: 01 06 00 0a 00 2a e8 1d\n"},
+		{WireLog{LogPDU: true, Redact: true}, frameRTU, true, []byte{1, 6, 0, 10, 0, 42, 0xe8, 0x1d},
+			"modbus: 10:04:05.000120 sending slave 1 write single register (6) address 10 quantity 1: 01 06 [6 bytes]\n"},
+		{WireLog{LogPDU: true}, frameRTU, false, []byte{1, 0x83, 2, 0xc0, 0xf1},
+			"modbus: 10:04:05.000120 received slave 1 read holding registers (131) exception 2 (illegal data address): 01 83 02 c0 f1\n"},
+		{WireLog{LogPDU: true}, frameTCP, false, []byte{0, 1, 0, 0, 0, 7, 17, 3, 4, 0, 42, 0, 0},
+			"modbus: 10:04:05.000120 received slave 17 read holding registers (3) byte count 4: 00 01 00 00 00 07 11 03 04 00 2a 00 00\n"},
+		{WireLog{}, frameASCII, true, []byte(":F7031389000A60\r\n"),
+			"modbus: 10:04:05.000120 sending slave 247 read holding registers (3): f7 03 13 89 00 0a 60\n"},
+		{WireLog{}, frameASCII, false, []byte(":F70\r\n"),
+			"modbus: 10:04:05.000120 received invalid frame \":F70\\r\\n\"\n"},
+		{WireLog{}, frameRTU, false, []byte{1, 3},
+			"modbus: 10:04:05.000120 received invalid frame 01 03\n"},
+		{WireLog{Redact: true}, frameASCII, false, []byte(":F70\r\n"),
+			"modbus: 10:04:05.000120 received invalid frame [6 bytes]\n"},
+		{WireLog{Redact: true}, frameRTU, false, []byte{1, 3},
+			"modbus: 10:04:05.000120 received invalid frame [2 bytes]\n"},
+	}
+	for _, test := range tests {
+		if actual := test.log.formatFrame(test.format, test.sent, at, test.frame); actual != test.expected {
+			t.Errorf("expected %q, actual %q", test.expected, actual)
+		}
+	}
+}